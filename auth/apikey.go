@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/google/uuid"
+)
+
+// apiKeyPrefix makes keys recognisable, e.g. in secret scanners.
+const apiKeyPrefix = "cfk_"
+
+// HashAPIKey returns the hash an API key is stored and looked up by.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewAPIKey generates a key for subject. The key is returned only here, the
+// record holds its hash.
+func NewAPIKey(subject string) (string, *config.APIKey, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", nil, err
+	}
+
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	return key, &config.APIKey{
+		ID:        uuid.New().String(),
+		Subject:   subject,
+		Hash:      HashAPIKey(key),
+		CreatedAt: time.Now().UTC(),
+	}, nil
+}
+
+// LoadAPIKeysFile reads API keys from a JSON file holding an array of
+// {"subject": "...", "hash": "<hex sha256 of the key>"} objects, and returns
+// them by hash.
+func LoadAPIKeysFile(path string) (map[string]*config.APIKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var apiKeys []*config.APIKey
+	err = json.Unmarshal(data, &apiKeys)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	byHash := make(map[string]*config.APIKey, len(apiKeys))
+	for i, apiKey := range apiKeys {
+		if apiKey.Subject == "" {
+			return nil, fmt.Errorf("%s: key %d has no subject", path, i)
+		}
+		if _, err := hex.DecodeString(apiKey.Hash); err != nil || len(apiKey.Hash) != sha256.Size*2 {
+			return nil, fmt.Errorf("%s: key %d: hash must be a hex SHA-256", path, i)
+		}
+		byHash[apiKey.Hash] = apiKey
+	}
+	return byHash, nil
+}
@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+)
+
+// Authentication methods.
+const (
+	MethodAPIKey = "api_key"
+	MethodJWT    = "jwt"
+)
+
+// Identity is the authenticated caller of a request.
+type Identity struct {
+	// Subject of the API key or the sub claim of the token
+	Subject string
+	// How the caller authenticated, MethodAPIKey or MethodJWT
+	Method string
+}
+
+// String identifies the caller in audit records, e.g. "jwt:alice".
+func (i *Identity) String() string {
+	return i.Method + ":" + i.Subject
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a context carrying the caller identity.
+func WithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// FromContext returns the caller identity, nil for anonymous requests.
+func FromContext(ctx context.Context) *Identity {
+	identity, _ := ctx.Value(identityKey{}).(*Identity)
+	return identity
+}
+
+// FromRequest is FromContext for the context of r.
+func FromRequest(r *http.Request) *Identity {
+	return FromContext(r.Context())
+}
@@ -0,0 +1,299 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+// JWT bearer tokens (RFC 7519) signed with RS256/384/512, ES256/384/512 or
+// EdDSA are verified against the public keys of a JWKS file (RFC 7517).
+// Symmetric algorithms and "none" are rejected.
+
+// clockSkew is the leeway given to exp, nbf and iat.
+const clockSkew = time.Minute
+
+var errInvalidToken = errors.New("invalid token")
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC and OKP
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type publicKey struct {
+	kid string
+	alg string
+	key crypto.PublicKey
+}
+
+// JWKS holds the keys tokens are verified with.
+type JWKS struct {
+	keys []*publicKey
+	// Issuer and Audience are checked against iss and aud when set.
+	Issuer   string
+	Audience string
+	now      func() time.Time
+}
+
+// LoadJWKS reads a JWKS file. Keys not meant for signatures are skipped.
+func LoadJWKS(path string) (*JWKS, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	jwks, err := ParseJWKS(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return jwks, nil
+}
+
+// ParseJWKS parses a JSON Web Key Set.
+func ParseJWKS(data []byte) (*JWKS, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	err := json.Unmarshal(data, &set)
+	if err != nil {
+		return nil, err
+	}
+
+	jwks := &JWKS{now: time.Now}
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %d (%s): %w", i, k.Kid, err)
+		}
+		jwks.keys = append(jwks.keys, &publicKey{kid: k.Kid, alg: k.Alg, key: key})
+	}
+	if len(jwks.keys) == 0 {
+		return nil, errors.New("no signing keys")
+	}
+	return jwks, nil
+}
+
+func decodeSegment(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+func (k *jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeSegment(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("n: %w", err)
+		}
+		e, err := decodeSegment(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("e: %w", err)
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeSegment(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("x: %w", err)
+		}
+		y, err := decodeSegment(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("y: %w", err)
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return key, nil
+
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeSegment(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("x: invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// verifySignature checks sig over signed with key for alg.
+func verifySignature(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "ES512":
+		hash = crypto.SHA512
+	case "EdDSA":
+		k, ok := key.(ed25519.PublicKey)
+		if !ok || !ed25519.Verify(k, signed, sig) {
+			return errInvalidToken
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported alg %q", alg)
+	}
+
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if alg[0] != 'R' || rsa.VerifyPKCS1v15(k, hash, digest, sig) != nil {
+			return errInvalidToken
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if alg[0] != 'E' || len(sig) != 2*size {
+			return errInvalidToken
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return errInvalidToken
+		}
+		return nil
+	}
+	return errInvalidToken
+}
+
+type claims struct {
+	Subject   string          `json:"sub"`
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt *float64        `json:"exp"`
+	NotBefore *float64        `json:"nbf"`
+	IssuedAt  *float64        `json:"iat"`
+}
+
+func (c *claims) hasAudience(audience string) bool {
+	var single string
+	if json.Unmarshal(c.Audience, &single) == nil {
+		return single == audience
+	}
+	var list []string
+	if json.Unmarshal(c.Audience, &list) == nil {
+		for _, a := range list {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func unixTime(seconds float64) time.Time {
+	return time.Unix(0, int64(seconds*float64(time.Second)))
+}
+
+// Verify checks the token signature and claims and returns the caller.
+func (j *JWKS) Verify(token string) (*Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: not a JWT", errInvalidToken)
+	}
+
+	headerJSON, err := decodeSegment(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: header: %v", errInvalidToken, err)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	err = json.Unmarshal(headerJSON, &header)
+	if err != nil {
+		return nil, fmt.Errorf("%w: header: %v", errInvalidToken, err)
+	}
+	sig, err := decodeSegment(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %v", errInvalidToken, err)
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, k := range j.keys {
+		if (header.Kid != "" && k.kid != header.Kid) || (k.alg != "" && k.alg != header.Alg) {
+			continue
+		}
+		if verifySignature(header.Alg, k.key, signed, sig) == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, fmt.Errorf("%w: signature doesn't verify with any key for alg %q", errInvalidToken, header.Alg)
+	}
+
+	payload, err := decodeSegment(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: payload: %v", errInvalidToken, err)
+	}
+	c := &claims{}
+	err = json.Unmarshal(payload, c)
+	if err != nil {
+		return nil, fmt.Errorf("%w: payload: %v", errInvalidToken, err)
+	}
+
+	now := j.now()
+	switch {
+	case c.Subject == "":
+		return nil, fmt.Errorf("%w: sub is missing", errInvalidToken)
+	case c.ExpiresAt == nil:
+		return nil, fmt.Errorf("%w: exp is missing", errInvalidToken)
+	case now.After(unixTime(*c.ExpiresAt).Add(clockSkew)):
+		return nil, fmt.Errorf("%w: token expired", errInvalidToken)
+	case c.NotBefore != nil && now.Add(clockSkew).Before(unixTime(*c.NotBefore)):
+		return nil, fmt.Errorf("%w: token not valid yet", errInvalidToken)
+	case c.IssuedAt != nil && now.Add(clockSkew).Before(unixTime(*c.IssuedAt)):
+		return nil, fmt.Errorf("%w: token issued in the future", errInvalidToken)
+	case j.Issuer != "" && c.Issuer != j.Issuer:
+		return nil, fmt.Errorf("%w: unexpected issuer %q", errInvalidToken, c.Issuer)
+	case j.Audience != "" && !c.hasAudience(j.Audience):
+		return nil, fmt.Errorf("%w: token is not meant for %q", errInvalidToken, j.Audience)
+	}
+
+	return &Identity{Subject: c.Subject, Method: MethodJWT}, nil
+}
@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/metrics"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/poststore"
+)
+
+// APIKeyHeader carries an API key, they can also be sent as bearer tokens.
+const APIKeyHeader = "X-API-Key"
+
+// KeyStore looks up API keys by hash, PostStore implements it.
+type KeyStore interface {
+	GetAPIKey(ctx context.Context, hash string) (*config.APIKey, error)
+}
+
+// Authenticator identifies the caller of every request from an API key or a
+// JWT bearer token. Keys are looked up in the file keys first, then in Store.
+type Authenticator struct {
+	// Store holds the API keys managed at runtime. Nil disables them.
+	Store KeyStore
+	// FileKeys are the API keys loaded from a local file, by hash.
+	FileKeys map[string]*config.APIKey
+	// JWKS verifies bearer tokens. Nil disables them.
+	JWKS *JWKS
+	// AllowAnonymous lets requests without credentials through without an
+	// identity. Requests with invalid credentials are always rejected.
+	AllowAnonymous bool
+	// PublicPaths are served without credentials, e.g. /metrics.
+	PublicPaths map[string]bool
+}
+
+var errNoCredentials = errors.New("authentication required")
+
+// InternalError is returned when the credentials couldn't be checked, e.g.
+// because the key store is unreachable. It says nothing about whether they
+// are valid.
+type InternalError struct {
+	Err error
+}
+
+func (e *InternalError) Error() string {
+	return "checking credentials: " + e.Err.Error()
+}
+
+func (e *InternalError) Unwrap() error {
+	return e.Err
+}
+
+// Authenticate returns the caller of r, or nil and errNoCredentials if it
+// sent no credentials.
+func (a *Authenticator) Authenticate(r *http.Request) (*Identity, error) {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		return a.apiKey(r.Context(), key)
+	}
+
+	authorization := r.Header.Get("Authorization")
+	if authorization == "" {
+		return nil, errNoCredentials
+	}
+	scheme, token, ok := strings.Cut(authorization, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return nil, errors.New("the Authorization header must be \"Bearer <token>\"")
+	}
+
+	// API keys never contain dots, JWTs always do
+	if !strings.Contains(token, ".") {
+		return a.apiKey(r.Context(), token)
+	}
+	if a.JWKS == nil {
+		return nil, fmt.Errorf("%w: bearer tokens are not accepted", errInvalidToken)
+	}
+	return a.JWKS.Verify(token)
+}
+
+func (a *Authenticator) apiKey(ctx context.Context, key string) (*Identity, error) {
+	hash := HashAPIKey(key)
+	if apiKey, ok := a.FileKeys[hash]; ok {
+		return &Identity{Subject: apiKey.Subject, Method: MethodAPIKey}, nil
+	}
+	if a.Store != nil {
+		apiKey, err := a.Store.GetAPIKey(ctx, hash)
+		if err == nil {
+			return &Identity{Subject: apiKey.Subject, Method: MethodAPIKey}, nil
+		}
+		if !errors.Is(err, poststore.ErrNotFound) {
+			return nil, &InternalError{Err: err}
+		}
+	}
+	return nil, errors.New("invalid API key")
+}
+
+// Middleware rejects requests without valid credentials with 401 and attaches
+// the identity of the others to their context. Credentials that couldn't be
+// checked are answered with 503, without the cause which is only logged.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.PublicPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		identity, err := a.Authenticate(r)
+		var internal *InternalError
+		switch {
+		case err == nil:
+			metrics.AuthRequests.WithLabelValues(identity.Method, "ok").Inc()
+			next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), identity)))
+		case errors.Is(err, errNoCredentials) && a.AllowAnonymous:
+			metrics.AuthRequests.WithLabelValues("anonymous", "ok").Inc()
+			next.ServeHTTP(w, r)
+		case errors.As(err, &internal):
+			metrics.AuthRequests.WithLabelValues(MethodAPIKey, "error").Inc()
+			log.Printf("authenticating %s %s: %v", r.Method, r.URL.Path, internal.Err)
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "credentials can't be checked right now, retry later", http.StatusServiceUnavailable)
+		case errors.Is(err, errNoCredentials):
+			metrics.AuthRequests.WithLabelValues("none", "rejected").Inc()
+			w.Header().Set("WWW-Authenticate", `Bearer realm="configurations"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		default:
+			metrics.AuthRequests.WithLabelValues("invalid", "rejected").Inc()
+			w.Header().Set("WWW-Authenticate", `Bearer realm="configurations", error="invalid_token"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		}
+	})
+}
@@ -0,0 +1,278 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/namespace"
+)
+
+// Permissions checked by Require, configurations:force by the handlers taking
+// ?force=true. The configuration permissions can be scoped, the others are only
+// granted by unscoped bindings.
+const (
+	PermConfigurationsRead   = "configurations:read"
+	PermConfigurationsWrite  = "configurations:write"
+	PermConfigurationsDelete = "configurations:delete"
+	PermConfigurationsForce  = "configurations:force"
+	PermEventsRead           = "events:read"
+	PermWebhooksManage       = "webhooks:manage"
+	PermAuditRead            = "audit:read"
+	PermRBACManage           = "rbac:manage"
+	PermSchemasManage        = "schemas:manage"
+)
+
+// Roles and the permissions they grant.
+const (
+	RoleReader = "reader"
+	RoleWriter = "writer"
+	RoleAdmin  = "admin"
+)
+
+// Roles maps every role to its permissions.
+var Roles = map[string][]string{
+	RoleReader: {PermConfigurationsRead, PermEventsRead},
+	RoleWriter: {PermConfigurationsRead, PermEventsRead, PermConfigurationsWrite, PermConfigurationsDelete},
+	RoleAdmin: {PermConfigurationsRead, PermEventsRead, PermConfigurationsWrite, PermConfigurationsDelete,
+		PermConfigurationsForce, PermWebhooksManage, PermAuditRead, PermRBACManage, PermSchemasManage},
+}
+
+func roleGrants(role, permission string) bool {
+	for _, p := range Roles[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// anonymousSubject is the subject of requests without an identity.
+const anonymousSubject = "anonymous"
+
+// Subject returns the subject role bindings of the caller of r refer to.
+func Subject(r *http.Request) string {
+	if identity := FromRequest(r); identity != nil {
+		return identity.String()
+	}
+	return anonymousSubject
+}
+
+// Target is a configuration a request reads or changes.
+type Target struct {
+	ID     string
+	Group  string
+	Labels config.Labels
+}
+
+func (t Target) String() string {
+	var parts []string
+	if t.ID != "" {
+		parts = append(parts, "configuration "+t.ID)
+	}
+	if t.Group != "" {
+		parts = append(parts, "group "+t.Group)
+	}
+	if len(t.Labels) != 0 {
+		parts = append(parts, "labels "+config.SelectorFromLabels(t.Labels).String())
+	}
+	return strings.Join(parts, ", ")
+}
+
+func scopeMatches(scope config.Scope, t Target) bool {
+	if scope.IDPrefix != "" && (t.ID == "" || !strings.HasPrefix(t.ID, scope.IDPrefix)) {
+		return false
+	}
+	if scope.Group != "" && t.Group != scope.Group {
+		return false
+	}
+	if scope.Labels != "" {
+		selector, err := config.ParseSelector(scope.Labels)
+		if err != nil || t.Labels == nil || !selector.Matches(t.Labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// BindingStore holds the role bindings, PostStore implements it.
+type BindingStore interface {
+	ListRoleBindings(ctx context.Context) ([]*config.RoleBinding, error)
+}
+
+// ForbiddenError names the permission the caller is missing.
+type ForbiddenError struct {
+	Subject    string `json:"subject"`
+	Permission string `json:"permission"`
+	Namespace  string `json:"namespace"`
+	// the target the permission is missing for, if any
+	Target string `json:"target,omitempty"`
+}
+
+func (e *ForbiddenError) Error() string {
+	if e.Target != "" {
+		return fmt.Sprintf("%s is missing permission %s for %s in namespace %s", e.Subject, e.Permission, e.Target, e.Namespace)
+	}
+	return fmt.Sprintf("%s is missing permission %s in namespace %s", e.Subject, e.Permission, e.Namespace)
+}
+
+// WriteForbidden writes a 403 response naming the missing permission.
+func WriteForbidden(w http.ResponseWriter, err *ForbiddenError) {
+	body, _ := json.Marshal(struct {
+		Error string `json:"error"`
+		*ForbiddenError
+	}{err.Error(), err})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	w.Write(body)
+}
+
+// Authorizer checks the caller's role bindings.
+type Authorizer struct {
+	Store BindingStore
+	// Admins are subjects that always have the admin role, so the first
+	// bindings can be created.
+	Admins map[string]bool
+}
+
+// bindingsFor returns the bindings of subject granting permission in the
+// namespace of ctx.
+func (a *Authorizer) bindingsFor(ctx context.Context, subject, permission string) ([]*config.RoleBinding, error) {
+	if a.Admins[subject] {
+		return []*config.RoleBinding{{Subject: subject, Role: RoleAdmin}}, nil
+	}
+
+	all, err := a.Store.ListRoleBindings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var bindings []*config.RoleBinding
+	for _, binding := range all {
+		if binding.Namespace != "" && binding.Namespace != namespace.FromContext(ctx) {
+			continue
+		}
+		if binding.Subject == subject && roleGrants(binding.Role, permission) {
+			bindings = append(bindings, binding)
+		}
+	}
+	return bindings, nil
+}
+
+// AllNamespaces is the namespace a role binding is created with to apply it
+// in every namespace. Such bindings are stored without a namespace.
+const AllNamespaces = "*"
+
+// Global reports whether the caller of r has permission in every namespace,
+// through Admins or an unscoped binding without a namespace. Only such
+// callers may act on other namespaces than the one of the request.
+func (a *Authorizer) Global(r *http.Request, permission string) (bool, error) {
+	subject := Subject(r)
+	if a.Admins[subject] {
+		return roleGrants(RoleAdmin, permission), nil
+	}
+
+	all, err := a.Store.ListRoleBindings(r.Context())
+	if err != nil {
+		return false, err
+	}
+	for _, binding := range all {
+		if binding.Namespace == "" && binding.Subject == subject && binding.Scope.Empty() &&
+			roleGrants(binding.Role, permission) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Authorize checks that the caller of r has permission for every target. The
+// targets are only resolved when a scoped binding has to be checked, since
+// that can mean reading them from the store. With no resolve function only
+// unscoped bindings grant the permission.
+func (a *Authorizer) Authorize(r *http.Request, permission string, resolve Resolver) error {
+	subject := Subject(r)
+	bindings, err := a.bindingsFor(r.Context(), subject, permission)
+	if err != nil {
+		return err
+	}
+
+	var scoped []config.Scope
+	for _, binding := range bindings {
+		if binding.Scope.Empty() {
+			return nil
+		}
+		scoped = append(scoped, binding.Scope)
+	}
+	forbidden := &ForbiddenError{Subject: subject, Permission: permission, Namespace: namespace.FromRequest(r)}
+	if len(scoped) == 0 || resolve == nil {
+		return forbidden
+	}
+
+	targets, err := resolve(r)
+	if err != nil {
+		return err
+	}
+	for _, t := range targets {
+		allowed := false
+		for _, scope := range scoped {
+			if scopeMatches(scope, t) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			forbidden.Target = t.String()
+			return forbidden
+		}
+	}
+	if len(targets) == 0 {
+		return forbidden
+	}
+	return nil
+}
+
+// AuthorizeTargets checks that the caller of r has permission for targets,
+// e.g. for a configuration a handler is about to store.
+func (a *Authorizer) AuthorizeTargets(r *http.Request, permission string, targets ...Target) error {
+	return a.Authorize(r, permission, func(*http.Request) ([]Target, error) {
+		return targets, nil
+	})
+}
+
+// Require wraps handler so it only runs if the caller has permission for the
+// targets resolve returns, it answers 403 otherwise.
+func (a *Authorizer) Require(permission string, resolve Resolver, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := a.Authorize(r, permission, resolve)
+		if forbidden, ok := err.(*ForbiddenError); ok {
+			WriteForbidden(w, forbidden)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// RolePermissions lists the roles with their permissions, sorted by role.
+func RolePermissions() []RoleInfo {
+	roles := make([]RoleInfo, 0, len(Roles))
+	for role, permissions := range Roles {
+		roles = append(roles, RoleInfo{Role: role, Permissions: permissions})
+	}
+	sort.Slice(roles, func(i, j int) bool {
+		return roles[i].Role < roles[j].Role
+	})
+	return roles
+}
+
+// RoleInfo describes a role.
+type RoleInfo struct {
+	Role        string   `json:"role"`
+	Permissions []string `json:"permissions"`
+}
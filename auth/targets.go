@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/poststore"
+	"github.com/gorilla/mux"
+)
+
+// Resolver returns the configurations a request reads or changes, so scoped
+// bindings can be checked against them.
+type Resolver func(r *http.Request) ([]Target, error)
+
+// maxPeekBytes bounds the body read by the body resolvers, the handlers
+// enforce their own limit.
+const maxPeekBytes = 1 << 20
+
+// ConfigStore is the part of the store the resolvers read.
+type ConfigStore interface {
+	GetConfiguration(ctx context.Context, id, version string) (*config.Config, error)
+	ListConfigurationVersions(ctx context.Context, id string) ([]*config.VersionInfo, error)
+	GetConfigurationGroup(ctx context.Context, id, version string) ([]*config.Config, error)
+	ListGroupVersions(ctx context.Context, id string) ([]string, error)
+}
+
+// Targets builds the resolvers of the routes.
+type Targets struct {
+	Store ConfigStore
+}
+
+func configTarget(c *config.Config) Target {
+	return Target{ID: c.ID, Group: c.GroupID, Labels: c.Labels}
+}
+
+// ConfigurationPath resolves the configuration in the {id} and {version} path
+// variables. Without a version, e.g. on /configurations/{id}, the latest one
+// is used. A configuration that doesn't exist only has its ID.
+func (t *Targets) ConfigurationPath(r *http.Request) ([]Target, error) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	version := vars["version"]
+	if version == "" {
+		version = config.LatestVersion
+	}
+
+	if version == config.LatestVersion {
+		versions, err := t.Store.ListConfigurationVersions(r.Context(), id)
+		if err != nil {
+			return nil, err
+		}
+		if len(versions) == 0 {
+			return []Target{{ID: id}}, nil
+		}
+		version = versions[len(versions)-1].Version
+	}
+
+	c, err := t.Store.GetConfiguration(r.Context(), id, version)
+	if errors.Is(err, poststore.ErrNotFound) {
+		return []Target{{ID: id}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []Target{configTarget(c)}, nil
+}
+
+// groupMembers returns the members of the group in the path, none if it
+// doesn't exist.
+func (t *Targets) groupMembers(r *http.Request) ([]*config.Config, error) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	version := vars["version"]
+	if version == "" {
+		version = config.LatestVersion
+	}
+
+	if version == config.LatestVersion {
+		versions, err := t.Store.ListGroupVersions(r.Context(), id)
+		if err != nil {
+			return nil, err
+		}
+		if len(versions) == 0 {
+			return nil, nil
+		}
+		version = versions[len(versions)-1]
+	}
+
+	members, err := t.Store.GetConfigurationGroup(r.Context(), id, version)
+	if errors.Is(err, poststore.ErrNotFound) {
+		return nil, nil
+	}
+	return members, err
+}
+
+// GroupPath resolves every member of the group in the {id} and {version}
+// path variables, the latest version without one.
+func (t *Targets) GroupPath(r *http.Request) ([]Target, error) {
+	members, err := t.groupMembers(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(members) == 0 {
+		return []Target{{Group: mux.Vars(r)["id"]}}, nil
+	}
+	targets := make([]Target, 0, len(members))
+	for _, member := range members {
+		targets = append(targets, configTarget(member))
+	}
+	return targets, nil
+}
+
+// GroupPathLabels resolves the members of the group in the path matching the
+// {labels} selector, only those are returned by the route.
+func (t *Targets) GroupPathLabels(r *http.Request) ([]Target, error) {
+	selector, err := config.ParseSelector(mux.Vars(r)["labels"])
+	if err != nil {
+		// the handler rejects the selector
+		return []Target{{Group: mux.Vars(r)["id"]}}, nil
+	}
+	members, err := t.groupMembers(r)
+	if err != nil {
+		return nil, err
+	}
+	targets := []Target{}
+	for _, member := range members {
+		if selector.Matches(member.Labels) {
+			targets = append(targets, configTarget(member))
+		}
+	}
+	if len(targets) == 0 {
+		targets = append(targets, Target{Group: mux.Vars(r)["id"]})
+	}
+	return targets, nil
+}
+
+// peekBody decodes the body into v and puts it back for the handler. A body
+// that doesn't decode leaves v empty.
+func peekBody(r *http.Request, v interface{}) error {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxPeekBytes+1))
+	if err != nil {
+		return err
+	}
+	r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+	json.Unmarshal(body, v)
+	return nil
+}
+
+// ConfigurationBody resolves the configuration in the request body.
+func (t *Targets) ConfigurationBody(r *http.Request) ([]Target, error) {
+	c := &config.Config{}
+	err := peekBody(r, c)
+	if err != nil {
+		return nil, err
+	}
+	return []Target{configTarget(c)}, nil
+}
+
+// GroupBody resolves the configurations in the request body.
+func (t *Targets) GroupBody(r *http.Request) ([]Target, error) {
+	var configs []*config.Config
+	err := peekBody(r, &configs)
+	if err != nil {
+		return nil, err
+	}
+	targets := make([]Target, 0, len(configs))
+	for _, c := range configs {
+		if c != nil {
+			targets = append(targets, configTarget(c))
+		}
+	}
+	return targets, nil
+}
+
+// ExtendBody resolves the configurations added to the group in the path.
+func (t *Targets) ExtendBody(r *http.Request) ([]Target, error) {
+	targets, err := t.GroupBody(r)
+	for i := range targets {
+		targets[i].Group = mux.Vars(r)["id"]
+	}
+	return targets, err
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/auth"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/namespace"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/poststore"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/service"
+)
+
+// runCommand runs a one-shot maintenance command instead of the server, e.g.
+//
+//	./main migrate-groups
+//	./main verify-audit
+//	./main create-api-key <subject>
+//	./main list-api-keys
+//	./main revoke-api-key <id>
+//	./main count-quota [namespace]
+func runCommand(ps *poststore.PostStore, args []string) error {
+	ctx := context.Background()
+
+	switch args[0] {
+	case "migrate-groups":
+		moved, err := ps.MigrateGroups(ctx)
+		log.Printf("migrated %d group keys", moved)
+		return err
+	case "verify-audit":
+		return verifyAudit(ctx, ps)
+	case "create-api-key":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: create-api-key <subject>")
+		}
+		key, apiKey, err := auth.NewAPIKey(args[1])
+		if err == nil {
+			err = ps.AddAPIKey(ctx, apiKey)
+		}
+		if err != nil {
+			return err
+		}
+		// the key isn't stored, this is the only time it is shown
+		fmt.Printf("id:  %s\nkey: %s\n", apiKey.ID, key)
+		return nil
+	case "list-api-keys":
+		apiKeys, err := ps.ListAPIKeys(ctx)
+		if err != nil {
+			return err
+		}
+		for _, apiKey := range apiKeys {
+			fmt.Printf("%s  %s  %s\n", apiKey.ID, apiKey.CreatedAt.Format(time.RFC3339), apiKey.Subject)
+		}
+		return nil
+	case "revoke-api-key":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: revoke-api-key <id>")
+		}
+		return ps.DeleteAPIKey(ctx, args[1])
+	case "count-quota":
+		// repairs usage that drifted, a namespace that has none yet, e.g. one
+		// written before quotas existed, is also counted by its first write
+		if len(args) > 2 {
+			return fmt.Errorf("usage: count-quota [namespace]")
+		}
+		ns := namespace.Default
+		if len(args) == 2 {
+			ns = args[1]
+		}
+		if err := namespace.Validate(ns); err != nil {
+			return err
+		}
+		counts, err := ps.CountQuotaUsage(namespace.With(ctx, ns))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("namespace %s: %d configs, %d groups, %d bytes\n", ns, counts.Configs, counts.Groups, counts.Bytes)
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// verifyAudit checks the audit chain, and the checkpoint signatures when
+// AUDIT_SIGNING_KEY is set.
+func verifyAudit(ctx context.Context, ps *poststore.PostStore) error {
+	var publicKey ed25519.PublicKey
+	if path := os.Getenv("AUDIT_SIGNING_KEY"); path != "" {
+		key, err := service.LoadAuditKey(path)
+		if err != nil {
+			return err
+		}
+		publicKey = key.Public().(ed25519.PublicKey)
+	}
+
+	result, err := service.VerifyAuditChain(ctx, ps, publicKey)
+	if err != nil {
+		return err
+	}
+	log.Printf("checked %d audit records and %d checkpoints", result.Records, result.Checkpoints)
+	if !result.SignaturesChecked {
+		log.Printf("AUDIT_SIGNING_KEY is not set, checkpoint signatures were not checked")
+	}
+	if !result.Valid {
+		return fmt.Errorf("audit chain broken at record %d: %s", result.BrokenAt, result.Reason)
+	}
+	log.Printf("audit chain is intact")
+	return nil
+}
@@ -0,0 +1,19 @@
+package config
+
+import "time"
+
+// APIKey is a static API key. Only the SHA-256 of the key is stored, the key
+// itself is shown once when it is created.
+type APIKey struct {
+	// ID of the key, used to revoke it
+	ID string `json:"id"`
+
+	// Identity of the callers using the key
+	Subject string `json:"subject"`
+
+	// Hex SHA-256 of the key
+	Hash string `json:"hash"`
+
+	// Time the key was created
+	CreatedAt time.Time `json:"created_at"`
+}
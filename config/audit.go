@@ -0,0 +1,161 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Kinds of audited targets.
+const (
+	TargetConfiguration = "configuration"
+	TargetGroup         = "group"
+	TargetWebhook       = "webhook"
+	TargetRoleBinding   = "role_binding"
+	TargetSchema        = "schema"
+)
+
+// Audit actions that aren't change events.
+const (
+	WebhookCreated = "webhook.created"
+	WebhookDeleted = "webhook.deleted"
+
+	RoleBindingCreated = "role_binding.created"
+	RoleBindingDeleted = "role_binding.deleted"
+
+	SchemaCreated = "schema.created"
+	SchemaDeleted = "schema.deleted"
+)
+
+// swagger:model AuditRecord
+type AuditRecord struct {
+	// Position of the record in the audit log, starting at 1. It is the
+	// cursor for the next page.
+	// in: int
+	Seq uint64 `json:"seq"`
+
+	// Time the change was made
+	// in: time
+	Time time.Time `json:"time"`
+
+	// Who made the change
+	// in: string
+	Actor string `json:"actor"`
+
+	// Namespace the request was made in
+	// in: string
+	Namespace string `json:"namespace,omitempty"`
+
+	// Address the request came from
+	// in: string
+	RemoteAddr string `json:"remote_addr,omitempty"`
+
+	// What was done, the event type for configuration and group changes
+	// in: string
+	Action string `json:"action"`
+
+	// Kind of the target: configuration, group or webhook
+	// in: string
+	TargetKind string `json:"target_kind"`
+
+	// ID of the changed configuration, group or webhook
+	// in: string
+	TargetID string `json:"target_id"`
+
+	// Version of the changed configuration or group
+	// in: string
+	TargetVersion string `json:"target_version,omitempty"`
+
+	// X-Request-ID of the request
+	// in: string
+	RequestID string `json:"request_id"`
+
+	// Trace the request was handled in
+	// in: string
+	TraceID string `json:"trace_id,omitempty"`
+
+	// SHA-256 of the target before the change, empty if it didn't exist
+	// in: string
+	BeforeHash string `json:"before_hash,omitempty"`
+
+	// SHA-256 of the target after the change, empty if it was deleted
+	// in: string
+	AfterHash string `json:"after_hash,omitempty"`
+
+	// Additional details, e.g. the source version of a promotion
+	// in: map[string]string
+	Details map[string]string `json:"details,omitempty"`
+
+	// Hash of the previous record, empty for the first one
+	// in: string
+	PrevHash string `json:"prev_hash"`
+
+	// SHA-256 of the record with this field left empty. Together with
+	// PrevHash it chains the records, changing one breaks every later link.
+	// in: string
+	Hash string `json:"hash"`
+}
+
+// ComputeHash returns the hash the record should have.
+func (r *AuditRecord) ComputeHash() string {
+	unhashed := *r
+	unhashed.Hash = ""
+	// encoding/json sorts map keys, so the encoding is stable
+	data, _ := json.Marshal(&unhashed)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// swagger:model AuditCheckpoint
+type AuditCheckpoint struct {
+	// Sequence number of the anchored record
+	// in: int
+	Seq uint64 `json:"seq"`
+
+	// Hash of the anchored record
+	// in: string
+	Hash string `json:"hash"`
+
+	// Time the checkpoint was signed
+	// in: time
+	Time time.Time `json:"time"`
+
+	// Ed25519 signature of SignedMessage
+	// in: []byte
+	Signature []byte `json:"signature"`
+}
+
+// SignedMessage is what the checkpoint signature covers.
+func (c *AuditCheckpoint) SignedMessage() []byte {
+	return []byte(fmt.Sprintf("audit-checkpoint:%d:%s:%s", c.Seq, c.Hash, c.Time.UTC().Format(time.RFC3339Nano)))
+}
+
+// swagger:model AuditVerification
+type AuditVerification struct {
+	// Whether the whole chain is intact
+	// in: bool
+	Valid bool `json:"valid"`
+
+	// Number of records checked
+	// in: int
+	Records uint64 `json:"records"`
+
+	// Number of checkpoints checked
+	// in: int
+	Checkpoints int `json:"checkpoints"`
+
+	// Whether checkpoint signatures were checked, they can't be without the
+	// signing key
+	// in: bool
+	SignaturesChecked bool `json:"signatures_checked"`
+
+	// Sequence number of the first broken link
+	// in: int
+	BrokenAt uint64 `json:"broken_at,omitempty"`
+
+	// Why the link is broken
+	// in: string
+	Reason string `json:"reason,omitempty"`
+}
@@ -28,9 +28,17 @@ type Config struct {
 
 	//List of labels of the config
 	//in: map[string]string
-	Labels string `json:"labels"`
+	Labels Labels `json:"labels"`
 
 	// Idempotency key associated with the configuration
 	// in: string
 	IdempotencyKey string `json:"idempotency_key"`
+
+	// Version this one was promoted from, set by the promote endpoints
+	// in: string
+	PromotedFrom string `json:"promoted_from,omitempty"`
+
+	// Consul ModifyIndex of the stored configuration, set when it is read.
+	// Not part of the stored or returned JSON.
+	ModifyIndex uint64 `json:"-"`
 }
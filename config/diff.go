@@ -0,0 +1,369 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ValueChange is a value that differs between two versions.
+type ValueChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// MapDiff lists the keys added, removed and changed between two maps.
+type MapDiff struct {
+	Added   map[string]string      `json:"added,omitempty"`
+	Removed map[string]string      `json:"removed,omitempty"`
+	Changed map[string]ValueChange `json:"changed,omitempty"`
+}
+
+// DiffMaps compares the map of an old version with the one of a new version.
+func DiffMaps(from, to map[string]string) MapDiff {
+	d := MapDiff{}
+	for k, v := range from {
+		newValue, ok := to[k]
+		switch {
+		case !ok:
+			if d.Removed == nil {
+				d.Removed = make(map[string]string)
+			}
+			d.Removed[k] = v
+		case newValue != v:
+			if d.Changed == nil {
+				d.Changed = make(map[string]ValueChange)
+			}
+			d.Changed[k] = ValueChange{From: v, To: newValue}
+		}
+	}
+	for k, v := range to {
+		if _, ok := from[k]; !ok {
+			if d.Added == nil {
+				d.Added = make(map[string]string)
+			}
+			d.Added[k] = v
+		}
+	}
+	return d
+}
+
+// Empty reports whether both maps were equal.
+func (d MapDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// swagger:model ConfigDiff
+type ConfigDiff struct {
+	// ID of the configuration
+	// in: string
+	ID string `json:"id"`
+
+	// Version compared from
+	// in: string
+	From string `json:"from"`
+
+	// Version compared to
+	// in: string
+	To string `json:"to"`
+
+	// Set if the name changed
+	// in: ValueChange
+	Name *ValueChange `json:"name,omitempty"`
+
+	// Entry changes
+	// in: MapDiff
+	Entries MapDiff `json:"entries"`
+
+	// Label changes
+	// in: MapDiff
+	Labels MapDiff `json:"labels"`
+}
+
+// DiffConfigs compares two versions of a configuration.
+func DiffConfigs(from, to *Config) *ConfigDiff {
+	d := &ConfigDiff{
+		ID:      to.ID,
+		From:    from.Version,
+		To:      to.Version,
+		Entries: DiffMaps(from.Entries, to.Entries),
+		Labels:  DiffMaps(from.Labels, to.Labels),
+	}
+	if from.Name != to.Name {
+		d.Name = &ValueChange{From: from.Name, To: to.Name}
+	}
+	return d
+}
+
+// Empty reports whether both versions have the same content.
+func (d *ConfigDiff) Empty() bool {
+	return d.Name == nil && d.Entries.Empty() && d.Labels.Empty()
+}
+
+// swagger:model GroupDiff
+type GroupDiff struct {
+	// ID of the group
+	// in: string
+	ID string `json:"id"`
+
+	// Version compared from
+	// in: string
+	From string `json:"from"`
+
+	// Version compared to
+	// in: string
+	To string `json:"to"`
+
+	// IDs of the configurations that joined the group
+	// in: []string
+	Added []string `json:"added"`
+
+	// IDs of the configurations that left the group
+	// in: []string
+	Removed []string `json:"removed"`
+
+	// Configurations in both versions whose content changed
+	// in: []ConfigDiff
+	Changed []*ConfigDiff `json:"changed"`
+}
+
+// DiffGroups compares the members of two versions of a group. Members are
+// matched by their configuration ID.
+func DiffGroups(id, fromVersion, toVersion string, from, to []*Config) *GroupDiff {
+	d := &GroupDiff{
+		ID:      id,
+		From:    fromVersion,
+		To:      toVersion,
+		Added:   make([]string, 0),
+		Removed: make([]string, 0),
+		Changed: make([]*ConfigDiff, 0),
+	}
+
+	old := configsByID(from)
+	current := configsByID(to)
+	for _, memberID := range sortedConfigIDs(old) {
+		if _, ok := current[memberID]; !ok {
+			d.Removed = append(d.Removed, memberID)
+		}
+	}
+	for _, memberID := range sortedConfigIDs(current) {
+		before, ok := old[memberID]
+		if !ok {
+			d.Added = append(d.Added, memberID)
+			continue
+		}
+		change := DiffConfigs(before, current[memberID])
+		if !change.Empty() {
+			d.Changed = append(d.Changed, change)
+		}
+	}
+
+	return d
+}
+
+// Empty reports whether both versions have the same members and content.
+func (d *GroupDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+func configsByID(configs []*Config) map[string]*Config {
+	byID := make(map[string]*Config, len(configs))
+	for _, c := range configs {
+		byID[c.ID] = c
+	}
+	return byID
+}
+
+func sortedConfigIDs(configs map[string]*Config) []string {
+	ids := make([]string, 0, len(configs))
+	for id := range configs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// The unified diff renders every version as sorted lines, one per name,
+// label and entry:
+//
+//	[member-id]           group members only
+//	name = "..."
+//	labels.env = "prod"
+//	entries.db = "..."
+//
+// Both sides are sorted the same way, so the lines can be diffed with a
+// merge instead of a general purpose diff algorithm.
+
+const diffContext = 3
+
+type diffLine struct {
+	member  string
+	section int
+	key     string
+	text    string
+}
+
+func (l diffLine) less(o diffLine) bool {
+	if l.member != o.member {
+		return l.member < o.member
+	}
+	if l.section != o.section {
+		return l.section < o.section
+	}
+	return l.key < o.key
+}
+
+func (l diffLine) sameKey(o diffLine) bool {
+	return l.member == o.member && l.section == o.section && l.key == o.key
+}
+
+func configLines(c *Config, member bool) []diffLine {
+	var lines []diffLine
+	memberID := ""
+	if member {
+		memberID = c.ID
+		lines = append(lines, diffLine{member: memberID, text: "[" + c.ID + "]"})
+	}
+	lines = append(lines, diffLine{member: memberID, section: 1, text: "name = " + strconv.Quote(c.Name)})
+	for _, k := range sortedKeys(c.Labels) {
+		lines = append(lines, diffLine{member: memberID, section: 2, key: k, text: "labels." + k + " = " + strconv.Quote(c.Labels[k])})
+	}
+	for _, k := range sortedKeys(c.Entries) {
+		lines = append(lines, diffLine{member: memberID, section: 3, key: k, text: "entries." + k + " = " + strconv.Quote(c.Entries[k])})
+	}
+	return lines
+}
+
+func groupLines(configs []*Config) []diffLine {
+	byID := configsByID(configs)
+	var lines []diffLine
+	for _, id := range sortedConfigIDs(byID) {
+		lines = append(lines, configLines(byID[id], true)...)
+	}
+	return lines
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// UnifiedConfigDiff renders the difference between two configuration
+// versions as a unified diff.
+func UnifiedConfigDiff(from, to *Config) string {
+	return unifiedDiff(
+		"configurations/"+from.ID+"/"+from.Version,
+		"configurations/"+to.ID+"/"+to.Version,
+		configLines(from, false),
+		configLines(to, false),
+	)
+}
+
+// UnifiedGroupDiff renders the difference between two group versions as a
+// unified diff.
+func UnifiedGroupDiff(id, fromVersion, toVersion string, from, to []*Config) string {
+	return unifiedDiff(
+		"group/"+id+"/"+fromVersion,
+		"group/"+id+"/"+toVersion,
+		groupLines(from),
+		groupLines(to),
+	)
+}
+
+type diffOp struct {
+	kind byte
+	text string
+}
+
+func unifiedDiff(fromName, toName string, from, to []diffLine) string {
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(from) || j < len(to) {
+		switch {
+		case j == len(to) || (i < len(from) && from[i].less(to[j])):
+			ops = append(ops, diffOp{'-', from[i].text})
+			i++
+		case i == len(from) || to[j].less(from[i]):
+			ops = append(ops, diffOp{'+', to[j].text})
+			j++
+		case from[i].sameKey(to[j]) && from[i].text == to[j].text:
+			ops = append(ops, diffOp{' ', from[i].text})
+			i++
+			j++
+		default:
+			ops = append(ops, diffOp{'-', from[i].text}, diffOp{'+', to[j].text})
+			i++
+			j++
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", fromName, toName)
+
+	// line numbers before each op on both sides
+	fromLine, toLine := make([]int, len(ops)+1), make([]int, len(ops)+1)
+	for k, op := range ops {
+		fromLine[k+1], toLine[k+1] = fromLine[k], toLine[k]
+		if op.kind != '+' {
+			fromLine[k+1]++
+		}
+		if op.kind != '-' {
+			toLine[k+1]++
+		}
+	}
+
+	for k := 0; k < len(ops); {
+		if ops[k].kind == ' ' {
+			k++
+			continue
+		}
+
+		// a hunk starts diffContext lines before the change and ends once
+		// more than twice that many unchanged lines follow
+		start := k - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end, unchanged := k, 0
+		for end < len(ops) && unchanged <= 2*diffContext {
+			if ops[end].kind == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+			end++
+		}
+		if unchanged > diffContext {
+			end -= unchanged - diffContext
+		}
+
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n",
+			hunkRange(fromLine[start], fromLine[end]-fromLine[start]),
+			hunkRange(toLine[start], toLine[end]-toLine[start]))
+		for _, op := range ops[start:end] {
+			b.WriteByte(op.kind)
+			b.WriteString(op.text)
+			b.WriteByte('\n')
+		}
+		k = end
+	}
+
+	return b.String()
+}
+
+// hunkRange formats a hunk range the way diff -u does, an empty range refers
+// to the line before it.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return strconv.Itoa(start + 1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
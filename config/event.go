@@ -0,0 +1,71 @@
+package config
+
+import "time"
+
+// Event types published for every successful write.
+const (
+	ConfigurationCreated = "configuration.created"
+	ConfigurationDeleted = "configuration.deleted"
+	GroupCreated         = "group.created"
+	GroupExtended        = "group.extended"
+	GroupDeleted         = "group.deleted"
+	// promotions create a new version copied from PromotedFrom
+	ConfigurationPromoted = "configuration.promoted"
+	GroupPromoted         = "group.promoted"
+)
+
+// swagger:model Event
+type Event struct {
+	// ID of the event, increasing in the order the events were stored. It is
+	// sent as the SSE event id and can be resumed from with Last-Event-ID.
+	// in: int
+	ID uint64 `json:"id"`
+
+	// Type of the change, e.g. "configuration.created"
+	// in: string
+	Type string `json:"type"`
+
+	// Namespace of the changed configurations
+	// in: string
+	Namespace string `json:"namespace,omitempty"`
+
+	// ID of the group for group events
+	// in: string
+	GroupID string `json:"group_id,omitempty"`
+
+	// Version of the configuration or group
+	// in: string
+	Version string `json:"version"`
+
+	// Source version of a promotion
+	// in: string
+	PromotedFrom string `json:"promoted_from,omitempty"`
+
+	// Configurations affected by the change
+	// in: []EventConfig
+	Configs []EventConfig `json:"configs,omitempty"`
+
+	// Time the change was made
+	// in: time
+	Time time.Time `json:"time"`
+}
+
+// EventConfig identifies a configuration touched by an event.
+type EventConfig struct {
+	ID     string `json:"id"`
+	Labels Labels `json:"labels,omitempty"`
+}
+
+// NewEvent returns an event of the given type for the configurations.
+func NewEvent(eventType, groupID, version string, configs ...*Config) *Event {
+	e := &Event{
+		Type:    eventType,
+		GroupID: groupID,
+		Version: version,
+		Time:    time.Now().UTC(),
+	}
+	for _, c := range configs {
+		e.Configs = append(e.Configs, EventConfig{ID: c.ID, Labels: c.Labels})
+	}
+	return e
+}
@@ -0,0 +1,44 @@
+package config
+
+import "time"
+
+// swagger:model Group
+type Group struct {
+	// ID of the group
+	// in: string
+	ID string `json:"id"`
+
+	// Version of the group
+	// in: string
+	Version string `json:"version"`
+
+	// IDs of the configurations in the group, in the order they were added
+	// in: []string
+	Members []string `json:"members"`
+
+	// Time the group version was created
+	// in: time
+	CreatedAt time.Time `json:"created_at"`
+
+	// Time the group version was last extended
+	// in: time
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// Version this one was promoted from, set by the promote endpoint
+	// in: string
+	PromotedFrom string `json:"promoted_from,omitempty"`
+
+	// Consul ModifyIndex of the manifest, set when it is read. Every change
+	// of the group version updates the manifest.
+	ModifyIndex uint64 `json:"-"`
+}
+
+// HasMember reports whether a configuration ID is part of the group.
+func (g *Group) HasMember(id string) bool {
+	for _, m := range g.Members {
+		if m == id {
+			return true
+		}
+	}
+	return false
+}
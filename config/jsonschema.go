@@ -0,0 +1,710 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// JSONSchema is a compiled JSON Schema for entries documents, objects whose
+// members are strings. It implements the validation keywords of draft
+// 2020-12 that apply to them:
+//
+//	type enum const $ref ($defs, definitions)
+//	allOf anyOf oneOf not if then else
+//	properties patternProperties additionalProperties required
+//	propertyNames minProperties maxProperties dependentRequired
+//	minLength maxLength pattern format
+//
+// format checks date-time, date, email, hostname, ipv4, ipv6 and uri. The
+// keywords of arrays and numbers, and keywords applied to a value they can't
+// apply to, are rejected since they could never pass or never run. Other
+// keywords are ignored, as the specification requires for unknown ones.
+type JSONSchema struct {
+	root     interface{}
+	patterns map[string]*regexp.Regexp
+}
+
+// SchemaViolation is a value that doesn't match a schema.
+type SchemaViolation struct {
+	// JSON Pointer of the value
+	Pointer string `json:"pointer"`
+	// Keyword that failed, e.g. "required"
+	Keyword string `json:"keyword"`
+	Message string `json:"message"`
+}
+
+// maxSchemaDepth bounds the nesting of subschemas and $ref chains.
+const maxSchemaDepth = 64
+
+// maxSchemaSize bounds the number of subschemas a schema has with every
+// reference replaced by its target, which is what a value is checked against
+// at most. Without it a few definitions referring twice to the next one
+// would multiply the work of every validation.
+const maxSchemaSize = 10000
+
+var jsonTypes = []string{"null", "boolean", "object", "array", "number", "integer", "string"}
+
+// CompileJSONSchema parses a schema and checks that every keyword it
+// implements has a value of the right type, patterns compile and references
+// resolve. References must not form a cycle and the schema must not have more
+// than maxSchemaSize subschemas with the references expanded.
+func CompileJSONSchema(data []byte) (*JSONSchema, error) {
+	root, err := decodeJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("schema is not valid JSON: %w", err)
+	}
+	s := &JSONSchema{root: root, patterns: make(map[string]*regexp.Regexp)}
+	if err := s.check(root, nil, 0); err != nil {
+		return nil, err
+	}
+	if _, err := s.checkExpanded(root, documentKind, nil, make(map[string]int), make(map[string]bool)); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func schemaError(path []string, format string, args ...interface{}) error {
+	pointer := ""
+	if len(path) > 0 {
+		pointer = pointerString(path) + ": "
+	}
+	return fmt.Errorf("invalid schema: "+pointer+format, args...)
+}
+
+func (s *JSONSchema) check(schema interface{}, path []string, depth int) error {
+	if depth > maxSchemaDepth {
+		return schemaError(path, "nested more than %d levels", maxSchemaDepth)
+	}
+	if _, ok := schema.(bool); ok {
+		return nil
+	}
+	object, ok := schema.(map[string]interface{})
+	if !ok {
+		return schemaError(path, "a schema must be an object or a boolean")
+	}
+	at := func(keys ...string) []string {
+		return append(append([]string{}, path...), keys...)
+	}
+
+	for _, keyword := range memberNames(object) {
+		value := object[keyword]
+		var err error
+		switch keyword {
+		case "type":
+			err = checkType(value, at(keyword))
+		case "enum":
+			if _, ok := value.([]interface{}); !ok {
+				err = schemaError(at(keyword), "must be an array")
+			}
+		case "$ref":
+			ref, ok := value.(string)
+			if !ok {
+				err = schemaError(at(keyword), "must be a string")
+			} else if _, err = s.resolve(ref); err != nil {
+				err = schemaError(at(keyword), "%v", err)
+			}
+		case "$defs", "definitions", "properties", "patternProperties", "dependentRequired":
+			members, ok := value.(map[string]interface{})
+			if !ok {
+				err = schemaError(at(keyword), "must be an object")
+				break
+			}
+			for _, name := range memberNames(members) {
+				switch keyword {
+				case "patternProperties":
+					if _, err = s.pattern(name); err != nil {
+						err = schemaError(at(keyword, name), "%v", err)
+					}
+				case "dependentRequired":
+					err = checkStrings(members[name], at(keyword, name))
+				}
+				if err == nil && keyword != "dependentRequired" {
+					err = s.check(members[name], at(keyword, name), depth+1)
+				}
+				if err != nil {
+					break
+				}
+			}
+		case "allOf", "anyOf", "oneOf":
+			list, ok := value.([]interface{})
+			if !ok || len(list) == 0 {
+				err = schemaError(at(keyword), "must be a non-empty array")
+				break
+			}
+			for i, sub := range list {
+				if err = s.check(sub, at(keyword, fmt.Sprint(i)), depth+1); err != nil {
+					break
+				}
+			}
+		case "not", "if", "then", "else", "additionalProperties", "propertyNames":
+			err = s.check(value, at(keyword), depth+1)
+		case "required":
+			err = checkStrings(value, at(keyword))
+		case "minProperties", "maxProperties", "minLength", "maxLength":
+			if n, ok := number(value); !ok || !n.IsInt() || n.Sign() < 0 {
+				err = schemaError(at(keyword), "must be a non-negative integer")
+			}
+		case "pattern":
+			pattern, ok := value.(string)
+			if !ok {
+				err = schemaError(at(keyword), "must be a string")
+			} else if _, err = s.pattern(pattern); err != nil {
+				err = schemaError(at(keyword), "%v", err)
+			}
+		case "format":
+			if _, ok := value.(string); !ok {
+				err = schemaError(at(keyword), "must be a string")
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Entries documents are objects whose members are strings, so the root of a
+// schema applies to an object and the subschemas of its members and property
+// names to strings.
+const (
+	documentKind = "object"
+	entryKind    = "string"
+)
+
+func describeKind(kind string) string {
+	if kind == documentKind {
+		return "the entries document is an object"
+	}
+	return "entry values are strings"
+}
+
+// checkExpanded checks that the keywords of schema apply to values of kind,
+// following every reference, and returns the number of subschemas it has
+// with the references expanded. sizes holds the sizes of the references
+// already expanded and active the references being expanded, a reference
+// found again while it is active is a cycle. check must have accepted the
+// schema.
+func (s *JSONSchema) checkExpanded(schema interface{}, kind string, path []string, sizes map[string]int, active map[string]bool) (int, error) {
+	object, ok := schema.(map[string]interface{})
+	if !ok {
+		return 1, nil
+	}
+	at := func(keys ...string) []string {
+		return append(append([]string{}, path...), keys...)
+	}
+
+	total := 1
+	add := func(sub interface{}, kind string, path []string) error {
+		n, err := s.checkExpanded(sub, kind, path, sizes, active)
+		if err != nil {
+			return err
+		}
+		total += n
+		if total > maxSchemaSize {
+			return schemaError(path, "has more than %d subschemas with the references expanded", maxSchemaSize)
+		}
+		return nil
+	}
+
+	for _, keyword := range memberNames(object) {
+		value := object[keyword]
+		var err error
+		switch keyword {
+		case "type":
+			if !allowsType(value, kind) {
+				err = schemaError(at(keyword), "%s, the type must allow %q", describeKind(kind), kind)
+			}
+		case "const":
+			if _, ok := value.(string); !ok && kind == entryKind {
+				err = schemaError(at(keyword), "%s, the value must be a string", describeKind(kind))
+			}
+		case "enum":
+			if kind == entryKind && !containsString(value.([]interface{})) {
+				err = schemaError(at(keyword), "%s, the values must contain a string", describeKind(kind))
+			}
+		case "$ref":
+			ref := value.(string)
+			key := kind + " " + ref
+			n, ok := sizes[key]
+			if !ok {
+				if active[key] {
+					return 0, schemaError(at(keyword), "reference %q is part of a cycle", ref)
+				}
+				// errors point into the target, where the cycle or the
+				// fan-out is
+				target, _ := s.resolve(ref)
+				targetPath, _ := parsePointer(strings.TrimPrefix(ref, "#"))
+				active[key] = true
+				n, err = s.checkExpanded(target, kind, targetPath, sizes, active)
+				delete(active, key)
+				if err != nil {
+					return 0, err
+				}
+				sizes[key] = n
+			}
+			total += n
+			if total > maxSchemaSize {
+				err = schemaError(at(keyword), "has more than %d subschemas with the references expanded", maxSchemaSize)
+			}
+		case "allOf", "anyOf", "oneOf":
+			for i, sub := range value.([]interface{}) {
+				if err = add(sub, kind, at(keyword, fmt.Sprint(i))); err != nil {
+					break
+				}
+			}
+		case "not", "if", "then", "else":
+			err = add(value, kind, at(keyword))
+		case "properties", "patternProperties":
+			if kind != documentKind {
+				err = schemaError(at(keyword), "doesn't apply, %s", describeKind(kind))
+				break
+			}
+			members := value.(map[string]interface{})
+			for _, name := range memberNames(members) {
+				if err = add(members[name], entryKind, at(keyword, name)); err != nil {
+					break
+				}
+			}
+		case "additionalProperties", "propertyNames":
+			if kind != documentKind {
+				err = schemaError(at(keyword), "doesn't apply, %s", describeKind(kind))
+				break
+			}
+			err = add(value, entryKind, at(keyword))
+		case "required", "dependentRequired", "minProperties", "maxProperties":
+			if kind != documentKind {
+				err = schemaError(at(keyword), "doesn't apply, %s", describeKind(kind))
+			}
+		case "minLength", "maxLength", "pattern", "format":
+			if kind != entryKind {
+				err = schemaError(at(keyword), "doesn't apply, %s", describeKind(kind))
+			}
+		case "items", "minItems", "maxItems", "uniqueItems",
+			"minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum", "multipleOf":
+			// arrays and numbers never occur in an entries document
+			err = schemaError(at(keyword), "doesn't apply, %s", describeKind(kind))
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return total, nil
+}
+
+// allowsType reports whether the value of a type keyword allows name.
+func allowsType(types interface{}, name string) bool {
+	names, ok := types.([]interface{})
+	if !ok {
+		names = []interface{}{types}
+	}
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []interface{}) bool {
+	for _, v := range values {
+		if _, ok := v.(string); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func checkType(value interface{}, path []string) error {
+	names, ok := value.([]interface{})
+	if !ok {
+		names = []interface{}{value}
+	}
+	for _, name := range names {
+		if n, ok := name.(string); !ok || !contains(jsonTypes, n) {
+			return schemaError(path, "must be one of %s or an array of them", strings.Join(jsonTypes, ", "))
+		}
+	}
+	return nil
+}
+
+func checkStrings(value interface{}, path []string) error {
+	list, ok := value.([]interface{})
+	if !ok {
+		return schemaError(path, "must be an array of strings")
+	}
+	for _, item := range list {
+		if _, ok := item.(string); !ok {
+			return schemaError(path, "must be an array of strings")
+		}
+	}
+	return nil
+}
+
+func (s *JSONSchema) pattern(pattern string) (*regexp.Regexp, error) {
+	if re, ok := s.patterns[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	s.patterns[pattern] = re
+	return re, nil
+}
+
+// resolve returns the subschema a local reference such as "#/$defs/port"
+// points to. References to other documents aren't supported.
+func (s *JSONSchema) resolve(ref string) (interface{}, error) {
+	pointer, ok := strings.CutPrefix(ref, "#")
+	if !ok {
+		return nil, fmt.Errorf("only references within the schema (\"#...\") are supported, not %q", ref)
+	}
+	path, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	target, err := getValue(s.root, path)
+	if err != nil {
+		return nil, fmt.Errorf("reference %q: %w", ref, err)
+	}
+	return target, nil
+}
+
+// Validate returns every violation of the schema by value, a document decoded
+// into interface{} values. The pointers are relative to value.
+func (s *JSONSchema) Validate(value interface{}) []SchemaViolation {
+	violations := make([]SchemaViolation, 0)
+	s.validate(s.root, value, nil, 0, &violations)
+	return violations
+}
+
+func (s *JSONSchema) validate(schema, value interface{}, path []string, depth int, out *[]SchemaViolation) {
+	add := func(path []string, keyword, format string, args ...interface{}) {
+		pointer := ""
+		if len(path) > 0 {
+			pointer = pointerString(path)
+		}
+		*out = append(*out, SchemaViolation{Pointer: pointer, Keyword: keyword, Message: fmt.Sprintf(format, args...)})
+	}
+	// matches reports whether value is valid against a subschema, without
+	// reporting its violations
+	matches := func(sub interface{}) bool {
+		violations := make([]SchemaViolation, 0)
+		s.validate(sub, value, path, depth+1, &violations)
+		return len(violations) == 0
+	}
+
+	if depth > maxSchemaDepth {
+		add(path, "$ref", "schema nested more than %d levels", maxSchemaDepth)
+		return
+	}
+	if b, ok := schema.(bool); ok {
+		if !b {
+			add(path, "false", "no value is allowed")
+		}
+		return
+	}
+	object, _ := schema.(map[string]interface{})
+
+	if ref, ok := object["$ref"].(string); ok {
+		if target, err := s.resolve(ref); err == nil {
+			s.validate(target, value, path, depth+1, out)
+		}
+	}
+
+	if types, ok := object["type"]; ok && !hasType(value, types) {
+		add(path, "type", "must be of type %s, not %s", typeNames(types), typeOf(value))
+		// the other keywords would only repeat the type mismatch
+		return
+	}
+	if enum, ok := object["enum"].([]interface{}); ok {
+		found := false
+		for _, option := range enum {
+			found = found || equalJSON(option, value)
+		}
+		if !found {
+			add(path, "enum", "must be one of %s", mustMarshal(enum))
+		}
+	}
+	if expected, ok := object["const"]; ok && !equalJSON(expected, value) {
+		add(path, "const", "must be %s", mustMarshal(expected))
+	}
+
+	if list, ok := object["allOf"].([]interface{}); ok {
+		for _, sub := range list {
+			s.validate(sub, value, path, depth+1, out)
+		}
+	}
+	if list, ok := object["anyOf"].([]interface{}); ok {
+		found := false
+		for _, sub := range list {
+			if found = matches(sub); found {
+				break
+			}
+		}
+		if !found {
+			add(path, "anyOf", "must match at least one of %d schemas", len(list))
+		}
+	}
+	if list, ok := object["oneOf"].([]interface{}); ok {
+		matched := 0
+		for _, sub := range list {
+			if matches(sub) {
+				matched++
+			}
+		}
+		if matched != 1 {
+			add(path, "oneOf", "must match exactly one of %d schemas, matches %d", len(list), matched)
+		}
+	}
+	if sub, ok := object["not"]; ok && matches(sub) {
+		add(path, "not", "must not match the schema")
+	}
+	if condition, ok := object["if"]; ok {
+		if matches(condition) {
+			if then, ok := object["then"]; ok {
+				s.validate(then, value, path, depth+1, out)
+			}
+		} else if otherwise, ok := object["else"]; ok {
+			s.validate(otherwise, value, path, depth+1, out)
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		s.validateObject(object, v, path, depth, add, out)
+	case string:
+		s.validateString(object, v, path, add)
+	}
+}
+
+type addFunc func(path []string, keyword, format string, args ...interface{})
+
+func (s *JSONSchema) validateObject(schema, value map[string]interface{}, path []string, depth int, add addFunc, out *[]SchemaViolation) {
+	at := func(key string) []string {
+		return append(append([]string{}, path...), key)
+	}
+
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, name := range required {
+			if _, ok := value[name.(string)]; !ok {
+				add(at(name.(string)), "required", "is required")
+			}
+		}
+	}
+	if dependencies, ok := schema["dependentRequired"].(map[string]interface{}); ok {
+		for _, name := range memberNames(dependencies) {
+			if _, ok := value[name]; !ok {
+				continue
+			}
+			for _, dependency := range dependencies[name].([]interface{}) {
+				if _, ok := value[dependency.(string)]; !ok {
+					add(at(dependency.(string)), "dependentRequired", "is required when %q is set", name)
+				}
+			}
+		}
+	}
+	if n, ok := number(schema["minProperties"]); ok && big.NewRat(int64(len(value)), 1).Cmp(n) < 0 {
+		add(path, "minProperties", "must have at least %s members", n.RatString())
+	}
+	if n, ok := number(schema["maxProperties"]); ok && big.NewRat(int64(len(value)), 1).Cmp(n) > 0 {
+		add(path, "maxProperties", "must have at most %s members", n.RatString())
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+	patternProperties, _ := schema["patternProperties"].(map[string]interface{})
+	additional, hasAdditional := schema["additionalProperties"]
+	names, hasNames := schema["propertyNames"]
+	for _, key := range memberNames(value) {
+		member := value[key]
+		if hasNames {
+			violations := make([]SchemaViolation, 0)
+			s.validate(names, key, at(key), depth+1, &violations)
+			for _, violation := range violations {
+				add(at(key), "propertyNames", "name %q: %s", key, violation.Message)
+			}
+		}
+
+		known := false
+		if sub, ok := properties[key]; ok {
+			known = true
+			s.validate(sub, member, at(key), depth+1, out)
+		}
+		for _, pattern := range memberNames(patternProperties) {
+			if re, err := s.pattern(pattern); err == nil && re.MatchString(key) {
+				known = true
+				s.validate(patternProperties[pattern], member, at(key), depth+1, out)
+			}
+		}
+		if !known && hasAdditional {
+			if allowed, ok := additional.(bool); ok && !allowed {
+				add(at(key), "additionalProperties", "is not allowed%s", suggestion(key, properties))
+			} else {
+				s.validate(additional, member, at(key), depth+1, out)
+			}
+		}
+	}
+}
+
+// suggestion points out the property a key was probably meant to be, e.g.
+// "db_port" for "db_prot".
+func suggestion(key string, properties map[string]interface{}) string {
+	best, distance := "", 3
+	for _, name := range memberNames(properties) {
+		if d := editDistance(key, name); d < distance {
+			best, distance = name, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(", did you mean %q?", best)
+}
+
+// editDistance is the Damerau-Levenshtein distance of a and b, counting a
+// swap of two neighbouring characters as one edit.
+func editDistance(a, b string) int {
+	x, y := []rune(a), []rune(b)
+	d := make([][]int, len(x)+1)
+	for i := range d {
+		d[i] = make([]int, len(y)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(x); i++ {
+		for j := 1; j <= len(y); j++ {
+			cost := 1
+			if x[i-1] == y[j-1] {
+				cost = 0
+			}
+			d[i][j] = minInt(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && x[i-1] == y[j-2] && x[i-2] == y[j-1] {
+				d[i][j] = minInt(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(x)][len(y)]
+}
+
+func minInt(values ...int) int {
+	lowest := values[0]
+	for _, v := range values[1:] {
+		if v < lowest {
+			lowest = v
+		}
+	}
+	return lowest
+}
+
+func (s *JSONSchema) validateString(schema map[string]interface{}, value string, path []string, add addFunc) {
+	length := big.NewRat(int64(utf8.RuneCountInString(value)), 1)
+	if n, ok := number(schema["minLength"]); ok && length.Cmp(n) < 0 {
+		add(path, "minLength", "must be at least %s characters", n.RatString())
+	}
+	if n, ok := number(schema["maxLength"]); ok && length.Cmp(n) > 0 {
+		add(path, "maxLength", "must be at most %s characters", n.RatString())
+	}
+	if pattern, ok := schema["pattern"].(string); ok {
+		if re, err := s.pattern(pattern); err == nil && !re.MatchString(value) {
+			add(path, "pattern", "must match %s", pattern)
+		}
+	}
+	if format, ok := schema["format"].(string); ok && !validFormat(format, value) {
+		add(path, "format", "must be a valid %s", format)
+	}
+}
+
+var hostnamePattern = regexp.MustCompile(`^([A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?)(\.[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?)*$`)
+
+func validFormat(format, value string) bool {
+	switch format {
+	case "date-time":
+		_, err := time.Parse(time.RFC3339, value)
+		return err == nil
+	case "date":
+		_, err := time.Parse(time.DateOnly, value)
+		return err == nil
+	case "email":
+		local, domain, ok := strings.Cut(value, "@")
+		return ok && local != "" && hostnamePattern.MatchString(domain)
+	case "hostname":
+		return len(value) <= 253 && hostnamePattern.MatchString(value)
+	case "ipv4":
+		ip := net.ParseIP(value)
+		return ip != nil && ip.To4() != nil && !strings.Contains(value, ":")
+	case "ipv6":
+		ip := net.ParseIP(value)
+		return ip != nil && strings.Contains(value, ":")
+	case "uri":
+		u, err := url.Parse(value)
+		return err == nil && u.Scheme != ""
+	}
+	return true
+}
+
+// number converts a JSON number exactly.
+func number(value interface{}) (*big.Rat, bool) {
+	n, ok := value.(json.Number)
+	if !ok {
+		return nil, false
+	}
+	return new(big.Rat).SetString(n.String())
+}
+
+func typeOf(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case json.Number:
+		return "number"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func hasType(value interface{}, types interface{}) bool {
+	return allowsType(types, typeOf(value))
+}
+
+func typeNames(types interface{}) string {
+	if names, ok := types.([]interface{}); ok {
+		return mustMarshal(names)
+	}
+	return fmt.Sprint(types)
+}
+
+// equalJSON compares two decoded JSON values, numbers by value.
+func equalJSON(a, b interface{}) bool {
+	x, xok := number(a)
+	y, yok := number(b)
+	if xok && yok {
+		return x.Cmp(y) == 0
+	}
+	return mustMarshal(a) == mustMarshal(b)
+}
+
+func memberNames(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
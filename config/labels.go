@@ -0,0 +1,68 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Labels are the key/value labels attached to a config.
+//
+// Older clients (and data already stored in Consul) encode labels as a single
+// "key:value;key:value" string, so Labels accepts that form when decoding JSON
+// and always encodes itself as an object.
+type Labels map[string]string
+
+// UnmarshalJSON decodes labels from either a JSON object or a legacy string.
+func (l *Labels) UnmarshalJSON(data []byte) error {
+	var legacy string
+	if err := json.Unmarshal(data, &legacy); err == nil {
+		parsed, err := ParseLabels(legacy)
+		if err != nil {
+			return err
+		}
+		*l = parsed
+		return nil
+	}
+
+	var m map[string]string
+	if err := json.Unmarshal(data, &m); err != nil {
+		return fmt.Errorf("labels must be an object or a \"key:value;key:value\" string")
+	}
+	*l = m
+	return nil
+}
+
+// ParseLabels parses the legacy "key:value;key:value" label encoding.
+func ParseLabels(s string) (Labels, error) {
+	labels := Labels{}
+	for _, part := range strings.Split(s, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, ":", 2)
+		key := strings.TrimSpace(kv[0])
+		if len(kv) != 2 || key == "" {
+			return nil, fmt.Errorf("invalid label %q, expected key:value", part)
+		}
+		labels[key] = strings.TrimSpace(kv[1])
+	}
+	return labels, nil
+}
+
+// String returns the labels in the legacy encoding with keys sorted.
+func (l Labels) String() string {
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, k+":"+l[k])
+	}
+	return strings.Join(parts, ";")
+}
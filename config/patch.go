@@ -0,0 +1,374 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// JSON Patch (RFC 6902) and JSON Merge Patch (RFC 7396) applied to the JSON
+// encoding of a configuration. Documents are decoded into plain
+// map[string]interface{} / []interface{} trees so the patches work on any
+// field without knowing the Config struct.
+
+// PatchOperation is one operation of a JSON Patch.
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// PatchError describes why an operation of a JSON Patch was rejected.
+type PatchError struct {
+	// Index of the operation in the patch
+	Index   int    `json:"index"`
+	Op      string `json:"op,omitempty"`
+	Path    string `json:"path,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e *PatchError) Error() string {
+	return fmt.Sprintf("operation %d (%s %s): %s", e.Index, e.Op, e.Path, e.Message)
+}
+
+// ParseJSONPatch decodes a JSON Patch document and checks every operation is
+// well formed, returning the problems of all operations at once. The error is
+// set if data isn't a JSON array of operations.
+func ParseJSONPatch(data []byte) ([]PatchOperation, []PatchError, error) {
+	var ops []PatchOperation
+	err := json.Unmarshal(data, &ops)
+	if err != nil {
+		return nil, nil, fmt.Errorf("a JSON Patch must be an array of operations: %w", err)
+	}
+
+	var problems []PatchError
+	for i, op := range ops {
+		problem := func(format string, args ...interface{}) {
+			problems = append(problems, PatchError{Index: i, Op: op.Op, Path: op.Path, Message: fmt.Sprintf(format, args...)})
+		}
+
+		if _, err := parsePointer(op.Path); err != nil {
+			problem("path: %s", err.Error())
+		}
+		switch op.Op {
+		case "add", "replace", "test":
+			if op.Value == nil {
+				problem("value is required")
+			}
+		case "remove":
+		case "move", "copy":
+			if _, err := parsePointer(op.From); err != nil {
+				problem("from: %s", err.Error())
+			}
+			if op.Op == "move" && (op.Path == op.From || strings.HasPrefix(op.Path, op.From+"/")) {
+				problem("can't move a value into itself")
+			}
+		default:
+			problem("unknown op %q, expected add, remove, replace, move, copy or test", op.Op)
+		}
+	}
+
+	return ops, problems, nil
+}
+
+// ApplyJSONPatch applies the operations to doc in order. Patches are atomic,
+// the first failing operation is returned and nothing is applied.
+func ApplyJSONPatch(doc []byte, ops []PatchOperation) ([]byte, *PatchError) {
+	root, err := decodeJSON(doc)
+	if err != nil {
+		return nil, &PatchError{Index: -1, Message: err.Error()}
+	}
+
+	for i, op := range ops {
+		root, err = applyOperation(root, op)
+		if err != nil {
+			return nil, &PatchError{Index: i, Op: op.Op, Path: op.Path, Message: err.Error()}
+		}
+	}
+
+	result, err := json.Marshal(root)
+	if err != nil {
+		return nil, &PatchError{Index: -1, Message: err.Error()}
+	}
+	return result, nil
+}
+
+func applyOperation(root interface{}, op PatchOperation) (interface{}, error) {
+	path, _ := parsePointer(op.Path)
+
+	switch op.Op {
+	case "add":
+		value, err := decodeJSON(op.Value)
+		if err != nil {
+			return nil, err
+		}
+		return addValue(root, path, value)
+	case "remove":
+		root, _, err := removeValue(root, path)
+		return root, err
+	case "replace":
+		value, err := decodeJSON(op.Value)
+		if err != nil {
+			return nil, err
+		}
+		if len(path) == 0 {
+			return value, nil
+		}
+		root, _, err = removeValue(root, path)
+		if err != nil {
+			return nil, err
+		}
+		return addValue(root, path, value)
+	case "move":
+		from, _ := parsePointer(op.From)
+		root, value, err := removeValue(root, from)
+		if err != nil {
+			return nil, fmt.Errorf("from: %w", err)
+		}
+		return addValue(root, path, value)
+	case "copy":
+		from, _ := parsePointer(op.From)
+		value, err := getValue(root, from)
+		if err != nil {
+			return nil, fmt.Errorf("from: %w", err)
+		}
+		value, err = deepCopy(value)
+		if err != nil {
+			return nil, err
+		}
+		return addValue(root, path, value)
+	case "test":
+		expected, err := decodeJSON(op.Value)
+		if err != nil {
+			return nil, err
+		}
+		actual, err := getValue(root, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(expected, actual) {
+			return nil, fmt.Errorf("test failed, the value is %s", mustMarshal(actual))
+		}
+		return root, nil
+	}
+
+	return nil, fmt.Errorf("unknown op %q", op.Op)
+}
+
+// ApplyMergePatch applies a JSON Merge Patch to doc: objects are merged
+// recursively, null removes a member and any other value replaces it.
+func ApplyMergePatch(doc, patch []byte) ([]byte, error) {
+	target, err := decodeJSON(doc)
+	if err != nil {
+		return nil, err
+	}
+	p, err := decodeJSON(patch)
+	if err != nil {
+		return nil, fmt.Errorf("invalid merge patch: %w", err)
+	}
+	return json.Marshal(mergePatch(target, p))
+}
+
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+		} else {
+			t[k] = mergePatch(t[k], v)
+		}
+	}
+	return t
+}
+
+// parsePointer splits a JSON Pointer (RFC 6901) into its unescaped tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, errors.New("a JSON Pointer must be empty or start with \"/\"")
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		if strings.Contains(strings.ReplaceAll(strings.ReplaceAll(t, "~0", ""), "~1", ""), "~") {
+			return nil, fmt.Errorf("invalid escape in %q, only ~0 and ~1 are allowed", t)
+		}
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func getValue(node interface{}, path []string) (interface{}, error) {
+	for i, token := range path {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			child, ok := n[token]
+			if !ok {
+				return nil, fmt.Errorf("%s doesn't exist", pointerString(path[:i+1]))
+			}
+			node = child
+		case []interface{}:
+			index, err := arrayIndex(token, len(n)-1)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", pointerString(path[:i+1]), err)
+			}
+			node = n[index]
+		default:
+			return nil, fmt.Errorf("%s is not an object or array", pointerString(path[:i]))
+		}
+	}
+	return node, nil
+}
+
+// update replaces the value at path with what change returns for the parent
+// container and the last token. It returns the new root, since arrays can be
+// reallocated the changed containers are stored back into their parents.
+func update(node interface{}, path []string, parent []string, change func(container interface{}, token string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return change(node, path[0])
+	}
+
+	token := path[0]
+	current := append(append([]string{}, parent...), token)
+	switch n := node.(type) {
+	case map[string]interface{}:
+		child, ok := n[token]
+		if !ok {
+			return nil, fmt.Errorf("%s doesn't exist", pointerString(current))
+		}
+		child, err := update(child, path[1:], current, change)
+		if err != nil {
+			return nil, err
+		}
+		n[token] = child
+		return n, nil
+	case []interface{}:
+		index, err := arrayIndex(token, len(n)-1)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", pointerString(current), err)
+		}
+		child, err := update(n[index], path[1:], current, change)
+		if err != nil {
+			return nil, err
+		}
+		n[index] = child
+		return n, nil
+	}
+	return nil, fmt.Errorf("%s is not an object or array", pointerString(parent))
+}
+
+func addValue(root interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return update(root, path, nil, func(container interface{}, token string) (interface{}, error) {
+		switch c := container.(type) {
+		case map[string]interface{}:
+			c[token] = value
+			return c, nil
+		case []interface{}:
+			if token == "-" {
+				return append(c, value), nil
+			}
+			index, err := arrayIndex(token, len(c))
+			if err != nil {
+				return nil, err
+			}
+			c = append(c, nil)
+			copy(c[index+1:], c[index:])
+			c[index] = value
+			return c, nil
+		}
+		return nil, fmt.Errorf("%s has no parent object or array", pointerString(path))
+	})
+}
+
+func removeValue(root interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, nil, errors.New("the whole document can't be removed")
+	}
+
+	var removed interface{}
+	root, err := update(root, path, nil, func(container interface{}, token string) (interface{}, error) {
+		switch c := container.(type) {
+		case map[string]interface{}:
+			value, ok := c[token]
+			if !ok {
+				return nil, fmt.Errorf("%s doesn't exist", pointerString(path))
+			}
+			removed = value
+			delete(c, token)
+			return c, nil
+		case []interface{}:
+			index, err := arrayIndex(token, len(c)-1)
+			if err != nil {
+				return nil, err
+			}
+			removed = c[index]
+			return append(c[:index], c[index+1:]...), nil
+		}
+		return nil, fmt.Errorf("%s has no parent object or array", pointerString(path))
+	})
+	return root, removed, err
+}
+
+// arrayIndex parses an array index token that must be at most max.
+func arrayIndex(token string, max int) (int, error) {
+	if token == "-" {
+		return 0, errors.New(`"-" can only be used to append`)
+	}
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("%q is not an array index", token)
+	}
+	if index > max {
+		return 0, fmt.Errorf("index %d is out of range", index)
+	}
+	return index, nil
+}
+
+func pointerString(path []string) string {
+	if len(path) == 0 {
+		return "the document"
+	}
+	escaped := make([]string, len(path))
+	for i, t := range path {
+		escaped[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~", "~0"), "/", "~1")
+	}
+	return "/" + strings.Join(escaped, "/")
+}
+
+func decodeJSON(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	err := decoder.Decode(&value)
+	return value, err
+}
+
+func deepCopy(value interface{}) (interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON(data)
+}
+
+func mustMarshal(value interface{}) string {
+	data, _ := json.Marshal(value)
+	return string(data)
+}
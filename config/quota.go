@@ -0,0 +1,79 @@
+package config
+
+import "encoding/json"
+
+// swagger:model QuotaLimits
+type QuotaLimits struct {
+	// Maximum number of configuration IDs
+	// in: int
+	MaxConfigs int `json:"max_configs,omitempty"`
+
+	// Maximum number of versions of one configuration ID
+	// in: int
+	MaxVersionsPerConfig int `json:"max_versions_per_config,omitempty"`
+
+	// Maximum number of group IDs
+	// in: int
+	MaxGroups int `json:"max_groups,omitempty"`
+
+	// Maximum number of entries of one configuration or group member
+	// in: int
+	MaxEntriesPerConfig int `json:"max_entries_per_config,omitempty"`
+
+	// Maximum size in bytes of all stored configurations and group members
+	// in: int
+	MaxBytes int64 `json:"max_bytes,omitempty"`
+}
+
+// QuotaUsage is the totals of what a namespace stores, kept up to date by
+// every write so they don't have to be counted on each request.
+type QuotaUsage struct {
+	// Number of configuration IDs
+	Configs int `json:"configs"`
+
+	// Number of group IDs
+	Groups int `json:"groups"`
+
+	// Size in bytes of all stored configurations and group members
+	Bytes int64 `json:"bytes"`
+}
+
+// ConfigSize is the number of bytes a configuration takes in the usage, the
+// size of its JSON encoding.
+func ConfigSize(c *Config) int64 {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return 0
+	}
+	return int64(len(data))
+}
+
+// swagger:model Quota
+type Quota struct {
+	// Namespace the quota applies to
+	// in: string
+	Namespace string `json:"namespace"`
+
+	// Limits of the namespace, zero means unlimited
+	// in: QuotaLimits
+	Limits QuotaLimits `json:"limits"`
+
+	// Current usage of the namespace
+	// in: QuotaCounts
+	Usage QuotaCounts `json:"usage"`
+}
+
+// QuotaCounts is the usage of a namespace compared with its limits.
+type QuotaCounts struct {
+	// Number of configuration IDs
+	Configs int `json:"configs"`
+
+	// Highest number of versions of one configuration ID
+	MaxVersionsPerConfig int `json:"max_versions_per_config"`
+
+	// Number of group IDs
+	Groups int `json:"groups"`
+
+	// Size in bytes of all stored configurations and group members
+	Bytes int64 `json:"bytes"`
+}
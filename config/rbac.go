@@ -0,0 +1,53 @@
+package config
+
+import "time"
+
+// swagger:model RoleBinding
+type RoleBinding struct {
+	// ID of the binding
+	// in: string
+	ID string `json:"id"`
+
+	// Identity the role is granted to, e.g. "jwt:alice", "api_key:ci" or
+	// "anonymous"
+	// in: string
+	Subject string `json:"subject"`
+
+	// Role granted: reader, writer or admin
+	// in: string
+	Role string `json:"role"`
+
+	// Namespace the role applies in, the namespace of the request by
+	// default. "*" applies it in every namespace, which only callers with
+	// rbac:manage in every namespace may grant; such bindings are stored and
+	// returned without a namespace.
+	// in: string
+	Namespace string `json:"namespace,omitempty"`
+
+	// Configurations the role applies to. An empty scope applies to all of
+	// them and to the endpoints that aren't about configurations.
+	// in: Scope
+	Scope Scope `json:"scope"`
+
+	// Time the binding was created
+	// in: time
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Scope limits a role binding to some configurations. Every set field must
+// match.
+type Scope struct {
+	// Configuration IDs must start with this prefix
+	IDPrefix string `json:"id_prefix,omitempty"`
+
+	// Configurations must belong to this group
+	Group string `json:"group,omitempty"`
+
+	// Configuration labels must match this selector, e.g. "env=dev"
+	Labels string `json:"labels,omitempty"`
+}
+
+// Empty reports whether the scope covers everything.
+func (s Scope) Empty() bool {
+	return s.IDPrefix == "" && s.Group == "" && s.Labels == ""
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// swagger:model Schema
+type Schema struct {
+	// ID of the schema
+	// in: string
+	ID string `json:"id"`
+
+	// Version of the schema, versions are write-once and the highest one
+	// is applied
+	// in: string
+	Version string `json:"version"`
+
+	// Name of the configurations the schema applies to
+	// in: string
+	ConfigName string `json:"config_name,omitempty"`
+
+	// Label selector of the configurations the schema applies to, e.g.
+	// "app=billing"
+	// in: string
+	Selector string `json:"selector,omitempty"`
+
+	// JSON Schema the entries must match. The entries are validated as an
+	// object whose values are strings.
+	// in: object
+	Schema json.RawMessage `json:"schema"`
+
+	// Time the version was created
+	// in: time
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Applies reports whether the schema applies to a configuration: every set
+// field must match.
+func (s *Schema) Applies(c *Config) bool {
+	if s.ConfigName != "" && s.ConfigName != c.Name {
+		return false
+	}
+	if s.Selector != "" {
+		selector, err := ParseSelector(s.Selector)
+		if err != nil || !selector.Matches(c.Labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// EntriesDocument returns the entries as the document schemas validate.
+func (c *Config) EntriesDocument() map[string]interface{} {
+	document := make(map[string]interface{}, len(c.Entries))
+	for key, value := range c.Entries {
+		document[key] = value
+	}
+	return document
+}
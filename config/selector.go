@@ -0,0 +1,210 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Operator is the comparison used by a single selector requirement.
+type Operator string
+
+const (
+	Equals       Operator = "="
+	NotEquals    Operator = "!="
+	In           Operator = "in"
+	NotIn        Operator = "notin"
+	Exists       Operator = "exists"
+	DoesNotExist Operator = "!"
+)
+
+// Requirement is one comma separated term of a selector, e.g. "env=prod" or
+// "tier in (web,api)".
+type Requirement struct {
+	Key      string
+	Operator Operator
+	Values   []string
+}
+
+// Selector is a Kubernetes style label selector. A config matches when its
+// labels satisfy every requirement; labels not mentioned are ignored.
+type Selector []Requirement
+
+// ParseSelector parses selectors such as
+//
+//	env=prod,team!=legacy,tier in (web,api),canary,!deprecated
+//
+// For backwards compatibility the old "key:value;key:value" label string is
+// accepted too and turned into equality requirements.
+func ParseSelector(s string) (Selector, error) {
+	s = strings.TrimSpace(s)
+	if isLegacyLabels(s) {
+		labels, err := ParseLabels(s)
+		if err != nil {
+			return nil, err
+		}
+		return SelectorFromLabels(labels), nil
+	}
+
+	selector := Selector{}
+	for _, term := range splitTerms(s) {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		req, err := parseRequirement(term)
+		if err != nil {
+			return nil, err
+		}
+		selector = append(selector, req)
+	}
+	return selector, nil
+}
+
+// SelectorFromLabels returns a selector requiring every given label.
+func SelectorFromLabels(labels Labels) Selector {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	selector := Selector{}
+	for _, k := range keys {
+		selector = append(selector, Requirement{Key: k, Operator: Equals, Values: []string{labels[k]}})
+	}
+	return selector
+}
+
+// Matches reports whether the labels satisfy every requirement.
+func (s Selector) Matches(labels Labels) bool {
+	for _, req := range s {
+		if !req.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r Requirement) Matches(labels Labels) bool {
+	value, ok := labels[r.Key]
+	switch r.Operator {
+	case Equals:
+		return ok && value == r.Values[0]
+	case NotEquals:
+		return !ok || value != r.Values[0]
+	case In:
+		return ok && contains(r.Values, value)
+	case NotIn:
+		return !ok || !contains(r.Values, value)
+	case Exists:
+		return ok
+	case DoesNotExist:
+		return !ok
+	}
+	return false
+}
+
+func (s Selector) String() string {
+	parts := make([]string, 0, len(s))
+	for _, req := range s {
+		switch req.Operator {
+		case Equals, NotEquals:
+			parts = append(parts, req.Key+string(req.Operator)+req.Values[0])
+		case In, NotIn:
+			parts = append(parts, req.Key+" "+string(req.Operator)+" ("+strings.Join(req.Values, ",")+")")
+		case Exists:
+			parts = append(parts, req.Key)
+		case DoesNotExist:
+			parts = append(parts, "!"+req.Key)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func parseRequirement(term string) (Requirement, error) {
+	if strings.HasPrefix(term, "!") && !strings.Contains(term, "=") {
+		key := strings.TrimSpace(term[1:])
+		if key == "" {
+			return Requirement{}, fmt.Errorf("invalid selector term %q", term)
+		}
+		return Requirement{Key: key, Operator: DoesNotExist}, nil
+	}
+
+	if i := strings.Index(term, "!="); i >= 0 {
+		return binaryRequirement(term, term[:i], NotEquals, term[i+2:])
+	}
+	if i := strings.Index(term, "=="); i >= 0 {
+		return binaryRequirement(term, term[:i], Equals, term[i+2:])
+	}
+	if i := strings.Index(term, "="); i >= 0 {
+		return binaryRequirement(term, term[:i], Equals, term[i+1:])
+	}
+
+	if open := strings.Index(term, "("); open >= 0 {
+		if !strings.HasSuffix(term, ")") {
+			return Requirement{}, fmt.Errorf("invalid selector term %q, missing \")\"", term)
+		}
+		fields := strings.Fields(term[:open])
+		if len(fields) != 2 || (fields[1] != string(In) && fields[1] != string(NotIn)) {
+			return Requirement{}, fmt.Errorf("invalid selector term %q, expected \"key in (...)\" or \"key notin (...)\"", term)
+		}
+		values := make([]string, 0)
+		for _, v := range strings.Split(term[open+1:len(term)-1], ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		if len(values) == 0 {
+			return Requirement{}, fmt.Errorf("invalid selector term %q, empty value set", term)
+		}
+		return Requirement{Key: fields[0], Operator: Operator(fields[1]), Values: values}, nil
+	}
+
+	if strings.ContainsAny(term, " \t") {
+		return Requirement{}, fmt.Errorf("invalid selector term %q", term)
+	}
+	return Requirement{Key: term, Operator: Exists}, nil
+}
+
+func binaryRequirement(term, key string, op Operator, value string) (Requirement, error) {
+	key = strings.TrimSpace(key)
+	value = strings.TrimSpace(value)
+	if key == "" || strings.ContainsAny(value, "=!") {
+		return Requirement{}, fmt.Errorf("invalid selector term %q", term)
+	}
+	return Requirement{Key: key, Operator: op, Values: []string{value}}, nil
+}
+
+// splitTerms splits a selector on commas that are not inside parentheses.
+func splitTerms(s string) []string {
+	terms := make([]string, 0)
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				terms = append(terms, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(terms, s[start:])
+}
+
+func isLegacyLabels(s string) bool {
+	return strings.Contains(s, ":") && !strings.ContainsAny(s, "=!(")
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,167 @@
+package config
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// LatestVersion is the alias that resolves to the highest stored version.
+const LatestVersion = "latest"
+
+// VersionInfo describes one stored version of a configuration.
+type VersionInfo struct {
+	Version     string `json:"version"`
+	Name        string `json:"name"`
+	Labels      Labels `json:"labels,omitempty"`
+	Entries     int    `json:"entries"`
+	CreateIndex uint64 `json:"create_index"`
+	ModifyIndex uint64 `json:"modify_index"`
+}
+
+// CompareVersions compares two version strings and returns -1, 0 or 1.
+//
+// Versions are compared semantically: "v1.2.3-rc.1", "1.10" and plain numbers
+// such as "10" are split into numeric parts so "10" sorts after "9". Versions
+// that are not numeric at all sort after numeric ones and are compared
+// lexically between themselves.
+func CompareVersions(a, b string) int {
+	va, okA := parseVersion(a)
+	vb, okB := parseVersion(b)
+
+	switch {
+	case okA && okB:
+		return va.compare(vb)
+	case okA:
+		return -1
+	case okB:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// SortVersions sorts version strings from lowest to highest.
+func SortVersions(versions []string) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		return CompareVersions(versions[i], versions[j]) < 0
+	})
+}
+
+type version struct {
+	parts      []uint64
+	prerelease []string
+}
+
+// parseVersion accepts an optional "v" prefix, any number of dot separated
+// numeric parts, an optional "-prerelease" and ignores "+build" metadata.
+func parseVersion(s string) (version, bool) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.Index(s, "+"); i >= 0 {
+		s = s[:i]
+	}
+
+	v := version{}
+	if i := strings.Index(s, "-"); i >= 0 {
+		if i == len(s)-1 {
+			return v, false
+		}
+		v.prerelease = strings.Split(s[i+1:], ".")
+		s = s[:i]
+	}
+	if s == "" {
+		return v, false
+	}
+
+	for _, p := range strings.Split(s, ".") {
+		n, err := strconv.ParseUint(p, 10, 64)
+		if err != nil {
+			return v, false
+		}
+		v.parts = append(v.parts, n)
+	}
+	return v, true
+}
+
+func (v version) compare(o version) int {
+	for i := 0; i < len(v.parts) || i < len(o.parts); i++ {
+		var a, b uint64
+		if i < len(v.parts) {
+			a = v.parts[i]
+		}
+		if i < len(o.parts) {
+			b = o.parts[i]
+		}
+		if a != b {
+			return compareUint(a, b)
+		}
+	}
+
+	// a release is higher than any of its prereleases
+	switch {
+	case len(v.prerelease) == 0 && len(o.prerelease) == 0:
+		return 0
+	case len(v.prerelease) == 0:
+		return 1
+	case len(o.prerelease) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(v.prerelease) && i < len(o.prerelease); i++ {
+		if c := comparePrerelease(v.prerelease[i], o.prerelease[i]); c != 0 {
+			return c
+		}
+	}
+	return compareUint(uint64(len(v.prerelease)), uint64(len(o.prerelease)))
+}
+
+// comparePrerelease follows semver: numeric identifiers compare numerically
+// and sort before alphanumeric ones.
+func comparePrerelease(a, b string) int {
+	na, errA := strconv.ParseUint(a, 10, 64)
+	nb, errB := strconv.ParseUint(b, 10, 64)
+	switch {
+	case errA == nil && errB == nil:
+		return compareUint(na, nb)
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func compareUint(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// NextVersion returns the version following v: the last numeric part is
+// incremented ("1.2.3" becomes "1.2.4", "v7" becomes "v8") and a prerelease
+// is promoted to its release ("2.0-rc.1" becomes "2.0"). It reports false for
+// versions that are not numeric.
+func NextVersion(v string) (string, bool) {
+	parsed, ok := parseVersion(v)
+	if !ok {
+		return "", false
+	}
+
+	if len(parsed.prerelease) == 0 {
+		parsed.parts[len(parsed.parts)-1]++
+	}
+
+	parts := make([]string, len(parsed.parts))
+	for i, p := range parsed.parts {
+		parts[i] = strconv.FormatUint(p, 10)
+	}
+
+	next := strings.Join(parts, ".")
+	if strings.HasPrefix(strings.TrimSpace(v), "v") {
+		next = "v" + next
+	}
+	return next, true
+}
@@ -0,0 +1,94 @@
+package config
+
+import "time"
+
+// swagger:model Webhook
+type Webhook struct {
+	// ID of the subscription
+	// in: string
+	ID string `json:"id"`
+
+	// Namespace the events are delivered from, the one the subscription was
+	// created in
+	// in: string
+	Namespace string `json:"namespace,omitempty"`
+
+	// URL the events are POSTed to
+	// in: string
+	URL string `json:"url"`
+
+	// Event types to deliver, e.g. "group.created". Empty delivers all types.
+	// in: []string
+	Events []string `json:"events,omitempty"`
+
+	// Only deliver events for this configuration ID
+	// in: string
+	ConfigID string `json:"config_id,omitempty"`
+
+	// Only deliver events for this group ID
+	// in: string
+	GroupID string `json:"group_id,omitempty"`
+
+	// Only deliver events for configurations matching this label selector
+	// in: string
+	Labels string `json:"labels,omitempty"`
+
+	// Secret used to sign the deliveries. It is only returned when the
+	// subscription is created.
+	// in: string
+	Secret string `json:"secret,omitempty"`
+
+	// Time the subscription was created
+	// in: time
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Delivery states.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// swagger:model Delivery
+type Delivery struct {
+	// ID of the delivery, sent in the X-Webhook-Delivery header
+	// in: string
+	ID string `json:"id"`
+
+	// ID of the subscription the delivery belongs to
+	// in: string
+	WebhookID string `json:"webhook_id"`
+
+	// Event being delivered
+	// in: Event
+	Event *Event `json:"event"`
+
+	// One of pending, delivered or failed
+	// in: string
+	Status string `json:"status"`
+
+	// Number of attempts made so far
+	// in: int
+	Attempts int `json:"attempts"`
+
+	// HTTP status of the last attempt, 0 if it didn't get a response
+	// in: int
+	LastStatusCode int `json:"last_status_code,omitempty"`
+
+	// Error of the last failed attempt
+	// in: string
+	LastError string `json:"last_error,omitempty"`
+
+	// Time the delivery was enqueued
+	// in: time
+	CreatedAt time.Time `json:"created_at"`
+
+	// Time of the last attempt
+	// in: time
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// Time of the next attempt of a pending delivery that failed before
+	// in: time
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+}
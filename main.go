@@ -7,50 +7,143 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/auth"
 	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
 	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/metrics"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/namespace"
 	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/poststore"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/ratelimit"
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
-	ps, err := poststore.New()
+	ps, err := newStore()
 	if err != nil {
 		log.Fatal(err)
 	}
+	ps.IdempotencyTTL = durationEnv("IDEMPOTENCY_TTL", 24*time.Hour)
+
+	if len(os.Args) > 1 {
+		if err := runCommand(ps, os.Args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	go ps.RunIdempotencySweeper(backgroundCtx, durationEnv("IDEMPOTENCY_SWEEP_INTERVAL", 10*time.Minute), 100)
+
+	// checkpoints of the audit chain are signed with this key
+	var checkpoints *service.AuditCheckpointer
+	if path := os.Getenv("AUDIT_SIGNING_KEY"); path != "" {
+		key, err := service.LoadAuditKey(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		checkpoints = service.NewAuditCheckpointer(ps, key)
+	}
+
+	// limits of what each namespace stores
+	var quotas *service.Quotas
+	if path := os.Getenv("QUOTAS_FILE"); path != "" {
+		quotas, err = service.LoadQuotas(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	authorizer := newAuthorizer(ps)
+	targets := &auth.Targets{Store: ps}
 
 	service := &service.Service{
-		Configurations: []*config.Config{},
-		PostStore:      ps,
+		Configurations:   []*config.Config{},
+		PostStore:        ps,
+		AdminToken:       os.Getenv("ADMIN_TOKEN"),
+		Events:           service.NewEventBroker(ps),
+		Webhooks:         newWebhookDispatcher(ps),
+		AuditCheckpoints: checkpoints,
+		Authorizer:       authorizer,
+		Quotas:           quotas,
+	}
+	// only these namespaces get their own metric labels, so callers naming
+	// new namespaces can't add series without bound
+	if err := service.AddMetricNamespaces(backgroundCtx); err != nil {
+		log.Printf("reading the namespaces of the role bindings: %v", err)
+	}
+	go service.Events.Run(backgroundCtx)
+	go service.Webhooks.Run(backgroundCtx, 4)
+	if service.AuditCheckpoints != nil {
+		go service.AuditCheckpoints.Run(backgroundCtx, durationEnv("AUDIT_CHECKPOINT_INTERVAL", time.Hour))
 	}
 
-	quit := make(chan os.Signal)
+	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 
+	authenticator, err := newAuthenticator(ps)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	router := mux.NewRouter()
 	router.StrictSlash(true)
+	limiter := newLimiter()
+	router.Use(limiter.AddressMiddleware, authenticator.Middleware, limiter.Middleware)
 
-	router.HandleFunc("/configurations", metrics.Count(service.AddConfiguration, "/configurations")).Methods("POST")
-	router.HandleFunc("/configurations/{id}/{version}", metrics.Count(service.GetConfiguration, "/configurations/{id}/{version}")).Methods("GET")
-	router.HandleFunc("/configurations/{id}/{version}", metrics.Count(service.DeleteConfiguration, "/configurations/{id}/{version}")).Methods("DELETE")
-	router.HandleFunc("/group", metrics.Count(service.AddConfigurationGroup, "/group")).Methods("POST")
-	router.HandleFunc("/group/{id}/{version}", metrics.Count(service.GetConfigurationGroup, "/group/{id}/{version}")).Methods("GET")
-	router.HandleFunc("/group/{id}/{version}", metrics.Count(service.DeleteConfigurationGroup, "/group/{id}/{version}")).Methods("DELETE")
-	router.HandleFunc("/group/{id}/{version}/extend", metrics.Count(service.ExtendConfigurationGroup, "/group/{id}/{version}/extend")).Methods("POST")
+	// require wraps a handler in the permission check, resolve returns the
+	// configurations scoped bindings are checked against
+	require := authorizer.Require
+
+	router.HandleFunc("/configurations", metrics.Count(require(auth.PermConfigurationsWrite, targets.ConfigurationBody, service.AddConfiguration), "/configurations")).Methods("POST")
+	router.HandleFunc("/configurations/{id}", metrics.Count(require(auth.PermConfigurationsRead, targets.ConfigurationPath, service.ListConfigurationVersions), "/configurations/{id}")).Methods("GET")
+	router.HandleFunc("/configurations/{id}/diff", metrics.Count(require(auth.PermConfigurationsRead, targets.ConfigurationPath, service.DiffConfiguration), "/configurations/{id}/diff")).Methods("GET")
+	router.HandleFunc("/configurations/{id}/{version}", metrics.Count(require(auth.PermConfigurationsRead, targets.ConfigurationPath, service.GetConfiguration), "/configurations/{id}/{version}")).Methods("GET")
+	router.HandleFunc("/configurations/{id}/{version}", metrics.Count(require(auth.PermConfigurationsDelete, targets.ConfigurationPath, service.DeleteConfiguration), "/configurations/{id}/{version}")).Methods("DELETE")
+	router.HandleFunc("/configurations/{id}/{version}", metrics.Count(require(auth.PermConfigurationsWrite, targets.ConfigurationPath, service.PatchConfiguration), "/configurations/{id}/{version}")).Methods("PATCH")
+	router.HandleFunc("/configurations/{id}/{version}/promote", metrics.Count(require(auth.PermConfigurationsWrite, targets.ConfigurationPath, service.PromoteConfiguration), "/configurations/{id}/{version}/promote")).Methods("POST")
+	router.HandleFunc("/group", metrics.Count(require(auth.PermConfigurationsWrite, targets.GroupBody, service.AddConfigurationGroup), "/group")).Methods("POST")
+	router.HandleFunc("/group/{id}/diff", metrics.Count(require(auth.PermConfigurationsRead, targets.GroupPath, service.DiffConfigurationGroup), "/group/{id}/diff")).Methods("GET")
+	router.HandleFunc("/group/{id}/{version}", metrics.Count(require(auth.PermConfigurationsRead, targets.GroupPath, service.GetConfigurationGroup), "/group/{id}/{version}")).Methods("GET")
+	router.HandleFunc("/group/{id}/{version}", metrics.Count(require(auth.PermConfigurationsDelete, targets.GroupPath, service.DeleteConfigurationGroup), "/group/{id}/{version}")).Methods("DELETE")
+	router.HandleFunc("/group/{id}/{version}/promote", metrics.Count(require(auth.PermConfigurationsWrite, targets.GroupPath, service.PromoteConfigurationGroup), "/group/{id}/{version}/promote")).Methods("POST")
+	router.HandleFunc("/group/{id}/{version}/extend", metrics.Count(require(auth.PermConfigurationsWrite, targets.ExtendBody, service.ExtendConfigurationGroup), "/group/{id}/{version}/extend")).Methods("POST")
+	router.HandleFunc("/events", metrics.Count(require(auth.PermEventsRead, nil, service.StreamEvents), "/events")).Methods("GET")
+	router.HandleFunc("/webhooks", metrics.Count(require(auth.PermWebhooksManage, nil, service.CreateWebhook), "/webhooks")).Methods("POST")
+	router.HandleFunc("/webhooks", metrics.Count(require(auth.PermWebhooksManage, nil, service.ListWebhooks), "/webhooks")).Methods("GET")
+	router.HandleFunc("/webhooks/{id}", metrics.Count(require(auth.PermWebhooksManage, nil, service.GetWebhook), "/webhooks/{id}")).Methods("GET")
+	router.HandleFunc("/webhooks/{id}", metrics.Count(require(auth.PermWebhooksManage, nil, service.DeleteWebhook), "/webhooks/{id}")).Methods("DELETE")
+	router.HandleFunc("/webhooks/{id}/deliveries", metrics.Count(require(auth.PermWebhooksManage, nil, service.ListWebhookDeliveries), "/webhooks/{id}/deliveries")).Methods("GET")
+	router.HandleFunc("/audit", metrics.Count(require(auth.PermAuditRead, nil, service.ListAudit), "/audit")).Methods("GET")
+	router.HandleFunc("/audit/verify", metrics.Count(require(auth.PermAuditRead, nil, service.VerifyAudit), "/audit/verify")).Methods("GET")
+	router.HandleFunc("/quota", metrics.Count(require(auth.PermConfigurationsRead, nil, service.GetQuota), "/quota")).Methods("GET")
+	router.HandleFunc("/schemas", metrics.Count(require(auth.PermSchemasManage, nil, service.CreateSchema), "/schemas")).Methods("POST")
+	router.HandleFunc("/schemas", metrics.Count(require(auth.PermConfigurationsRead, nil, service.ListSchemas), "/schemas")).Methods("GET")
+	router.HandleFunc("/schemas/{id}", metrics.Count(require(auth.PermConfigurationsRead, nil, service.ListSchemaVersions), "/schemas/{id}")).Methods("GET")
+	router.HandleFunc("/schemas/{id}/{version}", metrics.Count(require(auth.PermConfigurationsRead, nil, service.GetSchema), "/schemas/{id}/{version}")).Methods("GET")
+	router.HandleFunc("/schemas/{id}/{version}", metrics.Count(require(auth.PermSchemasManage, nil, service.DeleteSchema), "/schemas/{id}/{version}")).Methods("DELETE")
+	router.HandleFunc("/admin/roles", metrics.Count(require(auth.PermRBACManage, nil, service.ListRoles), "/admin/roles")).Methods("GET")
+	router.HandleFunc("/admin/role-bindings", metrics.Count(require(auth.PermRBACManage, nil, service.CreateRoleBinding), "/admin/role-bindings")).Methods("POST")
+	router.HandleFunc("/admin/role-bindings", metrics.Count(require(auth.PermRBACManage, nil, service.ListRoleBindings), "/admin/role-bindings")).Methods("GET")
+	router.HandleFunc("/admin/role-bindings/{id}", metrics.Count(require(auth.PermRBACManage, nil, service.DeleteRoleBinding), "/admin/role-bindings/{id}")).Methods("DELETE")
 	router.HandleFunc("/swagger.yaml", metrics.Count(service.SwaggerHandler, "/swagger.yaml")).Methods("GET")
-	router.HandleFunc("/group/{id}/{version}/{labels}", metrics.Count(service.GetConfigurationGroupsByLabels, "/group/{id}/{version}/{labels}")).Methods("GET")
+	router.HandleFunc("/configurations/{id}/{version}/watch", metrics.Count(require(auth.PermConfigurationsRead, targets.ConfigurationPath, service.WatchConfiguration), "/configurations/{id}/{version}/watch")).Methods("GET")
+	router.HandleFunc("/group/{id}/{version}/watch", metrics.Count(require(auth.PermConfigurationsRead, targets.GroupPath, service.WatchConfigurationGroup), "/group/{id}/{version}/watch")).Methods("GET")
+	router.HandleFunc("/group/{id}/{version}/{labels}/watch", metrics.Count(require(auth.PermConfigurationsRead, targets.GroupPathLabels, service.WatchConfigurationGroupsByLabels), "/group/{id}/{version}/{labels}/watch")).Methods("GET")
+	router.HandleFunc("/group/{id}/{version}/{labels}", metrics.Count(require(auth.PermConfigurationsRead, targets.GroupPathLabels, service.GetConfigurationGroupsByLabels), "/group/{id}/{version}/{labels}")).Methods("GET")
 
 	// Prometheus metrics endpoint
 	router.Handle("/metrics", promhttp.Handler())
 
 	// start server
+	// every route is also served under /namespaces/{namespace}
 	srv := &http.Server{
 		Addr:    "0.0.0.0:8000",
-		Handler: router,
+		Handler: namespace.Middleware(router),
 	}
 
 	go func() {
@@ -63,6 +156,8 @@ func main() {
 	}()
 
 	<-quit
+	// also ends the open event streams so Shutdown doesn't wait for them
+	stopBackground()
 
 	// gracefully stop server
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -74,3 +169,126 @@ func main() {
 
 	log.Println("server stopped")
 }
+
+// newStore picks the storage backend from the STORE env var: "memory" keeps
+// everything in process, anything else (the default) uses Consul.
+func newStore() (*poststore.PostStore, error) {
+	switch os.Getenv("STORE") {
+	case "memory":
+		log.Println("using in-memory store")
+		return poststore.NewInMemory(), nil
+	default:
+		return poststore.New()
+	}
+}
+
+// newAuthenticator accepts the API keys stored in Consul and, when set, the
+// ones in the AUTH_API_KEYS_FILE file and JWTs signed by a key in the
+// AUTH_JWKS_FILE file. AUTH_ALLOW_ANONYMOUS=true lets requests without
+// credentials through.
+func newAuthenticator(ps *poststore.PostStore) (*auth.Authenticator, error) {
+	a := &auth.Authenticator{
+		Store:          ps,
+		AllowAnonymous: os.Getenv("AUTH_ALLOW_ANONYMOUS") == "true",
+		PublicPaths:    map[string]bool{"/metrics": true, "/swagger.yaml": true},
+	}
+
+	if path := os.Getenv("AUTH_API_KEYS_FILE"); path != "" {
+		keys, err := auth.LoadAPIKeysFile(path)
+		if err != nil {
+			return nil, err
+		}
+		a.FileKeys = keys
+	}
+
+	if path := os.Getenv("AUTH_JWKS_FILE"); path != "" {
+		jwks, err := auth.LoadJWKS(path)
+		if err != nil {
+			return nil, err
+		}
+		jwks.Issuer = os.Getenv("AUTH_JWT_ISSUER")
+		jwks.Audience = os.Getenv("AUTH_JWT_AUDIENCE")
+		a.JWKS = jwks
+	}
+
+	if a.AllowAnonymous {
+		log.Println("AUTH_ALLOW_ANONYMOUS is set, requests without credentials are accepted")
+	}
+	return a, nil
+}
+
+// newWebhookDispatcher delivers to public addresses only, unless
+// WEBHOOK_ALLOW_PRIVATE=true, e.g. for receivers on a development machine.
+func newWebhookDispatcher(ps *poststore.PostStore) *service.WebhookDispatcher {
+	d := service.NewWebhookDispatcher(ps)
+	d.AllowPrivate = os.Getenv("WEBHOOK_ALLOW_PRIVATE") == "true"
+	return d
+}
+
+// newAuthorizer checks the role bindings stored in Consul. The subjects in the
+// comma separated RBAC_ADMINS, e.g. "jwt:alice,api_key:ops", are always admins
+// so they can create the first bindings.
+func newAuthorizer(ps *poststore.PostStore) *auth.Authorizer {
+	a := &auth.Authorizer{Store: ps, Admins: map[string]bool{}}
+	for _, subject := range strings.Split(os.Getenv("RBAC_ADMINS"), ",") {
+		if subject = strings.TrimSpace(subject); subject != "" {
+			a.Admins[subject] = true
+		}
+	}
+	if len(a.Admins) == 0 {
+		log.Println("RBAC_ADMINS is not set, only subjects with role bindings are authorized")
+	}
+	return a
+}
+
+// newLimiter limits the requests per IP address, per caller and per route,
+// with the limits in RATE_LIMIT_ADDRESS, RATE_LIMIT_IDENTITY_READ,
+// RATE_LIMIT_IDENTITY_WRITE, RATE_LIMIT_ROUTE_READ and RATE_LIMIT_ROUTE_WRITE,
+// e.g. "20/s", "600/1m" or "5/s:50" for a burst of 50. "off" disables a limit.
+// Addresses get 100 requests per second by default, callers 50 reads and 10
+// writes, routes are unlimited.
+func newLimiter() *ratelimit.Limiter {
+	limiter := ratelimit.New(ratelimit.Config{
+		Address:       limitEnv("RATE_LIMIT_ADDRESS", ratelimit.Limit{Rate: 100, Burst: 200}),
+		IdentityRead:  limitEnv("RATE_LIMIT_IDENTITY_READ", ratelimit.Limit{Rate: 50, Burst: 100}),
+		IdentityWrite: limitEnv("RATE_LIMIT_IDENTITY_WRITE", ratelimit.Limit{Rate: 10, Burst: 20}),
+		RouteRead:     limitEnv("RATE_LIMIT_ROUTE_READ", ratelimit.Limit{}),
+		RouteWrite:    limitEnv("RATE_LIMIT_ROUTE_WRITE", ratelimit.Limit{}),
+	})
+	limiter.Exempt["/metrics"] = true
+	limiter.Exempt["/swagger.yaml"] = true
+	return limiter
+}
+
+// limitEnv reads a rate limit from the environment, falling back to def when
+// the variable is unset or invalid.
+func limitEnv(name string, def ratelimit.Limit) ratelimit.Limit {
+	value := os.Getenv(name)
+	switch value {
+	case "":
+		return def
+	case "off":
+		return ratelimit.Limit{}
+	}
+	limit, err := ratelimit.ParseLimit(value)
+	if err != nil {
+		log.Printf("invalid %s: %v, using %s", name, err, def)
+		return def
+	}
+	return limit
+}
+
+// durationEnv reads a duration such as "24h" from the environment, falling
+// back to def when the variable is unset or invalid.
+func durationEnv(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("invalid %s %q, using %s", name, value, def)
+		return def
+	}
+	return d
+}
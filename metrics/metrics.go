@@ -2,7 +2,9 @@ package metrics
 
 import (
 	"net/http"
+	"sync"
 
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/namespace"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
@@ -13,13 +15,113 @@ var (
 			Name: "api_hits_total",
 			Help: "Total number of hits to API endpoints",
 		},
-		[]string{"endpoint"},
+		[]string{"endpoint", "namespace"},
+	)
+
+	// IdempotencyKeysSwept counts expired idempotency records removed by the sweeper.
+	IdempotencyKeysSwept = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "idempotency_keys_swept_total",
+			Help: "Total number of expired idempotency keys removed by the sweeper",
+		},
+	)
+
+	// AuthRequests counts authentication results by method ("api_key", "jwt",
+	// "anonymous") and result.
+	AuthRequests = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "auth_requests_total",
+			Help: "Total number of authenticated, anonymous and rejected requests",
+		},
+		[]string{"method", "result"},
+	)
+
+	// QuotaUsage is how much of each quota resource ("configs", "groups",
+	// "bytes", ...) a namespace uses.
+	QuotaUsage = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "quota_usage",
+			Help: "Usage of the quota resources per namespace",
+		},
+		[]string{"namespace", "resource"},
+	)
+
+	// QuotaLimit is the limit of each quota resource of a namespace, zero is
+	// unlimited.
+	QuotaLimit = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "quota_limit",
+			Help: "Limits of the quota resources per namespace, zero is unlimited",
+		},
+		[]string{"namespace", "resource"},
+	)
+
+	// QuotaRejections counts the writes rejected because they would exceed a
+	// quota.
+	QuotaRejections = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "quota_rejections_total",
+			Help: "Total number of writes rejected by a namespace quota",
+		},
+		[]string{"namespace", "resource"},
+	)
+
+	// AuditAppendFailures counts the changes that were made but couldn't be
+	// recorded in the audit log, by namespace and action. Anything but zero
+	// means the log is incomplete.
+	AuditAppendFailures = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "audit_append_failures_total",
+			Help: "Total number of audit records that couldn't be appended",
+		},
+		[]string{"namespace", "action"},
+	)
+
+	// RateLimited counts the requests rejected by the rate limiter, by the
+	// bucket that ran out: scope "address", "identity" or "route", kind
+	// "read" or "write".
+	RateLimited = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rate_limited_total",
+			Help: "Total number of requests rejected by the rate limiter",
+		},
+		[]string{"endpoint", "namespace", "scope", "kind"},
 	)
 )
 
+// OtherNamespace is the namespace label of the namespaces that weren't added
+// with AddNamespace. Any caller can name a new namespace, a label per
+// namespace would let them add series without bound. The underscore keeps it
+// apart from the namespace names.
+const OtherNamespace = "_other"
+
+var (
+	namespacesMu sync.RWMutex
+	namespaces   = map[string]bool{namespace.Default: true}
+)
+
+// AddNamespace gives ns its own namespace label, for the namespaces with
+// quotas or role bindings.
+func AddNamespace(ns string) {
+	namespacesMu.Lock()
+	defer namespacesMu.Unlock()
+	namespaces[ns] = true
+}
+
+// Namespace returns the namespace label of ns, OtherNamespace unless ns was
+// added with AddNamespace.
+func Namespace(ns string) string {
+	namespacesMu.RLock()
+	defer namespacesMu.RUnlock()
+	if namespaces[ns] {
+		return ns
+	}
+	return OtherNamespace
+}
+
 func Count(handler http.HandlerFunc, endpoint string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		apiHits.WithLabelValues(endpoint).Inc()
+		apiHits.WithLabelValues(endpoint, Namespace(namespace.FromRequest(r))).Inc()
 		handler(w, r)
 	}
 }
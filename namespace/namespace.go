@@ -0,0 +1,106 @@
+// Package namespace separates the configurations of different teams. Every
+// request belongs to one namespace, taken from a /namespaces/{namespace} path
+// prefix or the X-Namespace header. Requests with neither use Default, so the
+// URLs from before namespaces keep working.
+package namespace
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Default is the namespace of requests that don't name one. Its keys are
+// stored without a namespace prefix, where they were before namespaces.
+const Default = "default"
+
+// Header names the namespace of a request.
+const Header = "X-Namespace"
+
+// pathPrefix starts the URLs of a namespace, e.g.
+// /namespaces/team-a/configurations/api/1.
+const pathPrefix = "/namespaces/"
+
+const maxLength = 63
+
+// Namespaces become path segments of Consul keys, like IDs.
+var pattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// Validate checks a namespace name.
+func Validate(ns string) error {
+	if len(ns) > maxLength || !pattern.MatchString(ns) {
+		return fmt.Errorf("invalid namespace %q, namespaces are at most %d lowercase letters, digits and dashes", ns, maxLength)
+	}
+	return nil
+}
+
+type namespaceKey struct{}
+
+// With returns a context carrying the namespace.
+func With(ctx context.Context, ns string) context.Context {
+	return context.WithValue(ctx, namespaceKey{}, ns)
+}
+
+// FromContext returns the namespace of ctx, Default if it has none.
+func FromContext(ctx context.Context) string {
+	ns, _ := ctx.Value(namespaceKey{}).(string)
+	return Normalize(ns)
+}
+
+// FromRequest is FromContext for the context of r.
+func FromRequest(r *http.Request) string {
+	return FromContext(r.Context())
+}
+
+// Normalize returns the namespace stored in a record, which is empty in the
+// records written before namespaces existed.
+func Normalize(ns string) string {
+	if ns == "" {
+		return Default
+	}
+	return ns
+}
+
+// Path returns the URL of p in the namespace of ctx, e.g. for Location
+// headers.
+func Path(ctx context.Context, p string) string {
+	ns := FromContext(ctx)
+	if ns == Default {
+		return p
+	}
+	return pathPrefix + ns + p
+}
+
+// Middleware takes the namespace of every request from the path prefix or
+// the header and strips the prefix, so the routes only have to be registered
+// once. It must wrap the router, which matches routes before running its own
+// middlewares.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ns := r.Header.Get(Header)
+
+		if rest, ok := strings.CutPrefix(r.URL.Path, pathPrefix); ok {
+			fromPath, p, _ := strings.Cut(rest, "/")
+			if ns != "" && ns != fromPath {
+				http.Error(w, fmt.Sprintf("namespace %q in the path doesn't match the %s header %q", fromPath, Header, ns), http.StatusBadRequest)
+				return
+			}
+			ns = fromPath
+
+			r = r.Clone(r.Context())
+			r.URL.Path = "/" + p
+			r.URL.RawPath = ""
+		}
+
+		if ns == "" {
+			ns = Default
+		}
+		if err := Validate(ns); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(With(r.Context(), ns)))
+	})
+}
@@ -0,0 +1,118 @@
+package poststore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	tracer "github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/tracer"
+	"github.com/hashicorp/consul/api"
+)
+
+// API keys are stored by the hash of the key, so a presented key is looked up
+// with a single read:
+//
+//	apikeys/{sha256}
+
+func apiKeyKey(hash string) string {
+	return key("apikeys", hash)
+}
+
+func apiKeysPrefix() string {
+	return prefix("apikeys")
+}
+
+// AddAPIKey stores a new API key.
+func (ps *PostStore) AddAPIKey(ctx context.Context, apiKey *config.APIKey) error {
+	span := tracer.StartSpanFromContext(ctx, "Post")
+	defer span.Finish()
+
+	data, err := json.Marshal(apiKey)
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+
+	ok, _, err := ps.kv.CAS(&api.KVPair{Key: apiKeyKey(apiKey.Hash), Value: data, ModifyIndex: 0}, nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("api key %s: %w", apiKey.ID, ErrVersionExists)
+	}
+	return nil
+}
+
+// GetAPIKey returns the API key with the given hash.
+func (ps *PostStore) GetAPIKey(ctx context.Context, hash string) (*config.APIKey, error) {
+	span := tracer.StartSpanFromContext(ctx, "Get")
+	defer span.Finish()
+
+	pair, _, err := ps.kv.Get(apiKeyKey(hash), nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	if pair == nil {
+		return nil, fmt.Errorf("api key %w", ErrNotFound)
+	}
+
+	apiKey := &config.APIKey{}
+	err = json.Unmarshal(pair.Value, apiKey)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	return apiKey, nil
+}
+
+// ListAPIKeys returns every stored API key, oldest first.
+func (ps *PostStore) ListAPIKeys(ctx context.Context) ([]*config.APIKey, error) {
+	span := tracer.StartSpanFromContext(ctx, "GetAll")
+	defer span.Finish()
+
+	pairs, _, err := ps.list(apiKeysPrefix(), nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	apiKeys := make([]*config.APIKey, 0, len(pairs))
+	for _, pair := range pairs {
+		apiKey := &config.APIKey{}
+		err = json.Unmarshal(pair.Value, apiKey)
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, err
+		}
+		apiKeys = append(apiKeys, apiKey)
+	}
+	sort.Slice(apiKeys, func(i, j int) bool {
+		return apiKeys[i].CreatedAt.Before(apiKeys[j].CreatedAt)
+	})
+	return apiKeys, nil
+}
+
+// DeleteAPIKey revokes the API key with the given ID.
+func (ps *PostStore) DeleteAPIKey(ctx context.Context, id string) error {
+	span := tracer.StartSpanFromContext(ctx, "Delete")
+	defer span.Finish()
+
+	apiKeys, err := ps.ListAPIKeys(ctx)
+	if err != nil {
+		return err
+	}
+	for _, apiKey := range apiKeys {
+		if apiKey.ID == id {
+			_, err = ps.kv.Delete(apiKeyKey(apiKey.Hash), nil)
+			if err != nil {
+				tracer.LogError(span, err)
+			}
+			return err
+		}
+	}
+	return fmt.Errorf("api key %s %w", id, ErrNotFound)
+}
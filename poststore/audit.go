@@ -0,0 +1,346 @@
+package poststore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/namespace"
+	tracer "github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/tracer"
+	"github.com/hashicorp/consul/api"
+)
+
+// The audit log is append-only. Records are numbered by a head key holding
+// the last sequence number and hash, and every append creates the next record
+// and moves the head in one transaction, so concurrent writers never share or
+// skip a number and an existing record is never written again. Each record
+// carries the hash of the one before it, so editing a record in Consul breaks
+// the chain. Checkpoints sign the head from time to time, so the whole chain
+// can't be rewritten either:
+//
+//	audit/head
+//	audit/records/{seq}
+//	audit/checkpoints/{seq}
+
+// Appends that lose the race for the next sequence number are retried with
+// a jittered exponential backoff, until their context ends.
+const (
+	auditRetryBase = 2 * time.Millisecond
+	auditRetryMax  = 250 * time.Millisecond
+)
+
+// Sequence numbers are zero padded to a fixed width, so the keys of a block
+// of records share a prefix without a separator:
+// audit/records/00000000000000012 holds the records 12000 to 12999. Listings
+// read one block at a time instead of every key.
+const (
+	auditBlockDigits = 3
+	auditBlockSize   = 1000
+)
+
+func auditHeadKey() string {
+	return key("audit", "head")
+}
+
+func auditRecordsPrefix() string {
+	return prefix("audit", "records")
+}
+
+func auditRecordKey(seq uint64) string {
+	return auditRecordsPrefix() + fmt.Sprintf("%020d", seq)
+}
+
+func auditBlockPrefix(seq uint64) string {
+	k := auditRecordKey(seq)
+	return k[:len(k)-auditBlockDigits]
+}
+
+func auditCheckpointsPrefix() string {
+	return prefix("audit", "checkpoints")
+}
+
+func auditCheckpointKey(seq uint64) string {
+	return auditCheckpointsPrefix() + fmt.Sprintf("%020d", seq)
+}
+
+// AuditHead is the position of the last audit record.
+type AuditHead struct {
+	Seq  uint64 `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// AuditQuery selects audit records. Empty fields don't filter.
+type AuditQuery struct {
+	TargetKind string
+	TargetID   string
+	Actor      string
+	Namespace  string
+	Since      time.Time
+	Until      time.Time
+	// only records after this sequence number
+	After uint64
+	Limit int
+}
+
+func (q *AuditQuery) matches(record *config.AuditRecord) bool {
+	switch {
+	case q.TargetKind != "" && record.TargetKind != q.TargetKind:
+		return false
+	case q.TargetID != "" && record.TargetID != q.TargetID:
+		return false
+	case q.Actor != "" && record.Actor != q.Actor:
+		return false
+	case q.Namespace != "" && q.Namespace != record.Namespace && !(q.Namespace == namespace.Default && record.Namespace == ""):
+		// records from before namespaces are in the default one
+		return false
+	case !q.Since.IsZero() && record.Time.Before(q.Since):
+		return false
+	case !q.Until.IsZero() && !record.Time.Before(q.Until):
+		return false
+	}
+	return true
+}
+
+// AppendAudit adds a record to the end of the audit log and sets its
+// sequence number. It only gives up when ctx ends.
+func (ps *PostStore) AppendAudit(ctx context.Context, record *config.AuditRecord) error {
+	span := tracer.StartSpanFromContext(ctx, "Post")
+	defer span.Finish()
+
+	for attempt := 1; ; attempt++ {
+		head, _, err := ps.kv.Get(auditHeadKey(), nil)
+		if err != nil {
+			tracer.LogError(span, err)
+			return err
+		}
+
+		current := AuditHead{}
+		headOp := createOp(auditHeadKey(), nil)
+		if head != nil {
+			err = json.Unmarshal(head.Value, &current)
+			if err != nil {
+				tracer.LogError(span, err)
+				return err
+			}
+			headOp.Index = head.ModifyIndex
+		}
+
+		record.Seq = current.Seq + 1
+		record.PrevHash = current.Hash
+		record.Hash = record.ComputeHash()
+		headOp.Value, err = json.Marshal(AuditHead{Seq: record.Seq, Hash: record.Hash})
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(record)
+		if err != nil {
+			tracer.LogError(span, err)
+			return err
+		}
+
+		_, err = ps.txn(api.KVTxnOps{headOp, createOp(auditRecordKey(record.Seq), data)})
+		if !errors.Is(err, errTxnConflict) {
+			if err != nil {
+				tracer.LogError(span, err)
+			}
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			err = fmt.Errorf("appending audit record after %d attempts: %w (%v)", attempt, ErrConflict, ctx.Err())
+			tracer.LogError(span, err)
+			return err
+		case <-time.After(auditRetryDelay(attempt)):
+		}
+	}
+}
+
+// auditRetryDelay returns a random wait of up to twice the one before, so
+// the writers that lost a race don't collide again.
+func auditRetryDelay(attempt int) time.Duration {
+	delay := auditRetryBase
+	for i := 1; i < attempt && delay < auditRetryMax; i++ {
+		delay *= 2
+	}
+	if delay > auditRetryMax {
+		delay = auditRetryMax
+	}
+	return time.Duration(rand.Int63n(int64(delay))) + 1
+}
+
+// listAuditBlock returns the records of the block holding seq in sequence
+// order. Records that don't decode are returned with only the sequence
+// number from their key, so a tampered record still shows in the chain.
+func (ps *PostStore) listAuditBlock(seq uint64) ([]*config.AuditRecord, error) {
+	// the block prefix doesn't end with the separator, but it can't match
+	// keys across a boundary since every record key has the same length
+	pairs, _, err := ps.kv.List(auditBlockPrefix(seq), nil)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].Key < pairs[j].Key
+	})
+
+	records := make([]*config.AuditRecord, 0, len(pairs))
+	for _, pair := range pairs {
+		record := &config.AuditRecord{}
+		if json.Unmarshal(pair.Value, record) != nil {
+			record = &config.AuditRecord{}
+			fmt.Sscanf(strings.TrimPrefix(pair.Key, auditRecordsPrefix()), "%d", &record.Seq)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// ListAudit returns up to q.Limit matching records after q.After, oldest
+// first. The returned cursor is the sequence number to continue after, zero
+// when there are no more records.
+func (ps *PostStore) ListAudit(ctx context.Context, q AuditQuery) ([]*config.AuditRecord, uint64, error) {
+	span := tracer.StartSpanFromContext(ctx, "GetAll")
+	defer span.Finish()
+
+	head, err := ps.GetAuditHead(ctx)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, 0, err
+	}
+
+	records := make([]*config.AuditRecord, 0)
+	for block := (q.After + 1) / auditBlockSize * auditBlockSize; block <= head.Seq; block += auditBlockSize {
+		if err := ctx.Err(); err != nil {
+			return nil, 0, err
+		}
+		blockRecords, err := ps.listAuditBlock(block)
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, 0, err
+		}
+
+		for _, record := range blockRecords {
+			if record.Seq <= q.After || !q.matches(record) {
+				continue
+			}
+			records = append(records, record)
+			if q.Limit > 0 && len(records) == q.Limit {
+				if record.Seq < head.Seq {
+					return records, record.Seq, nil
+				}
+				return records, 0, nil
+			}
+		}
+	}
+
+	return records, 0, nil
+}
+
+// GetAuditHead returns the position of the last audit record, zero if there
+// is none yet.
+func (ps *PostStore) GetAuditHead(ctx context.Context) (*AuditHead, error) {
+	span := tracer.StartSpanFromContext(ctx, "Get")
+	defer span.Finish()
+
+	head := &AuditHead{}
+	pair, _, err := ps.kv.Get(auditHeadKey(), nil)
+	if err == nil && pair != nil {
+		err = json.Unmarshal(pair.Value, head)
+	}
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	return head, nil
+}
+
+// WalkAudit calls fn for every audit record in sequence order. Records are
+// read one block at a time, so the log doesn't have to fit in memory. The
+// walk goes on past the head as long as there are records, so records added
+// behind the head's back show up too.
+func (ps *PostStore) WalkAudit(ctx context.Context, fn func(record *config.AuditRecord) error) error {
+	span := tracer.StartSpanFromContext(ctx, "GetAll")
+	defer span.Finish()
+
+	head, err := ps.GetAuditHead(ctx)
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+
+	for block := uint64(0); ; block += auditBlockSize {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		records, err := ps.listAuditBlock(block)
+		if err != nil {
+			tracer.LogError(span, err)
+			return err
+		}
+		if len(records) == 0 && block > head.Seq {
+			return nil
+		}
+
+		for _, record := range records {
+			if err := fn(record); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// SaveAuditCheckpoint stores a signed checkpoint. There is at most one per
+// record, ErrVersionExists is returned if another instance was faster.
+func (ps *PostStore) SaveAuditCheckpoint(ctx context.Context, checkpoint *config.AuditCheckpoint) error {
+	span := tracer.StartSpanFromContext(ctx, "Post")
+	defer span.Finish()
+
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+
+	ok, _, err := ps.kv.CAS(&api.KVPair{Key: auditCheckpointKey(checkpoint.Seq), Value: data, ModifyIndex: 0}, nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("audit checkpoint %d: %w", checkpoint.Seq, ErrVersionExists)
+	}
+	return nil
+}
+
+// ListAuditCheckpoints returns the checkpoints, oldest first.
+func (ps *PostStore) ListAuditCheckpoints(ctx context.Context) ([]*config.AuditCheckpoint, error) {
+	span := tracer.StartSpanFromContext(ctx, "GetAll")
+	defer span.Finish()
+
+	pairs, _, err := ps.list(auditCheckpointsPrefix(), nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].Key < pairs[j].Key
+	})
+
+	checkpoints := make([]*config.AuditCheckpoint, 0, len(pairs))
+	for _, pair := range pairs {
+		checkpoint := &config.AuditCheckpoint{}
+		err = json.Unmarshal(pair.Value, checkpoint)
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, fmt.Errorf("audit checkpoint %s: %w", pair.Key, err)
+		}
+		checkpoints = append(checkpoints, checkpoint)
+	}
+	return checkpoints, nil
+}
@@ -0,0 +1,153 @@
+package poststore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	tracer "github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/tracer"
+	"github.com/google/uuid"
+	"github.com/hashicorp/consul/api"
+)
+
+// Events are stored under events/ so every instance sharing the Consul
+// cluster sees the changes made by the others. The ID of an event is the
+// CreateIndex Consul gave its key, which grows with every write in the
+// cluster, so events can be ordered and resumed from without a separate
+// counter. Key names start with the creation time, which keeps the oldest
+// events first when trimming.
+
+// DefaultEventRetention is how many events are kept when
+// PostStore.EventRetention is zero.
+const DefaultEventRetention = 1000
+
+func eventsPrefix() string {
+	return prefix("events")
+}
+
+func eventKey(t time.Time) string {
+	return eventsPrefix() + fmt.Sprintf("%020d-%s", t.UnixNano(), uuid.New().String())
+}
+
+// AppendEvent stores an event and, every tenth of the retention, trims the
+// oldest events beyond it. The event ID is set from the stored key.
+func (ps *PostStore) AppendEvent(ctx context.Context, event *config.Event) error {
+	span := tracer.StartSpanFromContext(ctx, "Post")
+	defer span.Finish()
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+
+	// a transaction returns the created pair, and with it the index
+	results, err := ps.txn(api.KVTxnOps{createOp(eventKey(event.Time), data)})
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+	if len(results) > 0 {
+		event.ID = results[0].CreateIndex
+	}
+
+	if ps.eventAppends.Add(1)%uint64(ps.trimInterval()) != 0 {
+		return nil
+	}
+	err = ps.trimEvents()
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+
+	return nil
+}
+
+func (ps *PostStore) eventRetention() int {
+	if ps.EventRetention <= 0 {
+		return DefaultEventRetention
+	}
+	return ps.EventRetention
+}
+
+// trimInterval is how many events an instance appends between trims, so the
+// key listing is paid once per tenth of the retention instead of on every
+// append. Events stay bounded by the retention plus what every instance
+// appended since its last trim.
+func (ps *PostStore) trimInterval() int {
+	interval := ps.eventRetention() / 10
+	if interval < 1 {
+		return 1
+	}
+	return interval
+}
+
+// trimEvents deletes the oldest events beyond the retention.
+func (ps *PostStore) trimEvents() error {
+	retention := ps.eventRetention()
+
+	keys, _, err := ps.kv.Keys(eventsPrefix(), "", nil)
+	if err != nil {
+		return err
+	}
+	if len(keys) <= retention {
+		return nil
+	}
+
+	sort.Strings(keys)
+	for _, key := range keys[:len(keys)-retention] {
+		_, err := ps.kv.Delete(key, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WatchEvents returns the stored events with an ID greater than after,
+// oldest first. With a non-zero index it blocks like the other watches until
+// events were written after index or wait elapsed, and returns the index to
+// pass to the next call.
+func (ps *PostStore) WatchEvents(ctx context.Context, after, index uint64, wait time.Duration) ([]*config.Event, uint64, error) {
+	span := tracer.StartSpanFromContext(ctx, "Watch")
+	defer span.Finish()
+
+	var pairs api.KVPairs
+	last, err := blockingQuery(ctx, index, wait, func(q *api.QueryOptions) (uint64, error) {
+		p, last, err := ps.list(eventsPrefix(), q)
+		if err != nil {
+			return 0, err
+		}
+		pairs = p
+		return last, nil
+	})
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, 0, err
+	}
+
+	events := make([]*config.Event, 0)
+	for _, pair := range pairs {
+		if pair.CreateIndex <= after {
+			continue
+		}
+		event := &config.Event{}
+		err := json.Unmarshal(pair.Value, event)
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, 0, err
+		}
+		event.ID = pair.CreateIndex
+		events = append(events, event)
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].ID < events[j].ID
+	})
+
+	return events, last, nil
+}
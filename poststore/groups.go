@@ -0,0 +1,380 @@
+package poststore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/namespace"
+	tracer "github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/tracer"
+	"github.com/hashicorp/consul/api"
+)
+
+// groupSnapshot is a group version read in a single List call.
+type groupSnapshot struct {
+	manifest      *config.Group
+	manifestIndex uint64
+	members       []*config.Config
+}
+
+// readGroup returns the group version, or nil if it doesn't exist. Members
+// are returned in manifest order.
+func (ps *PostStore) readGroup(ns, id, version string) (*groupSnapshot, error) {
+	pairs, _, err := ps.list(groupPrefix(ns, id, version), nil)
+	if err != nil {
+		return nil, err
+	}
+	return parseGroup(ns, id, version, pairs)
+}
+
+// parseGroup builds a group snapshot from the pairs under its prefix.
+func parseGroup(ns, id, version string, pairs api.KVPairs) (*groupSnapshot, error) {
+	snapshot := &groupSnapshot{}
+	members := make(map[string]*config.Config)
+	for _, pair := range pairs {
+		switch {
+		case pair.Key == manifestKey(ns, id, version):
+			snapshot.manifest = &config.Group{}
+			if err := json.Unmarshal(pair.Value, snapshot.manifest); err != nil {
+				return nil, err
+			}
+			snapshot.manifestIndex = pair.ModifyIndex
+			snapshot.manifest.ModifyIndex = pair.ModifyIndex
+		case strings.HasPrefix(pair.Key, memberPrefix(ns, id, version)):
+			c := &config.Config{}
+			if err := json.Unmarshal(pair.Value, c); err != nil {
+				return nil, err
+			}
+			c.ModifyIndex = pair.ModifyIndex
+			members[c.ID] = c
+		}
+	}
+	if snapshot.manifest == nil {
+		return nil, nil
+	}
+
+	for _, m := range snapshot.manifest.Members {
+		if c, ok := members[m]; ok {
+			snapshot.members = append(snapshot.members, c)
+		}
+	}
+	return snapshot, nil
+}
+
+// AddConfigurationGroup stores all configs, grouped by their group ID and
+// version, together with the idempotency record and the quota change if they
+// are given, in a single transaction. Nothing is written if any of the group
+// versions exists, except for the versions of the manifests in replace, which
+// are deleted in the same transaction if they weren't modified since they
+// were read. Otherwise ErrConflict is returned.
+func (ps *PostStore) AddConfigurationGroup(ctx context.Context, configs []*config.Config, replace []*config.Group, idempotency *IdempotencyEntry, quota *QuotaChange) error {
+	span := tracer.StartSpanFromContext(ctx, "Post")
+	defer span.Finish()
+
+	ns := namespace.FromContext(ctx)
+	now := time.Now().UTC()
+	manifests := make([]*config.Group, 0)
+	byKey := make(map[string]*config.Group)
+	creates := make(api.KVTxnOps, 0, len(configs))
+	for _, c := range configs {
+		key := manifestKey(ns, c.GroupID, c.Version)
+		manifest, ok := byKey[key]
+		if !ok {
+			manifest = &config.Group{ID: c.GroupID, Version: c.Version, PromotedFrom: c.PromotedFrom, CreatedAt: now, UpdatedAt: now}
+			byKey[key] = manifest
+			manifests = append(manifests, manifest)
+		}
+		if manifest.HasMember(c.ID) {
+			return fmt.Errorf("configuration %s is listed twice in group %s version %s", c.ID, c.GroupID, c.Version)
+		}
+		manifest.Members = append(manifest.Members, c.ID)
+
+		data, err := json.Marshal(c)
+		if err != nil {
+			tracer.LogError(span, err)
+			return err
+		}
+		creates = append(creates, createOp(memberKey(ns, c.GroupID, c.Version, c.ID), data))
+	}
+
+	// manifests go last: a group is only visible once its manifest exists
+	final := make(api.KVTxnOps, 0, len(manifests)+1)
+	for _, manifest := range manifests {
+		data, err := json.Marshal(manifest)
+		if err != nil {
+			tracer.LogError(span, err)
+			return err
+		}
+		final = append(final, createOp(manifestKey(ns, manifest.ID, manifest.Version), data))
+	}
+	if idempotency != nil {
+		op, err := ps.idempotencyOp(ns, idempotency)
+		if err != nil {
+			tracer.LogError(span, err)
+			return err
+		}
+		final = append(final, op)
+	}
+
+	// the replaced versions are deleted ahead of the creates of their keys
+	first := make(api.KVTxnOps, 0, 2*len(replace))
+	for _, manifest := range replace {
+		prefix := groupPrefix(ns, manifest.ID, manifest.Version)
+		if err := checkPrefix(prefix); err != nil {
+			tracer.LogError(span, err)
+			return err
+		}
+		first = append(first,
+			&api.KVTxnOp{Verb: api.KVCheckIndex, Key: manifestKey(ns, manifest.ID, manifest.Version), Index: manifest.ModifyIndex},
+			&api.KVTxnOp{Verb: api.KVDeleteTree, Key: prefix},
+		)
+	}
+
+	err := ps.withQuota(ctx, quota, func(quotaOps api.KVTxnOps) error {
+		return ps.commitTxn(first, creates, append(final, quotaOps...))
+	})
+	err = ps.idempotencyConflict(ns, idempotency, err)
+	if errors.Is(err, errTxnConflict) && len(replace) > 0 {
+		err = fmt.Errorf("group version: %w (%v)", ErrConflict, err)
+	} else if errors.Is(err, errTxnConflict) {
+		err = fmt.Errorf("group version: %w (%v)", ErrVersionExists, err)
+	}
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+
+	return nil
+}
+
+// ListGroupVersions returns the versions of a group ordered from the lowest
+// to the highest version.
+func (ps *PostStore) ListGroupVersions(ctx context.Context, id string) ([]string, error) {
+	span := tracer.StartSpanFromContext(ctx, "GetAll")
+	defer span.Finish()
+
+	prefix := groupVersionsPrefix(namespace.FromContext(ctx), id)
+	err := checkPrefix(prefix)
+	if err != nil {
+		return nil, err
+	}
+	keys, _, err := ps.kv.Keys(prefix, separator, nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	versions := make([]string, 0, len(keys))
+	for _, key := range keys {
+		// only the folders of group versions, legacy keys need a migration
+		if strings.HasSuffix(key, separator) {
+			versions = append(versions, strings.TrimSuffix(key[len(prefix):], separator))
+		}
+	}
+	config.SortVersions(versions)
+
+	return versions, nil
+}
+
+// GetGroupManifest returns the manifest of a group version, or nil if the
+// group version doesn't exist.
+func (ps *PostStore) GetGroupManifest(ctx context.Context, id, version string) (*config.Group, error) {
+	span := tracer.StartSpanFromContext(ctx, "Get")
+	defer span.Finish()
+
+	pair, _, err := ps.kv.Get(manifestKey(namespace.FromContext(ctx), id, version), nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	if pair == nil {
+		return nil, nil
+	}
+
+	manifest := &config.Group{}
+	err = json.Unmarshal(pair.Value, manifest)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	manifest.ModifyIndex = pair.ModifyIndex
+
+	return manifest, nil
+}
+
+// GetGroup returns the manifest and the members of a group version, read
+// consistently in a single List call. It returns ErrNotFound if the group
+// version doesn't exist.
+func (ps *PostStore) GetGroup(ctx context.Context, id, version string) (*config.Group, []*config.Config, error) {
+	span := tracer.StartSpanFromContext(ctx, "Get")
+	defer span.Finish()
+
+	snapshot, err := ps.readGroup(namespace.FromContext(ctx), id, version)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, nil, err
+	}
+	if snapshot == nil {
+		return nil, nil, fmt.Errorf("group %s version %s %w", id, version, ErrNotFound)
+	}
+
+	return snapshot.manifest, snapshot.members, nil
+}
+
+// GetConfigurationGroup returns the members of a group version. A group that
+// doesn't exist has no members.
+func (ps *PostStore) GetConfigurationGroup(ctx context.Context, id, version string) ([]*config.Config, error) {
+	span := tracer.StartSpanFromContext(ctx, "Get")
+	defer span.Finish()
+
+	snapshot, err := ps.readGroup(namespace.FromContext(ctx), id, version)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	if snapshot == nil {
+		return make([]*config.Config, 0), nil
+	}
+	if snapshot.members == nil {
+		return make([]*config.Config, 0), nil
+	}
+
+	return snapshot.members, nil
+}
+
+func (ps *PostStore) DeleteConfigurationGroup(ctx context.Context, id, version string, quota *QuotaChange) error {
+	span := tracer.StartSpanFromContext(ctx, "Delete")
+	defer span.Finish()
+
+	prefix := groupPrefix(namespace.FromContext(ctx), id, version)
+	err := checkPrefix(prefix)
+	if err == nil {
+		err = ps.withQuota(ctx, quota, func(quotaOps api.KVTxnOps) error {
+			_, err := ps.txn(append(api.KVTxnOps{{Verb: api.KVDeleteTree, Key: prefix}}, quotaOps...))
+			return err
+		})
+	}
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+
+	return nil
+}
+
+// DeleteConfigurationGroupCAS deletes a group version only if its manifest
+// ModifyIndex still equals index.
+func (ps *PostStore) DeleteConfigurationGroupCAS(ctx context.Context, id, version string, index uint64, quota *QuotaChange) error {
+	span := tracer.StartSpanFromContext(ctx, "Delete")
+	defer span.Finish()
+
+	ns := namespace.FromContext(ctx)
+	ops := api.KVTxnOps{
+		{Verb: api.KVCheckIndex, Key: manifestKey(ns, id, version), Index: index},
+		{Verb: api.KVDeleteTree, Key: groupPrefix(ns, id, version)},
+	}
+	err := ps.withQuota(ctx, quota, func(quotaOps api.KVTxnOps) error {
+		_, err := ps.txn(append(ops, quotaOps...))
+		return err
+	})
+	if errors.Is(err, errTxnConflict) {
+		err = fmt.Errorf("group %s version %s: %w", id, version, ErrPreconditionFailed)
+	}
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+
+	return nil
+}
+
+// ExtendConfigurationGroup adds new members to an existing group version.
+// The members and the updated manifest are written in one transaction which
+// fails with ErrConflict if the group was changed in the meantime. A non-zero
+// index is the manifest ModifyIndex the caller expects (from If-Match), if it
+// doesn't match ErrPreconditionFailed is returned instead. The quota change,
+// if one is given, is committed with the manifest.
+func (ps *PostStore) ExtendConfigurationGroup(ctx context.Context, id, version string, newConfigs []*config.Config, index uint64, quota *QuotaChange) error {
+	span := tracer.StartSpanFromContext(ctx, "Post")
+	defer span.Finish()
+
+	ns := namespace.FromContext(ctx)
+	snapshot, err := ps.readGroup(ns, id, version)
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+	if snapshot == nil {
+		return fmt.Errorf("group %s version %s %w", id, version, ErrNotFound)
+	}
+	if index != 0 && index != snapshot.manifestIndex {
+		return fmt.Errorf("group %s version %s: %w", id, version, ErrPreconditionFailed)
+	}
+
+	manifest := snapshot.manifest
+	creates := make(api.KVTxnOps, 0, len(newConfigs))
+	for _, c := range newConfigs {
+		if manifest.HasMember(c.ID) {
+			return fmt.Errorf("configuration %s in group %s version %s: %w", c.ID, id, version, ErrVersionExists)
+		}
+		manifest.Members = append(manifest.Members, c.ID)
+
+		data, err := json.Marshal(c)
+		if err != nil {
+			tracer.LogError(span, err)
+			return err
+		}
+		creates = append(creates, createOp(memberKey(ns, id, version, c.ID), data))
+	}
+	manifest.UpdatedAt = time.Now().UTC()
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+	final := api.KVTxnOps{
+		{Verb: api.KVCAS, Key: manifestKey(ns, id, version), Value: data, Index: snapshot.manifestIndex},
+	}
+
+	err = ps.withQuota(ctx, quota, func(quotaOps api.KVTxnOps) error {
+		return ps.commitTxn(nil, creates, append(final, quotaOps...))
+	})
+	if errors.Is(err, errTxnConflict) && index != 0 {
+		err = fmt.Errorf("group %s version %s: %w", id, version, ErrPreconditionFailed)
+	} else if errors.Is(err, errTxnConflict) {
+		err = fmt.Errorf("group %s version %s: %w (%v)", id, version, ErrConflict, err)
+	}
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+
+	return nil
+}
+
+// GetConfigurationGroupsByLabels returns the members of a group version whose
+// labels match the selector.
+func (ps *PostStore) GetConfigurationGroupsByLabels(ctx context.Context, id, version string, selector config.Selector) ([]*config.Config, error) {
+	span := tracer.StartSpanFromContext(ctx, "Get")
+	defer span.Finish()
+
+	members, err := ps.GetConfigurationGroup(ctx, id, version)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	configs := make([]*config.Config, 0)
+	for _, c := range members {
+		if selector.Matches(c.Labels) {
+			configs = append(configs, c)
+		}
+	}
+
+	return configs, nil
+}
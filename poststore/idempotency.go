@@ -0,0 +1,267 @@
+package poststore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/namespace"
+	tracer "github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/tracer"
+	"github.com/hashicorp/consul/api"
+)
+
+// IdempotencyRecord is what is stored for an Idempotency-Key: enough of the
+// first request and response to recognise a retry and replay the response.
+type IdempotencyRecord struct {
+	RequestHash string    `json:"request_hash"`
+	StatusCode  int       `json:"status_code"`
+	ContentType string    `json:"content_type"`
+	Body        []byte    `json:"body"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// IdempotencyEntry is an idempotency record written in the same transaction
+// as the data it belongs to.
+type IdempotencyEntry struct {
+	Scope  string
+	Key    string
+	Record *IdempotencyRecord
+}
+
+// idempotencyRecordKey builds the KV key of a record. Keys are scoped per endpoint
+// and escaped so a client supplied key can't reach outside idempotency/. The
+// keys of namespaces other than the default one are kept under
+// idempotency/namespaces/{namespace}/ so the sweeper finds all of them.
+func idempotencyRecordKey(ns, scope, idempotencyKey string) string {
+	if ns == namespace.Default || ns == "" {
+		return key("idempotency", scope, url.PathEscape(idempotencyKey))
+	}
+	return key("idempotency", "namespaces", ns, scope, url.PathEscape(idempotencyKey))
+}
+
+// GetIdempotencyRecord returns the record stored for the key in the given
+// scope, or nil if the key hasn't been used yet.
+func (ps *PostStore) GetIdempotencyRecord(ctx context.Context, scope, key string) (*IdempotencyRecord, error) {
+	span := tracer.StartSpanFromContext(ctx, "Get")
+	defer span.Finish()
+	kv := ps.kv
+
+	pair, _, err := kv.Get(idempotencyRecordKey(namespace.FromContext(ctx), scope, key), nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	if pair == nil {
+		return nil, nil
+	}
+
+	record := &IdempotencyRecord{}
+	err = json.Unmarshal(pair.Value, record)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	// expired records are treated as missing, the sweeper removes them later
+	if ps.expired(record, time.Now()) {
+		return nil, nil
+	}
+
+	return record, nil
+}
+
+// ErrIdempotencyKeyUsed is returned when a write carrying an idempotency
+// record lost the race against another request with the same key, whose
+// record can be replayed instead.
+var ErrIdempotencyKeyUsed = errors.New("idempotency key was used by a concurrent request")
+
+// idempotencyOp returns the transaction operation creating the entry. The
+// key may only hold an expired record, which the operation replaces, so of
+// concurrent requests with the same key only one can commit its write.
+func (ps *PostStore) idempotencyOp(ns string, e *IdempotencyEntry) (*api.KVTxnOp, error) {
+	key := idempotencyRecordKey(ns, e.Scope, e.Key)
+	index, err := ps.expiredRecordIndex(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.Record.CreatedAt.IsZero() {
+		e.Record.CreatedAt = time.Now().UTC()
+	}
+	data, err := json.Marshal(e.Record)
+	if err != nil {
+		return nil, err
+	}
+	return &api.KVTxnOp{Verb: api.KVCAS, Key: key, Value: data, Index: index}, nil
+}
+
+// expiredRecordIndex returns the ModifyIndex of the record stored under key
+// if it can be replaced, zero if there is none and ErrIdempotencyKeyUsed if
+// it is still valid.
+func (ps *PostStore) expiredRecordIndex(key string) (uint64, error) {
+	pair, _, err := ps.kv.Get(key, nil)
+	if err != nil || pair == nil {
+		return 0, err
+	}
+	record := &IdempotencyRecord{}
+	if err := json.Unmarshal(pair.Value, record); err == nil && !ps.expired(record, time.Now()) {
+		return 0, ErrIdempotencyKeyUsed
+	}
+	return pair.ModifyIndex, nil
+}
+
+// idempotencyConflict returns ErrIdempotencyKeyUsed if a transaction failed
+// because another request stored a record for the entry meanwhile, and err
+// otherwise.
+func (ps *PostStore) idempotencyConflict(ns string, e *IdempotencyEntry, err error) error {
+	if e == nil || !errors.Is(err, errTxnConflict) {
+		return err
+	}
+	if _, used := ps.expiredRecordIndex(idempotencyRecordKey(ns, e.Scope, e.Key)); errors.Is(used, ErrIdempotencyKeyUsed) {
+		return fmt.Errorf("%w (%v)", ErrIdempotencyKeyUsed, err)
+	}
+	return err
+}
+
+func (ps *PostStore) SaveIdempotencyRecord(ctx context.Context, scope, key string, record *IdempotencyRecord) error {
+	span := tracer.StartSpanFromContext(ctx, "Post")
+	defer span.Finish()
+	kv := ps.kv
+
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = time.Now().UTC()
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+
+	p := &api.KVPair{Key: idempotencyRecordKey(namespace.FromContext(ctx), scope, key), Value: data}
+	_, err = kv.Put(p, nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+
+	return nil
+}
+
+func (ps *PostStore) expired(record *IdempotencyRecord, now time.Time) bool {
+	return ps.IdempotencyTTL > 0 && now.After(record.CreatedAt.Add(ps.IdempotencyTTL))
+}
+
+// SweepIdempotencyRecords deletes expired idempotency records and returns how
+// many were removed. Entries that can't be decoded, like the empty values
+// written by older versions, are removed as well.
+//
+// Consul can't page a listing, so only the key names are listed up front. The
+// records are then read and deleted a page of batchSize keys at a time, at
+// most one transaction's worth, and the deletes of a page are committed
+// together.
+func (ps *PostStore) SweepIdempotencyRecords(ctx context.Context, batchSize int) (int, error) {
+	span := tracer.StartSpanFromContext(ctx, "Sweep")
+	defer span.Finish()
+
+	keys, _, err := ps.kv.Keys(prefix("idempotency"), "", nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return 0, err
+	}
+	if batchSize <= 0 || batchSize > maxTxnOps {
+		batchSize = maxTxnOps
+	}
+
+	removed := 0
+	for start := 0; start < len(keys); start += batchSize {
+		if err := ctx.Err(); err != nil {
+			return removed, err
+		}
+
+		end := start + batchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		n, err := ps.sweepIdempotencyPage(keys[start:end], time.Now())
+		removed += n
+		if err != nil {
+			tracer.LogError(span, err)
+			return removed, err
+		}
+	}
+
+	return removed, nil
+}
+
+// sweepIdempotencyPage deletes the expired records among keys in one
+// transaction. The deletes are check-and-set so a record rewritten since it
+// was read is kept, if one was the page is deleted key by key instead.
+func (ps *PostStore) sweepIdempotencyPage(keys []string, now time.Time) (int, error) {
+	pairs, err := ps.getMany(keys)
+	if err != nil {
+		return 0, err
+	}
+
+	deletes := make(api.KVTxnOps, 0, len(pairs))
+	for _, pair := range pairs {
+		record := &IdempotencyRecord{}
+		if err := json.Unmarshal(pair.Value, record); err != nil || ps.expired(record, now) {
+			deletes = append(deletes, &api.KVTxnOp{Verb: api.KVDeleteCAS, Key: pair.Key, Index: pair.ModifyIndex})
+		}
+	}
+	if len(deletes) == 0 {
+		return 0, nil
+	}
+
+	err = ps.commitTxn(nil, nil, deletes)
+	if err == nil {
+		return len(deletes), nil
+	}
+	if !errors.Is(err, errTxnConflict) {
+		return 0, err
+	}
+
+	removed := 0
+	for _, op := range deletes {
+		ok, _, err := ps.kv.DeleteCAS(&api.KVPair{Key: op.Key, ModifyIndex: op.Index}, nil)
+		if err != nil {
+			return removed, err
+		}
+		if ok {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// getMany reads keys in one transaction. Keys deleted since they were listed
+// fail the transaction, then they are read one by one and skipped.
+func (ps *PostStore) getMany(keys []string) (api.KVPairs, error) {
+	ops := make(api.KVTxnOps, 0, len(keys))
+	for _, key := range keys {
+		ops = append(ops, &api.KVTxnOp{Verb: api.KVGet, Key: key})
+	}
+	pairs, err := ps.txn(ops)
+	if err == nil {
+		return pairs, nil
+	}
+	if !errors.Is(err, errTxnConflict) {
+		return nil, err
+	}
+
+	pairs = make(api.KVPairs, 0, len(keys))
+	for _, key := range keys {
+		pair, _, err := ps.kv.Get(key, nil)
+		if err != nil {
+			return nil, err
+		}
+		if pair != nil {
+			pairs = append(pairs, pair)
+		}
+	}
+	return pairs, nil
+}
@@ -0,0 +1,98 @@
+package poststore
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/namespace"
+	"github.com/hashicorp/consul/api"
+)
+
+// All KV keys and prefixes are built here. Consul matches prefixes
+// byte-wise, so a prefix must end with the separator: listing or deleting
+// "groups/a/1" would also hit "groups/a/10/..." and "groups/a/1x/...", while
+// "groups/a/1/" only matches group a version 1.
+const separator = "/"
+
+func key(parts ...string) string {
+	return strings.Join(parts, separator)
+}
+
+func prefix(parts ...string) string {
+	return key(parts...) + separator
+}
+
+// Configurations and groups are stored per namespace. The default namespace
+// keeps the keys from before namespaces, the others are prefixed with
+//
+//	namespaces/{namespace}/
+
+func namespaced(ns string, parts ...string) []string {
+	if ns == namespace.Default || ns == "" {
+		return parts
+	}
+	return append([]string{"namespaces", ns}, parts...)
+}
+
+func configurationKey(ns, id, version string) string {
+	return key(namespaced(ns, "configurations", id, version)...)
+}
+
+func configurationPrefix(ns, id string) string {
+	return prefix(namespaced(ns, "configurations", id)...)
+}
+
+// A group version is stored as a manifest listing its members plus one key
+// per member:
+//
+//	groups/{groupID}/{version}/manifest
+//	groups/{groupID}/{version}/members/{configID}
+
+func groupVersionsPrefix(ns, id string) string {
+	return prefix(namespaced(ns, "groups", id)...)
+}
+
+func groupPrefix(ns, id, version string) string {
+	return prefix(namespaced(ns, "groups", id, version)...)
+}
+
+func manifestKey(ns, id, version string) string {
+	return groupPrefix(ns, id, version) + "manifest"
+}
+
+func memberPrefix(ns, id, version string) string {
+	return groupPrefix(ns, id, version) + prefix("members")
+}
+
+func memberKey(ns, id, version, configID string) string {
+	return memberPrefix(ns, id, version) + configID
+}
+
+// checkPrefix rejects prefixes that could match keys across a boundary.
+func checkPrefix(prefix string) error {
+	if !strings.HasSuffix(prefix, separator) {
+		return fmt.Errorf("unsafe key prefix %q, prefixes must end with %q", prefix, separator)
+	}
+	return nil
+}
+
+// list is kv.List restricted to boundary safe prefixes.
+func (ps *PostStore) list(prefix string, q *api.QueryOptions) (api.KVPairs, uint64, error) {
+	if err := checkPrefix(prefix); err != nil {
+		return nil, 0, err
+	}
+	pairs, meta, err := ps.kv.List(prefix, q)
+	if err != nil {
+		return nil, 0, err
+	}
+	return pairs, meta.LastIndex, nil
+}
+
+// deleteTree is kv.DeleteTree restricted to boundary safe prefixes.
+func (ps *PostStore) deleteTree(prefix string) error {
+	if err := checkPrefix(prefix); err != nil {
+		return err
+	}
+	_, err := ps.kv.DeleteTree(prefix, nil)
+	return err
+}
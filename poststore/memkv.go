@@ -0,0 +1,384 @@
+package poststore
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// defaultWaitTime and maxWaitTime bound blocking queries like Consul does.
+const (
+	defaultWaitTime = 5 * time.Minute
+	maxWaitTime     = 10 * time.Minute
+)
+
+// MemoryKV is a thread-safe in-memory KV that behaves like the Consul KV store
+// closely enough for PostStore: keys are kept sorted on reads and every write
+// bumps a global index which is recorded as CreateIndex/ModifyIndex.
+//
+// Reads report the highest index of the keys they cover, including deleted
+// ones, as QueryMeta.LastIndex and support blocking queries through
+// QueryOptions.WaitIndex and WaitTime.
+type MemoryKV struct {
+	mu         sync.RWMutex
+	pairs      map[string]*api.KVPair
+	tombstones map[string]uint64
+	index      uint64
+	// changed is closed and replaced on every write to wake blocked readers
+	changed chan struct{}
+}
+
+var _ KV = (*MemoryKV)(nil)
+
+func NewMemoryKV() *MemoryKV {
+	return &MemoryKV{
+		pairs:      make(map[string]*api.KVPair),
+		tombstones: make(map[string]uint64),
+		changed:    make(chan struct{}),
+	}
+}
+
+func (m *MemoryKV) Get(key string, q *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error) {
+	m.block(q, func() uint64 { return m.keyIndex(key) })
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	meta := &api.QueryMeta{LastIndex: m.keyIndex(key)}
+	pair, ok := m.pairs[key]
+	if !ok {
+		return nil, meta, nil
+	}
+	return copyPair(pair), meta, nil
+}
+
+func (m *MemoryKV) List(prefix string, q *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error) {
+	m.block(q, func() uint64 { return m.prefixIndex(prefix) })
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	pairs := make(api.KVPairs, 0)
+	for _, key := range sortedKeys(m.pairs, prefix) {
+		pairs = append(pairs, copyPair(m.pairs[key]))
+	}
+	return pairs, &api.QueryMeta{LastIndex: m.prefixIndex(prefix)}, nil
+}
+
+// block waits until index() is past q.WaitIndex, the wait time elapses or the
+// query's context is cancelled. Without a WaitIndex it returns right away.
+func (m *MemoryKV) block(q *api.QueryOptions, index func() uint64) {
+	if q == nil || q.WaitIndex == 0 {
+		return
+	}
+
+	wait := q.WaitTime
+	if wait <= 0 {
+		wait = defaultWaitTime
+	}
+	if wait > maxWaitTime {
+		wait = maxWaitTime
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		m.mu.RLock()
+		current, changed := index(), m.changed
+		m.mu.RUnlock()
+		if current > q.WaitIndex {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return
+		case <-q.Context().Done():
+			return
+		}
+	}
+}
+
+// keyIndex is the index of the last write or delete of key. The caller must
+// hold the lock.
+func (m *MemoryKV) keyIndex(key string) uint64 {
+	index := m.tombstones[key]
+	if pair, ok := m.pairs[key]; ok && pair.ModifyIndex > index {
+		index = pair.ModifyIndex
+	}
+	return atLeastOne(index)
+}
+
+// prefixIndex is the index of the last write or delete under prefix. The
+// caller must hold the lock.
+func (m *MemoryKV) prefixIndex(prefix string) uint64 {
+	var index uint64
+	for key, pair := range m.pairs {
+		if strings.HasPrefix(key, prefix) && pair.ModifyIndex > index {
+			index = pair.ModifyIndex
+		}
+	}
+	for key, deleted := range m.tombstones {
+		if strings.HasPrefix(key, prefix) && deleted > index {
+			index = deleted
+		}
+	}
+	return atLeastOne(index)
+}
+
+// atLeastOne keeps indexes usable as WaitIndex, Consul never returns 0 either.
+func atLeastOne(index uint64) uint64 {
+	if index == 0 {
+		return 1
+	}
+	return index
+}
+
+// commit finishes a write at the current index: deleted keys get a tombstone
+// and blocked readers are woken up. The caller must hold the write lock.
+func (m *MemoryKV) commit(deleted ...string) {
+	for _, key := range deleted {
+		m.tombstones[key] = m.index
+	}
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
+
+func (m *MemoryKV) Keys(prefix, separator string, q *api.QueryOptions) ([]string, *api.QueryMeta, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keys := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, key := range sortedKeys(m.pairs, prefix) {
+		if separator != "" {
+			rest := key[len(prefix):]
+			if i := strings.Index(rest, separator); i >= 0 {
+				key = prefix + rest[:i+len(separator)]
+			}
+		}
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys, &api.QueryMeta{LastIndex: m.index}, nil
+}
+
+func (m *MemoryKV) Put(p *api.KVPair, q *api.WriteOptions) (*api.WriteMeta, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.index++
+	set(m.pairs, p.Key, p.Value, p.Flags, m.index)
+	m.commit()
+	return &api.WriteMeta{}, nil
+}
+
+// CAS writes the pair only if its ModifyIndex matches the stored one. An index
+// of 0 means the key must not exist yet.
+func (m *MemoryKV) CAS(p *api.KVPair, q *api.WriteOptions) (bool, *api.WriteMeta, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !indexMatches(m.pairs, p.Key, p.ModifyIndex) {
+		return false, &api.WriteMeta{}, nil
+	}
+	m.index++
+	set(m.pairs, p.Key, p.Value, p.Flags, m.index)
+	m.commit()
+	return true, &api.WriteMeta{}, nil
+}
+
+func (m *MemoryKV) Delete(key string, w *api.WriteOptions) (*api.WriteMeta, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.pairs[key]; ok {
+		m.index++
+		delete(m.pairs, key)
+		m.commit(key)
+	}
+	return &api.WriteMeta{}, nil
+}
+
+// DeleteCAS deletes the key only if its ModifyIndex still matches. Like in
+// Consul, deleting a key that doesn't exist succeeds.
+func (m *MemoryKV) DeleteCAS(p *api.KVPair, q *api.WriteOptions) (bool, *api.WriteMeta, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pair, ok := m.pairs[p.Key]
+	if !ok {
+		return true, &api.WriteMeta{}, nil
+	}
+	if pair.ModifyIndex != p.ModifyIndex {
+		return false, &api.WriteMeta{}, nil
+	}
+	m.index++
+	delete(m.pairs, p.Key)
+	m.commit(p.Key)
+	return true, &api.WriteMeta{}, nil
+}
+
+func (m *MemoryKV) DeleteTree(prefix string, w *api.WriteOptions) (*api.WriteMeta, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := sortedKeys(m.pairs, prefix)
+	if len(keys) == 0 {
+		return &api.WriteMeta{}, nil
+	}
+	m.index++
+	for _, key := range keys {
+		delete(m.pairs, key)
+	}
+	m.commit(keys...)
+	return &api.WriteMeta{}, nil
+}
+
+// Txn applies all operations atomically under a single new index, or none of
+// them if any operation fails, like a Consul KV transaction.
+func (m *MemoryKV) Txn(txn api.KVTxnOps, q *api.QueryOptions) (bool, *api.KVTxnResponse, *api.QueryMeta, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(txn) > maxTxnOps {
+		return false, nil, nil, fmt.Errorf("transaction contains too many operations (%d > %d)", len(txn), maxTxnOps)
+	}
+
+	// operations are applied to a copy which replaces the data only if all
+	// of them succeed; set never mutates a pair in place so a shallow copy
+	// is enough
+	staged := make(map[string]*api.KVPair, len(m.pairs))
+	for k, v := range m.pairs {
+		staged[k] = v
+	}
+	index := m.index + 1
+
+	resp := &api.KVTxnResponse{}
+	for i, op := range txn {
+		result, err := applyOp(staged, op, index)
+		if err != nil {
+			resp.Errors = append(resp.Errors, &api.TxnError{OpIndex: i, What: err.Error()})
+			continue
+		}
+		if result != nil {
+			resp.Results = append(resp.Results, result)
+		}
+	}
+	if len(resp.Errors) > 0 {
+		resp.Results = nil
+		return false, resp, &api.QueryMeta{LastIndex: m.index}, nil
+	}
+
+	deleted := make([]string, 0)
+	for key := range m.pairs {
+		if _, ok := staged[key]; !ok {
+			deleted = append(deleted, key)
+		}
+	}
+	m.pairs = staged
+	m.index = index
+	m.commit(deleted...)
+	return true, resp, &api.QueryMeta{LastIndex: m.index}, nil
+}
+
+func applyOp(pairs map[string]*api.KVPair, op *api.KVTxnOp, index uint64) (*api.KVPair, error) {
+	switch op.Verb {
+	case api.KVSet:
+		return metadata(set(pairs, op.Key, op.Value, op.Flags, index)), nil
+	case api.KVCAS:
+		if !indexMatches(pairs, op.Key, op.Index) {
+			return nil, fmt.Errorf("failed to set key %q, index is stale", op.Key)
+		}
+		return metadata(set(pairs, op.Key, op.Value, op.Flags, index)), nil
+	case api.KVGet:
+		pair, ok := pairs[op.Key]
+		if !ok {
+			return nil, fmt.Errorf("key %q doesn't exist", op.Key)
+		}
+		return copyPair(pair), nil
+	case api.KVDelete:
+		delete(pairs, op.Key)
+		return nil, nil
+	case api.KVDeleteCAS:
+		// like in Consul a missing key is no error
+		if pair, ok := pairs[op.Key]; ok && pair.ModifyIndex != op.Index {
+			return nil, fmt.Errorf("failed to delete key %q, index is stale", op.Key)
+		}
+		delete(pairs, op.Key)
+		return nil, nil
+	case api.KVDeleteTree:
+		for _, key := range sortedKeys(pairs, op.Key) {
+			delete(pairs, key)
+		}
+		return nil, nil
+	case api.KVCheckIndex:
+		if pair, ok := pairs[op.Key]; !ok || pair.ModifyIndex != op.Index {
+			return nil, fmt.Errorf("current modify index for key %q doesn't match %d", op.Key, op.Index)
+		}
+		return nil, nil
+	case api.KVCheckNotExists:
+		if _, ok := pairs[op.Key]; ok {
+			return nil, fmt.Errorf("key %q exists", op.Key)
+		}
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unsupported operation %q", op.Verb)
+}
+
+// set stores a new pair for key under the given index and returns it.
+func set(pairs map[string]*api.KVPair, key string, value []byte, flags uint64, index uint64) *api.KVPair {
+	pair := &api.KVPair{Key: key, CreateIndex: index}
+	if old, ok := pairs[key]; ok {
+		pair.CreateIndex = old.CreateIndex
+	}
+	pair.ModifyIndex = index
+	pair.Flags = flags
+	pair.Value = append([]byte(nil), value...)
+	pairs[key] = pair
+	return pair
+}
+
+// indexMatches implements the check-and-set rule: index 0 means the key must
+// not exist, any other index must equal the stored ModifyIndex.
+func indexMatches(pairs map[string]*api.KVPair, key string, index uint64) bool {
+	pair, ok := pairs[key]
+	if index == 0 {
+		return !ok
+	}
+	return ok && pair.ModifyIndex == index
+}
+
+// sortedKeys returns all keys starting with prefix in lexical order, the same
+// order Consul uses.
+func sortedKeys(pairs map[string]*api.KVPair, prefix string) []string {
+	keys := make([]string, 0)
+	for key := range pairs {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func copyPair(p *api.KVPair) *api.KVPair {
+	c := *p
+	c.Value = append([]byte(nil), p.Value...)
+	return &c
+}
+
+// metadata returns a copy of the pair without its value, which is what Consul
+// returns for write operations inside a transaction.
+func metadata(p *api.KVPair) *api.KVPair {
+	c := *p
+	c.Value = nil
+	return &c
+}
@@ -0,0 +1,145 @@
+package poststore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/namespace"
+	tracer "github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/tracer"
+	"github.com/hashicorp/consul/api"
+)
+
+// legacyGroup collects the keys of one group version found while migrating.
+type legacyGroup struct {
+	id, version string
+	manifest    *api.KVPair
+	legacy      []*api.KVPair
+}
+
+// MigrateGroups rewrites groups stored in the layouts used before group
+// manifests existed into the manifest layout:
+//
+//	groups/{groupID}/{version}             one config, every member overwrote the previous one
+//	groups/{groupID}/{version}/{configID}  members written by ExtendConfigurationGroup
+//
+// Every legacy key is moved in its own transaction together with the manifest
+// update, so the migration can be interrupted and run again. Groups that are
+// already in the new layout are left alone. It returns the number of keys moved.
+func (ps *PostStore) MigrateGroups(ctx context.Context) (int, error) {
+	span := tracer.StartSpanFromContext(ctx, "Migrate")
+	defer span.Finish()
+
+	pairs, _, err := ps.list(prefix("groups"), nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return 0, err
+	}
+
+	groups := make([]*legacyGroup, 0)
+	byKey := make(map[string]*legacyGroup)
+	for _, pair := range pairs {
+		parts := strings.Split(strings.TrimPrefix(pair.Key, "groups/"), "/")
+		if len(parts) < 2 || len(parts) > 4 {
+			log.Printf("migrate groups: skipping unknown key %s", pair.Key)
+			continue
+		}
+
+		key := parts[0] + "/" + parts[1]
+		group, ok := byKey[key]
+		if !ok {
+			group = &legacyGroup{id: parts[0], version: parts[1]}
+			byKey[key] = group
+			groups = append(groups, group)
+		}
+
+		switch {
+		case len(parts) == 2:
+			group.legacy = append(group.legacy, pair)
+		case len(parts) == 3 && parts[2] == "manifest":
+			group.manifest = pair
+		case len(parts) == 3:
+			group.legacy = append(group.legacy, pair)
+		case len(parts) == 4 && parts[2] == "members":
+			// already migrated
+		default:
+			log.Printf("migrate groups: skipping unknown key %s", pair.Key)
+		}
+	}
+
+	moved := 0
+	for _, group := range groups {
+		if err := ctx.Err(); err != nil {
+			return moved, err
+		}
+		n, err := ps.migrateGroup(group)
+		moved += n
+		if err != nil {
+			tracer.LogError(span, err)
+			return moved, err
+		}
+	}
+
+	return moved, nil
+}
+
+func (ps *PostStore) migrateGroup(group *legacyGroup) (int, error) {
+	now := time.Now().UTC()
+	manifest := &config.Group{ID: group.id, Version: group.version, CreatedAt: now, UpdatedAt: now}
+	var manifestIndex uint64
+	if group.manifest != nil {
+		if err := json.Unmarshal(group.manifest.Value, manifest); err != nil {
+			return 0, fmt.Errorf("manifest %s: %w", group.manifest.Key, err)
+		}
+		manifestIndex = group.manifest.ModifyIndex
+	}
+
+	moved := 0
+	for _, pair := range group.legacy {
+		c := &config.Config{}
+		if err := json.Unmarshal(pair.Value, c); err != nil {
+			return moved, fmt.Errorf("member %s: %w", pair.Key, err)
+		}
+		if c.ID == "" {
+			// members written to groups/{id}/{version}/{configID} carry the ID in the key
+			c.ID = strings.TrimPrefix(pair.Key, groupPrefix(namespace.Default, group.id, group.version))
+		}
+		c.GroupID = group.id
+		c.Version = group.version
+
+		data, err := json.Marshal(c)
+		if err != nil {
+			return moved, err
+		}
+
+		if !manifest.HasMember(c.ID) {
+			manifest.Members = append(manifest.Members, c.ID)
+		}
+		manifestData, err := json.Marshal(manifest)
+		if err != nil {
+			return moved, err
+		}
+
+		ops := api.KVTxnOps{
+			setOp(memberKey(namespace.Default, group.id, group.version, c.ID), data),
+			{Verb: api.KVDeleteCAS, Key: pair.Key, Index: pair.ModifyIndex},
+			{Verb: api.KVCAS, Key: manifestKey(namespace.Default, group.id, group.version), Value: manifestData, Index: manifestIndex},
+		}
+		results, err := ps.txn(ops)
+		if err != nil {
+			return moved, fmt.Errorf("moving %s: %w", pair.Key, err)
+		}
+		for _, result := range results {
+			if result.Key == manifestKey(namespace.Default, group.id, group.version) {
+				manifestIndex = result.ModifyIndex
+			}
+		}
+		moved++
+	}
+
+	return moved, nil
+}
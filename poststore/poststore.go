@@ -3,16 +3,47 @@ package poststore
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/namespace"
 	tracer "github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/tracer"
 	"github.com/hashicorp/consul/api"
 	"os"
+	"sort"
+	"sync/atomic"
+	"time"
 )
 
+// ErrVersionExists is returned when writing a configuration version that is
+// already stored. Versions are write-once.
+var ErrVersionExists = errors.New("version already exists")
+
+// ErrNotFound is returned when the requested data doesn't exist.
+var ErrNotFound = errors.New("not found")
+
+// ErrPreconditionFailed is returned when an If-Match index doesn't match the
+// stored ModifyIndex.
+var ErrPreconditionFailed = errors.New("precondition failed, the data was modified")
+
+// ErrConflict is returned when a write lost a race with a concurrent change
+// of the same data and should be retried.
+var ErrConflict = errors.New("modified concurrently, please retry")
+
 type PostStore struct {
 	cli            *api.Client
+	kv             KV
 	Configurations []*config.Config
+	// IdempotencyTTL is how long idempotency records are honoured after they
+	// were written. Zero keeps them forever.
+	IdempotencyTTL time.Duration
+	// EventRetention is how many change events are kept for resuming event
+	// streams. Zero uses DefaultEventRetention.
+	EventRetention int
+
+	// eventAppends counts the events this instance appended, to trim them
+	// every tenth of the retention
+	eventAppends atomic.Uint64
 }
 
 func New() (*PostStore, error) {
@@ -28,66 +59,34 @@ func New() (*PostStore, error) {
 
 	return &PostStore{
 		cli: client,
+		kv:  client.KV(),
 	}, nil
 }
 
-func (ps *PostStore) AddConfiguration(ctx context.Context, config *config.Config) error {
-	span := tracer.StartSpanFromContext(ctx, "Post")
-	defer span.Finish()
-	kv := ps.cli.KV()
-
-	data, err := json.Marshal(config)
-	if err != nil {
-		tracer.LogError(span, err)
-		return err
-	}
-
-	key := "configurations/" + config.ID + "/" + config.Version
-	p := &api.KVPair{Key: key, Value: data}
-	_, err = kv.Put(p, nil)
-	if err != nil {
-		tracer.LogError(span, err)
-		return err
+// NewWithKV returns a PostStore that keeps its data in the given KV backend.
+func NewWithKV(kv KV) *PostStore {
+	return &PostStore{
+		kv: kv,
 	}
-
-	return nil
 }
 
-func (ps *PostStore) GetConfiguration(ctx context.Context, id, version string) (*config.Config, error) {
-	span := tracer.StartSpanFromContext(ctx, "GetAll")
-	defer span.Finish()
-
-	kv := ps.cli.KV()
-
-	key := "configurations/" + id + "/" + version
-	pair, _, err := kv.Get(key, nil)
-	if err != nil {
-		tracer.LogError(span, err)
-		return nil, err
-	}
-
-	if pair == nil {
-		return nil, fmt.Errorf("configuration not found")
-	}
-
-	config := &config.Config{}
-	err = json.Unmarshal(pair.Value, config)
-	if err != nil {
-		tracer.LogError(span, err)
-		return nil, err
-	}
-
-	return config, nil
+// NewInMemory returns a PostStore backed by a fresh MemoryKV, so the service
+// and the tests can run without a Consul agent.
+func NewInMemory() *PostStore {
+	return NewWithKV(NewMemoryKV())
 }
 
-func (ps *PostStore) DeleteConfiguration(ctx context.Context, id, version string) error {
-	span := tracer.StartSpanFromContext(ctx, "Delete")
+// AddConfiguration stores a new configuration version, together with the
+// idempotency record and the quota change if they are given, in a single
+// transaction.
+func (ps *PostStore) AddConfiguration(ctx context.Context, config *config.Config, idempotency *IdempotencyEntry, quota *QuotaChange) error {
+	span := tracer.StartSpanFromContext(ctx, "Post")
 	defer span.Finish()
 
-	kv := ps.cli.KV()
-
-	key := "configurations/" + id + "/" + version
-	_, err := kv.Delete(key, nil)
+	err := ps.writeConfiguration(ctx, config, false, idempotency, quota)
+	if errors.Is(err, errTxnConflict) {
+		err = fmt.Errorf("configuration %s version %s: %w", config.ID, config.Version, ErrVersionExists)
+	}
 	if err != nil {
 		tracer.LogError(span, err)
 		return err
@@ -96,21 +95,13 @@ func (ps *PostStore) DeleteConfiguration(ctx context.Context, id, version string
 	return nil
 }
 
-func (ps *PostStore) AddConfigurationGroup(ctx context.Context, config *config.Config) error {
-	span := tracer.StartSpanFromContext(ctx, "Post")
+// OverwriteConfiguration replaces a stored configuration version. It is only
+// meant for explicit admin overrides, AddConfiguration should be used otherwise.
+func (ps *PostStore) OverwriteConfiguration(ctx context.Context, config *config.Config, idempotency *IdempotencyEntry, quota *QuotaChange) error {
+	span := tracer.StartSpanFromContext(ctx, "Put")
 	defer span.Finish()
 
-	kv := ps.cli.KV()
-
-	data, err := json.Marshal(config)
-	if err != nil {
-		tracer.LogError(span, err)
-		return err
-	}
-
-	key := "groups/" + config.GroupID + "/" + config.Version
-	p := &api.KVPair{Key: key, Value: data}
-	_, err = kv.Put(p, nil)
+	err := ps.writeConfiguration(ctx, config, true, idempotency, quota)
 	if err != nil {
 		tracer.LogError(span, err)
 		return err
@@ -119,149 +110,136 @@ func (ps *PostStore) AddConfigurationGroup(ctx context.Context, config *config.C
 	return nil
 }
 
-func (ps *PostStore) GetConfigurationGroup(ctx context.Context, id, version string) ([]*config.Config, error) {
-	span := tracer.StartSpanFromContext(ctx, "Get")
-	defer span.Finish()
-
-	kv := ps.cli.KV()
-
-	configs := make([]*config.Config, 0)
-
-	keyPrefix := "groups/" + id + "/" + version
-	pairs, _, err := kv.List(keyPrefix, nil)
+// writeConfiguration creates, or with overwrite sets, a configuration version
+// in one transaction with the idempotency record and the quota change.
+func (ps *PostStore) writeConfiguration(ctx context.Context, c *config.Config, overwrite bool, idempotency *IdempotencyEntry, quota *QuotaChange) error {
+	data, err := json.Marshal(c)
 	if err != nil {
-		tracer.LogError(span, err)
-		return nil, err
+		return err
 	}
 
-	for _, pair := range pairs {
-		config := &config.Config{}
-		err := json.Unmarshal(pair.Value, config)
+	ns := namespace.FromContext(ctx)
+	op := createOp(configurationKey(ns, c.ID, c.Version), data)
+	if overwrite {
+		op = setOp(op.Key, data)
+	}
+	ops := api.KVTxnOps{op}
+	if idempotency != nil {
+		op, err := ps.idempotencyOp(ns, idempotency)
 		if err != nil {
-			tracer.LogError(span, err)
-			return nil, err
+			return err
 		}
-		configs = append(configs, config)
+		ops = append(ops, op)
 	}
 
-	return configs, nil
+	err = ps.withQuota(ctx, quota, func(quotaOps api.KVTxnOps) error {
+		_, err := ps.txn(append(ops, quotaOps...))
+		return err
+	})
+	return ps.idempotencyConflict(ns, idempotency, err)
 }
 
-func (ps *PostStore) DeleteConfigurationGroup(ctx context.Context, id, version string) error {
-	span := tracer.StartSpanFromContext(ctx, "Get")
+func (ps *PostStore) GetConfiguration(ctx context.Context, id, version string) (*config.Config, error) {
+	span := tracer.StartSpanFromContext(ctx, "GetAll")
 	defer span.Finish()
 
-	kv := ps.cli.KV()
+	kv := ps.kv
 
-	keyPrefix := "groups/" + id + "/" + version
-	_, err := kv.DeleteTree(keyPrefix, nil)
+	key := configurationKey(namespace.FromContext(ctx), id, version)
+	pair, _, err := kv.Get(key, nil)
 	if err != nil {
 		tracer.LogError(span, err)
-		return err
+		return nil, err
 	}
 
-	newConfigs := make([]*config.Config, 0)
-	for _, c := range ps.Configurations {
-		if c.GroupID != id || c.Version != version {
-			newConfigs = append(newConfigs, c)
-		}
+	if pair == nil {
+		return nil, fmt.Errorf("configuration %w", ErrNotFound)
 	}
-	ps.Configurations = newConfigs
 
-	return nil
-}
-
-func (ps *PostStore) ExtendConfigurationGroup(ctx context.Context, id, version string, newConfigs []*config.Config) error {
-	span := tracer.StartSpanFromContext(ctx, "Post")
-	defer span.Finish()
-
-	kv := ps.cli.KV()
-
-	// find the group to be extended
-
-	groupConfigs, err := ps.GetConfigurationGroup(ctx, id, version)
+	config := &config.Config{}
+	err = json.Unmarshal(pair.Value, config)
 	if err != nil {
-		return err
-	}
-
-	for _, c := range newConfigs {
-		data, err := json.Marshal(c)
-		if err != nil {
-			tracer.LogError(span, err)
-			return err
-		}
-
-		key := "groups/" + c.GroupID + "/" + c.Version + "/" + c.ID
-		p := &api.KVPair{Key: key, Value: data}
-		_, err = kv.Put(p, nil)
-		if err != nil {
-			tracer.LogError(span, err)
-			return err
-		}
-
-		groupConfigs = append(groupConfigs, c)
-		ps.Configurations = append(ps.Configurations, c)
+		tracer.LogError(span, err)
+		return nil, err
 	}
+	config.ModifyIndex = pair.ModifyIndex
 
-	return nil
+	return config, nil
 }
 
-func (ps *PostStore) GetConfigurationGroupsByLabels(ctx context.Context, id, version, labelString string) ([]*config.Config, error) {
-	span := tracer.StartSpanFromContext(ctx, "Get")
+// ListConfigurationVersions returns every stored version of a configuration,
+// ordered from the lowest to the highest version.
+func (ps *PostStore) ListConfigurationVersions(ctx context.Context, id string) ([]*config.VersionInfo, error) {
+	span := tracer.StartSpanFromContext(ctx, "GetAll")
 	defer span.Finish()
 
-	kv := ps.cli.KV()
-
-	configs := make([]*config.Config, 0)
-
-	keyPrefix := "groups/" + id + "/" + version
-	pairs, _, err := kv.List(keyPrefix, nil)
+	pairs, _, err := ps.list(configurationPrefix(namespace.FromContext(ctx), id), nil)
 	if err != nil {
 		tracer.LogError(span, err)
 		return nil, err
 	}
 
+	versions := make([]*config.VersionInfo, 0, len(pairs))
 	for _, pair := range pairs {
-		config := &config.Config{}
-		err := json.Unmarshal(pair.Value, config)
+		c := &config.Config{}
+		err := json.Unmarshal(pair.Value, c)
 		if err != nil {
 			tracer.LogError(span, err)
 			return nil, err
 		}
-		if config.Labels == labelString {
-			configs = append(configs, config)
-		}
+		versions = append(versions, &config.VersionInfo{
+			Version:     c.Version,
+			Name:        c.Name,
+			Labels:      c.Labels,
+			Entries:     len(c.Entries),
+			CreateIndex: pair.CreateIndex,
+			ModifyIndex: pair.ModifyIndex,
+		})
 	}
 
-	return configs, nil
+	sort.SliceStable(versions, func(i, j int) bool {
+		return config.CompareVersions(versions[i].Version, versions[j].Version) < 0
+	})
+
+	return versions, nil
 }
 
-func (ps *PostStore) CheckIdempotencyKey(ctx context.Context, idempotencyKey string) (bool, error) {
-	span := tracer.StartSpanFromContext(ctx, "Get")
+func (ps *PostStore) DeleteConfiguration(ctx context.Context, id, version string, quota *QuotaChange) error {
+	span := tracer.StartSpanFromContext(ctx, "Delete")
 	defer span.Finish()
-	kv := ps.cli.KV()
 
-	key := "idempotency/" + idempotencyKey
-	pair, _, err := kv.Get(key, nil)
+	key := configurationKey(namespace.FromContext(ctx), id, version)
+	err := ps.withQuota(ctx, quota, func(quotaOps api.KVTxnOps) error {
+		_, err := ps.txn(append(api.KVTxnOps{{Verb: api.KVDelete, Key: key}}, quotaOps...))
+		return err
+	})
 	if err != nil {
 		tracer.LogError(span, err)
-		return false, err
-	}
-	if pair != nil {
-		return true, nil
+		return err
 	}
 
-	return false, nil
+	return nil
 }
 
-func (ps *PostStore) SaveIdempotencyKey(ctx context.Context, idempotencyKey string) error {
-	span := tracer.StartSpanFromContext(ctx, "Post")
+// DeleteConfigurationCAS deletes a configuration version only if it exists
+// and its ModifyIndex still equals index.
+func (ps *PostStore) DeleteConfigurationCAS(ctx context.Context, id, version string, index uint64, quota *QuotaChange) error {
+	span := tracer.StartSpanFromContext(ctx, "Delete")
 	defer span.Finish()
-	kv := ps.cli.KV()
 
-	key := "idempotency/" + idempotencyKey
-	p := &api.KVPair{Key: key, Value: []byte{}}
-	_, err := kv.Put(p, nil)
+	key := configurationKey(namespace.FromContext(ctx), id, version)
+	err := ps.withQuota(ctx, quota, func(quotaOps api.KVTxnOps) error {
+		// Consul's delete-cas succeeds on a missing key, check-index doesn't
+		ops := api.KVTxnOps{
+			{Verb: api.KVCheckIndex, Key: key, Index: index},
+			{Verb: api.KVDelete, Key: key},
+		}
+		_, err := ps.txn(append(ops, quotaOps...))
+		return err
+	})
+	if errors.Is(err, errTxnConflict) {
+		err = fmt.Errorf("configuration %s version %s: %w", id, version, ErrPreconditionFailed)
+	}
 	if err != nil {
 		tracer.LogError(span, err)
 		return err
@@ -0,0 +1,391 @@
+package poststore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/namespace"
+	tracer "github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/tracer"
+	"github.com/hashicorp/consul/api"
+)
+
+// The usage of a namespace is kept in small keys, updated in the transaction
+// of every write that changes it:
+//
+//	quota/totals                configuration IDs, group IDs and bytes
+//	quota/configs/{configID}    versions of a configuration ID
+//	quota/groups/{groupID}      versions of a group ID
+//
+// A namespace without totals, e.g. one written before quotas existed, is
+// counted by its first write.
+
+// maxQuotaAttempts bounds the retries of a write whose usage keys were
+// changed by another write in the meantime, all writes of a namespace race
+// for the totals.
+const maxQuotaAttempts = 50
+
+func quotaTotalsKey(ns string) string {
+	return key(namespaced(ns, "quota", "totals")...)
+}
+
+func quotaConfigsPrefix(ns string) string {
+	return prefix(namespaced(ns, "quota", "configs")...)
+}
+
+func quotaGroupsPrefix(ns string) string {
+	return prefix(namespaced(ns, "quota", "groups")...)
+}
+
+// legacyQuotaUsageKey held the whole usage of a namespace in one value.
+func legacyQuotaUsageKey(ns string) string {
+	return key(namespaced(ns, "quota", "usage")...)
+}
+
+// QuotaChange is what a write adds to or removes from the usage of its
+// namespace. The store methods taking one apply it in the transaction of the
+// write.
+type QuotaChange struct {
+	// versions added (positive) or removed (negative) per ID
+	ConfigVersions map[string]int
+	GroupVersions  map[string]int
+	Bytes          int64
+
+	// Check is called with the usage before and after the change and with
+	// the versions of the configuration IDs of the change after it. An error
+	// aborts the write and is returned as is. Nil accepts every change.
+	Check func(before, after *config.QuotaUsage, versions map[string]int) error
+
+	// Usage is set to the usage after the change once the write succeeded.
+	Usage *config.QuotaUsage
+}
+
+// quotaUpdate is a change applied to the usage keys as they were read.
+type quotaUpdate struct {
+	ops   api.KVTxnOps
+	usage *config.QuotaUsage
+	// the ModifyIndex of every key read, zero if it didn't exist
+	indexes map[string]uint64
+}
+
+// readCount reads a key holding a number, zero if it doesn't exist.
+func (ps *PostStore) readCount(key string) (int, uint64, error) {
+	pair, _, err := ps.kv.Get(key, nil)
+	if err != nil || pair == nil {
+		return 0, 0, err
+	}
+	n, err := strconv.Atoi(string(pair.Value))
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s: %w", key, err)
+	}
+	return n, pair.ModifyIndex, nil
+}
+
+// readQuotaTotals returns the totals of a namespace and the ModifyIndex of
+// their key, nil if they weren't counted yet.
+func (ps *PostStore) readQuotaTotals(ns string) (*config.QuotaUsage, uint64, error) {
+	pair, _, err := ps.kv.Get(quotaTotalsKey(ns), nil)
+	if err != nil || pair == nil {
+		return nil, 0, err
+	}
+	usage := &config.QuotaUsage{}
+	err = json.Unmarshal(pair.Value, usage)
+	if err != nil {
+		return nil, 0, err
+	}
+	return usage, pair.ModifyIndex, nil
+}
+
+// countOp sets the key of a version count, deleting it when it drops to zero.
+func countOp(key string, n int, index uint64) *api.KVTxnOp {
+	if n <= 0 {
+		return &api.KVTxnOp{Verb: api.KVDeleteCAS, Key: key, Index: index}
+	}
+	return &api.KVTxnOp{Verb: api.KVCAS, Key: key, Value: []byte(strconv.Itoa(n)), Index: index}
+}
+
+// prepareQuota reads the usage keys change touches, applies the change and
+// returns the check-and-set operations storing the result. A nil change has
+// no operations.
+func (ps *PostStore) prepareQuota(ctx context.Context, ns string, change *QuotaChange) (*quotaUpdate, error) {
+	update := &quotaUpdate{indexes: make(map[string]uint64)}
+	if change == nil {
+		return update, nil
+	}
+
+	before, index, err := ps.readQuotaTotals(ns)
+	if err == nil && before == nil {
+		_, err = ps.CountQuotaUsage(namespace.With(ctx, ns))
+		if err == nil {
+			before, index, err = ps.readQuotaTotals(ns)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if before == nil {
+		before = &config.QuotaUsage{}
+	}
+	update.indexes[quotaTotalsKey(ns)] = index
+
+	after := *before
+	after.Bytes += change.Bytes
+	if after.Bytes < 0 {
+		after.Bytes = 0
+	}
+
+	versions := make(map[string]int)
+	counts := func(prefix string, changes map[string]int, ids *int, out map[string]int) error {
+		for _, id := range sortedIDs(changes) {
+			if changes[id] == 0 {
+				continue
+			}
+			key := prefix + id
+			n, index, err := ps.readCount(key)
+			if err != nil {
+				return err
+			}
+			update.indexes[key] = index
+			next := n + changes[id]
+			if next < 0 {
+				next = 0
+			}
+			switch {
+			case n == 0 && next > 0:
+				*ids++
+			case n > 0 && next == 0:
+				*ids--
+			}
+			if n > 0 || next > 0 {
+				update.ops = append(update.ops, countOp(key, next, index))
+			}
+			if out != nil {
+				out[id] = next
+			}
+		}
+		return nil
+	}
+	if err := counts(quotaConfigsPrefix(ns), change.ConfigVersions, &after.Configs, versions); err != nil {
+		return nil, err
+	}
+	if err := counts(quotaGroupsPrefix(ns), change.GroupVersions, &after.Groups, nil); err != nil {
+		return nil, err
+	}
+
+	if change.Check != nil {
+		if err := change.Check(before, &after, versions); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := json.Marshal(&after)
+	if err != nil {
+		return nil, err
+	}
+	update.ops = append(update.ops, &api.KVTxnOp{Verb: api.KVCAS, Key: quotaTotalsKey(ns), Value: data, Index: index})
+	update.usage = &after
+	return update, nil
+}
+
+// changedSince reports whether one of the usage keys was written since it was
+// read.
+func (ps *PostStore) changedSince(update *quotaUpdate) (bool, error) {
+	for key, index := range update.indexes {
+		pair, _, err := ps.kv.Get(key, nil)
+		if err != nil {
+			return false, err
+		}
+		current := uint64(0)
+		if pair != nil {
+			current = pair.ModifyIndex
+		}
+		if current != index {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// withQuota runs write with the operations applying change, which it must
+// commit in the transaction of the write. A transaction conflict is retried
+// when the usage keys changed in the meantime, otherwise it is returned as is
+// for write's caller to interpret.
+func (ps *PostStore) withQuota(ctx context.Context, change *QuotaChange, write func(quota api.KVTxnOps) error) error {
+	ns := namespace.FromContext(ctx)
+	for attempt := 1; attempt <= maxQuotaAttempts; attempt++ {
+		update, err := ps.prepareQuota(ctx, ns, change)
+		if err != nil {
+			return err
+		}
+
+		err = write(update.ops)
+		if err == nil {
+			if change != nil {
+				change.Usage = update.usage
+			}
+			return nil
+		}
+		if change == nil || !errors.Is(err, errTxnConflict) {
+			return err
+		}
+		changed, checkErr := ps.changedSince(update)
+		if checkErr != nil || !changed {
+			return err
+		}
+	}
+	return fmt.Errorf("updating the quota usage: %w", ErrConflict)
+}
+
+// GetQuotaUsage returns what the namespace of ctx stores.
+func (ps *PostStore) GetQuotaUsage(ctx context.Context) (*config.QuotaCounts, error) {
+	span := tracer.StartSpanFromContext(ctx, "Get")
+	defer span.Finish()
+
+	ns := namespace.FromContext(ctx)
+	usage, _, err := ps.readQuotaTotals(ns)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	if usage == nil {
+		return ps.CountQuotaUsage(ctx)
+	}
+
+	pairs, _, err := ps.list(quotaConfigsPrefix(ns), nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	counts := &config.QuotaCounts{Configs: usage.Configs, Groups: usage.Groups, Bytes: usage.Bytes}
+	for _, pair := range pairs {
+		n, err := strconv.Atoi(string(pair.Value))
+		if err != nil {
+			err = fmt.Errorf("%s: %w", pair.Key, err)
+			tracer.LogError(span, err)
+			return nil, err
+		}
+		if n > counts.MaxVersionsPerConfig {
+			counts.MaxVersionsPerConfig = n
+		}
+	}
+	return counts, nil
+}
+
+// CountQuotaUsage counts the configurations and groups stored in the
+// namespace of ctx and replaces its usage with the result. It repairs usage
+// that drifted, and counts namespaces written before quotas existed, which
+// their first write also does. Writes running meanwhile may be counted twice
+// or not at all.
+func (ps *PostStore) CountQuotaUsage(ctx context.Context) (*config.QuotaCounts, error) {
+	span := tracer.StartSpanFromContext(ctx, "Count")
+	defer span.Finish()
+
+	ns := namespace.FromContext(ctx)
+	configVersions := make(map[string]int)
+	groupVersions := make(map[string]int)
+	counts := &config.QuotaCounts{}
+
+	pairs, _, err := ps.list(prefix(namespaced(ns, "configurations")...), nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	for _, pair := range pairs {
+		c := &config.Config{}
+		if err := json.Unmarshal(pair.Value, c); err != nil {
+			tracer.LogError(span, err)
+			return nil, err
+		}
+		configVersions[c.ID]++
+		counts.Bytes += config.ConfigSize(c)
+	}
+
+	groups := prefix(namespaced(ns, "groups")...)
+	pairs, _, err = ps.list(groups, nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	for _, pair := range pairs {
+		// groups/{groupID}/{version}/manifest or .../members/{configID}
+		parts := strings.Split(strings.TrimPrefix(pair.Key, groups), separator)
+		switch {
+		case len(parts) == 3 && parts[2] == "manifest":
+			groupVersions[parts[0]]++
+		case len(parts) == 4 && parts[2] == "members":
+			c := &config.Config{}
+			if err := json.Unmarshal(pair.Value, c); err != nil {
+				tracer.LogError(span, err)
+				return nil, err
+			}
+			counts.Bytes += config.ConfigSize(c)
+		}
+	}
+
+	for _, id := range sortedIDs(configVersions) {
+		if configVersions[id] > counts.MaxVersionsPerConfig {
+			counts.MaxVersionsPerConfig = configVersions[id]
+		}
+	}
+	counts.Configs = len(configVersions)
+	counts.Groups = len(groupVersions)
+
+	err = ps.replaceCounts(quotaConfigsPrefix(ns), configVersions)
+	if err == nil {
+		err = ps.replaceCounts(quotaGroupsPrefix(ns), groupVersions)
+	}
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	data, err := json.Marshal(&config.QuotaUsage{Configs: counts.Configs, Groups: counts.Groups, Bytes: counts.Bytes})
+	if err == nil {
+		_, err = ps.kv.Put(&api.KVPair{Key: quotaTotalsKey(ns), Value: data}, nil)
+	}
+	if err == nil {
+		_, err = ps.kv.Delete(legacyQuotaUsageKey(ns), nil)
+	}
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	return counts, nil
+}
+
+// replaceCounts stores the version counts under prefix and deletes the counts
+// of the IDs that are gone.
+func (ps *PostStore) replaceCounts(prefix string, counts map[string]int) error {
+	keys, _, err := ps.kv.Keys(prefix, "", nil)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if _, ok := counts[strings.TrimPrefix(key, prefix)]; !ok {
+			if _, err := ps.kv.Delete(key, nil); err != nil {
+				return err
+			}
+		}
+	}
+	for _, id := range sortedIDs(counts) {
+		_, err := ps.kv.Put(&api.KVPair{Key: prefix + id, Value: []byte(strconv.Itoa(counts[id]))}, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortedIDs(m map[string]int) []string {
+	ids := make([]string, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -0,0 +1,114 @@
+package poststore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	tracer "github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/tracer"
+	"github.com/hashicorp/consul/api"
+)
+
+// Role bindings are stored as
+//
+//	rbac/bindings/{bindingID}
+
+func roleBindingKey(id string) string {
+	return key("rbac", "bindings", id)
+}
+
+func roleBindingsPrefix() string {
+	return prefix("rbac", "bindings")
+}
+
+// AddRoleBinding stores a new role binding.
+func (ps *PostStore) AddRoleBinding(ctx context.Context, binding *config.RoleBinding) error {
+	span := tracer.StartSpanFromContext(ctx, "Post")
+	defer span.Finish()
+
+	data, err := json.Marshal(binding)
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+
+	ok, _, err := ps.kv.CAS(&api.KVPair{Key: roleBindingKey(binding.ID), Value: data, ModifyIndex: 0}, nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("role binding %s: %w", binding.ID, ErrVersionExists)
+	}
+	return nil
+}
+
+// GetRoleBinding returns the role binding with the given ID.
+func (ps *PostStore) GetRoleBinding(ctx context.Context, id string) (*config.RoleBinding, error) {
+	span := tracer.StartSpanFromContext(ctx, "Get")
+	defer span.Finish()
+
+	pair, _, err := ps.kv.Get(roleBindingKey(id), nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	if pair == nil {
+		return nil, fmt.Errorf("role binding %s %w", id, ErrNotFound)
+	}
+
+	binding := &config.RoleBinding{}
+	err = json.Unmarshal(pair.Value, binding)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	return binding, nil
+}
+
+// ListRoleBindings returns every role binding, oldest first.
+func (ps *PostStore) ListRoleBindings(ctx context.Context) ([]*config.RoleBinding, error) {
+	span := tracer.StartSpanFromContext(ctx, "GetAll")
+	defer span.Finish()
+
+	pairs, _, err := ps.list(roleBindingsPrefix(), nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	bindings := make([]*config.RoleBinding, 0, len(pairs))
+	for _, pair := range pairs {
+		binding := &config.RoleBinding{}
+		err = json.Unmarshal(pair.Value, binding)
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, err
+		}
+		bindings = append(bindings, binding)
+	}
+	sort.Slice(bindings, func(i, j int) bool {
+		return bindings[i].CreatedAt.Before(bindings[j].CreatedAt)
+	})
+	return bindings, nil
+}
+
+// DeleteRoleBinding removes the role binding with the given ID.
+func (ps *PostStore) DeleteRoleBinding(ctx context.Context, id string) error {
+	span := tracer.StartSpanFromContext(ctx, "Delete")
+	defer span.Finish()
+
+	pair, _, err := ps.kv.Get(roleBindingKey(id), nil)
+	if err == nil && pair == nil {
+		return fmt.Errorf("role binding %s %w", id, ErrNotFound)
+	}
+	if err == nil {
+		_, err = ps.kv.Delete(roleBindingKey(id), nil)
+	}
+	if err != nil {
+		tracer.LogError(span, err)
+	}
+	return err
+}
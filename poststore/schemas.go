@@ -0,0 +1,161 @@
+package poststore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/namespace"
+	tracer "github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/tracer"
+	"github.com/hashicorp/consul/api"
+)
+
+// Schema versions are stored per namespace, like configurations:
+//
+//	schemas/{schemaID}/{version}
+
+func schemaKey(ns, id, version string) string {
+	return key(namespaced(ns, "schemas", id, version)...)
+}
+
+func schemaVersionsPrefix(ns, id string) string {
+	return prefix(namespaced(ns, "schemas", id)...)
+}
+
+func schemasPrefix(ns string) string {
+	return prefix(namespaced(ns, "schemas")...)
+}
+
+func decodeSchemas(pairs api.KVPairs) ([]*config.Schema, error) {
+	schemas := make([]*config.Schema, 0, len(pairs))
+	for _, pair := range pairs {
+		schema := &config.Schema{}
+		if err := json.Unmarshal(pair.Value, schema); err != nil {
+			return nil, err
+		}
+		schemas = append(schemas, schema)
+	}
+	return schemas, nil
+}
+
+// AddSchema stores a new schema version. Versions are write-once.
+func (ps *PostStore) AddSchema(ctx context.Context, schema *config.Schema) error {
+	span := tracer.StartSpanFromContext(ctx, "Post")
+	defer span.Finish()
+
+	data, err := json.Marshal(schema)
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+
+	key := schemaKey(namespace.FromContext(ctx), schema.ID, schema.Version)
+	ok, _, err := ps.kv.CAS(&api.KVPair{Key: key, Value: data, ModifyIndex: 0}, nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("schema %s version %s: %w", schema.ID, schema.Version, ErrVersionExists)
+	}
+	return nil
+}
+
+// GetSchema returns a schema version.
+func (ps *PostStore) GetSchema(ctx context.Context, id, version string) (*config.Schema, error) {
+	span := tracer.StartSpanFromContext(ctx, "Get")
+	defer span.Finish()
+
+	pair, _, err := ps.kv.Get(schemaKey(namespace.FromContext(ctx), id, version), nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	if pair == nil {
+		return nil, fmt.Errorf("schema %s version %s %w", id, version, ErrNotFound)
+	}
+
+	schema := &config.Schema{}
+	err = json.Unmarshal(pair.Value, schema)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	return schema, nil
+}
+
+// ListSchemaVersions returns the versions of a schema ordered from the lowest
+// to the highest version.
+func (ps *PostStore) ListSchemaVersions(ctx context.Context, id string) ([]*config.Schema, error) {
+	span := tracer.StartSpanFromContext(ctx, "GetAll")
+	defer span.Finish()
+
+	pairs, _, err := ps.list(schemaVersionsPrefix(namespace.FromContext(ctx), id), nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	schemas, err := decodeSchemas(pairs)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	sort.Slice(schemas, func(i, j int) bool {
+		return config.CompareVersions(schemas[i].Version, schemas[j].Version) < 0
+	})
+	return schemas, nil
+}
+
+// ListSchemas returns the highest version of every schema, ordered by ID.
+// These are the versions configurations are validated against.
+func (ps *PostStore) ListSchemas(ctx context.Context) ([]*config.Schema, error) {
+	span := tracer.StartSpanFromContext(ctx, "GetAll")
+	defer span.Finish()
+
+	pairs, _, err := ps.list(schemasPrefix(namespace.FromContext(ctx)), nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	schemas, err := decodeSchemas(pairs)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	latest := make(map[string]*config.Schema)
+	for _, schema := range schemas {
+		if current, ok := latest[schema.ID]; !ok || config.CompareVersions(schema.Version, current.Version) > 0 {
+			latest[schema.ID] = schema
+		}
+	}
+	result := make([]*config.Schema, 0, len(latest))
+	for _, schema := range latest {
+		result = append(result, schema)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+	return result, nil
+}
+
+// DeleteSchema removes a schema version, the previous version applies again.
+func (ps *PostStore) DeleteSchema(ctx context.Context, id, version string) error {
+	span := tracer.StartSpanFromContext(ctx, "Delete")
+	defer span.Finish()
+
+	key := schemaKey(namespace.FromContext(ctx), id, version)
+	pair, _, err := ps.kv.Get(key, nil)
+	if err == nil && pair == nil {
+		return fmt.Errorf("schema %s version %s %w", id, version, ErrNotFound)
+	}
+	if err == nil {
+		_, err = ps.kv.Delete(key, nil)
+	}
+	if err != nil {
+		tracer.LogError(span, err)
+	}
+	return err
+}
@@ -0,0 +1,62 @@
+package poststore
+
+import (
+	"context"
+	"time"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+)
+
+// Store is the storage used by the service handlers. PostStore implements it
+// on top of any KV backend (Consul or the in-memory one).
+type Store interface {
+	AddConfiguration(ctx context.Context, config *config.Config, idempotency *IdempotencyEntry, quota *QuotaChange) error
+	OverwriteConfiguration(ctx context.Context, config *config.Config, idempotency *IdempotencyEntry, quota *QuotaChange) error
+	GetConfiguration(ctx context.Context, id, version string) (*config.Config, error)
+	ListConfigurationVersions(ctx context.Context, id string) ([]*config.VersionInfo, error)
+	DeleteConfiguration(ctx context.Context, id, version string, quota *QuotaChange) error
+	DeleteConfigurationCAS(ctx context.Context, id, version string, index uint64, quota *QuotaChange) error
+	AddConfigurationGroup(ctx context.Context, configs []*config.Config, replace []*config.Group, idempotency *IdempotencyEntry, quota *QuotaChange) error
+	ListGroupVersions(ctx context.Context, id string) ([]string, error)
+	GetGroupManifest(ctx context.Context, id, version string) (*config.Group, error)
+	GetGroup(ctx context.Context, id, version string) (*config.Group, []*config.Config, error)
+	GetConfigurationGroup(ctx context.Context, id, version string) ([]*config.Config, error)
+	DeleteConfigurationGroup(ctx context.Context, id, version string, quota *QuotaChange) error
+	DeleteConfigurationGroupCAS(ctx context.Context, id, version string, index uint64, quota *QuotaChange) error
+	ExtendConfigurationGroup(ctx context.Context, id, version string, newConfigs []*config.Config, index uint64, quota *QuotaChange) error
+	GetConfigurationGroupsByLabels(ctx context.Context, id, version string, selector config.Selector) ([]*config.Config, error)
+	WatchConfiguration(ctx context.Context, id, version string, index uint64, wait time.Duration) (*config.Config, uint64, error)
+	WatchGroup(ctx context.Context, id, version string, index uint64, wait time.Duration) (*config.Group, []*config.Config, uint64, error)
+	AppendEvent(ctx context.Context, event *config.Event) error
+	WatchEvents(ctx context.Context, after, index uint64, wait time.Duration) ([]*config.Event, uint64, error)
+	AddWebhook(ctx context.Context, webhook *config.Webhook) error
+	GetWebhook(ctx context.Context, id string) (*config.Webhook, error)
+	ListWebhooks(ctx context.Context) ([]*config.Webhook, error)
+	DeleteWebhook(ctx context.Context, id string) error
+	SaveDelivery(ctx context.Context, delivery *config.Delivery) error
+	ListDeliveries(ctx context.Context, webhookID string) ([]*config.Delivery, error)
+	AppendAudit(ctx context.Context, record *config.AuditRecord) error
+	ListAudit(ctx context.Context, q AuditQuery) ([]*config.AuditRecord, uint64, error)
+	GetAuditHead(ctx context.Context) (*AuditHead, error)
+	WalkAudit(ctx context.Context, fn func(record *config.AuditRecord) error) error
+	SaveAuditCheckpoint(ctx context.Context, checkpoint *config.AuditCheckpoint) error
+	ListAuditCheckpoints(ctx context.Context) ([]*config.AuditCheckpoint, error)
+	AddAPIKey(ctx context.Context, apiKey *config.APIKey) error
+	GetAPIKey(ctx context.Context, hash string) (*config.APIKey, error)
+	ListAPIKeys(ctx context.Context) ([]*config.APIKey, error)
+	DeleteAPIKey(ctx context.Context, id string) error
+	AddRoleBinding(ctx context.Context, binding *config.RoleBinding) error
+	GetRoleBinding(ctx context.Context, id string) (*config.RoleBinding, error)
+	ListRoleBindings(ctx context.Context) ([]*config.RoleBinding, error)
+	DeleteRoleBinding(ctx context.Context, id string) error
+	AddSchema(ctx context.Context, schema *config.Schema) error
+	GetSchema(ctx context.Context, id, version string) (*config.Schema, error)
+	ListSchemaVersions(ctx context.Context, id string) ([]*config.Schema, error)
+	ListSchemas(ctx context.Context) ([]*config.Schema, error)
+	DeleteSchema(ctx context.Context, id, version string) error
+	GetQuotaUsage(ctx context.Context) (*config.QuotaCounts, error)
+	GetIdempotencyRecord(ctx context.Context, scope, key string) (*IdempotencyRecord, error)
+	SaveIdempotencyRecord(ctx context.Context, scope, key string, record *IdempotencyRecord) error
+}
+
+var _ Store = (*PostStore)(nil)
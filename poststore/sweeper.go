@@ -0,0 +1,90 @@
+package poststore
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/metrics"
+)
+
+const sweeperLockKey = "locks/idempotency-sweeper"
+
+// RunIdempotencySweeper removes expired idempotency records every interval
+// until ctx is cancelled. When several instances share a Consul cluster only
+// the one holding the sweeper lock does the work, the others wait for it.
+func (ps *PostStore) RunIdempotencySweeper(ctx context.Context, interval time.Duration, batchSize int) {
+	for ctx.Err() == nil {
+		lost, unlock, err := ps.acquireLeadership(ctx, sweeperLockKey)
+		if err != nil {
+			log.Printf("idempotency sweeper: %v", err)
+			if !sleep(ctx, interval) {
+				return
+			}
+			continue
+		}
+		if lost == nil {
+			// ctx was cancelled while waiting for the lock
+			return
+		}
+
+		ps.sweepWhileLeader(ctx, lost, interval, batchSize)
+		unlock()
+	}
+}
+
+func (ps *PostStore) sweepWhileLeader(ctx context.Context, lost <-chan struct{}, interval time.Duration, batchSize int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		removed, err := ps.SweepIdempotencyRecords(ctx, batchSize)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("idempotency sweeper: %v", err)
+		}
+		metrics.IdempotencyKeysSwept.Add(float64(removed))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-lost:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// acquireLeadership blocks until this instance holds the Consul lock on key.
+// The returned channel is closed if the lock is lost. Stores that don't talk
+// to Consul are the only instance and get the lock right away.
+func (ps *PostStore) acquireLeadership(ctx context.Context, key string) (<-chan struct{}, func(), error) {
+	if ps.cli == nil {
+		return make(chan struct{}), func() {}, nil
+	}
+
+	lock, err := ps.cli.LockKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lost, err := lock.Lock(ctx.Done())
+	if err != nil || lost == nil {
+		return nil, nil, err
+	}
+
+	return lost, func() {
+		if err := lock.Unlock(); err != nil {
+			log.Printf("releasing %s: %v", key, err)
+		}
+	}, nil
+}
+
+// sleep waits for d and reports false if ctx was cancelled first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
@@ -0,0 +1,107 @@
+package poststore
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// maxTxnOps is the number of operations Consul accepts in one transaction.
+const maxTxnOps = 64
+
+// errTxnConflict is returned when a transaction was rolled back because one
+// of its checks (check-and-set, check-not-exists, ...) failed.
+var errTxnConflict = errors.New("transaction rolled back")
+
+// createOp writes a key that must not exist yet.
+func createOp(key string, value []byte) *api.KVTxnOp {
+	return &api.KVTxnOp{Verb: api.KVCAS, Key: key, Value: value, Index: 0}
+}
+
+func setOp(key string, value []byte) *api.KVTxnOp {
+	return &api.KVTxnOp{Verb: api.KVSet, Key: key, Value: value}
+}
+
+// commitTxn atomically applies first, creates the keys in creates and applies
+// final.
+//
+// Consul limits a transaction to 64 operations, so when there are more the
+// creates are committed in chunks, first goes with the first one and final
+// with the last one. If a chunk fails the keys created by the earlier chunks
+// are deleted again, so either everything is written or nothing is. What
+// first deleted can't be restored, so anything it removes must be committed
+// in one chunk to be atomic. first and final may contain any kind of
+// operation but must fit in a single transaction together.
+func (ps *PostStore) commitTxn(first, creates, final api.KVTxnOps) error {
+	if len(first)+len(final) > maxTxnOps {
+		return fmt.Errorf("transaction contains too many operations (%d > %d)", len(first)+len(final), maxTxnOps)
+	}
+
+	chunks := make([]api.KVTxnOps, 0)
+	current := append(api.KVTxnOps{}, first...)
+	rest := creates
+	for len(current)+len(rest)+len(final) > maxTxnOps {
+		n := maxTxnOps - len(current)
+		if n > len(rest) {
+			n = len(rest)
+		}
+		chunks = append(chunks, append(current, rest[:n]...))
+		current = api.KVTxnOps{}
+		rest = rest[n:]
+	}
+	last := append(append(current, rest...), final...)
+	chunks = append(chunks, last)
+
+	created := make([]*api.KVPair, 0)
+	for _, chunk := range chunks {
+		results, err := ps.txn(chunk)
+		if err != nil {
+			ps.compensate(created)
+			return err
+		}
+		// not every operation has a result, they are matched by key
+		creating := make(map[string]bool)
+		for _, op := range chunk {
+			if op.Verb == api.KVCAS && op.Index == 0 {
+				creating[op.Key] = true
+			}
+		}
+		for _, result := range results {
+			if creating[result.Key] {
+				created = append(created, result)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (ps *PostStore) txn(ops api.KVTxnOps) ([]*api.KVPair, error) {
+	ok, resp, _, err := ps.kv.Txn(ops, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		reasons := make([]string, 0)
+		if resp != nil {
+			for _, e := range resp.Errors {
+				reasons = append(reasons, e.What)
+			}
+		}
+		return nil, fmt.Errorf("%w: %s", errTxnConflict, strings.Join(reasons, "; "))
+	}
+	return resp.Results, nil
+}
+
+// compensate deletes keys written by already committed chunks. Deletes are
+// check-and-set so a key changed by someone else in the meantime is kept.
+func (ps *PostStore) compensate(created []*api.KVPair) {
+	for _, pair := range created {
+		if _, _, err := ps.kv.DeleteCAS(pair, nil); err != nil {
+			log.Printf("compensating failed transaction, %s: %v", pair.Key, err)
+		}
+	}
+}
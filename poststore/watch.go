@@ -0,0 +1,116 @@
+package poststore
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/namespace"
+	tracer "github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/tracer"
+	"github.com/hashicorp/consul/api"
+)
+
+// Watches map to Consul blocking queries: the read waits until the index of
+// the watched data moves past the index the caller has already seen, or the
+// wait time elapses. Blocking queries can return early without a change, so
+// they are repeated until the index really moved or the time is up.
+
+// blockingQuery runs query with WaitIndex set to index until it reports a
+// higher index or wait has elapsed. It returns the last index seen.
+func blockingQuery(ctx context.Context, index uint64, wait time.Duration, query func(q *api.QueryOptions) (uint64, error)) (uint64, error) {
+	deadline := time.Now().Add(wait)
+	for {
+		q := (&api.QueryOptions{}).WithContext(ctx)
+		remaining := time.Until(deadline)
+		if remaining > 0 && index != 0 {
+			// Consul falls back to its default wait for a zero WaitTime,
+			// so a WaitIndex is only sent while there is time left
+			q.WaitIndex = index
+			q.WaitTime = remaining
+		} else {
+			remaining = 0
+		}
+
+		last, err := query(q)
+		if err != nil {
+			return 0, err
+		}
+		// an index going backwards means the data was reset, Consul
+		// recommends starting over from the new index
+		if last != index || remaining == 0 || ctx.Err() != nil {
+			return last, nil
+		}
+	}
+}
+
+// WatchConfiguration waits until the configuration changes after index and
+// returns it with its new index. The configuration is nil if it was deleted.
+// If nothing changed within wait the returned index equals index.
+func (ps *PostStore) WatchConfiguration(ctx context.Context, id, version string, index uint64, wait time.Duration) (*config.Config, uint64, error) {
+	span := tracer.StartSpanFromContext(ctx, "Watch")
+	defer span.Finish()
+
+	ns := namespace.FromContext(ctx)
+	var pair *api.KVPair
+	last, err := blockingQuery(ctx, index, wait, func(q *api.QueryOptions) (uint64, error) {
+		p, meta, err := ps.kv.Get(configurationKey(ns, id, version), q)
+		if err != nil {
+			return 0, err
+		}
+		pair = p
+		return meta.LastIndex, nil
+	})
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, 0, err
+	}
+	if pair == nil {
+		return nil, last, nil
+	}
+
+	c := &config.Config{}
+	err = json.Unmarshal(pair.Value, c)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, 0, err
+	}
+	c.ModifyIndex = pair.ModifyIndex
+
+	return c, last, nil
+}
+
+// WatchGroup waits until anything in the group version changes after index
+// and returns the manifest and members with the new index. The manifest is
+// nil if the group version was deleted. If nothing changed within wait the
+// returned index equals index.
+func (ps *PostStore) WatchGroup(ctx context.Context, id, version string, index uint64, wait time.Duration) (*config.Group, []*config.Config, uint64, error) {
+	span := tracer.StartSpanFromContext(ctx, "Watch")
+	defer span.Finish()
+
+	ns := namespace.FromContext(ctx)
+	var pairs api.KVPairs
+	last, err := blockingQuery(ctx, index, wait, func(q *api.QueryOptions) (uint64, error) {
+		p, last, err := ps.list(groupPrefix(ns, id, version), q)
+		if err != nil {
+			return 0, err
+		}
+		pairs = p
+		return last, nil
+	})
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, nil, 0, err
+	}
+
+	snapshot, err := parseGroup(ns, id, version, pairs)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, nil, 0, err
+	}
+	if snapshot == nil {
+		return nil, nil, last, nil
+	}
+
+	return snapshot.manifest, snapshot.members, last, nil
+}
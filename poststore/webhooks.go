@@ -0,0 +1,208 @@
+package poststore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	tracer "github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/tracer"
+	"github.com/hashicorp/consul/api"
+)
+
+// Webhook subscriptions and the status of their deliveries are stored as
+//
+//	webhooks/{webhookID}
+//	deliveries/{webhookID}/{deliveryID}
+//
+// Only the most recent deliveries of each subscription are kept.
+
+// DeliveryRetention is how many deliveries are kept per subscription.
+const DeliveryRetention = 100
+
+func webhookKey(id string) string {
+	return key("webhooks", id)
+}
+
+func webhooksPrefix() string {
+	return prefix("webhooks")
+}
+
+func deliveryPrefix(webhookID string) string {
+	return prefix("deliveries", webhookID)
+}
+
+func deliveryKey(webhookID, deliveryID string) string {
+	return deliveryPrefix(webhookID) + deliveryID
+}
+
+// AddWebhook stores a new subscription. Subscription IDs are unique.
+func (ps *PostStore) AddWebhook(ctx context.Context, webhook *config.Webhook) error {
+	span := tracer.StartSpanFromContext(ctx, "Post")
+	defer span.Finish()
+
+	data, err := json.Marshal(webhook)
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+
+	ok, _, err := ps.kv.CAS(&api.KVPair{Key: webhookKey(webhook.ID), Value: data, ModifyIndex: 0}, nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("webhook %s: %w", webhook.ID, ErrVersionExists)
+	}
+
+	return nil
+}
+
+// GetWebhook returns the subscription with the given ID.
+func (ps *PostStore) GetWebhook(ctx context.Context, id string) (*config.Webhook, error) {
+	span := tracer.StartSpanFromContext(ctx, "Get")
+	defer span.Finish()
+
+	pair, _, err := ps.kv.Get(webhookKey(id), nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	if pair == nil {
+		return nil, fmt.Errorf("webhook %w", ErrNotFound)
+	}
+
+	webhook := &config.Webhook{}
+	err = json.Unmarshal(pair.Value, webhook)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	return webhook, nil
+}
+
+// ListWebhooks returns every subscription ordered by ID.
+func (ps *PostStore) ListWebhooks(ctx context.Context) ([]*config.Webhook, error) {
+	span := tracer.StartSpanFromContext(ctx, "GetAll")
+	defer span.Finish()
+
+	pairs, _, err := ps.list(webhooksPrefix(), nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	webhooks := make([]*config.Webhook, 0, len(pairs))
+	for _, pair := range pairs {
+		webhook := &config.Webhook{}
+		err := json.Unmarshal(pair.Value, webhook)
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+
+	return webhooks, nil
+}
+
+// DeleteWebhook removes a subscription together with its delivery history.
+func (ps *PostStore) DeleteWebhook(ctx context.Context, id string) error {
+	span := tracer.StartSpanFromContext(ctx, "Delete")
+	defer span.Finish()
+
+	_, err := ps.txn(api.KVTxnOps{
+		{Verb: api.KVDelete, Key: webhookKey(id)},
+		{Verb: api.KVDeleteTree, Key: deliveryPrefix(id)},
+	})
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+
+	return nil
+}
+
+// SaveDelivery creates or updates the status of a delivery. New deliveries
+// push the oldest ones of the subscription out of the history.
+func (ps *PostStore) SaveDelivery(ctx context.Context, delivery *config.Delivery) error {
+	span := tracer.StartSpanFromContext(ctx, "Put")
+	defer span.Finish()
+
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+
+	_, err = ps.kv.Put(&api.KVPair{Key: deliveryKey(delivery.WebhookID, delivery.ID), Value: data}, nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+
+	if delivery.Attempts == 0 {
+		err = ps.trimDeliveries(delivery.WebhookID)
+		if err != nil {
+			tracer.LogError(span, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (ps *PostStore) trimDeliveries(webhookID string) error {
+	pairs, _, err := ps.list(deliveryPrefix(webhookID), nil)
+	if err != nil {
+		return err
+	}
+	if len(pairs) <= DeliveryRetention {
+		return nil
+	}
+
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].CreateIndex < pairs[j].CreateIndex
+	})
+	for _, pair := range pairs[:len(pairs)-DeliveryRetention] {
+		_, _, err := ps.kv.DeleteCAS(pair, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ListDeliveries returns the delivery history of a subscription, newest
+// first.
+func (ps *PostStore) ListDeliveries(ctx context.Context, webhookID string) ([]*config.Delivery, error) {
+	span := tracer.StartSpanFromContext(ctx, "GetAll")
+	defer span.Finish()
+
+	pairs, _, err := ps.list(deliveryPrefix(webhookID), nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].CreateIndex > pairs[j].CreateIndex
+	})
+
+	deliveries := make([]*config.Delivery, 0, len(pairs))
+	for _, pair := range pairs {
+		delivery := &config.Delivery{}
+		err := json.Unmarshal(pair.Value, delivery)
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, nil
+}
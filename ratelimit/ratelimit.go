@@ -0,0 +1,334 @@
+// Package ratelimit keeps clients in a retry loop from overloading the
+// service and Consul. Before authentication every request takes a token from
+// the bucket of its IP address, so guessing credentials is limited too. After
+// authentication it takes a token from the bucket of its caller and from the
+// bucket of its route, reads and writes have separate buckets. A request
+// finding a bucket empty is answered with 429.
+package ratelimit
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/auth"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/metrics"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/namespace"
+	"github.com/gorilla/mux"
+)
+
+// Limit is the budget of one bucket: Rate tokens are added per second up to
+// Burst. The zero Limit is unlimited.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Unlimited reports whether the limit lets everything through.
+func (l Limit) Unlimited() bool {
+	return l.Rate <= 0 || l.Burst <= 0
+}
+
+func (l Limit) String() string {
+	if l.Unlimited() {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%g/s, burst %d", l.Rate, l.Burst)
+}
+
+// ParseLimit reads a limit such as "20/s", "600/1m" or "5/10s:50", requests
+// per period with an optional burst after the colon. The burst defaults to
+// the requests per period. An empty string is unlimited.
+func ParseLimit(s string) (Limit, error) {
+	if s == "" {
+		return Limit{}, nil
+	}
+	budget, burst, hasBurst := strings.Cut(s, ":")
+	count, period, ok := strings.Cut(budget, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("invalid rate limit %q, expected <requests>/<period>[:<burst>]", s)
+	}
+
+	n, err := strconv.Atoi(count)
+	if err != nil || n <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q, the requests must be a positive number", s)
+	}
+	if period != "" && (period[0] < '0' || period[0] > '9') {
+		period = "1" + period
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q, the period must be a duration such as s, 1m or 10s", s)
+	}
+
+	limit := Limit{Rate: float64(n) / d.Seconds(), Burst: n}
+	if hasBurst {
+		limit.Burst, err = strconv.Atoi(burst)
+		if err != nil || limit.Burst <= 0 {
+			return Limit{}, fmt.Errorf("invalid rate limit %q, the burst must be a positive number", s)
+		}
+	}
+	return limit, nil
+}
+
+// Config holds the limits of the five kinds of buckets.
+type Config struct {
+	// Address limits every IP address before the caller is authenticated,
+	// reads and writes together.
+	Address Limit
+	// IdentityRead and IdentityWrite limit each caller, anonymous callers
+	// per IP address.
+	IdentityRead  Limit
+	IdentityWrite Limit
+	// RouteRead and RouteWrite limit each route for all callers together.
+	RouteRead  Limit
+	RouteWrite Limit
+}
+
+// bucket is a token bucket, refilled lazily when it is used.
+type bucket struct {
+	key    string
+	limit  Limit
+	tokens float64
+	last   time.Time
+	// element is the place of the bucket in Limiter.recent
+	element *list.Element
+}
+
+func (b *bucket) refill(now time.Time) {
+	b.tokens = math.Min(float64(b.limit.Burst), b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate)
+	b.last = now
+}
+
+// wait is how long until the bucket holds n tokens.
+func (b *bucket) wait(n float64) time.Duration {
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.limit.Rate * float64(time.Second))
+}
+
+// maxBuckets is the number of buckets kept. Beyond it the least recently
+// used bucket is dropped, it had the longest time to refill and is usually
+// full, which is the same as a new one.
+const maxBuckets = 10000
+
+// Limiter is the rate limiting middleware.
+type Limiter struct {
+	Config Config
+	// Exempt paths aren't limited, e.g. /metrics.
+	Exempt map[string]bool
+	// Now returns the current time, nil uses time.Now.
+	Now func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	// recent orders the buckets from the most to the least recently used
+	recent *list.List
+}
+
+// New returns a Limiter with the given limits.
+func New(config Config) *Limiter {
+	return &Limiter{Config: config, Exempt: map[string]bool{}}
+}
+
+func (l *Limiter) now() time.Time {
+	if l.Now != nil {
+		return l.Now()
+	}
+	return time.Now()
+}
+
+// caller identifies whose bucket a request takes from, anonymous callers by
+// IP address so they don't share one budget.
+func caller(r *http.Request) string {
+	if identity := auth.FromRequest(r); identity != nil {
+		return identity.String()
+	}
+	return "anonymous:" + address(r)
+}
+
+func address(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func isRead(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// decision is the outcome of taking a token. The headers report the bucket
+// closest to running out.
+type decision struct {
+	allowed bool
+	// the bucket that rejected the request, "address", "identity" or
+	// "route"
+	scope      string
+	limit      int
+	remaining  int
+	reset      time.Duration
+	retryAfter time.Duration
+}
+
+// claim is a bucket a request takes a token from.
+type claim struct {
+	key   string
+	limit Limit
+	scope string
+}
+
+// take takes a token from the bucket of every claim, or from none of them if
+// one is empty. It reports false if none of the buckets is limited.
+func (l *Limiter) take(claims ...claim) (decision, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if l.buckets == nil {
+		l.buckets = make(map[string]*bucket)
+		l.recent = list.New()
+	}
+
+	buckets := make([]*bucket, 0, len(claims))
+	d := decision{allowed: true}
+	var tightest *bucket
+	for _, c := range claims {
+		if c.limit.Unlimited() {
+			continue
+		}
+		b := l.bucket(c, now)
+		b.refill(now)
+		buckets = append(buckets, b)
+
+		if b.tokens < 1 && (d.allowed || b.wait(1) > d.retryAfter) {
+			d.allowed = false
+			d.scope = c.scope
+			d.retryAfter = b.wait(1)
+		}
+		if tightest == nil || b.tokens < tightest.tokens {
+			tightest = b
+		}
+	}
+	if tightest == nil {
+		return d, false
+	}
+
+	if d.allowed {
+		for _, b := range buckets {
+			b.tokens--
+		}
+	}
+	d.limit = tightest.limit.Burst
+	d.remaining = int(math.Max(0, tightest.tokens))
+	d.reset = tightest.wait(float64(tightest.limit.Burst))
+	return d, true
+}
+
+// bucket returns the bucket of a claim, creating it if needed, and marks it
+// as the most recently used one. Must be called with l.mu held.
+func (l *Limiter) bucket(c claim, now time.Time) *bucket {
+	b, ok := l.buckets[c.key]
+	if ok && b.limit == c.limit {
+		l.recent.MoveToFront(b.element)
+		return b
+	}
+	if ok {
+		l.recent.Remove(b.element)
+	}
+
+	b = &bucket{key: c.key, limit: c.limit, tokens: float64(c.limit.Burst), last: now}
+	b.element = l.recent.PushFront(b)
+	l.buckets[c.key] = b
+	for len(l.buckets) > maxBuckets {
+		oldest := l.recent.Remove(l.recent.Back()).(*bucket)
+		delete(l.buckets, oldest.key)
+	}
+	return b
+}
+
+// AddressMiddleware limits the requests of every IP address. It must run
+// before the authentication middleware, so failed authentications take
+// tokens and don't reach the key store once the bucket is empty.
+func (l *Limiter) AddressMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.Exempt[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		kind := "write"
+		if isRead(r.Method) {
+			kind = "read"
+		}
+		d, limited := l.take(claim{key: "address/" + address(r), limit: l.Config.Address, scope: "address"})
+		if limited && !allow(w, r, d, kind) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Middleware limits the requests of the routes of a mux router. It must run
+// after the authentication middleware, which sets the caller identity.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.Exempt[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		kind, identityLimit, routeLimit := "write", l.Config.IdentityWrite, l.Config.RouteWrite
+		if isRead(r.Method) {
+			kind, identityLimit, routeLimit = "read", l.Config.IdentityRead, l.Config.RouteRead
+		}
+
+		d, limited := l.take(
+			claim{key: "identity/" + kind + "/" + caller(r), limit: identityLimit, scope: "identity"},
+			claim{key: "route/" + kind + "/" + r.Method + " " + route(r), limit: routeLimit, scope: "route"},
+		)
+		if limited && !allow(w, r, d, kind) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allow sets the rate limit headers of a decision and answers with 429 if
+// the request was rejected.
+func allow(w http.ResponseWriter, r *http.Request, d decision, kind string) bool {
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(d.limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(d.remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(seconds(d.reset)))
+	if d.allowed {
+		return true
+	}
+
+	metrics.RateLimited.WithLabelValues(route(r), metrics.Namespace(namespace.FromRequest(r)), d.scope, kind).Inc()
+	w.Header().Set("Retry-After", strconv.Itoa(seconds(d.retryAfter)))
+	http.Error(w, fmt.Sprintf("rate limit of the %s exceeded for %s requests, retry later", d.scope, kind), http.StatusTooManyRequests)
+	return false
+}
+
+// route is the path template of the matched route, or the path.
+func route(r *http.Request) string {
+	if current := mux.CurrentRoute(r); current != nil {
+		if template, err := current.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return r.URL.Path
+}
+
+// seconds rounds a wait up to whole seconds, the unit of the headers.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/auth"
+)
+
+var errForceNotAllowed = errors.New("force overwrite requires a valid X-Admin-Token header")
+
+// forceRequested reports whether the request asks to overwrite an existing
+// version with ?force=true. The caller needs the configurations:force
+// permission for every target, which only the admin role grants. It writes the
+// 403 itself and returns ok false if the caller may not force.
+//
+// Without an Authorizer the X-Admin-Token header is checked instead. This is
+// the legacy fallback for services run without role bindings, the token is
+// ignored once an Authorizer is set.
+func (s *Service) forceRequested(w http.ResponseWriter, r *http.Request, targets ...auth.Target) (force, ok bool) {
+	if r.URL.Query().Get("force") != "true" {
+		return false, true
+	}
+
+	if s.Authorizer == nil {
+		token := r.Header.Get("X-Admin-Token")
+		if s.AdminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.AdminToken)) != 1 {
+			http.Error(w, errForceNotAllowed.Error(), http.StatusForbidden)
+			return false, false
+		}
+		return true, true
+	}
+
+	err := s.Authorizer.AuthorizeTargets(r, auth.PermConfigurationsForce, targets...)
+	var forbidden *auth.ForbiddenError
+	if errors.As(err, &forbidden) {
+		auth.WriteForbidden(w, forbidden)
+		return false, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false, false
+	}
+	return true, true
+}
@@ -0,0 +1,322 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/auth"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/metrics"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/namespace"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/poststore"
+	tracer "github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/tracer"
+	"github.com/google/uuid"
+)
+
+// Every mutating handler appends an audit record after its write succeeded.
+// Like publishEvent the write already happened, so a failing append can't undo
+// it: appends retry until auditTimeout and failures are logged and counted in
+// the audit_append_failures_total metric. The records carry hashes of the target before and after the change
+// instead of the content itself.
+
+const (
+	requestIDHeader = "X-Request-ID"
+
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// requestID returns the X-Request-ID of the request, or a new one which is
+// echoed in the response so the caller can find the audit record.
+func requestID(w http.ResponseWriter, r *http.Request) string {
+	id := r.Header.Get(requestIDHeader)
+	if id == "" {
+		id = uuid.New().String()
+	}
+	w.Header().Set(requestIDHeader, id)
+	return id
+}
+
+// actor identifies the caller by the authenticated identity, or "admin" for
+// unauthenticated requests with the admin token.
+func (s *Service) actor(r *http.Request) string {
+	if identity := auth.FromRequest(r); identity != nil {
+		return identity.String()
+	}
+	token := r.Header.Get("X-Admin-Token")
+	if s.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.AdminToken)) == 1 {
+		return "admin"
+	}
+	return "anonymous"
+}
+
+// contentHash returns the hex SHA-256 of the JSON encoding of v, or "" for
+// nil.
+func contentHash(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func configHash(c *config.Config) string {
+	if c == nil {
+		return ""
+	}
+	return contentHash(c)
+}
+
+// groupHash hashes the members ordered by ID, so the hash doesn't depend on
+// the order they were read in.
+func groupHash(configs []*config.Config) string {
+	if len(configs) == 0 {
+		return ""
+	}
+	sorted := append([]*config.Config{}, configs...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].ID < sorted[j].ID
+	})
+	return contentHash(sorted)
+}
+
+// webhookHash leaves the secret out of the hash.
+func webhookHash(webhook *config.Webhook) string {
+	if webhook == nil {
+		return ""
+	}
+	copied := *webhook
+	copied.Secret = ""
+	return contentHash(&copied)
+}
+
+// auditTimeout bounds how long an append keeps retrying. It doesn't depend on
+// the request, the change is made whether or not the client still waits.
+const auditTimeout = 30 * time.Second
+
+// audit fills in who made the change and appends the record. Records that
+// can't be appended are counted in the audit_append_failures_total metric.
+func (s *Service) audit(w http.ResponseWriter, r *http.Request, record *config.AuditRecord) {
+	span := tracer.StartSpanFromContext(r.Context(), "Audit")
+	defer span.Finish()
+
+	record.Time = time.Now().UTC()
+	record.Actor = s.actor(r)
+	record.Namespace = namespace.FromRequest(r)
+	record.RemoteAddr = r.RemoteAddr
+	record.RequestID = requestID(w, r)
+	record.TraceID = tracer.TraceID(span)
+
+	ctx, cancel := context.WithTimeout(tracer.ContextWithSpan(context.Background(), span), auditTimeout)
+	defer cancel()
+	err := s.PostStore.AppendAudit(ctx, record)
+	if err != nil {
+		metrics.AuditAppendFailures.WithLabelValues(metrics.Namespace(record.Namespace), record.Action).Inc()
+		log.Printf("appending audit record for %s %s: %v", record.Action, record.TargetID, err)
+		tracer.LogError(span, err)
+	}
+}
+
+func groupAudit(action, id, version string, before, after []*config.Config) *config.AuditRecord {
+	return &config.AuditRecord{
+		Action:        action,
+		TargetKind:    config.TargetGroup,
+		TargetID:      id,
+		TargetVersion: version,
+		BeforeHash:    groupHash(before),
+		AfterHash:     groupHash(after),
+	}
+}
+
+// auditGroups appends one record per group version in configs. before holds
+// the members of the versions that were replaced, by "groupID/version".
+func (s *Service) auditGroups(w http.ResponseWriter, r *http.Request, action string, configs []*config.Config, before map[string][]*config.Config) {
+	var keys []string
+	members := make(map[string][]*config.Config)
+	for _, c := range configs {
+		groupKey := c.GroupID + "/" + c.Version
+		if _, ok := members[groupKey]; !ok {
+			keys = append(keys, groupKey)
+		}
+		members[groupKey] = append(members[groupKey], c)
+	}
+
+	for _, groupKey := range keys {
+		first := members[groupKey][0]
+		record := groupAudit(action, first.GroupID, first.Version, before[groupKey], members[groupKey])
+		if before[groupKey] != nil {
+			record.Details = map[string]string{"force": "true"}
+		}
+		s.audit(w, r, record)
+	}
+}
+
+// existingConfiguration returns the stored version, or nil if there is none
+// or it couldn't be read. It is only used for the before hash.
+func (s *Service) existingConfiguration(ctx context.Context, id, version string) *config.Config {
+	c, err := s.PostStore.GetConfiguration(ctx, id, version)
+	if err != nil {
+		return nil
+	}
+	return c
+}
+
+// overwrittenConfiguration returns the version a forced add replaces.
+func (s *Service) overwrittenConfiguration(ctx context.Context, force bool, id, version string) *config.Config {
+	if !force {
+		return nil
+	}
+	return s.existingConfiguration(ctx, id, version)
+}
+
+func configurationAudit(action, id, version string, before, after *config.Config) *config.AuditRecord {
+	return &config.AuditRecord{
+		Action:        action,
+		TargetKind:    config.TargetConfiguration,
+		TargetID:      id,
+		TargetVersion: version,
+		BeforeHash:    configHash(before),
+		AfterHash:     configHash(after),
+	}
+}
+
+func configurationCreatedAudit(before, after *config.Config, force bool) *config.AuditRecord {
+	record := configurationAudit(config.ConfigurationCreated, after.ID, after.Version, before, after)
+	if force {
+		record.Details = map[string]string{"force": "true"}
+	}
+	return record
+}
+
+// auditPage is the body of a GET /audit response.
+type auditPage struct {
+	Records []*config.AuditRecord `json:"records"`
+	// Cursor for the next page, empty on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+func auditQuery(r *http.Request, v *validator) poststore.AuditQuery {
+	params := r.URL.Query()
+	q := poststore.AuditQuery{
+		TargetKind: params.Get("kind"),
+		TargetID:   params.Get("target"),
+		Actor:      params.Get("actor"),
+		Namespace:  params.Get("namespace"),
+		Limit:      defaultAuditLimit,
+	}
+	if q.Namespace != "" {
+		if err := namespace.Validate(q.Namespace); err != nil {
+			v.add("namespace", q.Namespace, "%s", err.Error())
+		}
+	}
+
+	switch q.TargetKind {
+	case "", config.TargetConfiguration, config.TargetGroup, config.TargetWebhook, config.TargetRoleBinding, config.TargetSchema:
+	default:
+		v.add("kind", q.TargetKind, "must be configuration, group, webhook, role_binding or schema")
+	}
+	v.id("target", q.TargetID, false)
+
+	for _, param := range []struct {
+		name string
+		t    *time.Time
+	}{{"since", &q.Since}, {"until", &q.Until}} {
+		value := params.Get(param.name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			v.add(param.name, value, "must be an RFC 3339 time")
+			continue
+		}
+		*param.t = t
+	}
+
+	if value := params.Get("cursor"); value != "" {
+		cursor, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			v.add("cursor", value, "must be a cursor returned as next_cursor")
+		}
+		q.After = cursor
+	}
+	if value := params.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxAuditLimit {
+			v.add("limit", value, "must be between 1 and %d", maxAuditLimit)
+		}
+		q.Limit = limit
+	}
+
+	return q
+}
+
+// swagger:route GET /audit audit listAudit
+//
+// Returns the audit log, oldest first. It can be filtered by ?kind=,
+// ?target=, ?actor= and the RFC 3339 times ?since= and ?until=. Callers with
+// audit:read in every namespace see all namespaces and can filter by
+// ?namespace=, the others only see the namespace of the request. Pages hold
+// ?limit= records (default 100), the next page is requested with the
+// next_cursor of the previous one in ?cursor=.
+//
+// Responses:
+//
+//	200: auditPageResponse
+//	400: badRequestResponse
+//	403: forbiddenResponse
+//	500: internalServerErrorResponse
+func (s *Service) ListAudit(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	span := tracer.StartSpanFromContext(ctx, "GetAll")
+	defer span.Finish()
+
+	v := &validator{}
+	q := auditQuery(r, v)
+	if !v.valid(w) {
+		return
+	}
+	if ns := namespace.FromRequest(r); q.Namespace != ns {
+		global, err := s.global(r, auth.PermAuditRead)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			tracer.LogError(span, err)
+			return
+		}
+		if !global && q.Namespace != "" {
+			auth.WriteForbidden(w, &auth.ForbiddenError{Subject: auth.Subject(r), Permission: auth.PermAuditRead, Namespace: q.Namespace})
+			return
+		}
+		if !global {
+			q.Namespace = ns
+		}
+	}
+
+	records, next, err := s.PostStore.ListAudit(ctx, q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		tracer.LogError(span, err)
+		return
+	}
+
+	page := auditPage{Records: records}
+	if next != 0 {
+		page.NextCursor = strconv.FormatUint(next, 10)
+	}
+	err = encodeJSON(w, page)
+	if err != nil {
+		tracer.LogError(span, err)
+	}
+}
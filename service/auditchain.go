@@ -0,0 +1,223 @@
+package service
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/auth"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/poststore"
+	tracer "github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/tracer"
+)
+
+// The audit records form a hash chain (see poststore/audit.go). A chain can
+// still be recomputed from any record on, so an AuditCheckpointer signs the
+// head with an ed25519 key kept outside Consul. A rewritten chain no longer
+// matches the signed hashes.
+
+// LoadAuditKey reads an ed25519 private key from a PEM file holding a PKCS #8
+// "PRIVATE KEY" block, as written by `openssl genpkey -algorithm ed25519`.
+func LoadAuditKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("%s: no PEM PRIVATE KEY block", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	ed, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an ed25519 key", path)
+	}
+	return ed, nil
+}
+
+// AuditCheckpointer signs the head of the audit chain periodically.
+type AuditCheckpointer struct {
+	store poststore.Store
+	key   ed25519.PrivateKey
+}
+
+func NewAuditCheckpointer(store poststore.Store, key ed25519.PrivateKey) *AuditCheckpointer {
+	return &AuditCheckpointer{store: store, key: key}
+}
+
+// PublicKey verifies the checkpoints signed by c.
+func (c *AuditCheckpointer) PublicKey() ed25519.PublicKey {
+	return c.key.Public().(ed25519.PublicKey)
+}
+
+// Run writes a checkpoint every interval until ctx is cancelled.
+func (c *AuditCheckpointer) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := c.Checkpoint(ctx)
+			if err != nil {
+				log.Printf("audit checkpoint: %v", err)
+			}
+		}
+	}
+}
+
+// Checkpoint signs the current head. It returns nil without writing anything
+// if the head is already checkpointed or the log is empty.
+func (c *AuditCheckpointer) Checkpoint(ctx context.Context) (*config.AuditCheckpoint, error) {
+	head, err := c.store.GetAuditHead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if head.Seq == 0 {
+		return nil, nil
+	}
+
+	checkpoint := &config.AuditCheckpoint{Seq: head.Seq, Hash: head.Hash, Time: time.Now().UTC()}
+	checkpoint.Signature = ed25519.Sign(c.key, checkpoint.SignedMessage())
+	err = c.store.SaveAuditCheckpoint(ctx, checkpoint)
+	if errors.Is(err, poststore.ErrVersionExists) {
+		// already signed, by this or another instance
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return checkpoint, nil
+}
+
+// errChainBroken stops the walk at the first broken link.
+var errChainBroken = errors.New("audit chain broken")
+
+// VerifyAuditChain walks the audit chain and reports the first broken link.
+// Without a public key the checkpoint hashes are compared but not their
+// signatures.
+func VerifyAuditChain(ctx context.Context, store poststore.Store, publicKey ed25519.PublicKey) (*config.AuditVerification, error) {
+	result := &config.AuditVerification{SignaturesChecked: publicKey != nil}
+	broken := func(seq uint64, format string, args ...interface{}) error {
+		result.BrokenAt = seq
+		result.Reason = fmt.Sprintf(format, args...)
+		return errChainBroken
+	}
+
+	head, err := store.GetAuditHead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	checkpoints, err := store.ListAuditCheckpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+	bySeq := make(map[uint64]*config.AuditCheckpoint, len(checkpoints))
+	for _, checkpoint := range checkpoints {
+		bySeq[checkpoint.Seq] = checkpoint
+	}
+
+	prevHash := ""
+	err = store.WalkAudit(ctx, func(record *config.AuditRecord) error {
+		expected := result.Records + 1
+		switch {
+		case record.Seq > expected:
+			return broken(expected, "record %d is missing", expected)
+		case record.Seq != expected:
+			return broken(expected, "record %d is stored as record %d", record.Seq, expected)
+		case record.PrevHash != prevHash:
+			return broken(expected, "prev_hash doesn't match the hash of record %d", expected-1)
+		case record.Hash != record.ComputeHash():
+			return broken(expected, "record doesn't match its hash")
+		}
+
+		if checkpoint, ok := bySeq[record.Seq]; ok {
+			if publicKey != nil && !ed25519.Verify(publicKey, checkpoint.SignedMessage(), checkpoint.Signature) {
+				return broken(expected, "checkpoint signature is invalid")
+			}
+			if checkpoint.Hash != record.Hash {
+				return broken(expected, "record doesn't match the signed checkpoint")
+			}
+			result.Checkpoints++
+		}
+
+		prevHash = record.Hash
+		result.Records++
+		return nil
+	})
+	if errors.Is(err, errChainBroken) {
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// records cut off the end only show in the head and the checkpoints
+	switch {
+	case head.Seq > result.Records:
+		broken(result.Records+1, "record %d is missing, the log ends before the head", result.Records+1)
+	case head.Seq < result.Records || head.Hash != prevHash:
+		broken(result.Records, "the head doesn't match the last record")
+	case result.Checkpoints < len(checkpoints):
+		for _, checkpoint := range checkpoints {
+			if checkpoint.Seq > result.Records {
+				broken(checkpoint.Seq, "checkpointed record %d is missing", checkpoint.Seq)
+				break
+			}
+		}
+	}
+
+	result.Valid = result.Reason == ""
+	return result, nil
+}
+
+// swagger:route GET /audit/verify audit verifyAudit
+//
+// Walks the audit chain and reports the first broken link. Checkpoint
+// signatures are checked when the server has the signing key. The chain
+// spans every namespace, so only callers with audit:read in every namespace
+// may verify it.
+//
+// Responses:
+//
+//	200: auditVerificationResponse
+//	403: forbiddenResponse
+//	500: internalServerErrorResponse
+func (s *Service) VerifyAudit(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	span := tracer.StartSpanFromContext(ctx, "Verify")
+	defer span.Finish()
+
+	if !s.requireGlobal(w, r, auth.PermAuditRead) {
+		return
+	}
+
+	var publicKey ed25519.PublicKey
+	if s.AuditCheckpoints != nil {
+		publicKey = s.AuditCheckpoints.PublicKey()
+	}
+
+	result, err := VerifyAuditChain(ctx, s.PostStore, publicKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		tracer.LogError(span, err)
+		return
+	}
+
+	err = encodeJSON(w, result)
+	if err != nil {
+		tracer.LogError(span, err)
+	}
+}
@@ -0,0 +1,190 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/poststore"
+	tracer "github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/tracer"
+	"github.com/gorilla/mux"
+)
+
+// diffVersion is the path segment of the diff endpoints, it can't be used as
+// a version.
+const diffVersion = "diff"
+
+// wantsUnifiedDiff reports whether the client asked for the text rendering
+// with ?format=unified or an Accept header of text/x-diff or text/plain.
+func wantsUnifiedDiff(r *http.Request) bool {
+	if r.URL.Query().Get("format") == "unified" {
+		return true
+	}
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "text/x-diff") || strings.Contains(accept, "text/plain")
+}
+
+func writeUnifiedDiff(w http.ResponseWriter, diff string) {
+	w.Header().Set("Content-Type", "text/x-diff; charset=utf-8")
+	io.WriteString(w, diff)
+}
+
+func diffParams(r *http.Request, v *validator) (string, string, string) {
+	id := mux.Vars(r)["id"]
+	from := r.URL.Query().Get("from")
+	to := r.URL.Query().Get("to")
+
+	v.id("id", id, true)
+	v.version("from", from, true)
+	v.version("to", to, true)
+	return id, from, to
+}
+
+// resolveVersion turns "latest" into the highest stored version.
+func (s *Service) resolveVersion(ctx context.Context, id, version string) (string, error) {
+	if version != config.LatestVersion {
+		return version, nil
+	}
+	versions, err := s.PostStore.ListConfigurationVersions(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if len(versions) == 0 {
+		return "", poststore.ErrNotFound
+	}
+	return versions[len(versions)-1].Version, nil
+}
+
+// resolveGroupVersion turns "latest" into the highest stored group version.
+func (s *Service) resolveGroupVersion(ctx context.Context, id, version string) (string, error) {
+	if version != config.LatestVersion {
+		return version, nil
+	}
+	versions, err := s.PostStore.ListGroupVersions(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if len(versions) == 0 {
+		return "", poststore.ErrNotFound
+	}
+	return versions[len(versions)-1], nil
+}
+
+// swagger:route GET /configurations/{id}/diff configurations diffConfiguration
+//
+// Returns the added, removed and changed entries and labels between the
+// versions ?from= and ?to= of a configuration. Either version can be
+// "latest". With ?format=unified or Accept: text/x-diff the difference is
+// rendered as a unified diff.
+//
+// Responses:
+//
+//	200: configDiffResponse
+//	400: badRequestResponse
+//	404: notFoundResponse
+//	500: internalServerErrorResponse
+func (s *Service) DiffConfiguration(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	span := tracer.StartSpanFromContext(ctx, "Diff")
+	defer span.Finish()
+
+	v := &validator{}
+	id, fromVersion, toVersion := diffParams(r, v)
+	if !v.valid(w) {
+		return
+	}
+
+	versions := make([]*config.Config, 0, 2)
+	for _, version := range []string{fromVersion, toVersion} {
+		resolved, err := s.resolveVersion(ctx, id, version)
+		if err == nil {
+			var c *config.Config
+			c, err = s.PostStore.GetConfiguration(ctx, id, resolved)
+			versions = append(versions, c)
+		}
+		if errors.Is(err, poststore.ErrNotFound) {
+			http.Error(w, "configuration "+id+" version "+version+" not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			tracer.LogError(span, err)
+			return
+		}
+	}
+	from, to := versions[0], versions[1]
+
+	if wantsUnifiedDiff(r) {
+		writeUnifiedDiff(w, config.UnifiedConfigDiff(from, to))
+		return
+	}
+
+	err := encodeJSON(w, config.DiffConfigs(from, to))
+	if err != nil {
+		tracer.LogError(span, err)
+	}
+}
+
+// swagger:route GET /group/{id}/diff groups diffConfigurationGroup
+//
+// Returns the membership changes and the changed members between the versions
+// ?from= and ?to= of a group. Either version can be "latest". With
+// ?format=unified or Accept: text/x-diff the difference is rendered as a
+// unified diff.
+//
+// Responses:
+//
+//	200: groupDiffResponse
+//	400: badRequestResponse
+//	404: notFoundResponse
+//	500: internalServerErrorResponse
+func (s *Service) DiffConfigurationGroup(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	span := tracer.StartSpanFromContext(ctx, "Diff")
+	defer span.Finish()
+
+	v := &validator{}
+	id, fromVersion, toVersion := diffParams(r, v)
+	if !v.valid(w) {
+		return
+	}
+
+	groups := make([][]*config.Config, 0, 2)
+	resolved := make([]string, 0, 2)
+	for _, version := range []string{fromVersion, toVersion} {
+		var group []*config.Config
+		latest, err := s.resolveGroupVersion(ctx, id, version)
+		if err == nil {
+			group, err = s.PostStore.GetConfigurationGroup(ctx, id, latest)
+		}
+		if err == nil && len(group) == 0 {
+			err = poststore.ErrNotFound
+		}
+		if errors.Is(err, poststore.ErrNotFound) {
+			http.Error(w, "group "+id+" version "+version+" not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			tracer.LogError(span, err)
+			return
+		}
+		groups = append(groups, group)
+		resolved = append(resolved, latest)
+	}
+	from, to := groups[0], groups[1]
+	fromVersion, toVersion = resolved[0], resolved[1]
+
+	if wantsUnifiedDiff(r) {
+		writeUnifiedDiff(w, config.UnifiedGroupDiff(id, fromVersion, toVersion, from, to))
+		return
+	}
+
+	err := encodeJSON(w, config.DiffGroups(id, fromVersion, toVersion, from, to))
+	if err != nil {
+		tracer.LogError(span, err)
+	}
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ETags are derived from the Consul ModifyIndex of the data, so an If-Match
+// value can be handed straight to a check-and-set write.
+
+func etag(index uint64) string {
+	return fmt.Sprintf("\"%d\"", index)
+}
+
+// listETag combines the indexes of every item of a list response.
+func listETag(indexes []uint64) string {
+	h := sha256.New()
+	buf := make([]byte, 8)
+	for _, index := range indexes {
+		binary.BigEndian.PutUint64(buf, index)
+		h.Write(buf)
+	}
+	return "\"" + hex.EncodeToString(h.Sum(nil)[:16]) + "\""
+}
+
+// notModified sets the ETag header and, if the request's If-None-Match
+// contains it, answers 304 Not Modified and returns true.
+func notModified(w http.ResponseWriter, r *http.Request, tag string) bool {
+	w.Header().Set("ETag", tag)
+
+	for _, candidate := range splitETags(r.Header.Get("If-None-Match")) {
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == tag {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+var errInvalidIfMatch = errors.New("If-Match must be a single ETag returned by a GET, e.g. \"42\", or *")
+
+// ifMatchIndex returns the ModifyIndex the client expects from the If-Match
+// header, or 0 when there is no precondition ("*" only requires the resource
+// to exist, which the handlers check anyway).
+func ifMatchIndex(r *http.Request) (uint64, error) {
+	values := splitETags(r.Header.Get("If-Match"))
+	if len(values) == 0 {
+		return 0, nil
+	}
+	if len(values) > 1 {
+		return 0, errInvalidIfMatch
+	}
+	if values[0] == "*" {
+		return 0, nil
+	}
+
+	index, err := strconv.ParseUint(strings.Trim(values[0], "\""), 10, 64)
+	if err != nil || index == 0 || !strings.HasPrefix(values[0], "\"") {
+		return 0, errInvalidIfMatch
+	}
+	return index, nil
+}
+
+func splitETags(header string) []string {
+	tags := make([]string, 0)
+	for _, tag := range strings.Split(header, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
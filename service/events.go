@@ -0,0 +1,348 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/namespace"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/poststore"
+	tracer "github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/tracer"
+)
+
+// Change events are written to the store by the handlers after every
+// successful write. Each instance runs an EventBroker that watches the stored
+// events with blocking queries and fans them out to its /events streams, so a
+// stream sees the writes made through every instance. Writers never wait for
+// streams: a subscriber whose buffer is full is disconnected and resumes with
+// Last-Event-ID when it reconnects.
+
+const (
+	subscriberBuffer  = 64
+	heartbeatInterval = 15 * time.Second
+	eventsRetryDelay  = time.Second
+)
+
+// EventBroker delivers stored change events to the subscribed streams.
+type EventBroker struct {
+	store poststore.Store
+
+	mu          sync.Mutex
+	subscribers map[*subscriber]struct{}
+	// closed when Run returns, which ends the open streams
+	stopped chan struct{}
+}
+
+type subscriber struct {
+	events chan *config.Event
+	// closed when the subscriber was dropped for falling behind
+	dropped chan struct{}
+}
+
+// NewEventBroker returns a broker for the events in store. Run must be called
+// for it to deliver anything.
+func NewEventBroker(store poststore.Store) *EventBroker {
+	return &EventBroker{
+		store:       store,
+		subscribers: make(map[*subscriber]struct{}),
+		stopped:     make(chan struct{}),
+	}
+}
+
+// Run watches the store for new events and delivers them until ctx is
+// cancelled. Events that already existed when Run started are not delivered,
+// streams get them by resuming with Last-Event-ID.
+func (b *EventBroker) Run(ctx context.Context) {
+	defer close(b.stopped)
+
+	var after, index uint64
+	for ctx.Err() == nil {
+		events, last, err := b.store.WatchEvents(ctx, after, index, 5*time.Minute)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("event broker: %v", err)
+			}
+			sleepContext(ctx, eventsRetryDelay)
+			continue
+		}
+
+		for _, event := range events {
+			if index != 0 {
+				b.publish(event)
+			}
+			after = event.ID
+		}
+		if index == 0 && after == 0 {
+			// nothing stored yet, everything from now on is new
+			after = last
+		}
+		index = last
+	}
+}
+
+func (b *EventBroker) publish(event *config.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subscribers {
+		select {
+		case sub.events <- event:
+		default:
+			delete(b.subscribers, sub)
+			close(sub.dropped)
+		}
+	}
+}
+
+func (b *EventBroker) subscribe() *subscriber {
+	sub := &subscriber{
+		events:  make(chan *config.Event, subscriberBuffer),
+		dropped: make(chan struct{}),
+	}
+
+	b.mu.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mu.Unlock()
+
+	return sub
+}
+
+func (b *EventBroker) unsubscribe(sub *subscriber) {
+	b.mu.Lock()
+	delete(b.subscribers, sub)
+	b.mu.Unlock()
+}
+
+// publishEvent stores a change event after a successful write and enqueues
+// its webhook deliveries. The write already happened, so a failure is only
+// logged.
+func (s *Service) publishEvent(ctx context.Context, event *config.Event) {
+	span := tracer.StartSpanFromContext(ctx, "Post")
+	defer span.Finish()
+
+	event.Namespace = namespace.FromContext(ctx)
+	err := s.PostStore.AppendEvent(ctx, event)
+	if err != nil {
+		log.Printf("publishing %s event: %v", event.Type, err)
+		tracer.LogError(span, err)
+	}
+
+	if s.Webhooks != nil {
+		err = s.Webhooks.Enqueue(ctx, event)
+		if err != nil {
+			log.Printf("enqueuing %s webhooks: %v", event.Type, err)
+			tracer.LogError(span, err)
+		}
+	}
+}
+
+// groupEvents returns one event per group version in configs.
+func groupEvents(eventType string, configs []*config.Config) []*config.Event {
+	var events []*config.Event
+	byGroup := make(map[string]*config.Event)
+	for _, c := range configs {
+		groupKey := c.GroupID + "/" + c.Version
+		event, ok := byGroup[groupKey]
+		if !ok {
+			event = config.NewEvent(eventType, c.GroupID, c.Version)
+			byGroup[groupKey] = event
+			events = append(events, event)
+		}
+		event.Configs = append(event.Configs, config.EventConfig{ID: c.ID, Labels: c.Labels})
+	}
+	return events
+}
+
+func configurationCreatedEvent(c *config.Config) *config.Event {
+	return config.NewEvent(config.ConfigurationCreated, "", c.Version, c)
+}
+
+// eventFilter selects the events a stream is interested in.
+type eventFilter struct {
+	namespace string
+	id        string
+	group     string
+	selector  config.Selector
+}
+
+// match returns the event to send, narrowed down to the configurations
+// matching the filter, or nil if the event doesn't match.
+func (f *eventFilter) match(event *config.Event) *config.Event {
+	if namespace.Normalize(event.Namespace) != f.namespace {
+		return nil
+	}
+	if f.group != "" && event.GroupID != f.group {
+		return nil
+	}
+	// group deletes don't list the members, they only match on the group
+	if (f.id == "" && len(f.selector) == 0) || len(event.Configs) == 0 {
+		return event
+	}
+
+	matched := *event
+	matched.Configs = nil
+	for _, c := range event.Configs {
+		if f.id != "" && c.ID != f.id {
+			continue
+		}
+		// configuration deletes don't carry labels, they match on the id
+		if len(f.selector) != 0 && c.Labels != nil && !f.selector.Matches(c.Labels) {
+			continue
+		}
+		matched.Configs = append(matched.Configs, c)
+	}
+	if len(matched.Configs) == 0 {
+		return nil
+	}
+
+	return &matched
+}
+
+// swagger:route GET /events events streamEvents
+//
+// Streams the configuration and group changes of the namespace as Server-Sent
+// Events. The stream can be narrowed with ?id=, ?group= and a ?labels= selector. A reconnecting
+// client sends Last-Event-ID to get the events it missed.
+//
+// Responses:
+//
+//	200: eventStreamResponse
+//	400: badRequestResponse
+//	503: serviceUnavailableResponse
+func (s *Service) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	span := tracer.StartSpanFromContext(ctx, "Events")
+	defer span.Finish()
+
+	if s.Events == nil {
+		http.Error(w, "event stream is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := &eventFilter{namespace: namespace.FromRequest(r), id: query.Get("id"), group: query.Get("group")}
+
+	v := &validator{}
+	v.id("id", filter.id, false)
+	v.id("group", filter.group, false)
+	if labels := query.Get("labels"); labels != "" {
+		selector, err := config.ParseSelector(labels)
+		if err != nil {
+			v.add("labels", labels, "%s", err.Error())
+		}
+		v.selector("labels", selector)
+		filter.selector = selector
+	}
+
+	var lastID uint64
+	resume := r.Header.Get("Last-Event-ID")
+	if resume == "" {
+		resume = query.Get("lastEventId")
+	}
+	if resume != "" {
+		id, err := strconv.ParseUint(resume, 10, 64)
+		if err != nil {
+			v.add("Last-Event-ID", resume, "must be an event id")
+		}
+		lastID = id
+	}
+	if !v.valid(w) {
+		return
+	}
+
+	// subscribe before reading the missed events so nothing falls in between,
+	// events seen twice are skipped by their ID
+	sub := s.Events.subscribe()
+	defer s.Events.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	send := func(event *config.Event) bool {
+		if event.ID <= lastID {
+			return true
+		}
+		lastID = event.ID
+		event = filter.match(event)
+		if event == nil {
+			return true
+		}
+		err := writeEvent(w, event)
+		if err != nil {
+			tracer.LogError(span, err)
+			return false
+		}
+		flusher.Flush()
+		return true
+	}
+
+	if resume != "" {
+		missed, _, err := s.PostStore.WatchEvents(ctx, lastID, 0, 0)
+		if err != nil {
+			tracer.LogError(span, err)
+			return
+		}
+		for _, event := range missed {
+			if !send(event) {
+				return
+			}
+		}
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.Events.stopped:
+			return
+		case <-sub.dropped:
+			// the client fell behind, it resumes with Last-Event-ID
+			return
+		case event := <-sub.events:
+			if !send(event) {
+				return
+			}
+		case <-heartbeat.C:
+			_, err := fmt.Fprint(w, ": heartbeat\n\n")
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event *config.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
+
+// sleepContext waits for d or until ctx is cancelled.
+func sleepContext(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
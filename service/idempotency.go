@@ -0,0 +1,110 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/poststore"
+	tracer "github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/tracer"
+	opentracing "github.com/opentracing/opentracing-go"
+)
+
+// Idempotency keys are scoped per endpoint, so the same key sent to
+// /configurations and /group refers to two different requests.
+const (
+	scopeConfigurations = "configurations"
+	scopeGroup          = "group"
+)
+
+// requestHash fingerprints a request so a reused Idempotency-Key with a
+// different payload can be told apart from a retry. JSON bodies are hashed in
+// canonical form, so whitespace and key order don't matter.
+func requestHash(r *http.Request, body []byte) string {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err == nil {
+		if canonical, err := json.Marshal(v); err == nil {
+			body = canonical
+		}
+	}
+
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// readBody reads the whole request body, up to maxBodyBytes, so it can be
+// both decoded and hashed.
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	if err != nil {
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// replayIdempotent answers the request from the stored idempotency record if
+// the key was already used. It returns true when the request has been handled:
+// either the original response was replayed, the key was reused with another
+// payload (422) or the lookup failed.
+func (s *Service) replayIdempotent(w http.ResponseWriter, r *http.Request, span opentracing.Span, scope, key, hash string) bool {
+	record, err := s.PostStore.GetIdempotencyRecord(r.Context(), scope, key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		tracer.LogError(span, err)
+		return true
+	}
+	if record == nil {
+		return false
+	}
+
+	if record.RequestHash != hash {
+		http.Error(w, "Idempotency-Key was already used with a different request payload", http.StatusUnprocessableEntity)
+		return true
+	}
+
+	if record.ContentType != "" {
+		w.Header().Set("Content-Type", record.ContentType)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(record.StatusCode)
+	w.Write(record.Body)
+	return true
+}
+
+// replayConcurrent answers a write that lost the race for its Idempotency-Key
+// against a concurrent request with the response of that request, and
+// returns true. It returns false for every other error.
+func (s *Service) replayConcurrent(w http.ResponseWriter, r *http.Request, span opentracing.Span, scope, key, hash string, err error) bool {
+	if !errors.Is(err, poststore.ErrIdempotencyKeyUsed) {
+		return false
+	}
+	if !s.replayIdempotent(w, r, span, scope, key, hash) {
+		// the record expired in the meantime
+		http.Error(w, err.Error(), http.StatusConflict)
+		tracer.LogError(span, err)
+	}
+	return true
+}
+
+func newIdempotencyRecord(hash string, status int, body []byte) *poststore.IdempotencyRecord {
+	return &poststore.IdempotencyRecord{
+		RequestHash: hash,
+		StatusCode:  status,
+		ContentType: "application/json",
+		Body:        body,
+	}
+}
+
+// writeJSON writes an already encoded JSON response.
+func writeJSON(w http.ResponseWriter, status int, body []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
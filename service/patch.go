@@ -0,0 +1,323 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/namespace"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/poststore"
+	tracer "github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/tracer"
+	"github.com/gorilla/mux"
+)
+
+const (
+	jsonPatchType  = "application/json-patch+json"
+	mergePatchType = "application/merge-patch+json"
+
+	// attempts at picking the next free version when two patches race
+	maxAutoVersionAttempts = 3
+)
+
+// readOnlyFields can't be changed by a patch, they identify the version.
+var readOnlyFields = []string{"id", "version", "group_id", "idempotency_key", "promoted_from"}
+
+// readOnlyField returns the read-only field a JSON Pointer points into. The
+// empty pointer replaces the whole document and so all of them.
+func readOnlyField(pointer string) (string, bool) {
+	if pointer == "" {
+		return strings.Join(readOnlyFields, ", "), true
+	}
+	for _, field := range readOnlyFields {
+		if pointer == "/"+field || strings.HasPrefix(pointer, "/"+field+"/") {
+			return field, true
+		}
+	}
+	return "", false
+}
+
+// patchResponse is the body of a 422 response to a patch that couldn't be
+// applied, with one entry per rejected operation.
+type patchResponse struct {
+	Error      string              `json:"error"`
+	Operations []config.PatchError `json:"operations"`
+}
+
+func writePatchErrors(w http.ResponseWriter, status int, message string, problems []config.PatchError) {
+	body, _ := json.Marshal(patchResponse{Error: message, Operations: problems})
+	writeJSON(w, status, body)
+}
+
+// applyPatch applies the request body to the base configuration according to
+// its Content-Type. It writes the error response itself and returns nil if
+// the patch was rejected.
+func applyPatch(w http.ResponseWriter, contentType string, base []byte, body []byte) []byte {
+	switch contentType {
+	case jsonPatchType:
+		ops, problems, err := config.ParseJSONPatch(body)
+		if err != nil {
+			writeValidationErrors(w, []FieldError{{Field: "body", Message: err.Error()}})
+			return nil
+		}
+		for i, op := range ops {
+			pointers := []string{op.Path}
+			if op.Op == "move" {
+				pointers = append(pointers, op.From)
+			}
+			for _, pointer := range pointers {
+				if field, ok := readOnlyField(pointer); ok {
+					problems = append(problems, config.PatchError{Index: i, Op: op.Op, Path: op.Path, Message: fmt.Sprintf("%s can't be changed by a patch", field)})
+					break
+				}
+			}
+		}
+		if len(problems) != 0 {
+			sort.SliceStable(problems, func(i, j int) bool {
+				return problems[i].Index < problems[j].Index
+			})
+			writePatchErrors(w, http.StatusBadRequest, "invalid patch", problems)
+			return nil
+		}
+
+		patched, problem := config.ApplyJSONPatch(base, ops)
+		if problem != nil {
+			writePatchErrors(w, http.StatusUnprocessableEntity, "patch could not be applied", []config.PatchError{*problem})
+			return nil
+		}
+		return patched
+
+	case mergePatchType:
+		var fields map[string]json.RawMessage
+		err := json.Unmarshal(body, &fields)
+		if err != nil {
+			writeValidationErrors(w, []FieldError{{Field: "body", Message: "a merge patch must be a JSON object"}})
+			return nil
+		}
+		var problems []FieldError
+		for _, field := range readOnlyFields {
+			if _, ok := fields[field]; ok {
+				problems = append(problems, FieldError{Field: field, Message: "can't be changed by a patch"})
+			}
+		}
+		if len(problems) != 0 {
+			writeValidationErrors(w, problems)
+			return nil
+		}
+
+		patched, err := config.ApplyMergePatch(base, body)
+		if err != nil {
+			writeValidationErrors(w, []FieldError{{Field: "body", Message: err.Error()}})
+			return nil
+		}
+		return patched
+	}
+
+	http.Error(w, fmt.Sprintf("Content-Type must be %s or %s", jsonPatchType, mergePatchType), http.StatusUnsupportedMediaType)
+	return nil
+}
+
+// nextVersion picks the version after the highest stored one.
+func (s *Service) nextVersion(ctx context.Context, id string) (string, error) {
+	versions, err := s.PostStore.ListConfigurationVersions(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	return followingVersion(versions[len(versions)-1].Version)
+}
+
+func followingVersion(latest string) (string, error) {
+	next, ok := config.NextVersion(latest)
+	if !ok {
+		return "", fmt.Errorf("version %q is not numeric, set the new version with ?to=", latest)
+	}
+	return next, nil
+}
+
+// swagger:route PATCH /configurations/{id}/{version} configurations patchConfiguration
+//
+// Creates a new version of the configuration by applying a JSON Patch
+// (Content-Type application/json-patch+json) or a JSON Merge Patch
+// (application/merge-patch+json) to the given version, which stays unchanged.
+// The new version is taken from ?to= or follows the highest stored version.
+// Retries with the same Idempotency-Key replay the first response.
+//
+// Responses:
+//
+//	200: configResponse
+//	400: badRequestResponse
+//	403: forbiddenResponse
+//	404: notFoundResponse
+//	409: conflictResponse
+//	412: preconditionFailedResponse
+//	415: unsupportedMediaTypeResponse
+//	422: unprocessableEntityResponse
+//	429: quotaExceededResponse
+//	500: internalServerErrorResponse
+//	507: quotaExceededResponse
+func (s *Service) PatchConfiguration(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	span := tracer.StartSpanFromContext(ctx, "Patch")
+	defer span.Finish()
+
+	vars := mux.Vars(r)
+	id := vars["id"]
+	version := vars["version"]
+	newVersion := r.URL.Query().Get("to")
+	idempotencyKey := r.Header.Get("Idempotency-Key")
+
+	if idempotencyKey == "" {
+		http.Error(w, "Idempotency-Key header missing", http.StatusBadRequest)
+		return
+	}
+
+	v := &validator{}
+	v.id("id", id, true)
+	v.version("version", version, true)
+	v.version("to", newVersion, false)
+	if isReservedVersion(newVersion) {
+		v.add("to", newVersion, "%q is reserved", newVersion)
+	}
+	v.idempotencyKey(idempotencyKey)
+	if !v.valid(w) {
+		return
+	}
+
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	body, err := readBody(w, r)
+	if err != nil {
+		bodyError(w, err)
+		return
+	}
+
+	hash := requestHash(r, body)
+	if s.replayIdempotent(w, r, span, scopeConfigurations, idempotencyKey, hash) {
+		return
+	}
+
+	index, err := ifMatchIndex(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resolved, err := s.resolveVersion(ctx, id, version)
+	var base *config.Config
+	if err == nil {
+		base, err = s.PostStore.GetConfiguration(ctx, id, resolved)
+	}
+	if errors.Is(err, poststore.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		tracer.LogError(span, err)
+		return
+	}
+	if index != 0 && index != base.ModifyIndex {
+		err = fmt.Errorf("configuration %s version %s: %w", id, resolved, poststore.ErrPreconditionFailed)
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+		return
+	}
+
+	baseJSON, err := json.Marshal(base)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		tracer.LogError(span, err)
+		return
+	}
+	patched := applyPatch(w, contentType, baseJSON, body)
+	if patched == nil {
+		return
+	}
+
+	result := &config.Config{}
+	err = json.Unmarshal(patched, result)
+	if err != nil {
+		writePatchErrors(w, http.StatusUnprocessableEntity, "patched document is not a configuration", []config.PatchError{{Index: -1, Message: err.Error()}})
+		return
+	}
+	result.ID = base.ID
+	result.GroupID = base.GroupID
+	result.IdempotencyKey = idempotencyKey
+	// a patched version is new content, not a promotion of the base
+	result.PromotedFrom = ""
+	if !s.authorizeResult(w, r, result) {
+		return
+	}
+	if !s.checkEntries(w, r, bodyPointer, result) {
+		return
+	}
+
+	var quota *poststore.QuotaChange
+	var response []byte
+	for attempt := 1; ; attempt++ {
+		result.Version = newVersion
+		if newVersion == "" {
+			result.Version, err = s.nextVersion(ctx, id)
+			if err != nil {
+				writeValidationErrors(w, []FieldError{{Field: "to", Message: err.Error()}})
+				return
+			}
+		}
+
+		v := &validator{}
+		v.config("", result, false)
+		if !v.valid(w) {
+			return
+		}
+
+		// the idempotency record is committed in the same transaction as the
+		// version, so concurrent retries can't create two
+		response, err = json.Marshal(result)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			tracer.LogError(span, err)
+			return
+		}
+		idempotency := &poststore.IdempotencyEntry{
+			Scope:  scopeConfigurations,
+			Key:    idempotencyKey,
+			Record: newIdempotencyRecord(hash, http.StatusOK, response),
+		}
+
+		quota = s.quota(ctx, newQuotaChange().config(result, 1))
+		err = s.PostStore.AddConfiguration(ctx, result, idempotency, quota)
+		if errors.Is(err, poststore.ErrVersionExists) && newVersion == "" && attempt < maxAutoVersionAttempts {
+			continue
+		}
+		break
+	}
+	if s.replayConcurrent(w, r, span, scopeConfigurations, idempotencyKey, hash, err) {
+		return
+	}
+	if s.quotaFailed(w, r, err) {
+		tracer.LogError(span, err)
+		return
+	}
+	if errors.Is(err, poststore.ErrVersionExists) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		tracer.LogError(span, err)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		tracer.LogError(span, err)
+		return
+	}
+
+	s.reportQuota(ctx, quota)
+	s.publishEvent(ctx, configurationCreatedEvent(result))
+	record := configurationAudit(config.ConfigurationCreated, result.ID, result.Version, nil, result)
+	record.Details = map[string]string{"patched_from": base.Version}
+	s.audit(w, r, record)
+
+	w.Header().Set("Location", namespace.Path(ctx, "/configurations/"+result.ID+"/"+result.Version))
+	writeJSON(w, http.StatusOK, response)
+}
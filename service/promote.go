@@ -0,0 +1,311 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/namespace"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/poststore"
+	tracer "github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/tracer"
+	"github.com/gorilla/mux"
+)
+
+// Promoting copies an existing version forward as a new version, which makes
+// it the latest one. Rolling back is promoting the last good version. The new
+// version records the source in promoted_from, versions stay write-once.
+
+// promoteParams reads and validates the path, ?to= and the Idempotency-Key
+// of a promote request. It writes the error response itself and returns false
+// if the request is invalid.
+func promoteParams(w http.ResponseWriter, r *http.Request) (string, string, string, string, bool) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	version := vars["version"]
+	newVersion := r.URL.Query().Get("to")
+	idempotencyKey := r.Header.Get("Idempotency-Key")
+
+	if idempotencyKey == "" {
+		http.Error(w, "Idempotency-Key header missing", http.StatusBadRequest)
+		return "", "", "", "", false
+	}
+
+	v := &validator{}
+	v.id("id", id, true)
+	v.version("version", version, true)
+	v.version("to", newVersion, false)
+	if isReservedVersion(newVersion) {
+		v.add("to", newVersion, "%q is reserved", newVersion)
+	}
+	v.idempotencyKey(idempotencyKey)
+	return id, version, newVersion, idempotencyKey, v.valid(w)
+}
+
+// nextGroupVersion picks the version after the highest stored group version.
+func (s *Service) nextGroupVersion(ctx context.Context, id string) (string, error) {
+	versions, err := s.PostStore.ListGroupVersions(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if len(versions) == 0 {
+		return "", poststore.ErrNotFound
+	}
+	return followingVersion(versions[len(versions)-1])
+}
+
+// swagger:route POST /configurations/{id}/{version}/promote configurations promoteConfiguration
+//
+// Copies the given version forward as a new version, taken from ?to= or
+// following the highest stored version. The copy records the source version
+// in promoted_from. Retries with the same Idempotency-Key replay the first
+// response.
+//
+// Responses:
+//
+//	200: configResponse
+//	400: badRequestResponse
+//	404: notFoundResponse
+//	409: conflictResponse
+//	422: unprocessableEntityResponse
+//	429: quotaExceededResponse
+//	500: internalServerErrorResponse
+//	507: quotaExceededResponse
+func (s *Service) PromoteConfiguration(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	span := tracer.StartSpanFromContext(ctx, "Promote")
+	defer span.Finish()
+
+	id, version, newVersion, idempotencyKey, ok := promoteParams(w, r)
+	if !ok {
+		return
+	}
+
+	body, err := readBody(w, r)
+	if err != nil {
+		bodyError(w, err)
+		return
+	}
+	hash := requestHash(r, body)
+	if s.replayIdempotent(w, r, span, scopeConfigurations, idempotencyKey, hash) {
+		return
+	}
+
+	resolved, err := s.resolveVersion(ctx, id, version)
+	var source *config.Config
+	if err == nil {
+		source, err = s.PostStore.GetConfiguration(ctx, id, resolved)
+	}
+	if errors.Is(err, poststore.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		tracer.LogError(span, err)
+		return
+	}
+
+	promoted := *source
+	promoted.PromotedFrom = source.Version
+	promoted.IdempotencyKey = idempotencyKey
+	promoted.ModifyIndex = 0
+
+	var quota *poststore.QuotaChange
+	var response []byte
+	for attempt := 1; ; attempt++ {
+		promoted.Version = newVersion
+		if newVersion == "" {
+			promoted.Version, err = s.nextVersion(ctx, id)
+			if err != nil {
+				writeValidationErrors(w, []FieldError{{Field: "to", Message: err.Error()}})
+				return
+			}
+		}
+
+		// like PromoteConfigurationGroup the idempotency record is committed
+		// in the same transaction as the version
+		response, err = json.Marshal(promoted)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			tracer.LogError(span, err)
+			return
+		}
+		idempotency := &poststore.IdempotencyEntry{
+			Scope:  scopeConfigurations,
+			Key:    idempotencyKey,
+			Record: newIdempotencyRecord(hash, http.StatusOK, response),
+		}
+
+		quota = s.quota(ctx, newQuotaChange().config(&promoted, 1))
+		err = s.PostStore.AddConfiguration(ctx, &promoted, idempotency, quota)
+		if errors.Is(err, poststore.ErrVersionExists) && newVersion == "" && attempt < maxAutoVersionAttempts {
+			continue
+		}
+		break
+	}
+	if s.replayConcurrent(w, r, span, scopeConfigurations, idempotencyKey, hash, err) {
+		return
+	}
+	if s.quotaFailed(w, r, err) {
+		tracer.LogError(span, err)
+		return
+	}
+	if errors.Is(err, poststore.ErrVersionExists) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		tracer.LogError(span, err)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		tracer.LogError(span, err)
+		return
+	}
+
+	event := config.NewEvent(config.ConfigurationPromoted, "", promoted.Version, &promoted)
+	event.PromotedFrom = source.Version
+	s.reportQuota(ctx, quota)
+	s.publishEvent(ctx, event)
+	record := configurationAudit(config.ConfigurationPromoted, promoted.ID, promoted.Version, nil, &promoted)
+	record.Details = map[string]string{"promoted_from": source.Version}
+	s.audit(w, r, record)
+
+	w.Header().Set("Location", namespace.Path(ctx, "/configurations/"+promoted.ID+"/"+promoted.Version))
+	writeJSON(w, http.StatusOK, response)
+}
+
+// swagger:route POST /group/{id}/{version}/promote groups promoteConfigurationGroup
+//
+// Copies the given group version with all its members forward as a new
+// version, taken from ?to= or following the highest group version. The copy
+// records the source version in promoted_from. Retries with the same
+// Idempotency-Key replay the first response.
+//
+// Responses:
+//
+//	200: configGroupResponse
+//	400: badRequestResponse
+//	404: notFoundResponse
+//	409: conflictResponse
+//	422: unprocessableEntityResponse
+//	429: quotaExceededResponse
+//	500: internalServerErrorResponse
+//	507: quotaExceededResponse
+func (s *Service) PromoteConfigurationGroup(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	span := tracer.StartSpanFromContext(ctx, "Promote")
+	defer span.Finish()
+
+	id, version, newVersion, idempotencyKey, ok := promoteParams(w, r)
+	if !ok {
+		return
+	}
+
+	body, err := readBody(w, r)
+	if err != nil {
+		bodyError(w, err)
+		return
+	}
+	hash := requestHash(r, body)
+	if s.replayIdempotent(w, r, span, scopeGroup, idempotencyKey, hash) {
+		return
+	}
+
+	version, err = s.resolveGroupVersion(ctx, id, version)
+	if errors.Is(err, poststore.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		tracer.LogError(span, err)
+		return
+	}
+
+	_, members, err := s.PostStore.GetGroup(ctx, id, version)
+	if errors.Is(err, poststore.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		tracer.LogError(span, err)
+		return
+	}
+
+	var target string
+	var promoted []*config.Config
+	var response []byte
+	var quota *poststore.QuotaChange
+	for attempt := 1; ; attempt++ {
+		target = newVersion
+		if target == "" {
+			target, err = s.nextGroupVersion(ctx, id)
+			if err != nil {
+				writeValidationErrors(w, []FieldError{{Field: "to", Message: err.Error()}})
+				return
+			}
+		}
+
+		promoted = make([]*config.Config, 0, len(members))
+		for _, member := range members {
+			c := *member
+			c.Version = target
+			c.PromotedFrom = version
+			c.IdempotencyKey = idempotencyKey
+			c.ModifyIndex = 0
+			promoted = append(promoted, &c)
+		}
+
+		// like AddConfigurationGroup the idempotency record is committed in
+		// the same transaction as the group
+		response, err = json.Marshal(promoted)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			tracer.LogError(span, err)
+			return
+		}
+		idempotency := &poststore.IdempotencyEntry{
+			Scope:  scopeGroup,
+			Key:    idempotencyKey,
+			Record: newIdempotencyRecord(hash, http.StatusOK, response),
+		}
+
+		quota = s.quota(ctx, newQuotaChange().groups(promoted, 1))
+		err = s.PostStore.AddConfigurationGroup(ctx, promoted, nil, idempotency, quota)
+		if errors.Is(err, poststore.ErrVersionExists) && newVersion == "" && attempt < maxAutoVersionAttempts {
+			continue
+		}
+		break
+	}
+	if s.replayConcurrent(w, r, span, scopeGroup, idempotencyKey, hash, err) {
+		return
+	}
+	if s.quotaFailed(w, r, err) {
+		tracer.LogError(span, err)
+		return
+	}
+	if errors.Is(err, poststore.ErrVersionExists) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		tracer.LogError(span, err)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		tracer.LogError(span, err)
+		return
+	}
+
+	event := config.NewEvent(config.GroupPromoted, id, target, promoted...)
+	event.PromotedFrom = version
+	s.reportQuota(ctx, quota)
+	s.publishEvent(ctx, event)
+	record := groupAudit(config.GroupPromoted, id, target, nil, promoted)
+	record.Details = map[string]string{"promoted_from": version}
+	s.audit(w, r, record)
+
+	w.Header().Set("Location", namespace.Path(ctx, fmt.Sprintf("/group/%s/%s", id, target)))
+	writeJSON(w, http.StatusOK, response)
+}
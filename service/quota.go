@@ -0,0 +1,292 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/metrics"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/namespace"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/poststore"
+	tracer "github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/tracer"
+)
+
+// Quotas are the limits of every namespace. A zero limit is unlimited.
+type Quotas struct {
+	// Default applies to the namespaces that aren't listed in Namespaces.
+	Default config.QuotaLimits `json:"default"`
+	// Namespaces replaces the default limits of some namespaces.
+	Namespaces map[string]config.QuotaLimits `json:"namespaces"`
+}
+
+// LoadQuotas reads the quotas from a JSON file such as
+//
+//	{"default": {"max_configs": 1000, "max_bytes": 10485760},
+//	 "namespaces": {"team-a": {"max_configs": 5000}}}
+func LoadQuotas(path string) (*Quotas, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	quotas := &Quotas{}
+	err = json.Unmarshal(data, quotas)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	check := func(name string, limits config.QuotaLimits) error {
+		if limits.MaxConfigs < 0 || limits.MaxVersionsPerConfig < 0 || limits.MaxGroups < 0 ||
+			limits.MaxEntriesPerConfig < 0 || limits.MaxBytes < 0 {
+			return fmt.Errorf("%s: limits of %s must not be negative", path, name)
+		}
+		return nil
+	}
+	if err := check("default", quotas.Default); err != nil {
+		return nil, err
+	}
+	for ns, limits := range quotas.Namespaces {
+		if err := namespace.Validate(ns); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if err := check(ns, limits); err != nil {
+			return nil, err
+		}
+	}
+	return quotas, nil
+}
+
+// Limits returns the limits of a namespace, none if q is nil.
+func (q *Quotas) Limits(ns string) config.QuotaLimits {
+	if q == nil {
+		return config.QuotaLimits{}
+	}
+	if limits, ok := q.Namespaces[ns]; ok {
+		return limits
+	}
+	return q.Default
+}
+
+// The resources reported in QuotaError and the quota metrics.
+const (
+	quotaConfigs           = "configs"
+	quotaVersionsPerConfig = "versions_per_config"
+	quotaGroups            = "groups"
+	quotaEntriesPerConfig  = "entries_per_config"
+	quotaBytes             = "bytes"
+)
+
+// QuotaError is returned when a write would take a namespace over one of its
+// limits.
+type QuotaError struct {
+	Namespace string `json:"namespace"`
+	Resource  string `json:"resource"`
+	Limit     int64  `json:"limit"`
+	// the usage the write would have reached
+	Requested int64 `json:"requested"`
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("quota exceeded in namespace %s: %s would be %d, the limit is %d", e.Namespace, e.Resource, e.Requested, e.Limit)
+}
+
+// status is 507 Insufficient Storage when the namespace is out of bytes and
+// 429 Too Many Requests when it has too many of something.
+func (e *QuotaError) status() int {
+	if e.Resource == quotaBytes {
+		return http.StatusInsufficientStorage
+	}
+	return http.StatusTooManyRequests
+}
+
+func writeQuotaError(w http.ResponseWriter, err *QuotaError) {
+	body, _ := json.Marshal(struct {
+		Error string `json:"error"`
+		*QuotaError
+	}{err.Error(), err})
+	writeJSON(w, err.status(), body)
+}
+
+// quotaChange is what a write adds to or removes from the usage of a
+// namespace.
+type quotaChange struct {
+	configVersions map[string]int
+	groupVersions  map[string]int
+	bytes          int64
+	// the most entries of an added configuration
+	entries int
+}
+
+func newQuotaChange() *quotaChange {
+	return &quotaChange{configVersions: make(map[string]int), groupVersions: make(map[string]int)}
+}
+
+// config adds (n = 1) or removes (n = -1) a configuration version.
+func (c *quotaChange) config(cfg *config.Config, n int) *quotaChange {
+	c.configVersions[cfg.ID] += n
+	c.members([]*config.Config{cfg}, n)
+	return c
+}
+
+// groups adds or removes the group versions the members belong to.
+func (c *quotaChange) groups(members []*config.Config, n int) *quotaChange {
+	seen := make(map[string]bool)
+	for _, m := range members {
+		if key := m.GroupID + "/" + m.Version; !seen[key] {
+			seen[key] = true
+			c.groupVersions[m.GroupID] += n
+		}
+	}
+	return c.members(members, n)
+}
+
+// members adds or removes the size of configurations without counting them
+// as versions, e.g. when a group version is extended.
+func (c *quotaChange) members(members []*config.Config, n int) *quotaChange {
+	for _, m := range members {
+		c.bytes += int64(n) * config.ConfigSize(m)
+		if n > 0 && len(m.Entries) > c.entries {
+			c.entries = len(m.Entries)
+		}
+	}
+	return c
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// check checks the limits against the usage before and after the change.
+// Only what the change makes grow is checked, so a namespace over a lowered
+// limit can still shrink.
+func (c *quotaChange) check(ns string, limits config.QuotaLimits, before, after *config.QuotaUsage, versions map[string]int) error {
+	exceeded := func(resource string, limit, before, after int64) error {
+		if limit > 0 && after > before && after > limit {
+			return &QuotaError{Namespace: ns, Resource: resource, Limit: limit, Requested: after}
+		}
+		return nil
+	}
+
+	if err := exceeded(quotaEntriesPerConfig, int64(limits.MaxEntriesPerConfig), 0, int64(c.entries)); err != nil {
+		return err
+	}
+	if err := exceeded(quotaConfigs, int64(limits.MaxConfigs), int64(before.Configs), int64(after.Configs)); err != nil {
+		return err
+	}
+	for _, id := range sortedKeys(c.configVersions) {
+		n := int64(versions[id])
+		if err := exceeded(quotaVersionsPerConfig, int64(limits.MaxVersionsPerConfig), n-int64(c.configVersions[id]), n); err != nil {
+			return err
+		}
+	}
+	if err := exceeded(quotaGroups, int64(limits.MaxGroups), int64(before.Groups), int64(after.Groups)); err != nil {
+		return err
+	}
+	return exceeded(quotaBytes, limits.MaxBytes, before.Bytes, after.Bytes)
+}
+
+// quota returns the change for the store to apply in the transaction of the
+// write, which then fails with a QuotaError if the change exceeds a limit of
+// the namespace of ctx. Checking and applying the change together keeps
+// concurrent writes from overshooting a limit.
+func (s *Service) quota(ctx context.Context, change *quotaChange) *poststore.QuotaChange {
+	ns := namespace.FromContext(ctx)
+	limits := s.Quotas.Limits(ns)
+	return &poststore.QuotaChange{
+		ConfigVersions: change.configVersions,
+		GroupVersions:  change.groupVersions,
+		Bytes:          change.bytes,
+		Check: func(before, after *config.QuotaUsage, versions map[string]int) error {
+			return change.check(ns, limits, before, after, versions)
+		},
+	}
+}
+
+// quotaFailed writes the response of a write rejected by its quota, or of
+// one that kept losing the race for the usage of the namespace, and returns
+// true. It returns false for every other error.
+func (s *Service) quotaFailed(w http.ResponseWriter, r *http.Request, err error) bool {
+	var exceeded *QuotaError
+	if errors.As(err, &exceeded) {
+		metrics.QuotaRejections.WithLabelValues(metrics.Namespace(exceeded.Namespace), exceeded.Resource).Inc()
+		writeQuotaError(w, exceeded)
+		return true
+	}
+	if errors.Is(err, poststore.ErrConflict) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return true
+	}
+	return false
+}
+
+// reportQuota updates the quota gauges of the namespace of ctx after a write
+// applied change.
+func (s *Service) reportQuota(ctx context.Context, change *poststore.QuotaChange) {
+	if change.Usage == nil {
+		return
+	}
+	ns := namespace.FromContext(ctx)
+	reportQuota(ns, s.Quotas.Limits(ns), change.Usage)
+}
+
+// reportQuota updates the quota gauges of a namespace. The versions per
+// configuration aren't part of the totals and are only reported by GetQuota.
+// Namespaces without their own metric label have no gauges, the usage of one
+// would overwrite the other's.
+func reportQuota(ns string, limits config.QuotaLimits, usage *config.QuotaUsage) {
+	if metrics.Namespace(ns) == metrics.OtherNamespace {
+		return
+	}
+	metrics.QuotaUsage.WithLabelValues(ns, quotaConfigs).Set(float64(usage.Configs))
+	metrics.QuotaUsage.WithLabelValues(ns, quotaGroups).Set(float64(usage.Groups))
+	metrics.QuotaUsage.WithLabelValues(ns, quotaBytes).Set(float64(usage.Bytes))
+
+	metrics.QuotaLimit.WithLabelValues(ns, quotaConfigs).Set(float64(limits.MaxConfigs))
+	metrics.QuotaLimit.WithLabelValues(ns, quotaVersionsPerConfig).Set(float64(limits.MaxVersionsPerConfig))
+	metrics.QuotaLimit.WithLabelValues(ns, quotaGroups).Set(float64(limits.MaxGroups))
+	metrics.QuotaLimit.WithLabelValues(ns, quotaEntriesPerConfig).Set(float64(limits.MaxEntriesPerConfig))
+	metrics.QuotaLimit.WithLabelValues(ns, quotaBytes).Set(float64(limits.MaxBytes))
+}
+
+// swagger:route GET /quota quota getQuota
+//
+// Returns the limits of the namespace and how much of them it uses. A zero
+// limit is unlimited.
+//
+// Responses:
+//
+//	200: quotaResponse
+//	500: internalServerErrorResponse
+func (s *Service) GetQuota(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	span := tracer.StartSpanFromContext(ctx, "Get")
+	defer span.Finish()
+
+	ns := namespace.FromContext(ctx)
+	counts, err := s.PostStore.GetQuotaUsage(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		tracer.LogError(span, err)
+		return
+	}
+	limits := s.Quotas.Limits(ns)
+	reportQuota(ns, limits, &config.QuotaUsage{Configs: counts.Configs, Groups: counts.Groups, Bytes: counts.Bytes})
+	if metrics.Namespace(ns) != metrics.OtherNamespace {
+		metrics.QuotaUsage.WithLabelValues(ns, quotaVersionsPerConfig).Set(float64(counts.MaxVersionsPerConfig))
+	}
+
+	err = encodeJSON(w, &config.Quota{Namespace: ns, Limits: limits, Usage: *counts})
+	if err != nil {
+		tracer.LogError(span, err)
+	}
+}
@@ -0,0 +1,316 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/auth"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/metrics"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/namespace"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/poststore"
+	tracer "github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/tracer"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+const maxSubjectLength = 255
+
+func (v *validator) roleBinding(binding *config.RoleBinding) {
+	v.id("id", binding.ID, false)
+
+	subject := binding.Subject
+	switch {
+	case subject == "":
+		v.add("subject", subject, "is required")
+	case len(subject) > maxSubjectLength:
+		v.add("subject", subject, "must be at most %d characters", maxSubjectLength)
+	case subject != "anonymous" &&
+		!strings.HasPrefix(subject, auth.MethodAPIKey+":") && !strings.HasPrefix(subject, auth.MethodJWT+":"):
+		v.add("subject", subject, `must be "anonymous", "api_key:<subject>" or "jwt:<subject>"`)
+	}
+
+	if _, ok := auth.Roles[binding.Role]; !ok {
+		v.add("role", binding.Role, "must be reader, writer or admin")
+	}
+
+	if binding.Namespace != "" && binding.Namespace != auth.AllNamespaces {
+		if err := namespace.Validate(binding.Namespace); err != nil {
+			v.add("namespace", binding.Namespace, "%s", err.Error())
+		}
+	}
+	v.id("scope.id_prefix", binding.Scope.IDPrefix, false)
+	v.id("scope.group", binding.Scope.Group, false)
+	if binding.Scope.Labels != "" {
+		selector, err := config.ParseSelector(binding.Scope.Labels)
+		if err != nil {
+			v.add("scope.labels", binding.Scope.Labels, "%s", err.Error())
+		}
+		v.selector("scope.labels", selector)
+	}
+}
+
+// authorizeResult checks a configuration a handler is about to store, when
+// its labels can differ from the ones checked before the handler ran. It
+// writes the 403 itself and returns false if the caller may not store it.
+func (s *Service) authorizeResult(w http.ResponseWriter, r *http.Request, c *config.Config) bool {
+	if s.Authorizer == nil {
+		return true
+	}
+	err := s.Authorizer.AuthorizeTargets(r, auth.PermConfigurationsWrite,
+		auth.Target{ID: c.ID, Group: c.GroupID, Labels: c.Labels})
+	var forbidden *auth.ForbiddenError
+	if errors.As(err, &forbidden) {
+		auth.WriteForbidden(w, forbidden)
+		return false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// global reports whether the caller of r has permission in every namespace.
+// Without an Authorizer nothing is checked and every caller has.
+func (s *Service) global(r *http.Request, permission string) (bool, error) {
+	if s.Authorizer == nil {
+		return true, nil
+	}
+	return s.Authorizer.Global(r, permission)
+}
+
+// requireGlobal answers 403 and returns false unless the caller of r has
+// permission in every namespace.
+func (s *Service) requireGlobal(w http.ResponseWriter, r *http.Request, permission string) bool {
+	global, err := s.global(r, permission)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if !global {
+		auth.WriteForbidden(w, &auth.ForbiddenError{Subject: auth.Subject(r), Permission: permission, Namespace: auth.AllNamespaces})
+		return false
+	}
+	return true
+}
+
+// visibleBinding reports whether a caller limited to the namespace of r may
+// see and revoke binding.
+func visibleBinding(r *http.Request, binding *config.RoleBinding) bool {
+	return binding.Namespace == namespace.FromRequest(r)
+}
+
+// AddMetricNamespaces gives the namespaces with quotas or role bindings their
+// own metric labels, the others share metrics.OtherNamespace. Role bindings
+// created later are added by CreateRoleBinding.
+func (s *Service) AddMetricNamespaces(ctx context.Context) error {
+	if s.Quotas != nil {
+		for ns := range s.Quotas.Namespaces {
+			metrics.AddNamespace(ns)
+		}
+	}
+
+	bindings, err := s.PostStore.ListRoleBindings(ctx)
+	if err != nil {
+		return err
+	}
+	for _, binding := range bindings {
+		if binding.Namespace != "" {
+			metrics.AddNamespace(binding.Namespace)
+		}
+	}
+	return nil
+}
+
+// swagger:route GET /admin/roles rbac listRoles
+//
+// Returns the roles and the permissions they grant.
+//
+// Responses:
+//
+//	200: roleListResponse
+func (s *Service) ListRoles(w http.ResponseWriter, r *http.Request) {
+	encodeJSON(w, auth.RolePermissions())
+}
+
+// swagger:route POST /admin/role-bindings rbac createRoleBinding
+//
+// Grants a role to a subject, limited to the configurations matching the
+// scope if one is given. The binding applies in the namespace of the request
+// unless it names another one, which only callers with rbac:manage in every
+// namespace may do.
+//
+// Responses:
+//
+//	201: roleBindingResponse
+//	400: badRequestResponse
+//	403: forbiddenResponse
+//	409: conflictResponse
+//	500: internalServerErrorResponse
+func (s *Service) CreateRoleBinding(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	span := tracer.StartSpanFromContext(ctx, "Post")
+	defer span.Finish()
+
+	binding := &config.RoleBinding{}
+	body, err := readBody(w, r)
+	if err == nil {
+		err = json.Unmarshal(body, binding)
+	}
+	if err != nil {
+		bodyError(w, err)
+		return
+	}
+
+	v := &validator{}
+	v.roleBinding(binding)
+	if !v.valid(w) {
+		return
+	}
+
+	ns := namespace.FromRequest(r)
+	if binding.Namespace == "" {
+		binding.Namespace = ns
+	}
+	if binding.Namespace != ns && !s.requireGlobal(w, r, auth.PermRBACManage) {
+		return
+	}
+	if binding.Namespace == auth.AllNamespaces {
+		binding.Namespace = ""
+	} else {
+		metrics.AddNamespace(binding.Namespace)
+	}
+
+	if binding.ID == "" {
+		binding.ID = uuid.New().String()
+	}
+	binding.CreatedAt = time.Now().UTC()
+
+	err = s.PostStore.AddRoleBinding(ctx, binding)
+	if errors.Is(err, poststore.ErrVersionExists) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		tracer.LogError(span, err)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		tracer.LogError(span, err)
+		return
+	}
+
+	response, err := json.Marshal(binding)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		tracer.LogError(span, err)
+		return
+	}
+	s.audit(w, r, &config.AuditRecord{
+		Action:     config.RoleBindingCreated,
+		TargetKind: config.TargetRoleBinding,
+		TargetID:   binding.ID,
+		AfterHash:  contentHash(binding),
+		Details:    map[string]string{"subject": binding.Subject, "role": binding.Role},
+	})
+	writeJSON(w, http.StatusCreated, response)
+}
+
+// swagger:route GET /admin/role-bindings rbac listRoleBindings
+//
+// Returns the role bindings of the namespace of the request, oldest first.
+// Callers with rbac:manage in every namespace get the bindings of all
+// namespaces.
+//
+// Responses:
+//
+//	200: roleBindingListResponse
+//	500: internalServerErrorResponse
+func (s *Service) ListRoleBindings(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	span := tracer.StartSpanFromContext(ctx, "GetAll")
+	defer span.Finish()
+
+	bindings, err := s.PostStore.ListRoleBindings(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		tracer.LogError(span, err)
+		return
+	}
+	global, err := s.global(r, auth.PermRBACManage)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		tracer.LogError(span, err)
+		return
+	}
+	if !global {
+		visible := make([]*config.RoleBinding, 0, len(bindings))
+		for _, binding := range bindings {
+			if visibleBinding(r, binding) {
+				visible = append(visible, binding)
+			}
+		}
+		bindings = visible
+	}
+
+	err = encodeJSON(w, bindings)
+	if err != nil {
+		tracer.LogError(span, err)
+	}
+}
+
+// swagger:route DELETE /admin/role-bindings/{id} rbac deleteRoleBinding
+//
+// Revokes the role binding with the given ID. Callers without rbac:manage in
+// every namespace can only revoke the bindings of the namespace of the request.
+//
+// Responses:
+//
+//	204: noContentResponse
+//	400: badRequestResponse
+//	404: notFoundResponse
+//	500: internalServerErrorResponse
+func (s *Service) DeleteRoleBinding(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	span := tracer.StartSpanFromContext(ctx, "Delete")
+	defer span.Finish()
+
+	id := mux.Vars(r)["id"]
+	v := &validator{}
+	v.id("id", id, true)
+	if !v.valid(w) {
+		return
+	}
+
+	binding, err := s.PostStore.GetRoleBinding(ctx, id)
+	if err == nil && !visibleBinding(r, binding) {
+		var global bool
+		global, err = s.global(r, auth.PermRBACManage)
+		if err == nil && !global {
+			err = poststore.ErrNotFound
+		}
+	}
+	if err == nil {
+		err = s.PostStore.DeleteRoleBinding(ctx, id)
+	}
+	if errors.Is(err, poststore.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		tracer.LogError(span, err)
+		return
+	}
+	s.audit(w, r, &config.AuditRecord{
+		Action:     config.RoleBindingDeleted,
+		TargetKind: config.TargetRoleBinding,
+		TargetID:   id,
+		BeforeHash: contentHash(binding),
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
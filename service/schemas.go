@@ -0,0 +1,348 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/namespace"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/poststore"
+	tracer "github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/tracer"
+	"github.com/gorilla/mux"
+)
+
+func (v *validator) schema(schema *config.Schema) {
+	v.id("id", schema.ID, true)
+	v.version("version", schema.Version, true)
+	if isReservedVersion(schema.Version) {
+		v.add("version", schema.Version, "%q is reserved", schema.Version)
+	}
+
+	if schema.ConfigName == "" && schema.Selector == "" {
+		v.add("config_name", "", "config_name or selector is required")
+	}
+	if len(schema.ConfigName) > maxNameLength {
+		v.add("config_name", schema.ConfigName, "must be at most %d characters", maxNameLength)
+	}
+	if schema.Selector != "" {
+		selector, err := config.ParseSelector(schema.Selector)
+		if err != nil {
+			v.add("selector", schema.Selector, "%s", err.Error())
+		}
+		v.selector("selector", selector)
+	}
+
+	if len(schema.Schema) == 0 {
+		v.add("schema", "", "is required")
+	} else if _, err := config.CompileJSONSchema(schema.Schema); err != nil {
+		v.add("schema", "", "%s", err.Error())
+	}
+}
+
+// SchemaViolation is an entry that doesn't match a schema, its pointer
+// points into the request body.
+type SchemaViolation struct {
+	config.SchemaViolation
+	SchemaID      string `json:"schema_id"`
+	SchemaVersion string `json:"schema_version"`
+}
+
+// violationsResponse is the body of a 422 response to configurations whose
+// entries don't match their schemas.
+type violationsResponse struct {
+	Error      string            `json:"error"`
+	Violations []SchemaViolation `json:"violations"`
+}
+
+// checkEntries validates the entries of configs against the schemas that
+// apply to them. pointer returns the JSON Pointer of the i-th configuration
+// in the request body. It answers with 422 and every violation, and returns
+// false, if there are any.
+func (s *Service) checkEntries(w http.ResponseWriter, r *http.Request, pointer func(i int) string, configs ...*config.Config) bool {
+	schemas, err := s.PostStore.ListSchemas(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+
+	violations := make([]SchemaViolation, 0)
+	for _, schema := range schemas {
+		var compiled *config.JSONSchema
+		for i, c := range configs {
+			if !schema.Applies(c) {
+				continue
+			}
+			if compiled == nil {
+				compiled, err = config.CompileJSONSchema(schema.Schema)
+				if err != nil {
+					err = fmt.Errorf("schema %s version %s: %w", schema.ID, schema.Version, err)
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return false
+				}
+			}
+			for _, violation := range compiled.Validate(c.EntriesDocument()) {
+				violation.Pointer = pointer(i) + "/entries" + violation.Pointer
+				violations = append(violations, SchemaViolation{SchemaViolation: violation, SchemaID: schema.ID, SchemaVersion: schema.Version})
+			}
+		}
+	}
+	if len(violations) == 0 {
+		return true
+	}
+
+	body, _ := json.Marshal(violationsResponse{Error: "entries don't match their schemas", Violations: violations})
+	writeJSON(w, http.StatusUnprocessableEntity, body)
+	return false
+}
+
+// bodyPointer is the pointer of a configuration sent on its own.
+func bodyPointer(int) string {
+	return ""
+}
+
+// arrayPointer is the pointer of a configuration in an array.
+func arrayPointer(i int) string {
+	return fmt.Sprintf("/%d", i)
+}
+
+// swagger:route POST /schemas schemas createSchema
+//
+// Registers a new version of a JSON Schema for the entries of the
+// configurations with the given name or matching the selector. The highest
+// version of every schema is applied when configurations are added.
+//
+// Responses:
+//
+//	201: schemaResponse
+//	400: badRequestResponse
+//	409: conflictResponse
+//	500: internalServerErrorResponse
+func (s *Service) CreateSchema(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	span := tracer.StartSpanFromContext(ctx, "Post")
+	defer span.Finish()
+
+	schema := &config.Schema{}
+	body, err := readBody(w, r)
+	if err == nil {
+		err = json.Unmarshal(body, schema)
+	}
+	if err != nil {
+		bodyError(w, err)
+		return
+	}
+
+	v := &validator{}
+	v.schema(schema)
+	if !v.valid(w) {
+		return
+	}
+	schema.CreatedAt = time.Now().UTC()
+
+	err = s.PostStore.AddSchema(ctx, schema)
+	if errors.Is(err, poststore.ErrVersionExists) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		tracer.LogError(span, err)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		tracer.LogError(span, err)
+		return
+	}
+
+	response, err := json.Marshal(schema)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		tracer.LogError(span, err)
+		return
+	}
+	s.audit(w, r, &config.AuditRecord{
+		Action:        config.SchemaCreated,
+		TargetKind:    config.TargetSchema,
+		TargetID:      schema.ID,
+		TargetVersion: schema.Version,
+		AfterHash:     contentHash(schema),
+	})
+	w.Header().Set("Location", namespace.Path(ctx, "/schemas/"+schema.ID+"/"+schema.Version))
+	writeJSON(w, http.StatusCreated, response)
+}
+
+// swagger:route GET /schemas schemas listSchemas
+//
+// Returns the highest version of every schema of the namespace, the ones
+// configurations are validated against.
+//
+// Responses:
+//
+//	200: schemaListResponse
+//	500: internalServerErrorResponse
+func (s *Service) ListSchemas(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	span := tracer.StartSpanFromContext(ctx, "GetAll")
+	defer span.Finish()
+
+	schemas, err := s.PostStore.ListSchemas(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		tracer.LogError(span, err)
+		return
+	}
+
+	err = encodeJSON(w, schemas)
+	if err != nil {
+		tracer.LogError(span, err)
+	}
+}
+
+// swagger:route GET /schemas/{id} schemas listSchemaVersions
+//
+// Returns all versions of the schema with the given ID, from the lowest to
+// the highest version.
+//
+// Responses:
+//
+//	200: schemaListResponse
+//	400: badRequestResponse
+//	404: notFoundResponse
+//	500: internalServerErrorResponse
+func (s *Service) ListSchemaVersions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	span := tracer.StartSpanFromContext(ctx, "GetAll")
+	defer span.Finish()
+
+	id := mux.Vars(r)["id"]
+	v := &validator{}
+	v.id("id", id, true)
+	if !v.valid(w) {
+		return
+	}
+
+	schemas, err := s.PostStore.ListSchemaVersions(ctx, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		tracer.LogError(span, err)
+		return
+	}
+	if len(schemas) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	err = encodeJSON(w, schemas)
+	if err != nil {
+		tracer.LogError(span, err)
+	}
+}
+
+// swagger:route GET /schemas/{id}/{version} schemas getSchema
+//
+// Returns a version of the schema with the given ID, "latest" for the
+// highest version.
+//
+// Responses:
+//
+//	200: schemaResponse
+//	400: badRequestResponse
+//	404: notFoundResponse
+//	500: internalServerErrorResponse
+func (s *Service) GetSchema(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	span := tracer.StartSpanFromContext(ctx, "Get")
+	defer span.Finish()
+
+	vars := mux.Vars(r)
+	id := vars["id"]
+	version := vars["version"]
+
+	v := &validator{}
+	v.id("id", id, true)
+	v.version("version", version, true)
+	if !v.valid(w) {
+		return
+	}
+
+	var schema *config.Schema
+	var err error
+	if version == config.LatestVersion {
+		var versions []*config.Schema
+		versions, err = s.PostStore.ListSchemaVersions(ctx, id)
+		if err == nil && len(versions) == 0 {
+			err = poststore.ErrNotFound
+		}
+		if err == nil {
+			schema = versions[len(versions)-1]
+		}
+	} else {
+		schema, err = s.PostStore.GetSchema(ctx, id, version)
+	}
+	if errors.Is(err, poststore.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		tracer.LogError(span, err)
+		return
+	}
+
+	err = encodeJSON(w, schema)
+	if err != nil {
+		tracer.LogError(span, err)
+	}
+}
+
+// swagger:route DELETE /schemas/{id}/{version} schemas deleteSchema
+//
+// Deletes a version of the schema with the given ID. The previous version, if
+// any, applies again.
+//
+// Responses:
+//
+//	204: noContentResponse
+//	400: badRequestResponse
+//	404: notFoundResponse
+//	500: internalServerErrorResponse
+func (s *Service) DeleteSchema(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	span := tracer.StartSpanFromContext(ctx, "Delete")
+	defer span.Finish()
+
+	vars := mux.Vars(r)
+	id := vars["id"]
+	version := vars["version"]
+
+	v := &validator{}
+	v.id("id", id, true)
+	v.version("version", version, true)
+	if !v.valid(w) {
+		return
+	}
+
+	before, _ := s.PostStore.GetSchema(ctx, id, version)
+	err := s.PostStore.DeleteSchema(ctx, id, version)
+	if errors.Is(err, poststore.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		tracer.LogError(span, err)
+		return
+	}
+	record := &config.AuditRecord{
+		Action:        config.SchemaDeleted,
+		TargetKind:    config.TargetSchema,
+		TargetID:      id,
+		TargetVersion: version,
+	}
+	if before != nil {
+		record.BeforeHash = contentHash(before)
+	}
+	s.audit(w, r, record)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -2,6 +2,9 @@ package service
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/auth"
 	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
 	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/poststore"
 	tracer "github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/tracer"
@@ -12,18 +15,44 @@ import (
 
 type Service struct {
 	Configurations []*config.Config `json:"configurations"`
-	PostStore      *poststore.PostStore
+	PostStore      poststore.Store
+	// AdminToken allows ?force=true overwrites of existing versions when sent
+	// in the X-Admin-Token header, but only without an Authorizer: it is the
+	// legacy fallback for services run without role bindings. Empty disables
+	// forced overwrites there.
+	AdminToken string
+	// Events feeds the /events streams. Nil disables them.
+	Events *EventBroker
+	// Webhooks delivers the change events to subscribers. Nil disables them.
+	Webhooks *WebhookDispatcher
+	// AuditCheckpoints signs the audit chain. Nil disables checkpoints and
+	// /audit/verify doesn't check signatures.
+	AuditCheckpoints *AuditCheckpointer
+	// Authorizer checks the role bindings where a handler changes what the
+	// route checked, e.g. the labels of a patched configuration. Nil skips
+	// those checks.
+	Authorizer *auth.Authorizer
+	// Quotas limits what each namespace stores. Nil tracks the usage without
+	// limiting it.
+	Quotas *Quotas
 }
 
 // swagger:route POST /configurations configurations addConfiguration
 //
-// Adds a new configuration to the list of configurations.
+// Adds a new configuration to the list of configurations. Versions are
+// write-once, adding an existing version fails unless an admin sends
+// ?force=true. Retries with the same Idempotency-Key replay the first response.
 //
 // Responses:
 //
 //	200: configResponse
 //	400: badRequestResponse
+//	403: forbiddenResponse
+//	409: conflictResponse
+//	422: unprocessableEntityResponse
+//	429: quotaExceededResponse
 //	500: internalServerErrorResponse
+//	507: quotaExceededResponse
 
 func (s *Service) AddConfiguration(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
@@ -31,9 +60,14 @@ func (s *Service) AddConfiguration(w http.ResponseWriter, r *http.Request) {
 	defer span.Finish()
 
 	var config config.Config
-	err := json.NewDecoder(r.Body).Decode(&config)
+	body, err := readBody(w, r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		bodyError(w, err)
+		return
+	}
+	err = json.Unmarshal(body, &config)
+	if err != nil {
+		bodyError(w, err)
 		return
 	}
 
@@ -43,15 +77,23 @@ func (s *Service) AddConfiguration(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	exists, err := s.PostStore.CheckIdempotencyKey(ctx, idempotencyKey)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		tracer.LogError(span, err)
+	v := &validator{}
+	v.config("", &config, false)
+	v.idempotencyKey(idempotencyKey)
+	if !v.valid(w) {
 		return
 	}
 
-	if exists {
-		w.WriteHeader(http.StatusCreated)
+	hash := requestHash(r, body)
+	if s.replayIdempotent(w, r, span, scopeConfigurations, idempotencyKey, hash) {
+		return
+	}
+
+	force, ok := s.forceRequested(w, r, auth.Target{ID: config.ID, Group: config.GroupID, Labels: config.Labels})
+	if !ok {
+		return
+	}
+	if !s.checkEntries(w, r, bodyPointer, &config) {
 		return
 	}
 
@@ -60,22 +102,100 @@ func (s *Service) AddConfiguration(w http.ResponseWriter, r *http.Request) {
 	}
 	config.IdempotencyKey = idempotencyKey
 
-	err = s.PostStore.AddConfiguration(ctx, &config)
+	before := s.overwrittenConfiguration(ctx, force, config.ID, config.Version)
+	change := newQuotaChange().config(&config, 1)
+	if before != nil {
+		change.config(before, -1)
+	}
+	// like for groups the idempotency record is committed in the same
+	// transaction as the configuration
+	response, err := json.Marshal(config)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		tracer.LogError(span, err)
+		return
+	}
+	idempotency := &poststore.IdempotencyEntry{
+		Scope:  scopeConfigurations,
+		Key:    idempotencyKey,
+		Record: newIdempotencyRecord(hash, http.StatusOK, response),
+	}
+
+	quota := s.quota(ctx, change)
+	if force {
+		err = s.PostStore.OverwriteConfiguration(ctx, &config, idempotency, quota)
+	} else {
+		err = s.PostStore.AddConfiguration(ctx, &config, idempotency, quota)
+	}
+	if s.replayConcurrent(w, r, span, scopeConfigurations, idempotencyKey, hash, err) {
+		return
+	}
+	if s.quotaFailed(w, r, err) {
+		tracer.LogError(span, err)
+		return
+	}
+	if errors.Is(err, poststore.ErrVersionExists) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		tracer.LogError(span, err)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		tracer.LogError(span, err)
 		return
 	}
+	s.reportQuota(ctx, quota)
+	s.publishEvent(ctx, configurationCreatedEvent(&config))
+	s.audit(w, r, configurationCreatedAudit(before, &config, force))
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// swagger:route GET /configurations/{id} configurations listConfigurationVersions
+//
+// Returns all stored versions of the configuration with the given ID, from the
+// lowest to the highest version.
+//
+// Responses:
+//
+//	200: versionListResponse
+//	304: notModifiedResponse
+//	404: notFoundResponse
+//	500: internalServerErrorResponse
+func (s *Service) ListConfigurationVersions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	span := tracer.StartSpanFromContext(ctx, "GetAll")
+	defer span.Finish()
+
+	id := mux.Vars(r)["id"]
 
-	err = s.PostStore.SaveIdempotencyKey(ctx, idempotencyKey)
+	v := &validator{}
+	v.id("id", id, true)
+	if !v.valid(w) {
+		return
+	}
+
+	versions, err := s.PostStore.ListConfigurationVersions(ctx, id)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		tracer.LogError(span, err)
 		return
 	}
+	if len(versions) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	indexes := make([]uint64, 0, len(versions))
+	for _, version := range versions {
+		indexes = append(indexes, version.ModifyIndex)
+	}
+	if notModified(w, r, listETag(indexes)) {
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(config)
+	err = json.NewEncoder(w).Encode(versions)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		tracer.LogError(span, err)
@@ -85,11 +205,14 @@ func (s *Service) AddConfiguration(w http.ResponseWriter, r *http.Request) {
 
 // swagger:route GET /configurations/{id}/{version} configurations getConfiguration
 //
-// Returns the configuration with the given ID and version.
+// Returns the configuration with the given ID and version. The version
+// "latest" resolves to the highest stored version. The ETag header can be sent
+// back in If-None-Match to get 304 Not Modified while nothing changed.
 //
 // Responses:
 //
 //	200: configResponse
+//	304: notModifiedResponse
 //	404: notFoundResponse
 //	500: internalServerErrorResponse
 func (s *Service) GetConfiguration(w http.ResponseWriter, r *http.Request) {
@@ -101,6 +224,27 @@ func (s *Service) GetConfiguration(w http.ResponseWriter, r *http.Request) {
 	id := vars["id"]
 	version := vars["version"]
 
+	v := &validator{}
+	v.id("id", id, true)
+	v.version("version", version, true)
+	if !v.valid(w) {
+		return
+	}
+
+	if version == config.LatestVersion {
+		versions, err := s.PostStore.ListConfigurationVersions(ctx, id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			tracer.LogError(span, err)
+			return
+		}
+		if len(versions) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		version = versions[len(versions)-1].Version
+	}
+
 	config, err := s.PostStore.GetConfiguration(ctx, id, version)
 	if err != nil {
 		http.NotFound(w, r)
@@ -108,6 +252,10 @@ func (s *Service) GetConfiguration(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if notModified(w, r, etag(config.ModifyIndex)) {
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	err = json.NewEncoder(w).Encode(config)
 	if err != nil {
@@ -119,12 +267,15 @@ func (s *Service) GetConfiguration(w http.ResponseWriter, r *http.Request) {
 
 // swagger:route DELETE /configurations/{id}/{version} configurations deleteConfiguration
 //
-// Deletes the configuration with the given ID and version.
+// Deletes the configuration with the given ID and version. With If-Match the
+// delete only happens if the configuration still has that ETag.
 //
 // Responses:
 //
 //	204: noContentResponse
 //	404: notFoundResponse
+//	412: preconditionFailedResponse
+//	500: internalServerErrorResponse
 func (s *Service) DeleteConfiguration(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	span := tracer.StartSpanFromContext(ctx, "Delete")
@@ -133,34 +284,89 @@ func (s *Service) DeleteConfiguration(w http.ResponseWriter, r *http.Request) {
 	id := vars["id"]
 	version := vars["version"]
 
-	err := s.PostStore.DeleteConfiguration(ctx, id, version)
+	v := &validator{}
+	v.id("id", id, true)
+	v.version("version", version, true)
+	if !v.valid(w) {
+		return
+	}
+
+	index, err := ifMatchIndex(r)
 	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Without If-Match the delete is conditioned on the version that was
+	// read, so concurrent deletes publish and audit it only once.
+	var before *config.Config
+	var quota *poststore.QuotaChange
+	for {
+		before, err = s.PostStore.GetConfiguration(ctx, id, version)
+		if err != nil {
+			break
+		}
+		expected := index
+		if expected == 0 {
+			expected = before.ModifyIndex
+		}
+		quota = s.quota(ctx, newQuotaChange().config(before, -1))
+		err = s.PostStore.DeleteConfigurationCAS(ctx, id, version, expected, quota)
+		if index != 0 || !errors.Is(err, poststore.ErrPreconditionFailed) {
+			break
+		}
+	}
+	if errors.Is(err, poststore.ErrNotFound) {
 		http.NotFound(w, r)
+		return
+	}
+	if errors.Is(err, poststore.ErrPreconditionFailed) {
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
 		tracer.LogError(span, err)
 		return
 	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		tracer.LogError(span, err)
+		return
+	}
+	s.reportQuota(ctx, quota)
+	s.publishEvent(ctx, config.NewEvent(config.ConfigurationDeleted, "", version, &config.Config{ID: id}))
+	s.audit(w, r, configurationAudit(config.ConfigurationDeleted, id, version, before, nil))
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
 // swagger:route POST /configurations/ configurations addConfigurationGroup
 //
-// Adds a group of new configurations to the list of configurations.
+// Adds a group of new configurations to the list of configurations. A group
+// version that already exists is only replaced when an admin sends ?force=true.
+// Retries with the same Idempotency-Key replay the first response.
 //
 // Responses:
 //
 //	200: configGroupResponse
 //	400: badRequestResponse
+//	403: forbiddenResponse
+//	409: conflictResponse
+//	422: unprocessableEntityResponse
+//	429: quotaExceededResponse
 //	500: internalServerErrorResponse
+//	507: quotaExceededResponse
 func (s *Service) AddConfigurationGroup(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	span := tracer.StartSpanFromContext(ctx, "Post")
 	defer span.Finish()
 
 	var configs []*config.Config
-	err := json.NewDecoder(r.Body).Decode(&configs)
+	body, err := readBody(w, r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		bodyError(w, err)
+		return
+	}
+	err = json.Unmarshal(body, &configs)
+	if err != nil {
+		bodyError(w, err)
 		return
 	}
 
@@ -171,47 +377,119 @@ func (s *Service) AddConfigurationGroup(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	exists, err := s.PostStore.CheckIdempotencyKey(ctx, idempotencyKey)
+	v := &validator{}
+	v.configs(configs, true)
+	v.idempotencyKey(idempotencyKey)
+	if !v.valid(w) {
+		return
+	}
 
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		tracer.LogError(span, err)
+	hash := requestHash(r, body)
+	if s.replayIdempotent(w, r, span, scopeGroup, idempotencyKey, hash) {
 		return
 	}
 
-	if exists {
-		w.WriteHeader(http.StatusCreated)
+	targets := make([]auth.Target, 0, len(configs))
+	for _, c := range configs {
+		targets = append(targets, auth.Target{ID: c.ID, Group: c.GroupID, Labels: c.Labels})
+	}
+	force, ok := s.forceRequested(w, r, targets...)
+	if !ok {
+		return
+	}
+	if !s.checkEntries(w, r, arrayPointer, configs...) {
 		return
 	}
 
-	for _, config := range configs {
-		if config.ID == "" {
-			config.ID = uuid.New().String()
+	// a forced replacement deletes the existing versions in the transaction
+	// creating the new ones, so a failed create keeps them
+	checked := make(map[string]bool)
+	replaced := make(map[string][]*config.Config)
+	replace := make([]*config.Group, 0)
+	change := newQuotaChange()
+	for _, c := range configs {
+		groupKey := c.GroupID + "/" + c.Version
+		if checked[groupKey] {
+			continue
 		}
-		config.IdempotencyKey = idempotencyKey
+		checked[groupKey] = true
 
-		err = s.PostStore.AddConfigurationGroup(ctx, config)
+		manifest, members, err := s.PostStore.GetGroup(ctx, c.GroupID, c.Version)
+		if errors.Is(err, poststore.ErrNotFound) {
+			continue
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			tracer.LogError(span, err)
 			return
 		}
+
+		if !force {
+			err = fmt.Errorf("group %s version %s: %w", c.GroupID, c.Version, poststore.ErrVersionExists)
+			http.Error(w, err.Error(), http.StatusConflict)
+			tracer.LogError(span, err)
+			return
+		}
+
+		if members == nil {
+			members = make([]*config.Config, 0)
+		}
+		replaced[groupKey] = members
+		replace = append(replace, manifest)
+		change.groups(members, -1)
+		if len(members) == 0 {
+			// an empty version still counts as one
+			change.groupVersions[c.GroupID]--
+		}
+	}
+
+	for _, config := range configs {
+		if config.ID == "" {
+			config.ID = uuid.New().String()
+		}
+		config.IdempotencyKey = idempotencyKey
 	}
 
-	err = s.PostStore.SaveIdempotencyKey(ctx, idempotencyKey)
+	// the response is known before anything is written, so the idempotency
+	// record is committed in the same transaction as the group
+	response, err := json.Marshal(configs)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		tracer.LogError(span, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(configs)
+	idempotency := &poststore.IdempotencyEntry{
+		Scope:  scopeGroup,
+		Key:    idempotencyKey,
+		Record: newIdempotencyRecord(hash, http.StatusOK, response),
+	}
+	quota := s.quota(ctx, change.groups(configs, 1))
+	err = s.PostStore.AddConfigurationGroup(ctx, configs, replace, idempotency, quota)
+	if s.replayConcurrent(w, r, span, scopeGroup, idempotencyKey, hash, err) {
+		return
+	}
+	if s.quotaFailed(w, r, err) {
+		tracer.LogError(span, err)
+		return
+	}
+	if errors.Is(err, poststore.ErrVersionExists) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		tracer.LogError(span, err)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		tracer.LogError(span, err)
 		return
 	}
+	s.reportQuota(ctx, quota)
+	for _, event := range groupEvents(config.GroupCreated, configs) {
+		s.publishEvent(ctx, event)
+	}
+	s.auditGroups(w, r, config.GroupCreated, configs, replaced)
+
+	writeJSON(w, http.StatusOK, response)
 }
 
 // swagger:route GET /configurations/{id}/{version} configurations getConfigurationGroup
@@ -221,6 +499,7 @@ func (s *Service) AddConfigurationGroup(w http.ResponseWriter, r *http.Request)
 // Responses:
 //
 //	200: configGroupResponse
+//	304: notModifiedResponse
 //	404: notFoundResponse
 //	500: internalServerErrorResponse
 func (s *Service) GetConfigurationGroup(w http.ResponseWriter, r *http.Request) {
@@ -232,13 +511,28 @@ func (s *Service) GetConfigurationGroup(w http.ResponseWriter, r *http.Request)
 	id := vars["id"]
 	version := vars["version"]
 
-	configs, err := s.PostStore.GetConfigurationGroup(ctx, id, version)
+	v := &validator{}
+	v.id("id", id, true)
+	v.version("version", version, true)
+	if !v.valid(w) {
+		return
+	}
+
+	manifest, configs, err := s.PostStore.GetGroup(ctx, id, version)
+	if errors.Is(err, poststore.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		tracer.LogError(span, err)
 		return
 	}
 
+	if notModified(w, r, etag(manifest.ModifyIndex)) {
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	err = json.NewEncoder(w).Encode(configs)
 	if err != nil {
@@ -250,12 +544,15 @@ func (s *Service) GetConfigurationGroup(w http.ResponseWriter, r *http.Request)
 
 // swagger:route DELETE /configurations/{id}/{version} configurations deleteConfigurationGroup
 //
-// Deletes the group of configurations with the given ID and version.
+// Deletes the group of configurations with the given ID and version. With
+// If-Match the delete only happens if the group still has that ETag.
 //
 // Responses:
 //
 //	204: noContentResponse
 //	404: notFoundResponse
+//	412: preconditionFailedResponse
+//	500: internalServerErrorResponse
 func (s *Service) DeleteConfigurationGroup(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	span := tracer.StartSpanFromContext(ctx, "Delete")
@@ -265,12 +562,56 @@ func (s *Service) DeleteConfigurationGroup(w http.ResponseWriter, r *http.Reques
 	id := vars["id"]
 	version := vars["version"]
 
-	err := s.PostStore.DeleteConfigurationGroup(ctx, id, version)
+	v := &validator{}
+	v.id("id", id, true)
+	v.version("version", version, true)
+	if !v.valid(w) {
+		return
+	}
+
+	index, err := ifMatchIndex(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Like for single configurations, the delete is conditioned on the
+	// manifest that was read even without If-Match.
+	var before []*config.Config
+	var quota *poststore.QuotaChange
+	for {
+		var manifest *config.Group
+		manifest, before, err = s.PostStore.GetGroup(ctx, id, version)
+		if err != nil {
+			break
+		}
+		expected := index
+		if expected == 0 {
+			expected = manifest.ModifyIndex
+		}
+		quota = s.quota(ctx, newQuotaChange().groups(before, -1))
+		err = s.PostStore.DeleteConfigurationGroupCAS(ctx, id, version, expected, quota)
+		if index != 0 || !errors.Is(err, poststore.ErrPreconditionFailed) {
+			break
+		}
+	}
+	if errors.Is(err, poststore.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if errors.Is(err, poststore.ErrPreconditionFailed) {
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+		tracer.LogError(span, err)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		tracer.LogError(span, err)
 		return
 	}
+	s.reportQuota(ctx, quota)
+	s.publishEvent(ctx, config.NewEvent(config.GroupDeleted, id, version))
+	s.audit(w, r, groupAudit(config.GroupDeleted, id, version, before, nil))
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -283,13 +624,20 @@ func (s *Service) SwaggerHandler(w http.ResponseWriter, r *http.Request) {
 // Extends the group of configurations with the given ID and version by adding new configurations.
 //
 // This endpoint allows you to extend an existing configuration group by adding new configurations to it.
+// All new configurations are added in a single transaction. With If-Match the
+// group is only extended if it still has that ETag.
 //
 // Responses:
 //
 //	200: configGroupResponse  // Successfully extended configuration group.
 //	400: badRequestResponse   // Invalid request or payload.
 //	404: notFoundResponse     // Configuration group not found.
+//	409: conflictResponse     // A member with the same ID is already in the group.
+//	412: preconditionFailedResponse  // If-Match does not match the current ETag of the group.
+//	422: schemaViolationResponse  // The entries of a new configuration don't match a schema.
+//	429: quotaExceededResponse  // A count quota of the namespace would be exceeded.
 //	500: internalServerErrorResponse  // Internal server error occurred.
+//	507: quotaExceededResponse  // The namespace would exceed its bytes quota.
 func (s *Service) ExtendConfigurationGroup(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	span := tracer.StartSpanFromContext(ctx, "Post")
@@ -299,31 +647,84 @@ func (s *Service) ExtendConfigurationGroup(w http.ResponseWriter, r *http.Reques
 	groupID := vars["id"]
 	version := vars["version"]
 
-	group, err := s.PostStore.GetConfigurationGroup(ctx, groupID, version)
+	var newConfigs []*config.Config
+	body, err := readBody(w, r)
+	if err == nil {
+		err = json.Unmarshal(body, &newConfigs)
+	}
 	if err != nil {
-		http.NotFound(w, r)
+		bodyError(w, err)
 		tracer.LogError(span, err)
 		return
 	}
-	var newConfigs []*config.Config
-	err = json.NewDecoder(r.Body).Decode(&newConfigs)
+
+	v := &validator{}
+	v.id("id", groupID, true)
+	v.version("version", version, true)
+	for _, c := range newConfigs {
+		if c != nil {
+			c.GroupID = groupID
+			c.Version = version
+		}
+	}
+	v.configs(newConfigs, true)
+	if !v.valid(w) {
+		return
+	}
+
+	index, err := ifMatchIndex(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	group, err := s.PostStore.GetConfigurationGroup(ctx, groupID, version)
+	if err != nil {
+		http.NotFound(w, r)
 		tracer.LogError(span, err)
 		return
 	}
+	if len(group) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	if !s.checkEntries(w, r, arrayPointer, newConfigs...) {
+		return
+	}
 
 	for _, c := range newConfigs {
-		c.GroupID = groupID
-		c.Version = version
-		err := s.PostStore.AddConfiguration(ctx, c)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			tracer.LogError(span, err)
-			return
+		if c.ID == "" {
+			c.ID = uuid.New().String()
 		}
 	}
 
+	quota := s.quota(ctx, newQuotaChange().members(newConfigs, 1))
+	err = s.PostStore.ExtendConfigurationGroup(ctx, groupID, version, newConfigs, index, quota)
+	if s.quotaFailed(w, r, err) {
+		tracer.LogError(span, err)
+		return
+	}
+	if errors.Is(err, poststore.ErrPreconditionFailed) {
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+		tracer.LogError(span, err)
+		return
+	}
+	if errors.Is(err, poststore.ErrVersionExists) || errors.Is(err, poststore.ErrConflict) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		tracer.LogError(span, err)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		tracer.LogError(span, err)
+		return
+	}
+	s.reportQuota(ctx, quota)
+	s.publishEvent(ctx, config.NewEvent(config.GroupExtended, groupID, version, newConfigs...))
+	extended := append(append([]*config.Config{}, group...), newConfigs...)
+	s.audit(w, r, groupAudit(config.GroupExtended, groupID, version, group, extended))
+	group = extended
+
 	w.Header().Set("Content-Type", "application/json")
 	err = json.NewEncoder(w).Encode(group)
 	if err != nil {
@@ -336,11 +737,16 @@ func (s *Service) ExtendConfigurationGroup(w http.ResponseWriter, r *http.Reques
 
 // swagger:route GET /groups/{id}/{version}/{labels} groups getConfigurationGroupsByLabels
 //
-// Returns the group of configurations with the given ID,version and labels.
+// Returns the configurations of the group with the given ID and version whose
+// labels match the selector, e.g. "env=prod,team!=legacy,tier in (web,api)".
+// The selector "watch" can't be used here, that path is the group watch; the
+// equivalent "watch," selects the members that have a watch label.
 //
 // Responses:
 //
 //	200: configGroupResponse
+//	304: notModifiedResponse
+//	400: badRequestResponse
 //	404: notFoundResponse
 //	500: internalServerErrorResponse
 func (s *Service) GetConfigurationGroupsByLabels(w http.ResponseWriter, r *http.Request) {
@@ -351,15 +757,36 @@ func (s *Service) GetConfigurationGroupsByLabels(w http.ResponseWriter, r *http.
 	vars := mux.Vars(r)
 	id := vars["id"]
 	version := vars["version"]
-	labelString := vars["labels"]
+	selector, err := config.ParseSelector(vars["labels"])
+	if err != nil {
+		writeValidationErrors(w, []FieldError{{Field: "labels", Value: vars["labels"], Message: err.Error()}})
+		tracer.LogError(span, err)
+		return
+	}
 
-	filteredGroups, err := s.PostStore.GetConfigurationGroupsByLabels(ctx, id, version, labelString)
+	v := &validator{}
+	v.id("id", id, true)
+	v.version("version", version, true)
+	v.selector("labels", selector)
+	if !v.valid(w) {
+		return
+	}
+
+	filteredGroups, err := s.PostStore.GetConfigurationGroupsByLabels(ctx, id, version, selector)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		tracer.LogError(span, err)
 		return
 	}
 
+	indexes := make([]uint64, 0, len(filteredGroups))
+	for _, c := range filteredGroups {
+		indexes = append(indexes, c.ModifyIndex)
+	}
+	if notModified(w, r, listETag(indexes)) {
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	err = json.NewEncoder(w).Encode(filteredGroups)
 	if err != nil {
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+)
+
+// Limits applied to everything that ends up in a Consul key or value.
+const (
+	maxBodyBytes          = 1 << 20
+	maxIDLength           = 128
+	maxNameLength         = 256
+	maxVersionLength      = 64
+	maxLabelKeyLength     = 63
+	maxLabelValueLength   = 256
+	maxLabels             = 64
+	maxEntries            = 512
+	maxEntryKeyLength     = 256
+	maxConfigsPerRequest  = 256
+	maxIdempotencyKeySize = 255
+)
+
+var (
+	// IDs and versions become path segments of Consul keys, so they must not
+	// contain "/" and can't start with "." (which also rules out "..")
+	idPattern       = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+	versionPattern  = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9.+_-]*$`)
+	labelKeyPattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._-]*[A-Za-z0-9])?$`)
+)
+
+// FieldError describes why a single field of a request was rejected.
+type FieldError struct {
+	Field   string `json:"field"`
+	Value   string `json:"value,omitempty"`
+	Message string `json:"message"`
+}
+
+// validationResponse is the body of a 400 response caused by invalid input.
+type validationResponse struct {
+	Error  string       `json:"error"`
+	Fields []FieldError `json:"fields"`
+}
+
+// validator collects field errors so a client gets all of them at once.
+type validator struct {
+	errors []FieldError
+}
+
+func (v *validator) add(field, value, format string, args ...interface{}) {
+	if len(value) > 64 {
+		value = value[:64] + "..."
+	}
+	v.errors = append(v.errors, FieldError{Field: field, Value: value, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) id(field, value string, required bool) {
+	v.pattern(field, value, required, maxIDLength, idPattern)
+}
+
+func (v *validator) version(field, value string, required bool) {
+	v.pattern(field, value, required, maxVersionLength, versionPattern)
+}
+
+func (v *validator) pattern(field, value string, required bool, maxLength int, pattern *regexp.Regexp) {
+	switch {
+	case value == "":
+		if required {
+			v.add(field, value, "is required")
+		}
+	case len(value) > maxLength:
+		v.add(field, value, "must be at most %d characters", maxLength)
+	case !pattern.MatchString(value) || strings.Contains(value, ".."):
+		v.add(field, value, "must match %s and must not contain \"..\"", pattern.String())
+	}
+}
+
+func (v *validator) labelKey(field, key string) {
+	switch {
+	case len(key) > maxLabelKeyLength:
+		v.add(field, key, "label key must be at most %d characters", maxLabelKeyLength)
+	case !labelKeyPattern.MatchString(key):
+		v.add(field, key, "label key must match %s", labelKeyPattern.String())
+	}
+}
+
+func (v *validator) labels(field string, labels config.Labels) {
+	if len(labels) > maxLabels {
+		v.add(field, "", "must have at most %d labels", maxLabels)
+	}
+	for key, value := range labels {
+		v.labelKey(field+"."+key, key)
+		if len(value) > maxLabelValueLength {
+			v.add(field+"."+key, value, "label value must be at most %d characters", maxLabelValueLength)
+		}
+	}
+}
+
+func (v *validator) selector(field string, selector config.Selector) {
+	for _, req := range selector {
+		v.labelKey(field, req.Key)
+	}
+}
+
+// config validates a configuration from a request body. IDs are optional
+// since the service generates missing ones.
+func (v *validator) config(field string, c *config.Config, requireGroup bool) {
+	v.id(field+"id", c.ID, false)
+	v.version(field+"version", c.Version, true)
+	v.id(field+"group_id", c.GroupID, requireGroup)
+	if isReservedVersion(c.Version) {
+		v.add(field+"version", c.Version, "%q is reserved", c.Version)
+	}
+	if c.PromotedFrom != "" {
+		v.add(field+"promoted_from", c.PromotedFrom, "is set by the promote endpoints")
+	}
+	v.labels(field+"labels", c.Labels)
+
+	if len(c.Entries) > maxEntries {
+		v.add(field+"entries", "", "must have at most %d entries", maxEntries)
+	}
+	for key := range c.Entries {
+		if key == "" || len(key) > maxEntryKeyLength {
+			v.add(field+"entries", key, "entry keys must be 1 to %d characters", maxEntryKeyLength)
+		}
+	}
+}
+
+func (v *validator) configs(configs []*config.Config, requireGroup bool) {
+	if len(configs) == 0 {
+		v.add("body", "", "must contain at least one configuration")
+	}
+	if len(configs) > maxConfigsPerRequest {
+		v.add("body", "", "must contain at most %d configurations", maxConfigsPerRequest)
+	}
+	for i, c := range configs {
+		if c == nil {
+			v.add(fmt.Sprintf("[%d]", i), "", "must not be null")
+			continue
+		}
+		v.config(fmt.Sprintf("[%d].", i), c, requireGroup)
+	}
+}
+
+func (v *validator) idempotencyKey(key string) {
+	if len(key) > maxIdempotencyKeySize {
+		v.add("Idempotency-Key", key, "must be at most %d characters", maxIdempotencyKeySize)
+	}
+}
+
+// valid reports whether no errors were found, otherwise it answers the
+// request with 400 and the list of field errors.
+func (v *validator) valid(w http.ResponseWriter) bool {
+	if len(v.errors) == 0 {
+		return true
+	}
+	writeValidationErrors(w, v.errors)
+	return false
+}
+
+func writeValidationErrors(w http.ResponseWriter, errors []FieldError) {
+	body, _ := json.Marshal(validationResponse{Error: "validation failed", Fields: errors})
+	writeJSON(w, http.StatusBadRequest, body)
+}
+
+// bodyError answers a request whose body couldn't be read or decoded.
+func bodyError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeValidationErrors(w, []FieldError{{Field: "body", Message: fmt.Sprintf("must be at most %d bytes", tooLarge.Limit)}})
+		return
+	}
+	writeValidationErrors(w, []FieldError{{Field: "body", Message: err.Error()}})
+}
+
+// isReservedVersion reports whether a version name is an alias that cannot be
+// stored, such as "latest", or is taken by a route, such as "diff".
+func isReservedVersion(version string) bool {
+	return version == config.LatestVersion || version == diffVersion
+}
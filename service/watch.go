@@ -0,0 +1,203 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	tracer "github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/tracer"
+	"github.com/gorilla/mux"
+)
+
+// Watch endpoints are long polls backed by Consul blocking queries. A client
+// first calls them without ?index= to get the current data and its index in
+// the X-Config-Index header, then keeps calling with ?index=<last index>.
+// The call returns as soon as the data changes, or with 304 Not Modified once
+// ?wait= (default 5m, at most 10m) has elapsed without a change.
+const (
+	defaultWatchWait = 5 * time.Minute
+	maxWatchWait     = 10 * time.Minute
+)
+
+// watchParams reads ?index= and ?wait=, recording problems in v.
+func watchParams(r *http.Request, v *validator) (uint64, time.Duration) {
+	query := r.URL.Query()
+
+	var index uint64
+	if value := query.Get("index"); value != "" {
+		i, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			v.add("index", value, "must be a non-negative integer")
+		}
+		index = i
+	}
+
+	wait := defaultWatchWait
+	if value := query.Get("wait"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			v.add("wait", value, "must be a positive duration such as 30s or 5m")
+		}
+		wait = d
+	}
+	if wait > maxWatchWait {
+		wait = maxWatchWait
+	}
+
+	return index, wait
+}
+
+// writeWatchIndex sets X-Config-Index and answers 304 when the index did not
+// move, which means the wait time elapsed without a change.
+func writeWatchIndex(w http.ResponseWriter, index, last uint64) bool {
+	w.Header().Set("X-Config-Index", strconv.FormatUint(last, 10))
+	if index != 0 && index == last {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+func encodeJSON(w http.ResponseWriter, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(v)
+}
+
+// swagger:route GET /configurations/{id}/{version}/watch configurations watchConfiguration
+//
+// Long-polls the configuration with the given ID and version, returning it
+// once it changes after ?index=.
+//
+// Responses:
+//
+//	200: configResponse
+//	304: notModifiedResponse
+//	400: badRequestResponse
+//	404: notFoundResponse
+//	500: internalServerErrorResponse
+func (s *Service) WatchConfiguration(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	span := tracer.StartSpanFromContext(ctx, "Watch")
+	defer span.Finish()
+
+	vars := mux.Vars(r)
+	id := vars["id"]
+	version := vars["version"]
+
+	v := &validator{}
+	v.id("id", id, true)
+	v.version("version", version, true)
+	if isReservedVersion(version) {
+		v.add("version", version, "%q can't be watched, watch a concrete version", version)
+	}
+	index, wait := watchParams(r, v)
+	if !v.valid(w) {
+		return
+	}
+
+	config, last, err := s.PostStore.WatchConfiguration(ctx, id, version, index, wait)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		tracer.LogError(span, err)
+		return
+	}
+
+	if writeWatchIndex(w, index, last) {
+		return
+	}
+	if config == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	err = encodeJSON(w, config)
+	if err != nil {
+		tracer.LogError(span, err)
+	}
+}
+
+// swagger:route GET /group/{id}/{version}/watch groups watchConfigurationGroup
+//
+// Long-polls the group with the given ID and version, returning its members
+// once anything in the group changes after ?index=.
+//
+// Responses:
+//
+//	200: configGroupResponse
+//	304: notModifiedResponse
+//	400: badRequestResponse
+//	404: notFoundResponse
+//	500: internalServerErrorResponse
+func (s *Service) WatchConfigurationGroup(w http.ResponseWriter, r *http.Request) {
+	s.watchGroup(w, r, nil)
+}
+
+// swagger:route GET /group/{id}/{version}/{labels}/watch groups watchConfigurationGroupsByLabels
+//
+// Long-polls the members of the group matching the label selector, returning
+// them once anything in the group changes after ?index=.
+//
+// Responses:
+//
+//	200: configGroupResponse
+//	304: notModifiedResponse
+//	400: badRequestResponse
+//	404: notFoundResponse
+//	500: internalServerErrorResponse
+func (s *Service) WatchConfigurationGroupsByLabels(w http.ResponseWriter, r *http.Request) {
+	labels := mux.Vars(r)["labels"]
+	selector, err := config.ParseSelector(labels)
+	if err != nil {
+		writeValidationErrors(w, []FieldError{{Field: "labels", Value: labels, Message: err.Error()}})
+		return
+	}
+	s.watchGroup(w, r, selector)
+}
+
+func (s *Service) watchGroup(w http.ResponseWriter, r *http.Request, selector config.Selector) {
+	ctx := r.Context()
+	span := tracer.StartSpanFromContext(ctx, "Watch")
+	defer span.Finish()
+
+	vars := mux.Vars(r)
+	id := vars["id"]
+	version := vars["version"]
+
+	v := &validator{}
+	v.id("id", id, true)
+	v.version("version", version, true)
+	v.selector("labels", selector)
+	index, wait := watchParams(r, v)
+	if !v.valid(w) {
+		return
+	}
+
+	manifest, members, last, err := s.PostStore.WatchGroup(ctx, id, version, index, wait)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		tracer.LogError(span, err)
+		return
+	}
+
+	if writeWatchIndex(w, index, last) {
+		return
+	}
+	if manifest == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	configs := make([]*config.Config, 0, len(members))
+	for _, c := range members {
+		if selector.Matches(c.Labels) {
+			configs = append(configs, c)
+		}
+	}
+
+	err = encodeJSON(w, configs)
+	if err != nil {
+		tracer.LogError(span, err)
+	}
+}
@@ -0,0 +1,764 @@
+package service
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/namespace"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/poststore"
+	tracer "github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/tracer"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Every change event is delivered to the matching webhook subscriptions by
+// the instance that made the change. Deliveries are POSTed as JSON with
+//
+//	X-Webhook-Event:     the event type
+//	X-Webhook-Delivery:  the delivery ID, the same for every retry
+//	X-Webhook-Timestamp: unix seconds of the attempt
+//	X-Webhook-Signature: sha256=<hex HMAC-SHA256 of "{timestamp}.{body}">
+//
+// Any non-2xx answer or transport error is retried with exponential backoff.
+// A worker only makes one attempt at a time: a failed delivery records its
+// next_attempt_at and waits in a schedule, so slow or dead receivers don't
+// hold the workers up. Pending deliveries are stored, and Run picks them up
+// again after a restart; receivers can tell a repeated delivery by its ID.
+// The status of every delivery is recorded and listed per subscription.
+
+const (
+	webhookQueueSize = 1024
+	maxSecretLength  = 256
+	maxURLLength     = 2048
+)
+
+var eventTypes = map[string]bool{
+	config.ConfigurationCreated:  true,
+	config.ConfigurationDeleted:  true,
+	config.GroupCreated:          true,
+	config.GroupExtended:         true,
+	config.GroupDeleted:          true,
+	config.ConfigurationPromoted: true,
+	config.GroupPromoted:         true,
+}
+
+// SignWebhook returns the X-Webhook-Signature value of a delivery body.
+// Receivers compute the same value to check a delivery is authentic.
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookDispatcher delivers change events to the webhook subscriptions.
+type WebhookDispatcher struct {
+	store poststore.Store
+
+	// Client sends the deliveries.
+	Client *http.Client
+	// AllowPrivate lets subscriptions deliver to loopback, private and
+	// link-local addresses, which are refused by default so webhooks can't
+	// reach the services inside the cluster, e.g. the Consul agent.
+	AllowPrivate bool
+	// MaxAttempts is how often a delivery is tried before it is marked failed.
+	MaxAttempts int
+	// BaseDelay is the wait before the first retry, it doubles with every
+	// further retry up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+
+	// queue holds the deliveries due now, scheduled the ones waiting for
+	// their next attempt
+	queue     chan *webhookJob
+	mu        sync.Mutex
+	scheduled webhookSchedule
+	wake      chan struct{}
+	// IDs of the deliveries queued, scheduled or being sent, so Resume
+	// doesn't pick them up a second time
+	active map[string]bool
+}
+
+type webhookJob struct {
+	webhook  *config.Webhook
+	delivery *config.Delivery
+	due      time.Time
+}
+
+// webhookSchedule is a heap of jobs ordered by due time.
+type webhookSchedule []*webhookJob
+
+func (h webhookSchedule) Len() int            { return len(h) }
+func (h webhookSchedule) Less(i, j int) bool  { return h[i].due.Before(h[j].due) }
+func (h webhookSchedule) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *webhookSchedule) Push(x interface{}) { *h = append(*h, x.(*webhookJob)) }
+func (h *webhookSchedule) Pop() interface{} {
+	old := *h
+	job := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return job
+}
+
+// NewWebhookDispatcher returns a dispatcher with the default retry policy.
+// Run must be called for it to deliver anything.
+func NewWebhookDispatcher(store poststore.Store) *WebhookDispatcher {
+	d := &WebhookDispatcher{
+		store:       store,
+		MaxAttempts: 6,
+		BaseDelay:   time.Second,
+		MaxDelay:    5 * time.Minute,
+		queue:       make(chan *webhookJob, webhookQueueSize),
+		wake:        make(chan struct{}, 1),
+		active:      make(map[string]bool),
+	}
+	d.Client = d.newClient(10 * time.Second)
+	return d
+}
+
+// newClient returns a client that checks every address it connects to, after
+// DNS resolution, so a name can't be pointed at an internal address once the
+// subscription exists. Redirects aren't followed, they count as failed
+// attempts. Proxies from the environment aren't used since they would hide
+// the destination.
+func (d *WebhookDispatcher) newClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			if d.AllowPrivate {
+				return nil
+			}
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || privateIP(ip) {
+				return fmt.Errorf("webhook destination %s is not allowed", host)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConnsPerHost: 2,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// sharedAddressSpace is 100.64.0.0/10, used inside carrier and cloud networks.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// privateIP reports whether ip is an address webhooks may not be delivered to.
+func privateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip)
+}
+
+// privateHost reports whether host names a destination webhooks may not be
+// delivered to without resolving it. Names are checked again when the
+// dispatcher connects.
+func privateHost(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && privateIP(ip)
+}
+
+// Run resumes the stored pending deliveries and then sends the queued ones
+// with the given number of workers until ctx is cancelled.
+func (d *WebhookDispatcher) Run(ctx context.Context, workers int) {
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job := <-d.queue:
+					d.deliver(ctx, job)
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		d.dispatchScheduled(ctx)
+	}()
+
+	resumed, err := d.Resume(ctx)
+	if err != nil && ctx.Err() == nil {
+		log.Printf("webhooks: resuming pending deliveries: %v", err)
+	}
+	if resumed > 0 {
+		log.Printf("webhooks: resumed %d pending deliveries", resumed)
+	}
+	wg.Wait()
+}
+
+// Resume schedules the pending deliveries of every subscription, e.g. the
+// ones left by a restart, at their next attempt. It returns how many there
+// were.
+func (d *WebhookDispatcher) Resume(ctx context.Context) (int, error) {
+	webhooks, err := d.store.ListWebhooks(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	resumed := 0
+	for _, webhook := range webhooks {
+		deliveries, err := d.store.ListDeliveries(ctx, webhook.ID)
+		if err != nil {
+			return resumed, err
+		}
+		for _, delivery := range deliveries {
+			if delivery.Status != config.DeliveryPending || !d.track(delivery.ID) {
+				continue
+			}
+			due := time.Now()
+			if delivery.NextAttemptAt != nil {
+				due = *delivery.NextAttemptAt
+			}
+			d.later(&webhookJob{webhook: webhook, delivery: delivery}, due)
+			resumed++
+		}
+	}
+	return resumed, nil
+}
+
+// track marks a delivery as handled by d. It returns false if it already is.
+func (d *WebhookDispatcher) track(id string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.active[id] {
+		return false
+	}
+	d.active[id] = true
+	return true
+}
+
+func (d *WebhookDispatcher) untrack(id string) {
+	d.mu.Lock()
+	delete(d.active, id)
+	d.mu.Unlock()
+}
+
+// later schedules job for an attempt at due.
+func (d *WebhookDispatcher) later(job *webhookJob, due time.Time) {
+	job.due = due
+	d.mu.Lock()
+	heap.Push(&d.scheduled, job)
+	d.mu.Unlock()
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// dispatchScheduled moves the scheduled jobs to the queue when they are due.
+func (d *WebhookDispatcher) dispatchScheduled(ctx context.Context) {
+	for {
+		d.mu.Lock()
+		if len(d.scheduled) > 0 && !d.scheduled[0].due.After(time.Now()) {
+			job := heap.Pop(&d.scheduled).(*webhookJob)
+			d.mu.Unlock()
+			select {
+			case d.queue <- job:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		wait := time.Hour
+		if len(d.scheduled) > 0 {
+			wait = time.Until(d.scheduled[0].due)
+		}
+		d.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-d.wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// Enqueue records a pending delivery of the event for every matching
+// subscription and queues it. It never waits for the receivers.
+func (d *WebhookDispatcher) Enqueue(ctx context.Context, event *config.Event) error {
+	webhooks, err := d.store.ListWebhooks(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, webhook := range webhooks {
+		matched := webhookMatches(webhook, event)
+		if matched == nil {
+			continue
+		}
+
+		now := time.Now().UTC()
+		delivery := &config.Delivery{
+			ID:        uuid.New().String(),
+			WebhookID: webhook.ID,
+			Event:     matched,
+			Status:    config.DeliveryPending,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+
+		// tracked before it is stored, so Resume can't see it untracked
+		d.track(delivery.ID)
+		err := d.store.SaveDelivery(ctx, delivery)
+		if err != nil {
+			d.untrack(delivery.ID)
+			return err
+		}
+
+		// with the workers busy the delivery waits in the schedule instead
+		job := &webhookJob{webhook: webhook, delivery: delivery}
+		select {
+		case d.queue <- job:
+		default:
+			d.later(job, now)
+		}
+	}
+
+	return nil
+}
+
+// webhookMatches returns the event narrowed down to what the subscription
+// asked for, or nil if it doesn't want the event.
+func webhookMatches(webhook *config.Webhook, event *config.Event) *config.Event {
+	if len(webhook.Events) != 0 {
+		wanted := false
+		for _, t := range webhook.Events {
+			wanted = wanted || t == event.Type
+		}
+		if !wanted {
+			return nil
+		}
+	}
+
+	// the selector was validated when the subscription was created
+	selector, _ := config.ParseSelector(webhook.Labels)
+	filter := &eventFilter{namespace: namespace.Normalize(webhook.Namespace), id: webhook.ConfigID, group: webhook.GroupID, selector: selector}
+	return filter.match(event)
+}
+
+// deliver makes one attempt and schedules the next one if it failed.
+func (d *WebhookDispatcher) deliver(ctx context.Context, job *webhookJob) {
+	delivery := job.delivery
+	body, err := json.Marshal(delivery.Event)
+	if err != nil {
+		log.Printf("webhook %s: %v", job.webhook.ID, err)
+		d.untrack(delivery.ID)
+		return
+	}
+
+	delivery.Attempts++
+	status, err := d.send(ctx, job.webhook, delivery, body)
+	if ctx.Err() != nil {
+		// shutting down, the stored delivery is still pending and resumed
+		// on the next start
+		return
+	}
+	now := time.Now().UTC()
+	delivery.LastStatusCode = status
+	delivery.LastError = ""
+	delivery.UpdatedAt = now
+	delivery.NextAttemptAt = nil
+
+	switch {
+	case err == nil:
+		delivery.Status = config.DeliveryDelivered
+	case delivery.Attempts >= d.MaxAttempts:
+		delivery.Status = config.DeliveryFailed
+		delivery.LastError = err.Error()
+	default:
+		delivery.LastError = err.Error()
+		next := now.Add(d.backoff(delivery.Attempts))
+		delivery.NextAttemptAt = &next
+	}
+
+	// the deliveries of a removed subscription aren't recorded anymore
+	if _, err := d.store.GetWebhook(ctx, job.webhook.ID); errors.Is(err, poststore.ErrNotFound) {
+		d.untrack(delivery.ID)
+		return
+	}
+	if err := d.store.SaveDelivery(ctx, delivery); err != nil && ctx.Err() == nil {
+		log.Printf("webhook %s: recording delivery %s: %v", job.webhook.ID, delivery.ID, err)
+	}
+
+	if delivery.NextAttemptAt != nil {
+		d.later(job, *delivery.NextAttemptAt)
+		return
+	}
+	d.untrack(delivery.ID)
+}
+
+// backoff returns the wait after the given number of failed attempts.
+func (d *WebhookDispatcher) backoff(attempts int) time.Duration {
+	delay := d.BaseDelay
+	for i := 1; i < attempts && delay < d.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > d.MaxDelay {
+		delay = d.MaxDelay
+	}
+	return delay
+}
+
+func (d *WebhookDispatcher) send(ctx context.Context, webhook *config.Webhook, delivery *config.Delivery, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", delivery.Event.Type)
+	req.Header.Set("X-Webhook-Delivery", delivery.ID)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", SignWebhook(webhook.Secret, timestamp, body))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+func (v *validator) webhook(webhook *config.Webhook, allowPrivate bool) {
+	v.id("id", webhook.ID, false)
+	v.id("config_id", webhook.ConfigID, false)
+	v.id("group_id", webhook.GroupID, false)
+
+	u, err := url.Parse(webhook.URL)
+	switch {
+	case webhook.URL == "":
+		v.add("url", webhook.URL, "is required")
+	case len(webhook.URL) > maxURLLength:
+		v.add("url", webhook.URL, "must be at most %d characters", maxURLLength)
+	case err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "":
+		v.add("url", webhook.URL, "must be an absolute http or https URL")
+	case !allowPrivate && privateHost(u.Hostname()):
+		v.add("url", webhook.URL, "must not point to a loopback, private or link-local address")
+	}
+
+	for i, t := range webhook.Events {
+		if !eventTypes[t] {
+			v.add(fmt.Sprintf("events[%d]", i), t, "unknown event type")
+		}
+	}
+
+	if webhook.Labels != "" {
+		selector, err := config.ParseSelector(webhook.Labels)
+		if err != nil {
+			v.add("labels", webhook.Labels, "%s", err.Error())
+		}
+		v.selector("labels", selector)
+	}
+
+	if len(webhook.Secret) > maxSecretLength {
+		v.add("secret", "", "must be at most %d characters", maxSecretLength)
+	}
+}
+
+func newWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// swagger:route POST /webhooks webhooks createWebhook
+//
+// Subscribes a URL to configuration changes. Without a secret one is
+// generated. The secret is only part of this response.
+//
+// Responses:
+//
+//	201: webhookResponse
+//	400: badRequestResponse
+//	409: conflictResponse
+//	500: internalServerErrorResponse
+func (s *Service) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	span := tracer.StartSpanFromContext(ctx, "Post")
+	defer span.Finish()
+
+	webhook := &config.Webhook{}
+	body, err := readBody(w, r)
+	if err == nil {
+		err = json.Unmarshal(body, webhook)
+	}
+	if err != nil {
+		bodyError(w, err)
+		return
+	}
+
+	v := &validator{}
+	v.webhook(webhook, s.Webhooks != nil && s.Webhooks.AllowPrivate)
+	if !v.valid(w) {
+		return
+	}
+
+	if webhook.ID == "" {
+		webhook.ID = uuid.New().String()
+	}
+	webhook.Namespace = namespace.FromRequest(r)
+	if webhook.Secret == "" {
+		webhook.Secret, err = newWebhookSecret()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			tracer.LogError(span, err)
+			return
+		}
+	}
+	webhook.CreatedAt = time.Now().UTC()
+
+	err = s.PostStore.AddWebhook(ctx, webhook)
+	if errors.Is(err, poststore.ErrVersionExists) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		tracer.LogError(span, err)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		tracer.LogError(span, err)
+		return
+	}
+
+	response, err := json.Marshal(webhook)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		tracer.LogError(span, err)
+		return
+	}
+	s.audit(w, r, &config.AuditRecord{
+		Action:     config.WebhookCreated,
+		TargetKind: config.TargetWebhook,
+		TargetID:   webhook.ID,
+		AfterHash:  webhookHash(webhook),
+	})
+	writeJSON(w, http.StatusCreated, response)
+}
+
+// namespaceWebhook returns the subscription with the given ID if it belongs to
+// the namespace of ctx, ErrNotFound otherwise.
+func (s *Service) namespaceWebhook(ctx context.Context, id string) (*config.Webhook, error) {
+	webhook, err := s.PostStore.GetWebhook(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if namespace.Normalize(webhook.Namespace) != namespace.FromContext(ctx) {
+		return nil, fmt.Errorf("webhook %w", poststore.ErrNotFound)
+	}
+	return webhook, nil
+}
+
+// swagger:route GET /webhooks webhooks listWebhooks
+//
+// Returns the webhook subscriptions of the namespace, without their secrets.
+//
+// Responses:
+//
+//	200: webhookListResponse
+//	500: internalServerErrorResponse
+func (s *Service) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	span := tracer.StartSpanFromContext(ctx, "GetAll")
+	defer span.Finish()
+
+	webhooks, err := s.PostStore.ListWebhooks(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		tracer.LogError(span, err)
+		return
+	}
+	ns := namespace.FromRequest(r)
+	inNamespace := make([]*config.Webhook, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		if namespace.Normalize(webhook.Namespace) == ns {
+			webhook.Secret = ""
+			inNamespace = append(inNamespace, webhook)
+		}
+	}
+
+	err = encodeJSON(w, inNamespace)
+	if err != nil {
+		tracer.LogError(span, err)
+	}
+}
+
+// swagger:route GET /webhooks/{id} webhooks getWebhook
+//
+// Returns the webhook subscription with the given ID, without its secret.
+//
+// Responses:
+//
+//	200: webhookResponse
+//	404: notFoundResponse
+//	500: internalServerErrorResponse
+func (s *Service) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	span := tracer.StartSpanFromContext(ctx, "Get")
+	defer span.Finish()
+
+	id := mux.Vars(r)["id"]
+	v := &validator{}
+	v.id("id", id, true)
+	if !v.valid(w) {
+		return
+	}
+
+	webhook, err := s.namespaceWebhook(ctx, id)
+	if errors.Is(err, poststore.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		tracer.LogError(span, err)
+		return
+	}
+	webhook.Secret = ""
+
+	err = encodeJSON(w, webhook)
+	if err != nil {
+		tracer.LogError(span, err)
+	}
+}
+
+// swagger:route DELETE /webhooks/{id} webhooks deleteWebhook
+//
+// Removes the webhook subscription with the given ID and its delivery history.
+//
+// Responses:
+//
+//	204: noContentResponse
+//	400: badRequestResponse
+//	500: internalServerErrorResponse
+func (s *Service) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	span := tracer.StartSpanFromContext(ctx, "Delete")
+	defer span.Finish()
+
+	id := mux.Vars(r)["id"]
+	v := &validator{}
+	v.id("id", id, true)
+	if !v.valid(w) {
+		return
+	}
+
+	before, err := s.namespaceWebhook(ctx, id)
+	if errors.Is(err, poststore.ErrNotFound) {
+		// deleting a subscription that doesn't exist succeeds
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err == nil {
+		err = s.PostStore.DeleteWebhook(ctx, id)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		tracer.LogError(span, err)
+		return
+	}
+	s.audit(w, r, &config.AuditRecord{
+		Action:     config.WebhookDeleted,
+		TargetKind: config.TargetWebhook,
+		TargetID:   id,
+		BeforeHash: webhookHash(before),
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// swagger:route GET /webhooks/{id}/deliveries webhooks listWebhookDeliveries
+//
+// Returns the most recent deliveries of the webhook subscription, newest
+// first.
+//
+// Responses:
+//
+//	200: deliveryListResponse
+//	404: notFoundResponse
+//	500: internalServerErrorResponse
+func (s *Service) ListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	span := tracer.StartSpanFromContext(ctx, "GetAll")
+	defer span.Finish()
+
+	id := mux.Vars(r)["id"]
+	v := &validator{}
+	v.id("id", id, true)
+	if !v.valid(w) {
+		return
+	}
+
+	_, err := s.namespaceWebhook(ctx, id)
+	if errors.Is(err, poststore.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		tracer.LogError(span, err)
+		return
+	}
+
+	deliveries, err := s.PostStore.ListDeliveries(ctx, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		tracer.LogError(span, err)
+		return
+	}
+
+	err = encodeJSON(w, deliveries)
+	if err != nil {
+		tracer.LogError(span, err)
+	}
+}
@@ -10,8 +10,7 @@ import (
 )
 
 func TestAddConfiguration(t *testing.T) {
-	ps, err := poststore.New()
-	assert.Nil(t, err)
+	ps := poststore.NewInMemory()
 	assert.NotNil(t, ps)
 
 	testConfig := &config.Config{
@@ -21,7 +20,7 @@ func TestAddConfiguration(t *testing.T) {
 	}
 	fmt.Println("Adding configuration:", testConfig)
 
-	err = ps.AddConfiguration(context.Background(), testConfig)
+	err := ps.AddConfiguration(context.Background(), testConfig, nil, nil)
 	assert.Nil(t, err)
 
 	fmt.Println("Retrieving configuration with ID:", testConfig.ID, "and version:", testConfig.Version)
@@ -0,0 +1,183 @@
+package test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/poststore"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/service"
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func newAuditChain(t *testing.T, records int) (*poststore.MemoryKV, *poststore.PostStore) {
+	kv := poststore.NewMemoryKV()
+	ps := poststore.NewWithKV(kv)
+	for i := 1; i <= records; i++ {
+		err := ps.AppendAudit(context.Background(), &config.AuditRecord{Action: "test", TargetID: fmt.Sprint(i)})
+		assert.NoError(t, err)
+	}
+	return kv, ps
+}
+
+func auditRecordKey(seq int) string {
+	return fmt.Sprintf("audit/records/%020d", seq)
+}
+
+func TestAuditChainLinksRecords(t *testing.T) {
+	_, ps := newAuditChain(t, 3)
+
+	records, _, err := ps.ListAudit(context.Background(), poststore.AuditQuery{})
+	assert.NoError(t, err)
+	assert.Empty(t, records[0].PrevHash)
+	assert.Equal(t, records[0].Hash, records[1].PrevHash)
+	assert.Equal(t, records[1].Hash, records[2].PrevHash)
+	for _, record := range records {
+		assert.Equal(t, record.ComputeHash(), record.Hash)
+	}
+
+	result, err := service.VerifyAuditChain(context.Background(), ps, nil)
+	assert.NoError(t, err)
+	assert.True(t, result.Valid, result.Reason)
+	assert.Equal(t, uint64(3), result.Records)
+}
+
+func TestAuditChainReportsFirstBrokenLink(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("edited record", func(t *testing.T) {
+		kv, ps := newAuditChain(t, 5)
+		pair, _, _ := kv.Get(auditRecordKey(3), nil)
+		var record config.AuditRecord
+		json.Unmarshal(pair.Value, &record)
+		record.Actor = "someone-else"
+		pair.Value, _ = json.Marshal(record)
+		kv.Put(pair, nil)
+
+		result, err := service.VerifyAuditChain(ctx, ps, nil)
+		assert.NoError(t, err)
+		assert.False(t, result.Valid)
+		assert.Equal(t, uint64(3), result.BrokenAt)
+		assert.Equal(t, "record doesn't match its hash", result.Reason)
+	})
+
+	t.Run("edited record with recomputed hash", func(t *testing.T) {
+		kv, ps := newAuditChain(t, 5)
+		pair, _, _ := kv.Get(auditRecordKey(2), nil)
+		var record config.AuditRecord
+		json.Unmarshal(pair.Value, &record)
+		record.Actor = "someone-else"
+		record.Hash = record.ComputeHash()
+		pair.Value, _ = json.Marshal(record)
+		kv.Put(pair, nil)
+
+		result, _ := service.VerifyAuditChain(ctx, ps, nil)
+		assert.Equal(t, uint64(3), result.BrokenAt)
+		assert.Contains(t, result.Reason, "prev_hash")
+	})
+
+	t.Run("deleted record", func(t *testing.T) {
+		kv, ps := newAuditChain(t, 5)
+		kv.Delete(auditRecordKey(4), nil)
+
+		result, _ := service.VerifyAuditChain(ctx, ps, nil)
+		assert.Equal(t, uint64(4), result.BrokenAt)
+		assert.Equal(t, "record 4 is missing", result.Reason)
+	})
+
+	t.Run("truncated log", func(t *testing.T) {
+		kv, ps := newAuditChain(t, 5)
+		kv.Delete(auditRecordKey(5), nil)
+
+		result, _ := service.VerifyAuditChain(ctx, ps, nil)
+		assert.False(t, result.Valid)
+		assert.Equal(t, uint64(5), result.BrokenAt)
+	})
+}
+
+func writeAuditKey(t *testing.T) (string, ed25519.PrivateKey) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "audit.pem")
+	err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	assert.NoError(t, err)
+	return path, key
+}
+
+func TestAuditCheckpointsAnchorTheChain(t *testing.T) {
+	ctx := context.Background()
+	path, key := writeAuditKey(t)
+	loaded, err := service.LoadAuditKey(path)
+	assert.NoError(t, err)
+	assert.Equal(t, key, loaded)
+
+	kv, ps := newAuditChain(t, 3)
+	checkpointer := service.NewAuditCheckpointer(ps, loaded)
+	checkpoint, err := checkpointer.Checkpoint(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), checkpoint.Seq)
+	// nothing new to sign
+	checkpoint, err = checkpointer.Checkpoint(ctx)
+	assert.NoError(t, err)
+	assert.Nil(t, checkpoint)
+
+	s := newTestService()
+	s.PostStore = ps
+	s.AuditCheckpoints = checkpointer
+	router := newTestRouter(s)
+
+	rec := doRequest(router, "GET", "/audit/verify", nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var result config.AuditVerification
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	assert.True(t, result.Valid, result.Reason)
+	assert.True(t, result.SignaturesChecked)
+	assert.Equal(t, 1, result.Checkpoints)
+
+	// rewriting the whole chain from record 2 on keeps the links intact but
+	// no longer matches the signed head
+	var prev string
+	for seq := 1; seq <= 3; seq++ {
+		pair, _, _ := kv.Get(auditRecordKey(seq), nil)
+		var record config.AuditRecord
+		json.Unmarshal(pair.Value, &record)
+		if seq >= 2 {
+			record.Actor = "someone-else"
+			record.PrevHash = prev
+			record.Hash = record.ComputeHash()
+			pair.Value, _ = json.Marshal(record)
+			kv.Put(pair, nil)
+		}
+		prev = record.Hash
+	}
+	head, _ := json.Marshal(poststore.AuditHead{Seq: 3, Hash: prev})
+	kv.Put(&api.KVPair{Key: "audit/head", Value: head}, nil)
+
+	rec = doRequest(router, "GET", "/audit/verify", nil, nil)
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	assert.False(t, result.Valid)
+	assert.Equal(t, uint64(3), result.BrokenAt)
+	assert.Equal(t, "record doesn't match the signed checkpoint", result.Reason)
+
+	// a forged checkpoint doesn't verify with the real key
+	_, other, _ := ed25519.GenerateKey(rand.Reader)
+	_, ps2 := newAuditChain(t, 2)
+	_, err = service.NewAuditCheckpointer(ps2, other).Checkpoint(ctx)
+	assert.NoError(t, err)
+	forged, _ := service.VerifyAuditChain(ctx, ps2, key.Public().(ed25519.PublicKey))
+	assert.False(t, forged.Valid)
+	assert.Equal(t, "checkpoint signature is invalid", forged.Reason)
+}
@@ -0,0 +1,241 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/poststore"
+	"github.com/stretchr/testify/assert"
+)
+
+type auditPage struct {
+	Records    []*config.AuditRecord `json:"records"`
+	NextCursor string                `json:"next_cursor"`
+}
+
+func getAudit(t *testing.T, router http.Handler, query string) auditPage {
+	rec := doRequest(router, "GET", "/audit"+query, nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var page auditPage
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+	return page
+}
+
+func TestAuditRecordsMutations(t *testing.T) {
+	s := newTestService()
+	router := newTestRouter(s)
+
+	c := &config.Config{ID: "api", Version: "1", Entries: map[string]string{"timeout": "5s"}}
+	rec := doRequest(router, "POST", "/configurations", c, map[string]string{"Idempotency-Key": "audit-1", "X-Request-ID": "req-1"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "req-1", rec.Header().Get("X-Request-ID"))
+
+	rec = doRequest(router, "POST", "/configurations/api/1/promote", nil, map[string]string{"Idempotency-Key": "audit-2"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	generated := rec.Header().Get("X-Request-ID")
+	assert.NotEmpty(t, generated)
+
+	rec = doRequest(router, "DELETE", "/configurations/api/1", nil, nil)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	rec = doRequest(router, "POST", "/group", newGroup("fleet", "1", 2), map[string]string{"Idempotency-Key": "audit-3"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// reads and rejected writes aren't audited
+	doRequest(router, "GET", "/configurations/api/2", nil, nil)
+	doRequest(router, "POST", "/configurations/api/9/promote", nil, map[string]string{"Idempotency-Key": "audit-4"})
+
+	page := getAudit(t, router, "")
+	if !assert.Len(t, page.Records, 4) {
+		return
+	}
+	assert.Empty(t, page.NextCursor)
+
+	created, promoted, deleted, group := page.Records[0], page.Records[1], page.Records[2], page.Records[3]
+	assert.Equal(t, uint64(1), created.Seq)
+	assert.Equal(t, config.ConfigurationCreated, created.Action)
+	assert.Equal(t, "api", created.TargetID)
+	assert.Equal(t, "1", created.TargetVersion)
+	assert.Equal(t, "req-1", created.RequestID)
+	assert.Equal(t, "anonymous", created.Actor)
+	assert.Empty(t, created.BeforeHash)
+	assert.Len(t, created.AfterHash, 64)
+
+	assert.Equal(t, config.ConfigurationPromoted, promoted.Action)
+	assert.Equal(t, "2", promoted.TargetVersion)
+	assert.Equal(t, "1", promoted.Details["promoted_from"])
+	assert.Equal(t, generated, promoted.RequestID)
+
+	// the before hash of the delete is the after hash of the create
+	assert.Equal(t, config.ConfigurationDeleted, deleted.Action)
+	assert.Equal(t, created.AfterHash, deleted.BeforeHash)
+	assert.Empty(t, deleted.AfterHash)
+
+	assert.Equal(t, config.GroupCreated, group.Action)
+	assert.Equal(t, config.TargetGroup, group.TargetKind)
+	assert.Equal(t, "fleet", group.TargetID)
+}
+
+func TestAuditFiltersAndPages(t *testing.T) {
+	s := newTestService()
+	router := newTestRouter(s)
+
+	for i := 1; i <= 5; i++ {
+		headers := map[string]string{"Idempotency-Key": fmt.Sprintf("page-%d", i)}
+		if i%2 == 0 {
+			headers["X-Admin-Token"] = "admin-token"
+		}
+		c := &config.Config{ID: "api", Version: fmt.Sprint(i), Entries: map[string]string{"n": fmt.Sprint(i)}}
+		rec := doRequest(router, "POST", "/configurations", c, headers)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+	rec := doRequest(router, "POST", "/configurations", &config.Config{ID: "web", Version: "1"}, map[string]string{"Idempotency-Key": "page-web"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	page := getAudit(t, router, "?target=api&limit=2")
+	assert.Len(t, page.Records, 2)
+	assert.Equal(t, "2", page.NextCursor)
+	page = getAudit(t, router, "?target=api&limit=2&cursor="+page.NextCursor)
+	assert.Equal(t, []uint64{3, 4}, []uint64{page.Records[0].Seq, page.Records[1].Seq})
+	page = getAudit(t, router, "?target=api&limit=2&cursor="+page.NextCursor)
+	assert.Len(t, page.Records, 1)
+	assert.Empty(t, page.NextCursor)
+
+	page = getAudit(t, router, "?actor=admin")
+	assert.Len(t, page.Records, 2)
+
+	page = getAudit(t, router, "?until="+time.Now().Add(-time.Hour).Format(time.RFC3339))
+	assert.Empty(t, page.Records)
+	page = getAudit(t, router, "?since="+time.Now().Add(-time.Hour).Format(time.RFC3339))
+	assert.Len(t, page.Records, 6)
+
+	for _, query := range []string{"?since=yesterday", "?limit=0", "?cursor=abc", "?kind=table"} {
+		rec := doRequest(router, "GET", "/audit"+query, nil, nil)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, query)
+	}
+}
+
+func TestForcedOverwritesAreAudited(t *testing.T) {
+	router := newTestRouter(newTestService())
+	admin := func(key string) map[string]string {
+		return map[string]string{"Idempotency-Key": key, "X-Admin-Token": "admin-token"}
+	}
+
+	c := &config.Config{ID: "api", Version: "1", Name: "first"}
+	rec := doRequest(router, "POST", "/configurations", c, admin("forced-1"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	c.Name = "second"
+	rec = doRequest(router, "POST", "/configurations?force=true", c, admin("forced-2"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = doRequest(router, "POST", "/group", newGroup("fleet", "1", 2), admin("forced-3"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = doRequest(router, "POST", "/group?force=true", newGroup("fleet", "1", 1), admin("forced-4"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	page := getAudit(t, router, "")
+	if assert.Len(t, page.Records, 4) {
+		assert.Empty(t, page.Records[0].Details["force"])
+		assert.Equal(t, "true", page.Records[1].Details["force"])
+		assert.Equal(t, page.Records[0].AfterHash, page.Records[1].BeforeHash)
+		assert.Empty(t, page.Records[2].Details["force"])
+		assert.Equal(t, "true", page.Records[3].Details["force"])
+		assert.Equal(t, page.Records[2].AfterHash, page.Records[3].BeforeHash)
+	}
+}
+
+func TestAuditAppendIsSequential(t *testing.T) {
+	ps := poststore.NewInMemory()
+	ctx := context.Background()
+
+	// far more writers than the head key lets through at once, none of them
+	// may give up
+	var wg sync.WaitGroup
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := ps.AppendAudit(ctx, &config.AuditRecord{Action: "test", TargetID: fmt.Sprint(i)})
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	records, next, err := ps.ListAudit(ctx, poststore.AuditQuery{})
+	assert.NoError(t, err)
+	assert.Zero(t, next)
+	assert.Len(t, records, 200)
+	for i, record := range records {
+		assert.Equal(t, uint64(i+1), record.Seq)
+	}
+}
+
+func TestAuditPagesCrossBlocks(t *testing.T) {
+	ps := poststore.NewInMemory()
+	ctx := context.Background()
+	for i := 1; i <= 2100; i++ {
+		kind := config.TargetConfiguration
+		if i%700 == 0 {
+			kind = config.TargetGroup
+		}
+		assert.NoError(t, ps.AppendAudit(ctx, &config.AuditRecord{Action: "test", TargetKind: kind}))
+	}
+
+	// records 700, 1400 and 2100 are in three different blocks
+	var seqs []uint64
+	after := uint64(0)
+	for {
+		records, next, err := ps.ListAudit(ctx, poststore.AuditQuery{TargetKind: config.TargetGroup, After: after, Limit: 2})
+		assert.NoError(t, err)
+		for _, record := range records {
+			seqs = append(seqs, record.Seq)
+		}
+		if next == 0 {
+			break
+		}
+		after = next
+	}
+	assert.Equal(t, []uint64{700, 1400, 2100}, seqs)
+
+	records, next, err := ps.ListAudit(ctx, poststore.AuditQuery{After: 998, Limit: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1001), next)
+	assert.Equal(t, []uint64{999, 1000, 1001}, []uint64{records[0].Seq, records[1].Seq, records[2].Seq})
+
+	walked := uint64(0)
+	assert.NoError(t, ps.WalkAudit(ctx, func(record *config.AuditRecord) error {
+		walked++
+		assert.Equal(t, walked, record.Seq)
+		return nil
+	}))
+	assert.Equal(t, uint64(2100), walked)
+}
+
+func TestDeletingMissingTargetsIsNotAudited(t *testing.T) {
+	s := newTestService()
+	router := newTestRouter(s)
+
+	rec := doRequest(router, "DELETE", "/configurations/missing/1", nil, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = doRequest(router, "DELETE", "/group/missing/1", nil, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	assert.Empty(t, getAudit(t, router, "").Records)
+	events, _, err := s.PostStore.WatchEvents(context.Background(), 0, 0, 0)
+	assert.Nil(t, err)
+	assert.Empty(t, events)
+
+	rec = doRequest(router, "POST", "/group", newGroup("fleet", "1", 2), map[string]string{"Idempotency-Key": "missing-1"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = doRequest(router, "DELETE", "/group/fleet/1", nil, nil)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	rec = doRequest(router, "DELETE", "/group/fleet/1", nil, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Len(t, getAudit(t, router, "").Records, 2)
+}
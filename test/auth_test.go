@@ -0,0 +1,241 @@
+package test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/auth"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/poststore"
+	"github.com/stretchr/testify/assert"
+)
+
+var b64 = base64.RawURLEncoding
+
+// signJWT builds a token signed with key, an *rsa.PrivateKey (RS256),
+// *ecdsa.PrivateKey (ES256) or ed25519.PrivateKey (EdDSA).
+func signJWT(t *testing.T, key crypto.Signer, kid string, claims map[string]interface{}) string {
+	var alg string
+	switch key.(type) {
+	case *rsa.PrivateKey:
+		alg = "RS256"
+	case *ecdsa.PrivateKey:
+		alg = "ES256"
+	case ed25519.PrivateKey:
+		alg = "EdDSA"
+	}
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64.EncodeToString(header) + "." + b64.EncodeToString(payload)
+
+	var sig []byte
+	var err error
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(signed))
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, digest[:])
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	assert.NoError(t, err)
+	return signed + "." + b64.EncodeToString(sig)
+}
+
+type testKeys struct {
+	rsa     *rsa.PrivateKey
+	ec      *ecdsa.PrivateKey
+	ed      ed25519.PrivateKey
+	jwksDoc []byte
+}
+
+func newTestKeys(t *testing.T) *testKeys {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	doc, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "alg": "RS256", "use": "sig",
+			"n": b64.EncodeToString(rsaKey.N.Bytes()), "e": b64.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "ec", "crv": "P-256",
+			"x": b64.EncodeToString(ecKey.X.FillBytes(make([]byte, 32))), "y": b64.EncodeToString(ecKey.Y.FillBytes(make([]byte, 32)))},
+		{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": b64.EncodeToString(edKey.Public().(ed25519.PublicKey))},
+		{"kty": "RSA", "kid": "enc", "use": "enc", "n": "AQAB", "e": "AQAB"},
+	}})
+	return &testKeys{rsa: rsaKey, ec: ecKey, ed: edKey, jwksDoc: doc}
+}
+
+func validClaims(sub string) map[string]interface{} {
+	return map[string]interface{}{
+		"sub": sub,
+		"iss": "https://issuer.example",
+		"aud": []string{"other", "configurations"},
+		"exp": time.Now().Add(time.Hour).Unix(),
+		"iat": time.Now().Unix(),
+	}
+}
+
+func newAuthRouter(t *testing.T, allowAnonymous bool) (http.Handler, *testKeys, string, *poststore.PostStore) {
+	keys := newTestKeys(t)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	assert.NoError(t, os.WriteFile(path, keys.jwksDoc, 0600))
+	jwks, err := auth.LoadJWKS(path)
+	assert.NoError(t, err)
+	jwks.Issuer = "https://issuer.example"
+	jwks.Audience = "configurations"
+
+	s := newTestService()
+	ps := s.PostStore.(*poststore.PostStore)
+	fileKey := "cfk_file-key"
+	authenticator := &auth.Authenticator{
+		Store:          ps,
+		FileKeys:       map[string]*config.APIKey{auth.HashAPIKey(fileKey): {Subject: "ci", Hash: auth.HashAPIKey(fileKey)}},
+		JWKS:           jwks,
+		AllowAnonymous: allowAnonymous,
+		PublicPaths:    map[string]bool{"/swagger.yaml": true},
+	}
+	router := newTestRouter(s)
+	router.Use(authenticator.Middleware)
+	return router, keys, fileKey, ps
+}
+
+func TestAuthenticationRequiresCredentials(t *testing.T) {
+	router, keys, fileKey, ps := newAuthRouter(t, false)
+
+	rec := doRequest(router, "GET", "/configurations/api/1", nil, nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Header().Get("WWW-Authenticate"), "Bearer")
+
+	stored, apiKey, err := auth.NewAPIKey("deployer")
+	assert.NoError(t, err)
+	assert.NotEqual(t, stored, apiKey.Hash)
+	assert.NoError(t, ps.AddAPIKey(context.Background(), apiKey))
+
+	for name, headers := range map[string]map[string]string{
+		"file key header":   {"X-API-Key": fileKey},
+		"stored key bearer": {"Authorization": "Bearer " + stored},
+		"rsa token":         {"Authorization": "Bearer " + signJWT(t, keys.rsa, "rsa", validClaims("alice"))},
+		"ec token":          {"Authorization": "Bearer " + signJWT(t, keys.ec, "ec", validClaims("alice"))},
+		"ed25519 token":     {"Authorization": "Bearer " + signJWT(t, keys.ed, "", validClaims("alice"))},
+	} {
+		rec := doRequest(router, "GET", "/configurations/api/1", nil, headers)
+		assert.Equal(t, http.StatusNotFound, rec.Code, name)
+	}
+
+	expired := validClaims("alice")
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	wrongAudience := validClaims("alice")
+	wrongAudience["aud"] = "someone-else"
+	wrongIssuer := validClaims("alice")
+	wrongIssuer["iss"] = "https://evil.example"
+	noSubject := validClaims("")
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	for name, token := range map[string]string{
+		"expired":        signJWT(t, keys.ed, "ed", expired),
+		"wrong audience": signJWT(t, keys.ed, "ed", wrongAudience),
+		"wrong issuer":   signJWT(t, keys.ed, "ed", wrongIssuer),
+		"no subject":     signJWT(t, keys.ed, "ed", noSubject),
+		"unknown signer": signJWT(t, otherKey, "ed", validClaims("alice")),
+		"kid of rsa key": signJWT(t, keys.ed, "rsa", validClaims("alice")),
+		"alg none":       b64.EncodeToString([]byte(`{"alg":"none"}`)) + "." + b64.EncodeToString([]byte(`{"sub":"alice"}`)) + ".",
+		"unknown key":    "cfk_unknown",
+	} {
+		rec := doRequest(router, "GET", "/configurations/api/1", nil, map[string]string{"Authorization": "Bearer " + token})
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, name)
+		assert.Contains(t, rec.Header().Get("WWW-Authenticate"), "invalid_token", name)
+	}
+
+	rec = doRequest(router, "GET", "/configurations/api/1", nil, map[string]string{"Authorization": "Basic dXNlcjpwYXNz"})
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestAuthenticatedIdentityIsAudited(t *testing.T) {
+	router, keys, fileKey, _ := newAuthRouter(t, true)
+
+	token := signJWT(t, keys.ed, "ed", validClaims("alice"))
+	c := &config.Config{ID: "api", Version: "1"}
+	rec := doRequest(router, "POST", "/configurations", c, map[string]string{"Idempotency-Key": "auth-1", "Authorization": "Bearer " + token})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	c.Version = "2"
+	rec = doRequest(router, "POST", "/configurations", c, map[string]string{"Idempotency-Key": "auth-2", "X-API-Key": fileKey})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	// anonymous requests are allowed here, invalid credentials never are
+	c.Version = "3"
+	rec = doRequest(router, "POST", "/configurations", c, map[string]string{"Idempotency-Key": "auth-3"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = doRequest(router, "POST", "/configurations", c, map[string]string{"Idempotency-Key": "auth-4", "X-API-Key": "cfk_wrong"})
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	page := getAudit(t, router, "")
+	actors := make([]string, 0)
+	for _, record := range page.Records {
+		actors = append(actors, record.Actor)
+	}
+	assert.Equal(t, []string{"jwt:alice", "api_key:ci", "anonymous"}, actors)
+
+	page = getAudit(t, router, "?actor=jwt:alice")
+	assert.Len(t, page.Records, 1)
+}
+
+func TestLoadAPIKeysFile(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "keys.json")
+	os.WriteFile(valid, []byte(fmt.Sprintf(`[{"subject": "ci", "hash": %q}]`, auth.HashAPIKey("cfk_secret"))), 0600)
+	keys, err := auth.LoadAPIKeysFile(valid)
+	assert.NoError(t, err)
+	assert.Equal(t, "ci", keys[auth.HashAPIKey("cfk_secret")].Subject)
+
+	plain := filepath.Join(dir, "plain.json")
+	os.WriteFile(plain, []byte(`[{"subject": "ci", "hash": "cfk_secret"}]`), 0600)
+	_, err = auth.LoadAPIKeysFile(plain)
+	assert.Error(t, err)
+}
+
+// unavailableKeyStore fails every lookup like an unreachable Consul.
+type unavailableKeyStore struct{}
+
+func (unavailableKeyStore) GetAPIKey(ctx context.Context, hash string) (*config.APIKey, error) {
+	return nil, fmt.Errorf("Get \"http://10.0.0.5:8500/v1/kv/apikeys/%s\": connection refused", hash)
+}
+
+func TestUncheckedCredentialsAreNotRejectedAsInvalid(t *testing.T) {
+	authenticator := &auth.Authenticator{Store: unavailableKeyStore{}}
+	router := newTestRouter(newTestService())
+	router.Use(authenticator.Middleware)
+
+	rec := doRequest(router, "GET", "/configurations/api/1", nil, map[string]string{"X-API-Key": "cfk_deployer"})
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+	assert.Empty(t, rec.Header().Get("WWW-Authenticate"))
+	assert.NotContains(t, rec.Body.String(), "10.0.0.5")
+
+	r := httptest.NewRequest(http.MethodGet, "/configurations/api/1", nil)
+	r.Header.Set("X-API-Key", "cfk_deployer")
+	_, err := authenticator.Authenticate(r)
+	var internal *auth.InternalError
+	assert.ErrorAs(t, err, &internal)
+}
@@ -0,0 +1,45 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareVersions(t *testing.T) {
+	versions := []string{"10", "v1.2.0", "9", "1.2.0-rc.1", "1.10", "beta", "1.1", "alpha", "1.2.0-rc.10"}
+	config.SortVersions(versions)
+	assert.Equal(t, []string{"1.1", "1.2.0-rc.1", "1.2.0-rc.10", "v1.2.0", "1.10", "9", "10", "alpha", "beta"}, versions)
+}
+
+func TestListVersionsAndLatest(t *testing.T) {
+	router := newTestRouter(newTestService())
+
+	for _, v := range []string{"9", "10", "2"} {
+		c := config.Config{ID: "versioned", Version: v, Name: "version " + v}
+		rec := doRequest(router, http.MethodPost, "/configurations", c, map[string]string{"Idempotency-Key": "v" + v})
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	rec := doRequest(router, http.MethodGet, "/configurations/versioned", nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var versions []*config.VersionInfo
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&versions))
+	got := make([]string, 0)
+	for _, v := range versions {
+		got = append(got, v.Version)
+	}
+	assert.Equal(t, []string{"2", "9", "10"}, got)
+
+	rec = doRequest(router, http.MethodGet, "/configurations/versioned/latest", nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	latest := &config.Config{}
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(latest))
+	assert.Equal(t, "10", latest.Version)
+
+	rec = doRequest(router, http.MethodGet, "/configurations/missing/latest", nil, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
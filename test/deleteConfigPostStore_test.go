@@ -5,13 +5,13 @@ import (
 	"fmt"
 	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
 	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/poststore"
+	"github.com/hashicorp/consul/api"
 	"github.com/stretchr/testify/assert"
 	"testing"
 )
 
 func TestDeleteConfiguration(t *testing.T) {
-	ps, err := poststore.New()
-	assert.Nil(t, err)
+	ps := poststore.NewInMemory()
 	assert.NotNil(t, ps)
 
 	testConfig := &config.Config{
@@ -20,10 +20,10 @@ func TestDeleteConfiguration(t *testing.T) {
 		Name:    "Test Configuration",
 	}
 
-	err = ps.AddConfiguration(context.Background(), testConfig)
+	err := ps.AddConfiguration(context.Background(), testConfig, nil, nil)
 	assert.Nil(t, err)
 
-	err = ps.DeleteConfiguration(context.Background(), testConfig.ID, testConfig.Version)
+	err = ps.DeleteConfiguration(context.Background(), testConfig.ID, testConfig.Version, nil)
 	assert.Nil(t, err)
 
 	_, err = ps.GetConfiguration(context.Background(), testConfig.ID, testConfig.Version)
@@ -34,3 +34,30 @@ func TestDeleteConfiguration(t *testing.T) {
 	fmt.Println("TestDeleteConfiguration - Configuration Still Exists:", err != nil)
 	fmt.Println("TestDeleteConfiguration - Test Finished")
 }
+
+func TestDeleteConfigurationCASFailsOnceDeleted(t *testing.T) {
+	kv := poststore.NewMemoryKV()
+	ps := poststore.NewWithKV(kv)
+	ctx := context.Background()
+
+	c := &config.Config{ID: "gone", Version: "1"}
+	assert.Nil(t, ps.AddConfiguration(ctx, c, nil, nil))
+	stored, err := ps.GetConfiguration(ctx, "gone", "1")
+	assert.Nil(t, err)
+
+	quota := &poststore.QuotaChange{ConfigVersions: map[string]int{"gone": -1}}
+	assert.Nil(t, ps.DeleteConfigurationCAS(ctx, "gone", "1", stored.ModifyIndex, quota))
+
+	// a second delete with the same index must not succeed and count again,
+	// although a check-and-set delete of a missing key does, as in Consul
+	err = ps.DeleteConfigurationCAS(ctx, "gone", "1", stored.ModifyIndex, &poststore.QuotaChange{ConfigVersions: map[string]int{"gone": -1}})
+	assert.ErrorIs(t, err, poststore.ErrPreconditionFailed)
+	ok, _, err := kv.DeleteCAS(&api.KVPair{Key: "configurations/gone/1", ModifyIndex: stored.ModifyIndex}, nil)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	usage, err := ps.GetQuotaUsage(ctx)
+	assert.Nil(t, err)
+	assert.Zero(t, usage.Configs)
+	assert.Zero(t, usage.MaxVersionsPerConfig)
+}
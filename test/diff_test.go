@@ -0,0 +1,135 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffConfigurationVersions(t *testing.T) {
+	router := newTestRouter(newTestService())
+
+	v3 := &config.Config{ID: "app", Version: "3", Name: "app",
+		Entries: map[string]string{"host": "db1", "port": "5432", "debug": "true"},
+		Labels:  config.Labels{"env": "prod"}}
+	v4 := &config.Config{ID: "app", Version: "4", Name: "app",
+		Entries: map[string]string{"host": "db2", "port": "5432", "pool": "10"},
+		Labels:  config.Labels{"env": "prod", "team": "core"}}
+	for _, c := range []*config.Config{v3, v4} {
+		rec := doRequest(router, "POST", "/configurations", c, map[string]string{"Idempotency-Key": "diff-" + c.Version})
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	rec := doRequest(router, "GET", "/configurations/app/diff?from=3&to=latest", nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var diff config.ConfigDiff
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &diff))
+	assert.Equal(t, "4", diff.To)
+	assert.Nil(t, diff.Name)
+	assert.Equal(t, map[string]string{"pool": "10"}, diff.Entries.Added)
+	assert.Equal(t, map[string]string{"debug": "true"}, diff.Entries.Removed)
+	assert.Equal(t, map[string]config.ValueChange{"host": {From: "db1", To: "db2"}}, diff.Entries.Changed)
+	assert.Equal(t, map[string]string{"team": "core"}, diff.Labels.Added)
+
+	rec = doRequest(router, "GET", "/configurations/app/diff?from=3&to=4&format=unified", nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `--- configurations/app/3
++++ configurations/app/4
+@@ -1,5 +1,6 @@
+ name = "app"
+ labels.env = "prod"
++labels.team = "core"
+-entries.debug = "true"
+-entries.host = "db1"
++entries.host = "db2"
++entries.pool = "10"
+ entries.port = "5432"
+`, rec.Body.String())
+
+	rec = doRequest(router, "GET", "/configurations/app/diff?from=3&to=9", nil, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = doRequest(router, "GET", "/configurations/app/diff?from=3", nil, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestDiffConfigurationGroupVersions(t *testing.T) {
+	router := newTestRouter(newTestService())
+
+	v1 := []*config.Config{
+		{ID: "web", GroupID: "shop", Version: "1", Entries: map[string]string{"replicas": "2"}},
+		{ID: "cache", GroupID: "shop", Version: "1"},
+	}
+	v2 := []*config.Config{
+		{ID: "web", GroupID: "shop", Version: "2", Entries: map[string]string{"replicas": "4"}},
+		{ID: "db", GroupID: "shop", Version: "2"},
+	}
+	for i, group := range [][]*config.Config{v1, v2} {
+		rec := doRequest(router, "POST", "/group", group, map[string]string{"Idempotency-Key": "group-diff-" + group[0].Version})
+		assert.Equal(t, http.StatusOK, rec.Code, i)
+	}
+
+	rec := doRequest(router, "GET", "/group/shop/diff?from=1&to=2", nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var diff config.GroupDiff
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &diff))
+	assert.Equal(t, []string{"db"}, diff.Added)
+	assert.Equal(t, []string{"cache"}, diff.Removed)
+	if assert.Len(t, diff.Changed, 1) {
+		assert.Equal(t, "web", diff.Changed[0].ID)
+		assert.Equal(t, config.ValueChange{From: "2", To: "4"}, diff.Changed[0].Entries.Changed["replicas"])
+	}
+
+	rec = doRequest(router, "GET", "/group/shop/diff?from=1&to=2", nil, map[string]string{"Accept": "text/x-diff"})
+	assert.Equal(t, "text/x-diff; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), "-[cache]\n")
+	assert.Contains(t, rec.Body.String(), "+[db]\n")
+	assert.Contains(t, rec.Body.String(), "-entries.replicas = \"2\"\n+entries.replicas = \"4\"\n")
+
+	// latest resolves to the highest group version and is reported as such
+	rec = doRequest(router, "GET", "/group/shop/diff?from=1&to=latest", nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var latest config.GroupDiff
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &latest))
+	assert.Equal(t, "2", latest.To)
+	assert.Equal(t, diff.Added, latest.Added)
+	rec = doRequest(router, "GET", "/group/shop/diff?from=latest&to=latest", nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = doRequest(router, "GET", "/group/shop/diff?from=1&to=3", nil, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = doRequest(router, "GET", "/group/missing/diff?from=latest&to=1", nil, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestUnifiedDiffSplitsDistantChanges(t *testing.T) {
+	from := &config.Config{ID: "big", Version: "1", Entries: map[string]string{}}
+	to := &config.Config{ID: "big", Version: "2", Entries: map[string]string{}}
+	for _, k := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l"} {
+		from.Entries[k] = "1"
+		to.Entries[k] = "1"
+	}
+	to.Entries["a"] = "2"
+	to.Entries["l"] = "2"
+
+	assert.Equal(t, `--- configurations/big/1
++++ configurations/big/2
+@@ -1,5 +1,5 @@
+ name = ""
+-entries.a = "1"
++entries.a = "2"
+ entries.b = "1"
+ entries.c = "1"
+ entries.d = "1"
+@@ -10,4 +10,4 @@
+ entries.i = "1"
+ entries.j = "1"
+ entries.k = "1"
+-entries.l = "1"
++entries.l = "2"
+`, config.UnifiedConfigDiff(from, to))
+}
@@ -0,0 +1,60 @@
+package test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigurationETag(t *testing.T) {
+	router := newTestRouter(newTestService())
+
+	rec := doRequest(router, http.MethodPost, "/configurations", config.Config{ID: "tagged", Version: "1"}, map[string]string{"Idempotency-Key": "t1"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = doRequest(router, http.MethodGet, "/configurations/tagged/1", nil, nil)
+	tag := rec.Header().Get("ETag")
+	assert.NotEmpty(t, tag)
+
+	rec = doRequest(router, http.MethodGet, "/configurations/tagged/1", nil, map[string]string{"If-None-Match": tag})
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+	assert.Empty(t, rec.Body.String())
+
+	rec = doRequest(router, http.MethodDelete, "/configurations/tagged/1", nil, map[string]string{"If-Match": `"999"`})
+	assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+
+	rec = doRequest(router, http.MethodDelete, "/configurations/tagged/1", nil, map[string]string{"If-Match": tag})
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+}
+
+func TestGroupIfMatch(t *testing.T) {
+	router := newTestRouter(newTestService())
+
+	group := []config.Config{{ID: "a", GroupID: "etag", Version: "1"}}
+	rec := doRequest(router, http.MethodPost, "/group", group, map[string]string{"Idempotency-Key": "g1"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = doRequest(router, http.MethodGet, "/group/etag/1", nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	tag := rec.Header().Get("ETag")
+
+	// the first operator extends the group, the second one still holds the old ETag
+	rec = doRequest(router, http.MethodPost, "/group/etag/1/extend", []config.Config{{ID: "b"}}, map[string]string{"If-Match": tag})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = doRequest(router, http.MethodPost, "/group/etag/1/extend", []config.Config{{ID: "c"}}, map[string]string{"If-Match": tag})
+	assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+
+	rec = doRequest(router, http.MethodDelete, "/group/etag/1", nil, map[string]string{"If-Match": tag})
+	assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+
+	rec = doRequest(router, http.MethodGet, "/group/etag/1", nil, map[string]string{"If-None-Match": tag})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	newTag := rec.Header().Get("ETag")
+	assert.NotEqual(t, tag, newTag)
+
+	rec = doRequest(router, http.MethodDelete, "/group/etag/1", nil, map[string]string{"If-Match": newTag})
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+}
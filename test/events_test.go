@@ -0,0 +1,174 @@
+package test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/poststore"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/service"
+	"github.com/stretchr/testify/assert"
+)
+
+type sseEvent struct {
+	id    string
+	event string
+	data  *config.Event
+}
+
+// openEventStream connects to /events and returns a channel of the parsed
+// events. The stream is closed with the test.
+func openEventStream(t *testing.T, server *httptest.Server, query string, headers map[string]string) <-chan sseEvent {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/events"+query, nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	events := make(chan sseEvent, 16)
+	go func() {
+		defer resp.Body.Close()
+		defer close(events)
+		scanner := bufio.NewScanner(resp.Body)
+		var current sseEvent
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				current.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				current.event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				current.data = &config.Event{}
+				json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), current.data)
+			case line == "" && current.data != nil:
+				events <- current
+				current = sseEvent{}
+			}
+		}
+	}()
+	return events
+}
+
+func nextEvent(t *testing.T, events <-chan sseEvent) sseEvent {
+	select {
+	case e, ok := <-events:
+		if !ok {
+			t.Fatal("event stream closed")
+		}
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+	}
+	return sseEvent{}
+}
+
+func newEventServer(t *testing.T) (*service.Service, *httptest.Server) {
+	s := newTestService()
+	s.Events = service.NewEventBroker(s.PostStore)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go s.Events.Run(ctx)
+
+	server := httptest.NewServer(newTestRouter(s))
+	t.Cleanup(func() {
+		cancel()
+		server.Close()
+	})
+	// give the broker time to read the current position
+	time.Sleep(50 * time.Millisecond)
+	return s, server
+}
+
+func TestEventStreamDeliversFilteredChanges(t *testing.T) {
+	s, server := newEventServer(t)
+	router := newTestRouter(s)
+
+	events := openEventStream(t, server, "?group=dashboard&labels=env%3Dprod", nil)
+
+	group := []*config.Config{
+		{ID: "web", GroupID: "dashboard", Version: "1", Labels: config.Labels{"env": "prod"}},
+		{ID: "db", GroupID: "dashboard", Version: "1", Labels: config.Labels{"env": "dev"}},
+	}
+	doRequest(router, "POST", "/configurations", &config.Config{ID: "other", Version: "1"}, map[string]string{"Idempotency-Key": "events-1"})
+	rec := doRequest(router, "POST", "/group", group, map[string]string{"Idempotency-Key": "events-2"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	e := nextEvent(t, events)
+	assert.Equal(t, config.GroupCreated, e.event)
+	assert.Equal(t, "dashboard", e.data.GroupID)
+	if assert.Len(t, e.data.Configs, 1) {
+		assert.Equal(t, "web", e.data.Configs[0].ID)
+	}
+
+	rec = doRequest(router, "DELETE", "/group/dashboard/1", nil, nil)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	e = nextEvent(t, events)
+	assert.Equal(t, config.GroupDeleted, e.event)
+}
+
+func TestEventStreamResumesFromLastEventID(t *testing.T) {
+	s, server := newEventServer(t)
+	router := newTestRouter(s)
+
+	for i, id := range []string{"first", "second", "third"} {
+		rec := doRequest(router, "POST", "/configurations", &config.Config{ID: id, Version: "1"}, map[string]string{"Idempotency-Key": "resume-" + id})
+		assert.Equal(t, http.StatusOK, rec.Code, i)
+	}
+
+	all := openEventStream(t, server, "", map[string]string{"Last-Event-ID": "0"})
+	first := nextEvent(t, all)
+	assert.Equal(t, "first", first.data.Configs[0].ID)
+
+	resumed := openEventStream(t, server, "", map[string]string{"Last-Event-ID": first.id})
+	assert.Equal(t, "second", nextEvent(t, resumed).data.Configs[0].ID)
+	assert.Equal(t, "third", nextEvent(t, resumed).data.Configs[0].ID)
+
+	doRequest(router, "DELETE", "/configurations/second/1", nil, nil)
+	e := nextEvent(t, resumed)
+	assert.Equal(t, config.ConfigurationDeleted, e.event)
+	assert.Equal(t, "second", e.data.Configs[0].ID)
+}
+
+func TestEventStreamRejectsInvalidFilters(t *testing.T) {
+	_, server := newEventServer(t)
+
+	resp, err := http.Get(server.URL + "/events?id=..%2Fx&labels=%3D%3D")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestEventsAreTrimmedToTheRetention(t *testing.T) {
+	ps := poststore.NewInMemory()
+	ps.EventRetention = 20
+	ctx := context.Background()
+
+	var last uint64
+	for i := 0; i < 45; i++ {
+		event := config.NewEvent(config.ConfigurationCreated, "", "1", &config.Config{ID: "c"})
+		assert.Nil(t, ps.AppendEvent(ctx, event))
+		assert.Greater(t, event.ID, last)
+		last = event.ID
+	}
+
+	events, _, err := ps.WatchEvents(ctx, 0, 0, 0)
+	assert.Nil(t, err)
+	// trimmed after the 44th append, every other append
+	assert.Len(t, events, 21)
+	assert.Equal(t, last, events[len(events)-1].ID)
+}
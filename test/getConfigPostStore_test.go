@@ -11,8 +11,7 @@ import (
 )
 
 func TestGetConfiguration(t *testing.T) {
-	ps, err := poststore.New()
-	assert.Nil(t, err)
+	ps := poststore.NewInMemory()
 	assert.NotNil(t, ps)
 
 	testConfig := &config.Config{
@@ -21,7 +20,7 @@ func TestGetConfiguration(t *testing.T) {
 		Name:    "Test Configuration",
 	}
 
-	err = ps.AddConfiguration(context.Background(), testConfig)
+	err := ps.AddConfiguration(context.Background(), testConfig, nil, nil)
 	assert.Nil(t, err)
 
 	retrievedConfig, err := ps.GetConfiguration(context.Background(), testConfig.ID, testConfig.Version)
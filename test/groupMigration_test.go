@@ -0,0 +1,53 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/poststore"
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrateGroups(t *testing.T) {
+	kv := poststore.NewMemoryKV()
+	ps := poststore.NewWithKV(kv)
+	ctx := context.Background()
+
+	legacy := map[string]string{
+		"groups/g/1":        `{"id":"first","group_id":"g","version":"1"}`,
+		"groups/g/1/second": `{"id":"second","group_id":"g","version":"1"}`,
+		"groups/g/2/third":  `{"group_id":"g","version":"2"}`,
+	}
+	for key, value := range legacy {
+		_, err := kv.Put(&api.KVPair{Key: key, Value: []byte(value)}, nil)
+		assert.Nil(t, err)
+	}
+
+	moved, err := ps.MigrateGroups(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, moved)
+
+	manifest, err := ps.GetGroupManifest(ctx, "g", "1")
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"first", "second"}, manifest.Members)
+
+	configs, err := ps.GetConfigurationGroup(ctx, "g", "2")
+	assert.Nil(t, err)
+	assert.Len(t, configs, 1)
+	assert.Equal(t, "third", configs[0].ID)
+
+	keys, _, err := kv.Keys("groups/", "", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"groups/g/1/manifest",
+		"groups/g/1/members/first",
+		"groups/g/1/members/second",
+		"groups/g/2/manifest",
+		"groups/g/2/members/third",
+	}, keys)
+
+	moved, err = ps.MigrateGroups(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, moved)
+}
@@ -0,0 +1,84 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/poststore"
+	"github.com/stretchr/testify/assert"
+)
+
+func newGroup(id, version string, size int) []*config.Config {
+	configs := make([]*config.Config, 0, size)
+	for i := 0; i < size; i++ {
+		configs = append(configs, &config.Config{ID: fmt.Sprintf("member-%03d", i), GroupID: id, Version: version})
+	}
+	return configs
+}
+
+func TestLargeGroupIsWrittenAtomically(t *testing.T) {
+	ps := poststore.NewInMemory()
+	ctx := context.Background()
+
+	idempotency := &poststore.IdempotencyEntry{
+		Scope:  "group",
+		Key:    "big",
+		Record: &poststore.IdempotencyRecord{RequestHash: "h", StatusCode: 200},
+	}
+	err := ps.AddConfigurationGroup(ctx, newGroup("big", "1", 150), nil, idempotency, nil)
+	assert.Nil(t, err)
+
+	configs, err := ps.GetConfigurationGroup(ctx, "big", "1")
+	assert.Nil(t, err)
+	assert.Len(t, configs, 150)
+
+	record, err := ps.GetIdempotencyRecord(ctx, "group", "big")
+	assert.Nil(t, err)
+	assert.NotNil(t, record)
+}
+
+func TestFailedGroupLeavesNothingBehind(t *testing.T) {
+	ps := poststore.NewInMemory()
+	ctx := context.Background()
+
+	// the last member already exists, so the final chunk fails after the
+	// first two chunks were committed
+	configs := newGroup("partial", "1", 150)
+	assert.Nil(t, ps.AddConfigurationGroup(ctx, configs[149:], nil, nil, nil))
+
+	idempotency := &poststore.IdempotencyEntry{
+		Scope:  "group",
+		Key:    "partial",
+		Record: &poststore.IdempotencyRecord{RequestHash: "h", StatusCode: 200},
+	}
+	err := ps.AddConfigurationGroup(ctx, configs, nil, idempotency, nil)
+	assert.True(t, errors.Is(err, poststore.ErrVersionExists))
+
+	stored, err := ps.GetConfigurationGroup(ctx, "partial", "1")
+	assert.Nil(t, err)
+	assert.Len(t, stored, 1)
+
+	record, err := ps.GetIdempotencyRecord(ctx, "group", "partial")
+	assert.Nil(t, err)
+	assert.Nil(t, record)
+}
+
+func TestExtendConfigurationGroup(t *testing.T) {
+	ps := poststore.NewInMemory()
+	ctx := context.Background()
+
+	assert.Nil(t, ps.AddConfigurationGroup(ctx, newGroup("ext", "1", 2), nil, nil, nil))
+
+	extra := []*config.Config{{ID: "extra", GroupID: "ext", Version: "1"}}
+	assert.Nil(t, ps.ExtendConfigurationGroup(ctx, "ext", "1", extra, 0, nil))
+
+	err := ps.ExtendConfigurationGroup(ctx, "ext", "1", extra, 0, nil)
+	assert.True(t, errors.Is(err, poststore.ErrVersionExists))
+
+	manifest, err := ps.GetGroupManifest(ctx, "ext", "1")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"member-000", "member-001", "extra"}, manifest.Members)
+}
@@ -0,0 +1,68 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/poststore"
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotencyRecordsExpire(t *testing.T) {
+	kv := poststore.NewMemoryKV()
+	ps := poststore.NewWithKV(kv)
+	ps.IdempotencyTTL = time.Hour
+	ctx := context.Background()
+
+	old := &poststore.IdempotencyRecord{RequestHash: "h", StatusCode: 200, CreatedAt: time.Now().Add(-2 * time.Hour)}
+	assert.Nil(t, ps.SaveIdempotencyRecord(ctx, "configurations", "old", old))
+	fresh := &poststore.IdempotencyRecord{RequestHash: "h", StatusCode: 200}
+	assert.Nil(t, ps.SaveIdempotencyRecord(ctx, "configurations", "fresh", fresh))
+	// value written by the previous key format
+	_, err := kv.Put(&api.KVPair{Key: "idempotency/legacy", Value: []byte{}}, nil)
+	assert.Nil(t, err)
+
+	record, err := ps.GetIdempotencyRecord(ctx, "configurations", "old")
+	assert.Nil(t, err)
+	assert.Nil(t, record)
+
+	record, err = ps.GetIdempotencyRecord(ctx, "configurations", "fresh")
+	assert.Nil(t, err)
+	assert.NotNil(t, record)
+
+	removed, err := ps.SweepIdempotencyRecords(ctx, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, removed)
+
+	keys, _, err := kv.Keys("idempotency/", "", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"idempotency/configurations/fresh"}, keys)
+}
+
+func TestIdempotencySweepPagesThroughRecords(t *testing.T) {
+	ps := poststore.NewInMemory()
+	ps.IdempotencyTTL = time.Hour
+	ctx := context.Background()
+
+	// more records than fit in one transaction
+	for i := 0; i < 150; i++ {
+		old := &poststore.IdempotencyRecord{RequestHash: "h", StatusCode: 200, CreatedAt: time.Now().Add(-2 * time.Hour)}
+		assert.Nil(t, ps.SaveIdempotencyRecord(ctx, "group", fmt.Sprintf("old-%d", i), old))
+	}
+	fresh := &poststore.IdempotencyRecord{RequestHash: "h", StatusCode: 200}
+	assert.Nil(t, ps.SaveIdempotencyRecord(ctx, "group", "fresh", fresh))
+
+	removed, err := ps.SweepIdempotencyRecords(ctx, 100)
+	assert.Nil(t, err)
+	assert.Equal(t, 150, removed)
+
+	record, err := ps.GetIdempotencyRecord(ctx, "group", "fresh")
+	assert.Nil(t, err)
+	assert.NotNil(t, record)
+	removed, err = ps.SweepIdempotencyRecords(ctx, 100)
+	assert.Nil(t, err)
+	assert.Zero(t, removed)
+}
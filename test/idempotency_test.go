@@ -0,0 +1,91 @@
+package test
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/poststore"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotentReplay(t *testing.T) {
+	router := newTestRouter(newTestService())
+	headers := map[string]string{"Idempotency-Key": "same-key"}
+
+	c := config.Config{ID: "idem", Version: "1", Name: "first"}
+	first := doRequest(router, http.MethodPost, "/configurations", c, headers)
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.Empty(t, first.Header().Get("Idempotent-Replayed"))
+
+	replay := doRequest(router, http.MethodPost, "/configurations", c, headers)
+	assert.Equal(t, http.StatusOK, replay.Code)
+	assert.Equal(t, "true", replay.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, first.Body.String(), replay.Body.String())
+
+	c.Name = "changed"
+	mismatch := doRequest(router, http.MethodPost, "/configurations", c, headers)
+	assert.Equal(t, http.StatusUnprocessableEntity, mismatch.Code)
+}
+
+func TestIdempotencyKeysAreScopedPerEndpoint(t *testing.T) {
+	router := newTestRouter(newTestService())
+	headers := map[string]string{"Idempotency-Key": "shared"}
+
+	rec := doRequest(router, http.MethodPost, "/configurations", config.Config{ID: "c", Version: "1"}, headers)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = doRequest(router, http.MethodPost, "/group", []config.Config{{ID: "c", GroupID: "g", Version: "1"}}, headers)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("Idempotent-Replayed"))
+}
+
+func TestConcurrentRetriesCreateOneConfiguration(t *testing.T) {
+	router := newTestRouter(newTestService())
+	headers := map[string]string{"Idempotency-Key": "racing"}
+
+	// without an id every request would pick its own
+	c := config.Config{Version: "1", Name: "raced"}
+	bodies := make([]string, 20)
+	var wg sync.WaitGroup
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rec := doRequest(router, http.MethodPost, "/configurations", c, headers)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			bodies[i] = rec.Body.String()
+		}(i)
+	}
+	wg.Wait()
+
+	for _, body := range bodies {
+		assert.Equal(t, bodies[0], body)
+	}
+}
+
+func TestIdempotencyRecordIsCreatedWithTheWrite(t *testing.T) {
+	ps := poststore.NewInMemory()
+	ps.IdempotencyTTL = time.Hour
+	ctx := context.Background()
+	entry := func() *poststore.IdempotencyEntry {
+		return &poststore.IdempotencyEntry{Scope: "configurations", Key: "k", Record: &poststore.IdempotencyRecord{RequestHash: "h", StatusCode: 200}}
+	}
+
+	// an expired record is replaced
+	old := &poststore.IdempotencyRecord{RequestHash: "old", StatusCode: 200, CreatedAt: time.Now().Add(-2 * time.Hour)}
+	assert.Nil(t, ps.SaveIdempotencyRecord(ctx, "configurations", "k", old))
+	assert.Nil(t, ps.AddConfiguration(ctx, &config.Config{ID: "a", Version: "1"}, entry(), nil))
+	record, err := ps.GetIdempotencyRecord(ctx, "configurations", "k")
+	assert.Nil(t, err)
+	assert.Equal(t, "h", record.RequestHash)
+
+	// a valid one stops the write
+	err = ps.AddConfiguration(ctx, &config.Config{ID: "b", Version: "1"}, entry(), nil)
+	assert.ErrorIs(t, err, poststore.ErrIdempotencyKeyUsed)
+	_, err = ps.GetConfiguration(ctx, "b", "1")
+	assert.ErrorIs(t, err, poststore.ErrNotFound)
+}
@@ -0,0 +1,62 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/poststore"
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSelector(t *testing.T) {
+	labels := config.Labels{"env": "prod", "team": "core", "tier": "web"}
+
+	cases := map[string]bool{
+		"env=prod":               true,
+		"env==prod,team=core":    true,
+		"team=core,env=prod":     true,
+		"team!=legacy":           true,
+		"tier in (web,api)":      true,
+		"tier notin (web,api)":   false,
+		"env=prod,tier in (api)": false,
+		"team":                   true,
+		"!deprecated":            true,
+		"!team":                  false,
+		"team:core;env:prod":     true,
+		"env:prod;team:legacy":   false,
+		"env=prod,team!=legacy,tier in (web, api)": true,
+	}
+	for s, want := range cases {
+		selector, err := config.ParseSelector(s)
+		assert.Nil(t, err, s)
+		assert.Equal(t, want, selector.Matches(labels), s)
+	}
+
+	for _, s := range []string{"tier in (web", "tier in ()", "=prod", "a b"} {
+		_, err := config.ParseSelector(s)
+		assert.NotNil(t, err, s)
+	}
+}
+
+func TestLegacyLabelsOnRead(t *testing.T) {
+	kv := poststore.NewMemoryKV()
+	ps := poststore.NewWithKV(kv)
+
+	legacy := `{"id":"c1","group_id":"g","version":"1","labels":"env:prod;team:core"}`
+	_, err := kv.Put(&api.KVPair{Key: "groups/g/1", Value: []byte(legacy)}, nil)
+	assert.Nil(t, err)
+
+	moved, err := ps.MigrateGroups(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, moved)
+
+	selector, err := config.ParseSelector("team=core,env=prod")
+	assert.Nil(t, err)
+
+	configs, err := ps.GetConfigurationGroupsByLabels(context.Background(), "g", "1", selector)
+	assert.Nil(t, err)
+	assert.Len(t, configs, 1)
+	assert.Equal(t, config.Labels{"env": "prod", "team": "core"}, configs[0].Labels)
+}
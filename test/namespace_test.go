@@ -0,0 +1,123 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/metrics"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/namespace"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/poststore"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamespacesSeparateConfigurations(t *testing.T) {
+	s := newTestService()
+	ps := s.PostStore.(*poststore.PostStore)
+	router := namespace.Middleware(newTestRouter(s))
+
+	shared := &config.Config{ID: "api", Version: "1", Entries: map[string]string{"owner": "default"}}
+	rec := doRequest(router, "POST", "/configurations", shared, map[string]string{"Idempotency-Key": "ns-1"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// the same ID, version and Idempotency-Key don't collide in another namespace
+	teamA := &config.Config{ID: "api", Version: "1", Entries: map[string]string{"owner": "team-a"}}
+	rec = doRequest(router, "POST", "/namespaces/team-a/configurations", teamA, map[string]string{"Idempotency-Key": "ns-1"})
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	for url, owner := range map[string]string{
+		"/configurations/api/1":                    "default",
+		"/namespaces/default/configurations/api/1": "default",
+		"/namespaces/team-a/configurations/api/1":  "team-a",
+	} {
+		rec = doRequest(router, "GET", url, nil, nil)
+		assert.Equal(t, http.StatusOK, rec.Code, url)
+		c := &config.Config{}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), c))
+		assert.Equal(t, owner, c.Entries["owner"], url)
+	}
+	rec = doRequest(router, "GET", "/configurations/api/1", nil, map[string]string{namespace.Header: "team-a"})
+	assert.Contains(t, rec.Body.String(), "team-a")
+	rec = doRequest(router, "GET", "/namespaces/team-b/configurations/api/1", nil, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// team-a deleting its configuration leaves the default one alone
+	rec = doRequest(router, "DELETE", "/namespaces/team-a/configurations/api/1", nil, nil)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	_, err := ps.GetConfiguration(context.Background(), "api", "1")
+	assert.NoError(t, err)
+	_, err = ps.GetConfiguration(namespace.With(context.Background(), "team-a"), "api", "1")
+	assert.ErrorIs(t, err, poststore.ErrNotFound)
+
+	// the default namespace keeps the keys from before namespaces
+	rec = doRequest(router, "POST", "/namespaces/team-a/group", newGroup("fleet", "1", 2), map[string]string{"Idempotency-Key": "ns-2"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	members, err := ps.GetConfigurationGroup(context.Background(), "fleet", "1")
+	assert.NoError(t, err)
+	assert.Empty(t, members)
+	rec = doRequest(router, "GET", "/group/fleet/1", nil, map[string]string{namespace.Header: "team-a"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = doRequest(router, "POST", "/namespaces/team-a/group/fleet/1/promote?to=2", nil, map[string]string{"Idempotency-Key": "ns-3"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "/namespaces/team-a/group/fleet/2", rec.Header().Get("Location"))
+}
+
+func TestNamespaceIsValidated(t *testing.T) {
+	router := namespace.Middleware(newTestRouter(newTestService()))
+
+	rec := doRequest(router, "GET", "/namespaces/team-a/configurations/api/1", nil, map[string]string{namespace.Header: "team-b"})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	for _, ns := range []string{"Team-A", "team_a", "-team", "..", "a%2Fb"} {
+		rec = doRequest(router, "GET", "/configurations/api/1", nil, map[string]string{namespace.Header: ns})
+		assert.Equal(t, http.StatusBadRequest, rec.Code, ns)
+	}
+}
+
+func TestNamespaceOfAuditRecordsAndWebhooks(t *testing.T) {
+	router := namespace.Middleware(newTestRouter(newTestService()))
+
+	c := &config.Config{ID: "api", Version: "1"}
+	rec := doRequest(router, "POST", "/configurations", c, map[string]string{"Idempotency-Key": "ns-audit-1"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = doRequest(router, "POST", "/namespaces/team-a/configurations", c, map[string]string{"Idempotency-Key": "ns-audit-1"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	page := getAudit(t, router, "")
+	assert.Len(t, page.Records, 2)
+	assert.Equal(t, "default", page.Records[0].Namespace)
+	assert.Equal(t, "team-a", page.Records[1].Namespace)
+	page = getAudit(t, router, "?namespace=team-a")
+	assert.Len(t, page.Records, 1)
+
+	webhook := &config.Webhook{URL: "http://receiver.example/hook", Namespace: "default"}
+	rec = doRequest(router, "POST", "/namespaces/team-a/webhooks", webhook, nil)
+	assert.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	created := &config.Webhook{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), created))
+	assert.Equal(t, "team-a", created.Namespace)
+
+	rec = doRequest(router, "GET", "/webhooks", nil, nil)
+	assert.JSONEq(t, "[]", rec.Body.String())
+	rec = doRequest(router, "GET", "/webhooks/"+created.ID, nil, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = doRequest(router, "GET", "/webhooks/"+created.ID, nil, map[string]string{namespace.Header: "team-a"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestOnlyKnownNamespacesAreMetricLabels(t *testing.T) {
+	assert.Equal(t, namespace.Default, metrics.Namespace(namespace.Default))
+	assert.Equal(t, metrics.OtherNamespace, metrics.Namespace("made-up"))
+
+	s := newTestService()
+	s.Quotas = &service.Quotas{Namespaces: map[string]config.QuotaLimits{"with-quota": {MaxConfigs: 1}}}
+	assert.Nil(t, s.AddMetricNamespaces(context.Background()))
+	assert.Equal(t, "with-quota", metrics.Namespace("with-quota"))
+
+	router := newRBACRouter()
+	bind(t, router, config.RoleBinding{Subject: "api_key:dev", Role: "reader", Namespace: "with-binding"})
+	assert.Equal(t, "with-binding", metrics.Namespace("with-binding"))
+}
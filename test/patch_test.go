@@ -0,0 +1,176 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func patchHeaders(contentType, key string) map[string]string {
+	return map[string]string{"Content-Type": contentType, "Idempotency-Key": key}
+}
+
+func addPatchBase(t *testing.T, router http.Handler) {
+	base := &config.Config{ID: "svc", Version: "1.0", Name: "svc",
+		Entries: map[string]string{"host": "db1", "port": "5432"},
+		Labels:  config.Labels{"env": "prod"}}
+	rec := doRequest(router, "POST", "/configurations", base, map[string]string{"Idempotency-Key": "patch-base"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestJSONPatchCreatesNextVersion(t *testing.T) {
+	s := newTestService()
+	router := newTestRouter(s)
+	addPatchBase(t, router)
+
+	patch := []map[string]interface{}{
+		{"op": "test", "path": "/entries/host", "value": "db1"},
+		{"op": "replace", "path": "/entries/host", "value": "db2"},
+		{"op": "remove", "path": "/entries/port"},
+		{"op": "add", "path": "/labels/team", "value": "core"},
+	}
+	rec := doRequest(router, "PATCH", "/configurations/svc/1.0", patch, patchHeaders("application/json-patch+json", "patch-1"))
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, "/configurations/svc/1.1", rec.Header().Get("Location"))
+
+	var patched config.Config
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &patched))
+	assert.Equal(t, "1.1", patched.Version)
+	assert.Equal(t, map[string]string{"host": "db2"}, patched.Entries)
+	assert.Equal(t, config.Labels{"env": "prod", "team": "core"}, patched.Labels)
+
+	// the base version is untouched
+	base, err := s.PostStore.GetConfiguration(context.Background(), "svc", "1.0")
+	assert.NoError(t, err)
+	assert.Equal(t, "db1", base.Entries["host"])
+
+	// a retry replays the response instead of creating 1.2
+	rec = doRequest(router, "PATCH", "/configurations/svc/1.0", patch, patchHeaders("application/json-patch+json", "patch-1"))
+	assert.Equal(t, "true", rec.Header().Get("Idempotent-Replayed"))
+	versions, _ := s.PostStore.ListConfigurationVersions(context.Background(), "svc")
+	assert.Len(t, versions, 2)
+}
+
+func TestConcurrentPatchRetriesCreateOneVersion(t *testing.T) {
+	s := newTestService()
+	router := newTestRouter(s)
+	addPatchBase(t, router)
+
+	patch := map[string]interface{}{"entries": map[string]interface{}{"host": "db3"}}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := doRequest(router, "PATCH", "/configurations/svc/1.0", patch, patchHeaders("application/merge-patch+json", "racing-patch"))
+			assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		}()
+	}
+	wg.Wait()
+
+	versions, _ := s.PostStore.ListConfigurationVersions(context.Background(), "svc")
+	assert.Len(t, versions, 2)
+}
+
+func TestMergePatchWithExplicitVersion(t *testing.T) {
+	router := newTestRouter(newTestService())
+	addPatchBase(t, router)
+
+	patch := map[string]interface{}{
+		"name":    "renamed",
+		"entries": map[string]interface{}{"port": nil, "pool": "10"},
+	}
+	rec := doRequest(router, "PATCH", "/configurations/svc/latest?to=2.0", patch, patchHeaders("application/merge-patch+json", "merge-1"))
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var patched config.Config
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &patched))
+	assert.Equal(t, "2.0", patched.Version)
+	assert.Equal(t, "renamed", patched.Name)
+	assert.Equal(t, map[string]string{"host": "db1", "pool": "10"}, patched.Entries)
+
+	rec = doRequest(router, "PATCH", "/configurations/svc/1.0?to=2.0", patch, patchHeaders("application/merge-patch+json", "merge-2"))
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = doRequest(router, "PATCH", "/configurations/svc/1.0", map[string]interface{}{"version": "9"}, patchHeaders("application/merge-patch+json", "merge-3"))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "version")
+}
+
+func TestJSONPatchReportsErrorsPerOperation(t *testing.T) {
+	router := newTestRouter(newTestService())
+	addPatchBase(t, router)
+
+	patch := []map[string]interface{}{
+		{"op": "add", "path": "/entries/ok", "value": "1"},
+		{"op": "jump", "path": "/entries/x"},
+		{"op": "replace", "path": "/id", "value": "other"},
+		{"op": "add", "path": "entries"},
+	}
+	rec := doRequest(router, "PATCH", "/configurations/svc/1.0", patch, patchHeaders("application/json-patch+json", "bad-1"))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	var response struct {
+		Operations []config.PatchError `json:"operations"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	indexes := make([]int, 0)
+	for _, op := range response.Operations {
+		indexes = append(indexes, op.Index)
+	}
+	assert.Equal(t, []int{1, 2, 3, 3}, indexes)
+
+	patch = []map[string]interface{}{
+		{"op": "add", "path": "/entries/ok", "value": "1"},
+		{"op": "test", "path": "/entries/host", "value": "db9"},
+	}
+	rec = doRequest(router, "PATCH", "/configurations/svc/1.0", patch, patchHeaders("application/json-patch+json", "bad-2"))
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	if assert.Len(t, response.Operations, 1) {
+		assert.Equal(t, 1, response.Operations[0].Index)
+		assert.Contains(t, response.Operations[0].Message, "test failed")
+	}
+
+	rec = doRequest(router, "PATCH", "/configurations/svc/1.0", patch, patchHeaders("application/json", "bad-3"))
+	assert.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+}
+
+func TestApplyJSONPatchArrays(t *testing.T) {
+	doc := []byte(`{"list":[1,2,3],"nested":{"a":{"b":"c"}}}`)
+	ops, problems, err := config.ParseJSONPatch([]byte(`[
+		{"op":"add","path":"/list/1","value":9},
+		{"op":"add","path":"/list/-","value":4},
+		{"op":"remove","path":"/list/0"},
+		{"op":"move","from":"/nested/a/b","path":"/moved"},
+		{"op":"copy","from":"/list","path":"/copy"}
+	]`))
+	assert.NoError(t, err)
+	assert.Empty(t, problems)
+
+	result, problem := config.ApplyJSONPatch(doc, ops)
+	assert.Nil(t, problem)
+	assert.JSONEq(t, `{"list":[9,2,3,4],"nested":{"a":{}},"moved":"c","copy":[9,2,3,4]}`, string(result))
+
+	ops, _, _ = config.ParseJSONPatch([]byte(`[{"op":"remove","path":"/list/7"}]`))
+	_, problem = config.ApplyJSONPatch(doc, ops)
+	if assert.NotNil(t, problem) {
+		assert.Equal(t, 0, problem.Index)
+		assert.Contains(t, problem.Message, "out of range")
+	}
+}
+
+func TestNextVersion(t *testing.T) {
+	for version, expected := range map[string]string{"1": "2", "1.2.3": "1.2.4", "v7": "v8", "2.0-rc.1": "2.0"} {
+		next, ok := config.NextVersion(version)
+		assert.True(t, ok, version)
+		assert.Equal(t, expected, next, version)
+	}
+	_, ok := config.NextVersion("stable")
+	assert.False(t, ok)
+}
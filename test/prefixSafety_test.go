@@ -0,0 +1,84 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/poststore"
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakeKVMatchesPrefixesLikeConsul(t *testing.T) {
+	kv := poststore.NewMemoryKV()
+	for _, key := range []string{"groups/g/1/manifest", "groups/g/10/manifest"} {
+		_, err := kv.Put(&api.KVPair{Key: key}, nil)
+		assert.Nil(t, err)
+	}
+
+	// a bare prefix crosses the version boundary, which is what PostStore
+	// must never rely on
+	keys, _, err := kv.Keys("groups/g/1", "", nil)
+	assert.Nil(t, err)
+	assert.Len(t, keys, 2)
+}
+
+func TestDeleteGroupLeavesSimilarVersionsUntouched(t *testing.T) {
+	kv := poststore.NewMemoryKV()
+	ps := poststore.NewWithKV(kv)
+	ctx := context.Background()
+
+	for _, version := range []string{"1", "10", "11"} {
+		err := ps.AddConfigurationGroup(ctx, []*config.Config{{ID: "c" + version, GroupID: "g", Version: version}}, nil, nil, nil)
+		assert.Nil(t, err)
+	}
+
+	configs, err := ps.GetConfigurationGroup(ctx, "g", "1")
+	assert.Nil(t, err)
+	assert.Len(t, configs, 1)
+
+	assert.Nil(t, ps.DeleteConfigurationGroup(ctx, "g", "1", nil))
+
+	for _, version := range []string{"10", "11"} {
+		configs, err := ps.GetConfigurationGroup(ctx, "g", version)
+		assert.Nil(t, err)
+		assert.Len(t, configs, 1, version)
+	}
+
+	keys, _, err := kv.Keys("groups/g/1/", "", nil)
+	assert.Nil(t, err)
+	assert.Empty(t, keys)
+}
+
+func TestGroupIDsArePrefixSafe(t *testing.T) {
+	ps := poststore.NewInMemory()
+	ctx := context.Background()
+
+	assert.Nil(t, ps.AddConfigurationGroup(ctx, []*config.Config{{ID: "x", GroupID: "a", Version: "1", Labels: config.Labels{"env": "prod"}}}, nil, nil, nil))
+	assert.Nil(t, ps.AddConfigurationGroup(ctx, []*config.Config{{ID: "y", GroupID: "ab", Version: "1", Labels: config.Labels{"env": "prod"}}}, nil, nil, nil))
+
+	selector, err := config.ParseSelector("env=prod")
+	assert.Nil(t, err)
+	configs, err := ps.GetConfigurationGroupsByLabels(ctx, "a", "1", selector)
+	assert.Nil(t, err)
+	assert.Len(t, configs, 1)
+	assert.Equal(t, "x", configs[0].ID)
+
+	assert.Nil(t, ps.DeleteConfigurationGroup(ctx, "a", "1", nil))
+	configs, err = ps.GetConfigurationGroup(ctx, "ab", "1")
+	assert.Nil(t, err)
+	assert.Len(t, configs, 1)
+}
+
+func TestConfigurationVersionsArePrefixSafe(t *testing.T) {
+	ps := poststore.NewInMemory()
+	ctx := context.Background()
+
+	assert.Nil(t, ps.AddConfiguration(ctx, &config.Config{ID: "a", Version: "1"}, nil, nil))
+	assert.Nil(t, ps.AddConfiguration(ctx, &config.Config{ID: "ab", Version: "1"}, nil, nil))
+
+	versions, err := ps.ListConfigurationVersions(ctx, "a")
+	assert.Nil(t, err)
+	assert.Len(t, versions, 1)
+}
@@ -0,0 +1,108 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPromoteConfigurationRollsBack(t *testing.T) {
+	s := newTestService()
+	router := newTestRouter(s)
+
+	for _, c := range []*config.Config{
+		{ID: "api", Version: "1", Entries: map[string]string{"timeout": "5s"}},
+		{ID: "api", Version: "2", Entries: map[string]string{"timeout": "1ms"}},
+	} {
+		rec := doRequest(router, "POST", "/configurations", c, map[string]string{"Idempotency-Key": "promote-" + c.Version})
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	headers := map[string]string{"Idempotency-Key": "rollback-1"}
+	rec := doRequest(router, "POST", "/configurations/api/1/promote", nil, headers)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, "/configurations/api/3", rec.Header().Get("Location"))
+
+	var promoted config.Config
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &promoted))
+	assert.Equal(t, "3", promoted.Version)
+	assert.Equal(t, "1", promoted.PromotedFrom)
+	assert.Equal(t, "5s", promoted.Entries["timeout"])
+
+	rec = doRequest(router, "GET", "/configurations/api/latest", nil, nil)
+	assert.Contains(t, rec.Body.String(), `"promoted_from":"1"`)
+
+	// a retry replays instead of promoting again
+	rec = doRequest(router, "POST", "/configurations/api/1/promote", nil, headers)
+	assert.Equal(t, "true", rec.Header().Get("Idempotent-Replayed"))
+	versions, _ := s.PostStore.ListConfigurationVersions(context.Background(), "api")
+	assert.Len(t, versions, 3)
+
+	rec = doRequest(router, "POST", "/configurations/api/2/promote?to=3", nil, map[string]string{"Idempotency-Key": "rollback-2"})
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	rec = doRequest(router, "POST", "/configurations/api/9/promote", nil, map[string]string{"Idempotency-Key": "rollback-3"})
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = doRequest(router, "POST", "/configurations/api/1/promote", nil, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestConcurrentPromoteRetriesCreateOneVersion(t *testing.T) {
+	s := newTestService()
+	router := newTestRouter(s)
+	rec := doRequest(router, "POST", "/configurations", config.Config{ID: "api", Version: "1"}, map[string]string{"Idempotency-Key": "promote-base"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := doRequest(router, "POST", "/configurations/api/1/promote", nil, map[string]string{"Idempotency-Key": "racing-promote"})
+			assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		}()
+	}
+	wg.Wait()
+
+	versions, _ := s.PostStore.ListConfigurationVersions(context.Background(), "api")
+	assert.Len(t, versions, 2)
+}
+
+func TestPromoteConfigurationGroup(t *testing.T) {
+	s := newTestService()
+	router := newTestRouter(s)
+
+	rec := doRequest(router, "POST", "/group", newGroup("fleet", "1.0", 3), map[string]string{"Idempotency-Key": "fleet-1"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = doRequest(router, "POST", "/group", newGroup("fleet", "1.1", 1), map[string]string{"Idempotency-Key": "fleet-2"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = doRequest(router, "POST", "/group/fleet/1.0/promote", nil, map[string]string{"Idempotency-Key": "fleet-rollback"})
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, "/group/fleet/1.2", rec.Header().Get("Location"))
+
+	manifest, members, err := s.PostStore.GetGroup(context.Background(), "fleet", "1.2")
+	assert.NoError(t, err)
+	assert.Equal(t, "1.0", manifest.PromotedFrom)
+	assert.Len(t, members, 3)
+	for _, m := range members {
+		assert.Equal(t, "1.0", m.PromotedFrom)
+		assert.Equal(t, "1.2", m.Version)
+	}
+
+	versions, err := s.PostStore.ListGroupVersions(context.Background(), "fleet")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1.0", "1.1", "1.2"}, versions)
+}
+
+func TestPromotedFromCantBeSetByClients(t *testing.T) {
+	router := newTestRouter(newTestService())
+
+	rec := doRequest(router, "POST", "/configurations", &config.Config{ID: "x", Version: "1", PromotedFrom: "0"}, map[string]string{"Idempotency-Key": "forged"})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "promoted_from")
+}
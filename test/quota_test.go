@@ -0,0 +1,210 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/namespace"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/poststore"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/service"
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/assert"
+)
+
+var quotaKeys = 0
+
+// addQuotaConfig posts a configuration with a fresh Idempotency-Key.
+func addQuotaConfig(router http.Handler, url string, body interface{}) (int, *service.QuotaError) {
+	quotaKeys++
+	rec := doRequest(router, "POST", url, body, map[string]string{"Idempotency-Key": fmt.Sprintf("quota-%d", quotaKeys)})
+	if rec.Code != http.StatusTooManyRequests && rec.Code != http.StatusInsufficientStorage {
+		return rec.Code, nil
+	}
+	exceeded := &service.QuotaError{}
+	json.Unmarshal(rec.Body.Bytes(), exceeded)
+	return rec.Code, exceeded
+}
+
+func getQuota(t *testing.T, router http.Handler, url string) *config.Quota {
+	rec := doRequest(router, "GET", url, nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	quota := &config.Quota{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), quota))
+	return quota
+}
+
+func TestQuotasLimitCounts(t *testing.T) {
+	s := newTestService()
+	s.Quotas = &service.Quotas{Default: config.QuotaLimits{MaxConfigs: 2, MaxVersionsPerConfig: 2, MaxGroups: 1, MaxEntriesPerConfig: 3}}
+	router := namespace.Middleware(newTestRouter(s))
+
+	for _, version := range []string{"1", "2"} {
+		code, _ := addQuotaConfig(router, "/configurations", &config.Config{ID: "api", Version: version})
+		assert.Equal(t, http.StatusOK, code)
+	}
+	code, exceeded := addQuotaConfig(router, "/configurations", &config.Config{ID: "api", Version: "3"})
+	assert.Equal(t, http.StatusTooManyRequests, code)
+	assert.Equal(t, &service.QuotaError{Namespace: "default", Resource: "versions_per_config", Limit: 2, Requested: 3}, exceeded)
+
+	code, _ = addQuotaConfig(router, "/configurations", &config.Config{ID: "web", Version: "1"})
+	assert.Equal(t, http.StatusOK, code)
+	code, exceeded = addQuotaConfig(router, "/configurations", &config.Config{ID: "db", Version: "1"})
+	assert.Equal(t, http.StatusTooManyRequests, code)
+	assert.Equal(t, "configs", exceeded.Resource)
+
+	// deleting a version makes room for another one
+	rec := doRequest(router, "DELETE", "/configurations/api/1", nil, nil)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	code, _ = addQuotaConfig(router, "/configurations", &config.Config{ID: "api", Version: "3"})
+	assert.Equal(t, http.StatusOK, code)
+
+	entries := map[string]string{"a": "1", "b": "2", "c": "3", "d": "4"}
+	code, exceeded = addQuotaConfig(router, "/configurations", &config.Config{ID: "web", Version: "2", Entries: entries})
+	assert.Equal(t, http.StatusTooManyRequests, code)
+	assert.Equal(t, "entries_per_config", exceeded.Resource)
+
+	code, _ = addQuotaConfig(router, "/group", newGroup("fleet", "1", 2))
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = addQuotaConfig(router, "/group", newGroup("fleet", "2", 2))
+	assert.Equal(t, http.StatusOK, code)
+	code, exceeded = addQuotaConfig(router, "/group", newGroup("other", "1", 1))
+	assert.Equal(t, http.StatusTooManyRequests, code)
+	assert.Equal(t, "groups", exceeded.Resource)
+
+	quota := getQuota(t, router, "/quota")
+	assert.Equal(t, "default", quota.Namespace)
+	assert.Equal(t, 2, quota.Limits.MaxConfigs)
+	assert.Equal(t, 2, quota.Usage.Configs)
+	assert.Equal(t, 2, quota.Usage.MaxVersionsPerConfig)
+	assert.Equal(t, 1, quota.Usage.Groups)
+
+	// the rejected writes stored nothing
+	_, err := s.PostStore.GetConfiguration(context.Background(), "db", "1")
+	assert.ErrorIs(t, err, poststore.ErrNotFound)
+}
+
+func TestQuotasLimitBytesPerNamespace(t *testing.T) {
+	big := map[string]string{"payload": strings.Repeat("x", 100)}
+	// room for one version of the configuration, not for two
+	limit := config.ConfigSize(&config.Config{ID: "api", Version: "1", Entries: big, IdempotencyKey: "quota-0000"}) * 3 / 2
+
+	s := newTestService()
+	s.Quotas = &service.Quotas{
+		Default:    config.QuotaLimits{MaxBytes: limit},
+		Namespaces: map[string]config.QuotaLimits{"team-a": {}},
+	}
+	router := namespace.Middleware(newTestRouter(s))
+
+	code, _ := addQuotaConfig(router, "/configurations", &config.Config{ID: "api", Version: "1", Entries: big})
+	assert.Equal(t, http.StatusOK, code)
+	code, exceeded := addQuotaConfig(router, "/configurations", &config.Config{ID: "api", Version: "2", Entries: big})
+	assert.Equal(t, http.StatusInsufficientStorage, code)
+	assert.Equal(t, "bytes", exceeded.Resource)
+	assert.Equal(t, limit, exceeded.Limit)
+
+	// team-a has its own, unlimited quota
+	for _, version := range []string{"1", "2", "3"} {
+		code, _ = addQuotaConfig(router, "/namespaces/team-a/configurations", &config.Config{ID: "api", Version: version, Entries: big})
+		assert.Equal(t, http.StatusOK, code)
+	}
+	quota := getQuota(t, router, "/namespaces/team-a/quota")
+	assert.Equal(t, "team-a", quota.Namespace)
+	assert.Equal(t, config.QuotaLimits{}, quota.Limits)
+	assert.Equal(t, 1, quota.Usage.Configs)
+	assert.Equal(t, 3, quota.Usage.MaxVersionsPerConfig)
+	assert.Greater(t, quota.Usage.Bytes, limit)
+}
+
+func TestQuotaUsageMatchesCount(t *testing.T) {
+	s := newTestService()
+	ps := s.PostStore.(*poststore.PostStore)
+	router := namespace.Middleware(newTestRouter(s))
+
+	addQuotaConfig(router, "/configurations", &config.Config{ID: "api", Version: "1", Entries: map[string]string{"a": "1"}})
+	addQuotaConfig(router, "/configurations/api/1/promote", nil)
+	quotaKeys++
+	rec := doRequest(router, "PATCH", "/configurations/api/1", map[string]string{"b": "2"},
+		map[string]string{"Idempotency-Key": fmt.Sprintf("quota-%d", quotaKeys), "Content-Type": "application/merge-patch+json"})
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	addQuotaConfig(router, "/group", newGroup("fleet", "1", 3))
+	addQuotaConfig(router, "/group/fleet/1/promote", nil)
+	rec = doRequest(router, "POST", "/group/fleet/1/extend", []*config.Config{{ID: "extra"}}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = doRequest(router, "DELETE", "/group/fleet/2", nil, nil)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	rec = doRequest(router, "DELETE", "/configurations/api/2", nil, nil)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	// a forced overwrite replaces the size of the version
+	rec = doRequest(router, "POST", "/configurations?force=true", &config.Config{ID: "api", Version: "1", Entries: map[string]string{"long": "value"}},
+		map[string]string{"Idempotency-Key": "quota-force", "X-Admin-Token": "admin-token"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	tracked, err := ps.GetQuotaUsage(context.Background())
+	assert.NoError(t, err)
+	counted, err := ps.CountQuotaUsage(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, counted, tracked)
+	assert.Equal(t, &config.QuotaCounts{Configs: 1, MaxVersionsPerConfig: 2, Groups: 1, Bytes: counted.Bytes}, tracked)
+}
+
+func TestQuotaUsageIsCountedOnFirstWrite(t *testing.T) {
+	kv := poststore.NewMemoryKV()
+	ps := poststore.NewWithKV(kv)
+	ctx := context.Background()
+
+	// written before quotas existed
+	assert.Nil(t, ps.AddConfiguration(ctx, &config.Config{ID: "api", Version: "1"}, nil, nil))
+	assert.Nil(t, ps.AddConfiguration(ctx, &config.Config{ID: "api", Version: "2"}, nil, nil))
+	assert.Nil(t, ps.AddConfigurationGroup(ctx, newGroup("fleet", "1", 2), nil, nil, nil))
+	_, err := kv.Put(&api.KVPair{Key: "quota/usage", Value: []byte(`{"bytes": 1}`)}, nil)
+	assert.Nil(t, err)
+
+	c := &config.Config{ID: "web", Version: "1", Entries: map[string]string{"a": "1"}, Labels: config.Labels{"env": "prod"}}
+	quota := &poststore.QuotaChange{ConfigVersions: map[string]int{"web": 1}, Bytes: config.ConfigSize(c)}
+	assert.Nil(t, ps.AddConfiguration(ctx, c, nil, quota))
+	assert.Equal(t, 2, quota.Usage.Configs)
+	assert.Equal(t, 1, quota.Usage.Groups)
+
+	tracked, err := ps.GetQuotaUsage(ctx)
+	assert.Nil(t, err)
+	counted, err := ps.CountQuotaUsage(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, counted, tracked)
+	assert.Equal(t, 2, tracked.MaxVersionsPerConfig)
+
+	// every ID has its own key, the legacy single value is gone
+	keys, _, err := kv.Keys("quota/", "", nil)
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"quota/totals", "quota/configs/api", "quota/configs/web", "quota/groups/fleet"}, keys)
+}
+
+func TestQuotaCheckAbortsTheWrite(t *testing.T) {
+	ps := poststore.NewInMemory()
+	ctx := context.Background()
+
+	exceeded := &service.QuotaError{Resource: "configs"}
+	quota := &poststore.QuotaChange{
+		ConfigVersions: map[string]int{"api": 1},
+		Check: func(before, after *config.QuotaUsage, versions map[string]int) error {
+			assert.Equal(t, 0, before.Configs)
+			assert.Equal(t, 1, after.Configs)
+			assert.Equal(t, map[string]int{"api": 1}, versions)
+			return exceeded
+		},
+	}
+	err := ps.AddConfiguration(ctx, &config.Config{ID: "api", Version: "1"}, nil, quota)
+	assert.Equal(t, exceeded, err)
+	assert.Nil(t, quota.Usage)
+
+	_, err = ps.GetConfiguration(ctx, "api", "1")
+	assert.ErrorIs(t, err, poststore.ErrNotFound)
+	usage, err := ps.GetQuotaUsage(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, &config.QuotaCounts{}, usage)
+}
@@ -0,0 +1,138 @@
+package test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/auth"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/ratelimit"
+	"github.com/stretchr/testify/assert"
+)
+
+// newRateLimitedRouter returns the test routes behind the API keys
+// "cfk_alice" and "cfk_bob" and a limiter on a clock the test moves.
+func newRateLimitedRouter(limits ratelimit.Config) (http.Handler, *time.Time) {
+	keys := make(map[string]*config.APIKey)
+	for _, subject := range []string{"alice", "bob"} {
+		hash := auth.HashAPIKey("cfk_" + subject)
+		keys[hash] = &config.APIKey{Subject: subject, Hash: hash}
+	}
+	authenticator := &auth.Authenticator{FileKeys: keys, AllowAnonymous: true}
+
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	limiter := ratelimit.New(limits)
+	limiter.Now = func() time.Time { return now }
+
+	router := newTestRouter(newTestService())
+	router.Use(limiter.AddressMiddleware, authenticator.Middleware, limiter.Middleware)
+	return router, &now
+}
+
+func TestParseLimit(t *testing.T) {
+	for s, want := range map[string]ratelimit.Limit{
+		"":         {},
+		"20/s":     {Rate: 20, Burst: 20},
+		"600/1m":   {Rate: 10, Burst: 600},
+		"5/10s:50": {Rate: 0.5, Burst: 50},
+	} {
+		limit, err := ratelimit.ParseLimit(s)
+		assert.NoError(t, err, s)
+		assert.Equal(t, want, limit, s)
+	}
+	for _, s := range []string{"20", "0/s", "x/s", "20/x", "20/s:0", "20/-1s"} {
+		_, err := ratelimit.ParseLimit(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestRateLimitPerIdentity(t *testing.T) {
+	router, now := newRateLimitedRouter(ratelimit.Config{
+		IdentityRead:  ratelimit.Limit{Rate: 1, Burst: 2},
+		IdentityWrite: ratelimit.Limit{Rate: 0.1, Burst: 1},
+	})
+
+	rec := doRequest(router, "GET", "/configurations/api", nil, as("alice"))
+	assert.Equal(t, "2", rec.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", rec.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "1", rec.Header().Get("RateLimit-Reset"))
+	doRequest(router, "GET", "/configurations/api", nil, as("alice"))
+
+	rec = doRequest(router, "GET", "/configurations/api", nil, as("alice"))
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+	assert.Equal(t, "0", rec.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "2", rec.Header().Get("RateLimit-Reset"))
+
+	// bob and anonymous callers have budgets of their own, and writes don't
+	// take from the read budget
+	rec = doRequest(router, "GET", "/configurations/api", nil, as("bob"))
+	assert.NotEqual(t, http.StatusTooManyRequests, rec.Code)
+	rec = doRequest(router, "GET", "/configurations/api", nil, nil)
+	assert.NotEqual(t, http.StatusTooManyRequests, rec.Code)
+	c := &config.Config{ID: "api", Version: "1"}
+	rec = doRequest(router, "POST", "/configurations", c, as("alice", "Idempotency-Key", "rl-1"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = doRequest(router, "POST", "/configurations", c, as("alice", "Idempotency-Key", "rl-1"))
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "10", rec.Header().Get("Retry-After"))
+
+	*now = now.Add(time.Second)
+	rec = doRequest(router, "GET", "/configurations/api", nil, as("alice"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = doRequest(router, "GET", "/configurations/api", nil, as("alice"))
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+}
+
+func TestRateLimitPerRoute(t *testing.T) {
+	router, now := newRateLimitedRouter(ratelimit.Config{
+		RouteRead: ratelimit.Limit{Rate: 1, Burst: 1},
+	})
+
+	rec := doRequest(router, "GET", "/configurations/api/1", nil, as("alice"))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	// the route is shared by all callers and all IDs
+	rec = doRequest(router, "GET", "/configurations/web/2", nil, as("bob"))
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Contains(t, rec.Body.String(), "route")
+
+	// other routes have buckets of their own and writes aren't limited
+	rec = doRequest(router, "GET", "/configurations/api", nil, as("bob"))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = doRequest(router, "POST", "/configurations", &config.Config{ID: "api", Version: "1"}, as("bob", "Idempotency-Key", "rl-2"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("RateLimit-Limit"))
+
+	*now = now.Add(time.Second)
+	rec = doRequest(router, "GET", "/configurations/api/1", nil, as("bob"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestRateLimitPerAddressBeforeAuthentication(t *testing.T) {
+	router, now := newRateLimitedRouter(ratelimit.Config{
+		Address:      ratelimit.Limit{Rate: 1, Burst: 2},
+		IdentityRead: ratelimit.Limit{Rate: 10, Burst: 10},
+	})
+
+	// guessed keys take from the bucket of the address and stop getting
+	// answered once it is empty
+	for i := 0; i < 2; i++ {
+		rec := doRequest(router, "GET", "/configurations/api", nil, as("mallory"))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	}
+	rec := doRequest(router, "GET", "/configurations/api", nil, as("mallory"))
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Contains(t, rec.Body.String(), "address")
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+
+	// valid keys from the same address share its budget
+	rec = doRequest(router, "GET", "/configurations/api", nil, as("alice"))
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+
+	*now = now.Add(time.Second)
+	rec = doRequest(router, "GET", "/configurations/api", nil, as("alice"))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	// the identity bucket sets the headers after authentication
+	assert.Equal(t, "10", rec.Header().Get("RateLimit-Limit"))
+}
@@ -0,0 +1,339 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/auth"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/namespace"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/poststore"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// newRBACRouter serves the routes like main.go does, with the permission
+// checks. Every caller is identified by an API key named after the subject,
+// "cfk_root" is an admin through Admins.
+func newRBACRouter() http.Handler {
+	s := newTestService()
+	ps := s.PostStore.(*poststore.PostStore)
+	authorizer := &auth.Authorizer{Store: ps, Admins: map[string]bool{"api_key:root": true}}
+	s.Authorizer = authorizer
+	targets := &auth.Targets{Store: ps}
+
+	keys := make(map[string]*config.APIKey)
+	for _, subject := range []string{"root", "reader", "dev", "team-a", "nobody"} {
+		hash := auth.HashAPIKey("cfk_" + subject)
+		keys[hash] = &config.APIKey{Subject: subject, Hash: hash}
+	}
+	authenticator := &auth.Authenticator{FileKeys: keys}
+
+	require := authorizer.Require
+	router := mux.NewRouter()
+	router.Use(authenticator.Middleware)
+	router.HandleFunc("/configurations", require(auth.PermConfigurationsWrite, targets.ConfigurationBody, s.AddConfiguration)).Methods("POST")
+	router.HandleFunc("/configurations/{id}/{version}", require(auth.PermConfigurationsRead, targets.ConfigurationPath, s.GetConfiguration)).Methods("GET")
+	router.HandleFunc("/configurations/{id}/{version}", require(auth.PermConfigurationsDelete, targets.ConfigurationPath, s.DeleteConfiguration)).Methods("DELETE")
+	router.HandleFunc("/configurations/{id}/{version}", require(auth.PermConfigurationsWrite, targets.ConfigurationPath, s.PatchConfiguration)).Methods("PATCH")
+	router.HandleFunc("/group", require(auth.PermConfigurationsWrite, targets.GroupBody, s.AddConfigurationGroup)).Methods("POST")
+	router.HandleFunc("/group/{id}/{version}", require(auth.PermConfigurationsRead, targets.GroupPath, s.GetConfigurationGroup)).Methods("GET")
+	router.HandleFunc("/group/{id}/{version}/extend", require(auth.PermConfigurationsWrite, targets.ExtendBody, s.ExtendConfigurationGroup)).Methods("POST")
+	router.HandleFunc("/group/{id}/{version}/{labels}", require(auth.PermConfigurationsRead, targets.GroupPathLabels, s.GetConfigurationGroupsByLabels)).Methods("GET")
+	router.HandleFunc("/audit", require(auth.PermAuditRead, nil, s.ListAudit)).Methods("GET")
+	router.HandleFunc("/audit/verify", require(auth.PermAuditRead, nil, s.VerifyAudit)).Methods("GET")
+	router.HandleFunc("/admin/roles", require(auth.PermRBACManage, nil, s.ListRoles)).Methods("GET")
+	router.HandleFunc("/admin/role-bindings", require(auth.PermRBACManage, nil, s.CreateRoleBinding)).Methods("POST")
+	router.HandleFunc("/admin/role-bindings", require(auth.PermRBACManage, nil, s.ListRoleBindings)).Methods("GET")
+	router.HandleFunc("/admin/role-bindings/{id}", require(auth.PermRBACManage, nil, s.DeleteRoleBinding)).Methods("DELETE")
+	return namespace.Middleware(router)
+}
+
+func as(subject string, headers ...string) map[string]string {
+	h := map[string]string{"X-API-Key": "cfk_" + subject}
+	for i := 0; i+1 < len(headers); i += 2 {
+		h[headers[i]] = headers[i+1]
+	}
+	return h
+}
+
+func bind(t *testing.T, router http.Handler, binding config.RoleBinding) *config.RoleBinding {
+	rec := doRequest(router, "POST", "/admin/role-bindings", binding, as("root"))
+	assert.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	created := &config.RoleBinding{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), created))
+	return created
+}
+
+// forbidden checks rec is a 403 and returns the missing permission.
+func forbidden(t *testing.T, rec *httptest.ResponseRecorder) auth.ForbiddenError {
+	assert.Equal(t, http.StatusForbidden, rec.Code, rec.Body.String())
+	var e auth.ForbiddenError
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &e))
+	return e
+}
+
+func TestRoleBindingsAreManagedByAdmins(t *testing.T) {
+	router := newRBACRouter()
+
+	rec := doRequest(router, "GET", "/admin/roles", nil, as("root"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var roles []auth.RoleInfo
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &roles))
+	assert.Len(t, roles, 3)
+	assert.Equal(t, "admin", roles[0].Role)
+
+	for name, binding := range map[string]config.RoleBinding{
+		"no subject":    {Role: "reader"},
+		"bad subject":   {Subject: "alice", Role: "reader"},
+		"unknown role":  {Subject: "api_key:reader", Role: "owner"},
+		"bad selector":  {Subject: "api_key:reader", Role: "reader", Scope: config.Scope{Labels: "env in"}},
+		"bad id prefix": {Subject: "api_key:reader", Role: "reader", Scope: config.Scope{IDPrefix: "../"}},
+	} {
+		rec := doRequest(router, "POST", "/admin/role-bindings", binding, as("root"))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, name)
+	}
+
+	// only admins manage bindings
+	rec = doRequest(router, "GET", "/admin/role-bindings", nil, as("reader"))
+	e := forbidden(t, rec)
+	assert.Equal(t, auth.PermRBACManage, e.Permission)
+	assert.Equal(t, "api_key:reader", e.Subject)
+
+	binding := bind(t, router, config.RoleBinding{Subject: "api_key:reader", Role: "reader"})
+	assert.NotEmpty(t, binding.ID)
+	rec = doRequest(router, "GET", "/admin/role-bindings", nil, as("root"))
+	var bindings []*config.RoleBinding
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &bindings))
+	assert.Len(t, bindings, 1)
+
+	rec = doRequest(router, "GET", "/configurations/api/1", nil, as("reader"))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = doRequest(router, "DELETE", "/admin/role-bindings/"+binding.ID, nil, as("root"))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	rec = doRequest(router, "DELETE", "/admin/role-bindings/"+binding.ID, nil, as("root"))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = doRequest(router, "GET", "/configurations/api/1", nil, as("reader"))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = doRequest(router, "GET", "/audit?kind=role_binding", nil, as("root"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), config.RoleBindingCreated)
+	assert.Contains(t, rec.Body.String(), config.RoleBindingDeleted)
+}
+
+func TestRolesGrantPermissions(t *testing.T) {
+	router := newRBACRouter()
+	bind(t, router, config.RoleBinding{Subject: "api_key:reader", Role: "reader"})
+
+	rec := doRequest(router, "GET", "/configurations/api/1", nil, as("nobody"))
+	e := forbidden(t, rec)
+	assert.Equal(t, auth.PermConfigurationsRead, e.Permission)
+	assert.Contains(t, rec.Body.String(), "missing permission configurations:read")
+
+	c := &config.Config{ID: "api", Version: "1", Labels: config.Labels{"env": "dev"}}
+	rec = doRequest(router, "POST", "/configurations", c, as("reader", "Idempotency-Key", "rbac-1"))
+	e = forbidden(t, rec)
+	assert.Equal(t, auth.PermConfigurationsWrite, e.Permission)
+
+	rec = doRequest(router, "POST", "/configurations", c, as("root", "Idempotency-Key", "rbac-1"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = doRequest(router, "GET", "/configurations/api/1", nil, as("reader"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = doRequest(router, "DELETE", "/configurations/api/1", nil, as("reader"))
+	e = forbidden(t, rec)
+	assert.Equal(t, auth.PermConfigurationsDelete, e.Permission)
+	rec = doRequest(router, "GET", "/audit", nil, as("reader"))
+	e = forbidden(t, rec)
+	assert.Equal(t, auth.PermAuditRead, e.Permission)
+}
+
+func TestScopedBindings(t *testing.T) {
+	router := newRBACRouter()
+	bind(t, router, config.RoleBinding{Subject: "api_key:dev", Role: "writer",
+		Scope: config.Scope{IDPrefix: "dev-", Labels: "env=dev"}})
+
+	dev := &config.Config{ID: "dev-api", Version: "1", Labels: config.Labels{"env": "dev"}}
+	rec := doRequest(router, "POST", "/configurations", dev, as("dev", "Idempotency-Key", "scope-1"))
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	rec = doRequest(router, "GET", "/configurations/dev-api/latest", nil, as("dev"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	prod := &config.Config{ID: "prod-api", Version: "1", Labels: config.Labels{"env": "dev"}}
+	rec = doRequest(router, "POST", "/configurations", prod, as("dev", "Idempotency-Key", "scope-2"))
+	e := forbidden(t, rec)
+	assert.Equal(t, auth.PermConfigurationsWrite, e.Permission)
+	assert.Contains(t, e.Target, "configuration prod-api")
+
+	prodLabels := &config.Config{ID: "dev-api", Version: "2", Labels: config.Labels{"env": "prod"}}
+	rec = doRequest(router, "POST", "/configurations", prodLabels, as("dev", "Idempotency-Key", "scope-3"))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	// the patched labels are checked as well as the base ones
+	patch := map[string]interface{}{"labels": map[string]string{"env": "prod"}}
+	rec = doRequest(router, "PATCH", "/configurations/dev-api/1", patch, as("dev", "Content-Type", "application/merge-patch+json", "Idempotency-Key", "scope-4"))
+	e = forbidden(t, rec)
+	assert.Contains(t, e.Target, "env=prod")
+	patch = map[string]interface{}{"entries": map[string]string{"replicas": "2"}}
+	rec = doRequest(router, "PATCH", "/configurations/dev-api/1", patch, as("dev", "Content-Type", "application/merge-patch+json", "Idempotency-Key", "scope-5"))
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	// scoped bindings never grant the endpoints that aren't about configurations
+	rec = doRequest(router, "GET", "/audit", nil, as("dev"))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = doRequest(router, "DELETE", "/configurations/dev-api/1", nil, as("dev"))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+}
+
+func TestGroupScopedBindings(t *testing.T) {
+	router := newRBACRouter()
+	bind(t, router, config.RoleBinding{Subject: "api_key:team-a", Role: "writer", Scope: config.Scope{Group: "team-a"}})
+	bind(t, router, config.RoleBinding{Subject: "api_key:dev", Role: "reader", Scope: config.Scope{Labels: "env=dev"}})
+
+	rec := doRequest(router, "POST", "/group", newGroup("team-a", "1", 2), as("team-a", "Idempotency-Key", "group-1"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = doRequest(router, "POST", "/group", newGroup("team-b", "1", 2), as("team-a", "Idempotency-Key", "group-2"))
+	e := forbidden(t, rec)
+	assert.Contains(t, e.Target, "group team-b")
+
+	extra := []*config.Config{{ID: "member-100", Labels: config.Labels{"env": "dev"}}}
+	rec = doRequest(router, "POST", "/group/team-a/1/extend", extra, as("team-a"))
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	rec = doRequest(router, "GET", "/group/team-a/1", nil, as("team-a"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// a label scope covers the members matching the labels of the route only
+	rec = doRequest(router, "GET", "/group/team-a/1", nil, as("dev"))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	rec = doRequest(router, "GET", "/group/team-a/1/env=dev", nil, as("dev"))
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+}
+
+func TestForceNeedsTheForcePermission(t *testing.T) {
+	router := newRBACRouter()
+	bind(t, router, config.RoleBinding{Subject: "api_key:dev", Role: "writer"})
+	bind(t, router, config.RoleBinding{Subject: "api_key:team-a", Role: "writer"})
+	bind(t, router, config.RoleBinding{Subject: "api_key:team-a", Role: "admin", Scope: config.Scope{Group: "team-a"}})
+
+	c := &config.Config{ID: "api", Version: "1"}
+	rec := doRequest(router, "POST", "/configurations", c, as("root", "Idempotency-Key", "force-1"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// writers can't force, and with role bindings the admin token isn't enough
+	rec = doRequest(router, "POST", "/configurations?force=true", c, as("dev", "Idempotency-Key", "force-2", "X-Admin-Token", "admin-token"))
+	e := forbidden(t, rec)
+	assert.Equal(t, auth.PermConfigurationsForce, e.Permission)
+	rec = doRequest(router, "POST", "/configurations?force=true", c, as("root", "Idempotency-Key", "force-3"))
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	// a scoped admin only forces within its scope
+	rec = doRequest(router, "POST", "/group", newGroup("team-a", "1", 2), as("team-a", "Idempotency-Key", "force-4"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = doRequest(router, "POST", "/group?force=true", newGroup("team-a", "1", 1), as("team-a", "Idempotency-Key", "force-5"))
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	rec = doRequest(router, "POST", "/configurations?force=true", c, as("team-a", "Idempotency-Key", "force-6"))
+	e = forbidden(t, rec)
+	assert.Equal(t, auth.PermConfigurationsForce, e.Permission)
+	assert.Contains(t, e.Target, "configuration api")
+}
+
+func TestNamespacedBindings(t *testing.T) {
+	router := newRBACRouter()
+	bind(t, router, config.RoleBinding{Subject: "api_key:reader", Role: "writer", Namespace: "team-a"})
+
+	c := &config.Config{ID: "api", Version: "1"}
+	rec := doRequest(router, "POST", "/namespaces/team-a/configurations", c, as("reader", "Idempotency-Key", "ns-rbac-1"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = doRequest(router, "POST", "/configurations", c, as("reader", "Idempotency-Key", "ns-rbac-1"))
+	e := forbidden(t, rec)
+	assert.Equal(t, namespace.Default, e.Namespace)
+	rec = doRequest(router, "GET", "/configurations/api/1", nil, as("reader", namespace.Header, "team-b"))
+	e = forbidden(t, rec)
+	assert.Equal(t, "team-b", e.Namespace)
+}
+
+func TestNamespacedAdminsStayInTheirNamespace(t *testing.T) {
+	router := newRBACRouter()
+	bind(t, router, config.RoleBinding{Subject: "api_key:team-a", Role: "admin", Namespace: "team-a"})
+	other := bind(t, router, config.RoleBinding{Subject: "api_key:reader", Role: "reader", Namespace: "team-b"})
+
+	// the namespace of the request is the default
+	rec := doRequest(router, "POST", "/namespaces/team-a/admin/role-bindings", config.RoleBinding{Subject: "api_key:dev", Role: "reader"}, as("team-a"))
+	assert.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	created := &config.RoleBinding{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), created))
+	assert.Equal(t, "team-a", created.Namespace)
+
+	// granting itself admin in another or every namespace is forbidden
+	for _, ns := range []string{"team-b", auth.AllNamespaces} {
+		rec = doRequest(router, "POST", "/namespaces/team-a/admin/role-bindings", config.RoleBinding{Subject: "api_key:team-a", Role: "admin", Namespace: ns}, as("team-a"))
+		e := forbidden(t, rec)
+		assert.Equal(t, auth.PermRBACManage, e.Permission)
+		assert.Equal(t, auth.AllNamespaces, e.Namespace)
+	}
+	rec = doRequest(router, "GET", "/namespaces/team-b/configurations/api/1", nil, as("team-a"))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	// the bindings of other namespaces can't be listed or revoked
+	rec = doRequest(router, "GET", "/namespaces/team-a/admin/role-bindings", nil, as("team-a"))
+	var bindings []*config.RoleBinding
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &bindings))
+	assert.Len(t, bindings, 2)
+	for _, binding := range bindings {
+		assert.Equal(t, "team-a", binding.Namespace)
+	}
+	rec = doRequest(router, "DELETE", "/namespaces/team-a/admin/role-bindings/"+other.ID, nil, as("team-a"))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = doRequest(router, "DELETE", "/namespaces/team-a/admin/role-bindings/"+created.ID, nil, as("team-a"))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	// global admins see and grant everything
+	rec = doRequest(router, "GET", "/admin/role-bindings", nil, as("root"))
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &bindings))
+	assert.Len(t, bindings, 2)
+	everywhere := bind(t, router, config.RoleBinding{Subject: "api_key:dev", Role: "reader", Namespace: auth.AllNamespaces})
+	assert.Empty(t, everywhere.Namespace)
+	rec = doRequest(router, "DELETE", "/namespaces/team-a/admin/role-bindings/"+other.ID, nil, as("root"))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+}
+
+func TestNamespacedAuditReaders(t *testing.T) {
+	router := newRBACRouter()
+	bind(t, router, config.RoleBinding{Subject: "api_key:team-a", Role: "admin", Namespace: "team-a"})
+	c := &config.Config{ID: "api", Version: "1"}
+	for i, ns := range []string{"team-a", "team-b"} {
+		rec := doRequest(router, "POST", "/namespaces/"+ns+"/configurations", c, as("root", "Idempotency-Key", fmt.Sprintf("ns-audit-%d", i)))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	// without a filter only the namespace of the request is returned
+	rec := doRequest(router, "GET", "/namespaces/team-a/audit", nil, as("team-a"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var page struct {
+		Records []*config.AuditRecord `json:"records"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+	assert.Len(t, page.Records, 1)
+	assert.Equal(t, "team-a", page.Records[0].Namespace)
+
+	rec = doRequest(router, "GET", "/namespaces/team-a/audit?namespace=team-b", nil, as("team-a"))
+	e := forbidden(t, rec)
+	assert.Equal(t, "team-b", e.Namespace)
+	rec = doRequest(router, "GET", "/namespaces/team-a/audit/verify", nil, as("team-a"))
+	forbidden(t, rec)
+
+	// global admins read every namespace
+	rec = doRequest(router, "GET", "/audit?kind=configuration", nil, as("root"))
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+	assert.Len(t, page.Records, 2)
+	rec = doRequest(router, "GET", "/audit?namespace=team-b", nil, as("root"))
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+	assert.Len(t, page.Records, 1)
+	rec = doRequest(router, "GET", "/audit/verify", nil, as("root"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
@@ -0,0 +1,209 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/service"
+	"github.com/stretchr/testify/assert"
+)
+
+const databaseSchema = `{
+	"type": "object",
+	"required": ["db_host", "db_port"],
+	"properties": {
+		"db_host": {"type": "string", "format": "hostname"},
+		"db_port": {"type": "string", "pattern": "^[0-9]+$"}
+	},
+	"additionalProperties": false
+}`
+
+var schemaKeys = 0
+
+// postSchemaConfig posts body with a fresh Idempotency-Key and returns the
+// status and the schema violations, if any.
+func postSchemaConfig(router http.Handler, url string, body interface{}) (int, []service.SchemaViolation) {
+	schemaKeys++
+	rec := doRequest(router, "POST", url, body, map[string]string{"Idempotency-Key": fmt.Sprintf("schema-%d", schemaKeys)})
+	response := struct {
+		Violations []service.SchemaViolation `json:"violations"`
+	}{}
+	if rec.Code == http.StatusUnprocessableEntity {
+		json.Unmarshal(rec.Body.Bytes(), &response)
+	}
+	return rec.Code, response.Violations
+}
+
+func pointers(violations []service.SchemaViolation) []string {
+	result := make([]string, 0, len(violations))
+	for _, violation := range violations {
+		result = append(result, violation.Pointer)
+	}
+	return result
+}
+
+func TestSchemaRejectsTypos(t *testing.T) {
+	router := newTestRouter(newTestService())
+	schema := &config.Schema{ID: "database", Version: "1", ConfigName: "database", Schema: json.RawMessage(databaseSchema)}
+	rec := doRequest(router, "POST", "/schemas", schema, nil)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, "/schemas/database/1", rec.Header().Get("Location"))
+
+	typo := &config.Config{ID: "db", Version: "1", Name: "database", Entries: map[string]string{"db_host": "db.local", "db_prot": "5432"}}
+	code, violations := postSchemaConfig(router, "/configurations", typo)
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+	assert.ElementsMatch(t, []string{"/entries/db_port", "/entries/db_prot"}, pointers(violations))
+	for _, violation := range violations {
+		assert.Equal(t, "database", violation.SchemaID)
+		if violation.Pointer == "/entries/db_prot" {
+			assert.Contains(t, violation.Message, `"db_port"`)
+		}
+	}
+
+	// other names aren't validated
+	typo.Name = "cache"
+	code, _ = postSchemaConfig(router, "/configurations", typo)
+	assert.Equal(t, http.StatusOK, code)
+
+	valid := &config.Config{ID: "db", Version: "2", Name: "database", Entries: map[string]string{"db_host": "db.local", "db_port": "5432"}}
+	code, _ = postSchemaConfig(router, "/configurations", valid)
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestSchemaValidatesGroups(t *testing.T) {
+	router := newTestRouter(newTestService())
+	schema := &config.Schema{ID: "billing", Version: "1", Selector: "app=billing", Schema: json.RawMessage(databaseSchema)}
+	rec := doRequest(router, "POST", "/schemas", schema, nil)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	group := newGroup("g", "1", 3)
+	for _, c := range group {
+		c.Entries = map[string]string{"db_host": "db.local", "db_port": "5432"}
+	}
+	group[1].Labels = config.Labels{"app": "billing"}
+	group[1].Entries["db_port"] = "http"
+	// the schema doesn't apply to members without the label
+	group[2].Entries["extra"] = "1"
+
+	code, violations := postSchemaConfig(router, "/group", group)
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+	assert.Equal(t, []string{"/1/entries/db_port"}, pointers(violations))
+	assert.Equal(t, "pattern", violations[0].Keyword)
+
+	group[1].Entries["db_port"] = "5432"
+	code, _ = postSchemaConfig(router, "/group", group)
+	assert.Equal(t, http.StatusOK, code)
+
+	extension := []*config.Config{
+		{ID: "member-100", Entries: map[string]string{"db_host": "db.local", "db_port": "5432"}},
+		{ID: "member-101", Labels: config.Labels{"app": "billing"}, Entries: map[string]string{"db_host": "-bad-"}},
+	}
+	code, violations = postSchemaConfig(router, "/group/g/1/extend", extension)
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+	assert.ElementsMatch(t, []string{"/1/entries/db_host", "/1/entries/db_port"}, pointers(violations))
+
+	rec = doRequest(router, "GET", "/group/g/1", nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var members []*config.Config
+	json.Unmarshal(rec.Body.Bytes(), &members)
+	assert.Len(t, members, 3)
+}
+
+func TestSchemaVersions(t *testing.T) {
+	router := newTestRouter(newTestService())
+	strict := &config.Schema{ID: "database", Version: "1", ConfigName: "database", Schema: json.RawMessage(databaseSchema)}
+	rec := doRequest(router, "POST", "/schemas", strict, nil)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	rec = doRequest(router, "POST", "/schemas", strict, nil)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	loose := &config.Schema{ID: "database", Version: "2", ConfigName: "database", Schema: json.RawMessage(`{"required": ["db_host"]}`)}
+	rec = doRequest(router, "POST", "/schemas", loose, nil)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	rec = doRequest(router, "GET", "/schemas/database/latest", nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	latest := &config.Schema{}
+	json.Unmarshal(rec.Body.Bytes(), latest)
+	assert.Equal(t, "2", latest.Version)
+
+	rec = doRequest(router, "GET", "/schemas/database", nil, nil)
+	var versions []*config.Schema
+	json.Unmarshal(rec.Body.Bytes(), &versions)
+	assert.Len(t, versions, 2)
+	assert.Equal(t, "1", versions[0].Version)
+
+	// only the highest version applies
+	c := &config.Config{ID: "db", Version: "1", Name: "database", Entries: map[string]string{"db_host": "db.local", "extra": "1"}}
+	code, _ := postSchemaConfig(router, "/configurations", c)
+	assert.Equal(t, http.StatusOK, code)
+
+	rec = doRequest(router, "DELETE", "/schemas/database/2", nil, nil)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	rec = doRequest(router, "DELETE", "/schemas/database/2", nil, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	c.Version = "2"
+	code, _ = postSchemaConfig(router, "/configurations", c)
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+
+	rec = doRequest(router, "GET", "/schemas", nil, nil)
+	var schemas []*config.Schema
+	json.Unmarshal(rec.Body.Bytes(), &schemas)
+	assert.Len(t, schemas, 1)
+	assert.Equal(t, "1", schemas[0].Version)
+}
+
+func TestInvalidSchemaIsRejected(t *testing.T) {
+	router := newTestRouter(newTestService())
+	for _, schema := range []*config.Schema{
+		{ID: "s", Version: "1", Schema: json.RawMessage(`{}`)},
+		{ID: "s", Version: "latest", ConfigName: "db", Schema: json.RawMessage(`{}`)},
+		{ID: "s", Version: "1", ConfigName: "db"},
+		{ID: "s", Version: "1", ConfigName: "db", Schema: json.RawMessage(`{"pattern": "("}`)},
+		{ID: "s", Version: "1", ConfigName: "db", Schema: json.RawMessage(`{"$ref": "#/definitions/missing"}`)},
+		{ID: "s", Version: "1", Selector: "app in (", Schema: json.RawMessage(`{}`)},
+		{ID: "s", Version: "1", ConfigName: "db", Schema: json.RawMessage(`{"$defs":{"a":{"anyOf":[{"$ref":"#/$defs/a"},{"$ref":"#/$defs/a"}]}},"$ref":"#/$defs/a"}`)},
+		{ID: "s", Version: "1", ConfigName: "db", Schema: json.RawMessage(`{"$defs":{"a":{"$ref":"#/$defs/b"},"b":{"not":{"$ref":"#/$defs/a"}}},"$ref":"#/$defs/a"}`)},
+		{ID: "s", Version: "1", ConfigName: "db", Schema: fanOutSchema(20)},
+		// entries are strings in an object, keywords that could never pass or
+		// never run are rejected
+		{ID: "s", Version: "1", ConfigName: "db", Schema: json.RawMessage(`{"properties": {"db_port": {"type": "integer"}}}`)},
+		{ID: "s", Version: "1", ConfigName: "db", Schema: json.RawMessage(`{"properties": {"db_port": {"minimum": 1}}}`)},
+		{ID: "s", Version: "1", ConfigName: "db", Schema: json.RawMessage(`{"properties": {"db_port": {"enum": [5432, 5433]}}}`)},
+		{ID: "s", Version: "1", ConfigName: "db", Schema: json.RawMessage(`{"properties": {"db": {"required": ["host"]}}}`)},
+		{ID: "s", Version: "1", ConfigName: "db", Schema: json.RawMessage(`{"$defs": {"port": {"type": "number"}}, "additionalProperties": {"$ref": "#/$defs/port"}}`)},
+		{ID: "s", Version: "1", ConfigName: "db", Schema: json.RawMessage(`{"type": "array", "items": true}`)},
+		{ID: "s", Version: "1", ConfigName: "db", Schema: json.RawMessage(`{"pattern": "^db"}`)},
+	} {
+		rec := doRequest(router, "POST", "/schemas", schema, nil)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+	}
+}
+
+// fanOutSchema returns a schema without cycles whose definitions each refer
+// twice to the next one, 2^n subschemas once the references are expanded.
+func fanOutSchema(n int) json.RawMessage {
+	defs := make(map[string]interface{})
+	for i := 0; i < n; i++ {
+		next := map[string]string{"$ref": fmt.Sprintf("#/$defs/d%d", i+1)}
+		defs[fmt.Sprintf("d%d", i)] = map[string]interface{}{"anyOf": []interface{}{next, next}}
+	}
+	defs[fmt.Sprintf("d%d", n)] = map[string]interface{}{"required": []string{"db_host"}}
+	data, _ := json.Marshal(map[string]interface{}{"$defs": defs, "$ref": "#/$defs/d0"})
+	return data
+}
+
+func TestSharedDefinitionsAreAccepted(t *testing.T) {
+	router := newTestRouter(newTestService())
+	schema := &config.Schema{ID: "s", Version: "1", ConfigName: "db", Schema: fanOutSchema(8)}
+	rec := doRequest(router, "POST", "/schemas", schema, nil)
+	assert.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	code, violations := postSchemaConfig(router, "/configurations", &config.Config{ID: "db", Version: "1", Name: "db"})
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+	assert.Equal(t, []string{"/entries"}, pointers(violations))
+}
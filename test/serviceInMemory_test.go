@@ -0,0 +1,107 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/poststore"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/service"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestService() *service.Service {
+	return &service.Service{
+		Configurations: []*config.Config{},
+		PostStore:      poststore.NewInMemory(),
+		AdminToken:     "admin-token",
+	}
+}
+
+func newTestRouter(s *service.Service) *mux.Router {
+	router := mux.NewRouter()
+	router.HandleFunc("/configurations", s.AddConfiguration).Methods("POST")
+	router.HandleFunc("/configurations/{id}", s.ListConfigurationVersions).Methods("GET")
+	router.HandleFunc("/configurations/{id}/diff", s.DiffConfiguration).Methods("GET")
+	router.HandleFunc("/configurations/{id}/{version}", s.GetConfiguration).Methods("GET")
+	router.HandleFunc("/configurations/{id}/{version}", s.DeleteConfiguration).Methods("DELETE")
+	router.HandleFunc("/configurations/{id}/{version}", s.PatchConfiguration).Methods("PATCH")
+	router.HandleFunc("/configurations/{id}/{version}/promote", s.PromoteConfiguration).Methods("POST")
+	router.HandleFunc("/group", s.AddConfigurationGroup).Methods("POST")
+	router.HandleFunc("/group/{id}/{version}/promote", s.PromoteConfigurationGroup).Methods("POST")
+	router.HandleFunc("/group/{id}/diff", s.DiffConfigurationGroup).Methods("GET")
+	router.HandleFunc("/group/{id}/{version}", s.GetConfigurationGroup).Methods("GET")
+	router.HandleFunc("/group/{id}/{version}", s.DeleteConfigurationGroup).Methods("DELETE")
+	router.HandleFunc("/group/{id}/{version}/extend", s.ExtendConfigurationGroup).Methods("POST")
+	router.HandleFunc("/configurations/{id}/{version}/watch", s.WatchConfiguration).Methods("GET")
+	router.HandleFunc("/group/{id}/{version}/watch", s.WatchConfigurationGroup).Methods("GET")
+	router.HandleFunc("/group/{id}/{version}/{labels}/watch", s.WatchConfigurationGroupsByLabels).Methods("GET")
+	router.HandleFunc("/group/{id}/{version}/{labels}", s.GetConfigurationGroupsByLabels).Methods("GET")
+	router.HandleFunc("/events", s.StreamEvents).Methods("GET")
+	router.HandleFunc("/webhooks", s.CreateWebhook).Methods("POST")
+	router.HandleFunc("/webhooks", s.ListWebhooks).Methods("GET")
+	router.HandleFunc("/webhooks/{id}", s.GetWebhook).Methods("GET")
+	router.HandleFunc("/webhooks/{id}", s.DeleteWebhook).Methods("DELETE")
+	router.HandleFunc("/webhooks/{id}/deliveries", s.ListWebhookDeliveries).Methods("GET")
+	router.HandleFunc("/audit", s.ListAudit).Methods("GET")
+	router.HandleFunc("/audit/verify", s.VerifyAudit).Methods("GET")
+	router.HandleFunc("/quota", s.GetQuota).Methods("GET")
+	router.HandleFunc("/schemas", s.CreateSchema).Methods("POST")
+	router.HandleFunc("/schemas", s.ListSchemas).Methods("GET")
+	router.HandleFunc("/schemas/{id}", s.ListSchemaVersions).Methods("GET")
+	router.HandleFunc("/schemas/{id}/{version}", s.GetSchema).Methods("GET")
+	router.HandleFunc("/schemas/{id}/{version}", s.DeleteSchema).Methods("DELETE")
+	return router
+}
+
+// doRequest sends a JSON request through the router and returns the recorder.
+func doRequest(router http.Handler, method, url string, body interface{}, headers map[string]string) *httptest.ResponseRecorder {
+	var reader *bytes.Reader
+	if body != nil {
+		data, _ := json.Marshal(body)
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req := httptest.NewRequest(method, url, reader)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestServiceInMemory(t *testing.T) {
+	router := newTestRouter(newTestService())
+
+	body, _ := json.Marshal(config.Config{ID: "svc-id", Version: "1", Name: "Service Configuration"})
+	req := httptest.NewRequest(http.MethodPost, "/configurations", bytes.NewReader(body))
+	req.Header.Set("Idempotency-Key", "svc-key")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/configurations/svc-id/1", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	retrievedConfig := &config.Config{}
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(retrievedConfig))
+	assert.Equal(t, "Service Configuration", retrievedConfig.Name)
+
+	req = httptest.NewRequest(http.MethodDelete, "/configurations/svc-id/1", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/configurations/svc-id/1", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -0,0 +1,53 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/stretchr/testify/assert"
+)
+
+type validationBody struct {
+	Error  string `json:"error"`
+	Fields []struct {
+		Field   string `json:"field"`
+		Message string `json:"message"`
+	} `json:"fields"`
+}
+
+func fieldsOf(t *testing.T, body string) []string {
+	var v validationBody
+	assert.Nil(t, json.Unmarshal([]byte(body), &v))
+	fields := make([]string, 0)
+	for _, f := range v.Fields {
+		fields = append(fields, f.Field)
+	}
+	return fields
+}
+
+func TestRejectsUnsafeIDs(t *testing.T) {
+	router := newTestRouter(newTestService())
+	headers := map[string]string{"Idempotency-Key": "v1"}
+
+	c := config.Config{ID: "../idempotency/x", Version: "1/2", Labels: config.Labels{"bad key": "v"}}
+	rec := doRequest(router, http.MethodPost, "/configurations", c, headers)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.ElementsMatch(t, []string{"id", "version", "labels.bad key"}, fieldsOf(t, rec.Body.String()))
+
+	group := []config.Config{{ID: "ok", Version: "1"}, {ID: "a..b", GroupID: "g", Version: "1"}}
+	rec = doRequest(router, http.MethodPost, "/group", group, headers)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.ElementsMatch(t, []string{"[0].group_id", "[1].id"}, fieldsOf(t, rec.Body.String()))
+}
+
+func TestRejectsLargeBodies(t *testing.T) {
+	router := newTestRouter(newTestService())
+
+	c := config.Config{ID: "big", Version: "1", Entries: map[string]string{"blob": strings.Repeat("x", 2<<20)}}
+	rec := doRequest(router, http.MethodPost, "/configurations", c, map[string]string{"Idempotency-Key": "big"})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, []string{"body"}, fieldsOf(t, rec.Body.String()))
+}
@@ -0,0 +1,79 @@
+package test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigurationVersionIsWriteOnce(t *testing.T) {
+	router := newTestRouter(newTestService())
+
+	first := config.Config{ID: "immutable", Version: "1", Name: "first"}
+	rec := doRequest(router, http.MethodPost, "/configurations", first, map[string]string{"Idempotency-Key": "k1"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	second := config.Config{ID: "immutable", Version: "1", Name: "second"}
+	rec = doRequest(router, http.MethodPost, "/configurations", second, map[string]string{"Idempotency-Key": "k2"})
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Contains(t, rec.Body.String(), "already exists")
+
+	rec = doRequest(router, http.MethodPost, "/configurations?force=true", second, map[string]string{"Idempotency-Key": "k3"})
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = doRequest(router, http.MethodPost, "/configurations?force=true", second, map[string]string{"Idempotency-Key": "k4", "X-Admin-Token": "admin-token"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = doRequest(router, http.MethodGet, "/configurations/immutable/1", nil, nil)
+	assert.Contains(t, rec.Body.String(), "second")
+}
+
+func TestGroupVersionIsWriteOnce(t *testing.T) {
+	router := newTestRouter(newTestService())
+
+	group := []config.Config{
+		{ID: "a", GroupID: "g", Version: "1"},
+		{ID: "b", GroupID: "g", Version: "1"},
+	}
+	rec := doRequest(router, http.MethodPost, "/group", group, map[string]string{"Idempotency-Key": "g1"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = doRequest(router, http.MethodPost, "/group", group[:1], map[string]string{"Idempotency-Key": "g2"})
+	assert.Equal(t, http.StatusConflict, rec.Code)
+}
+
+func TestFailedForcedGroupReplacementKeepsTheOldVersion(t *testing.T) {
+	s := newTestService()
+	router := newTestRouter(s)
+
+	rec := doRequest(router, http.MethodPost, "/group", newGroup("g", "1", 2), map[string]string{"Idempotency-Key": "r1"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// the replacement exceeds the entries quota, so its create fails
+	s.Quotas = &service.Quotas{Default: config.QuotaLimits{MaxEntriesPerConfig: 1}}
+	replacement := []*config.Config{{ID: "c", GroupID: "g", Version: "1", Entries: map[string]string{"a": "1", "b": "2"}}}
+	rec = doRequest(router, http.MethodPost, "/group?force=true", replacement, map[string]string{"Idempotency-Key": "r2", "X-Admin-Token": "admin-token"})
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+
+	members, err := s.PostStore.GetConfigurationGroup(context.Background(), "g", "1")
+	assert.Nil(t, err)
+	assert.Len(t, members, 2)
+
+	// within the quota the old version is replaced as a whole
+	replacement[0].Entries = map[string]string{"a": "1"}
+	rec = doRequest(router, http.MethodPost, "/group?force=true", replacement, map[string]string{"Idempotency-Key": "r3", "X-Admin-Token": "admin-token"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	members, err = s.PostStore.GetConfigurationGroup(context.Background(), "g", "1")
+	assert.Nil(t, err)
+	if assert.Len(t, members, 1) {
+		assert.Equal(t, "c", members[0].ID)
+	}
+
+	usage, err := s.PostStore.GetQuotaUsage(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, usage.Groups)
+}
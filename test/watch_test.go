@@ -0,0 +1,104 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchConfigurationReturnsOnChange(t *testing.T) {
+	s := newTestService()
+	router := newTestRouter(s)
+	ctx := context.Background()
+
+	err := s.PostStore.AddConfiguration(ctx, &config.Config{ID: "watched", Version: "1", Entries: map[string]string{"a": "1"}}, nil, nil)
+	assert.Nil(t, err)
+
+	rec := doRequest(router, http.MethodGet, "/configurations/watched/1/watch", nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	index := rec.Header().Get("X-Config-Index")
+	assert.NotEmpty(t, index)
+
+	rec = doRequest(router, http.MethodGet, "/configurations/watched/1/watch?index="+index+"&wait=20ms", nil, nil)
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+	assert.Equal(t, index, rec.Header().Get("X-Config-Index"))
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		s.PostStore.DeleteConfiguration(ctx, "watched", "1", nil)
+	}()
+
+	rec = doRequest(router, http.MethodGet, "/configurations/watched/1/watch?index="+index+"&wait=5s", nil, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.NotEqual(t, index, rec.Header().Get("X-Config-Index"))
+}
+
+func TestWatchGroupReturnsNewMembers(t *testing.T) {
+	s := newTestService()
+	router := newTestRouter(s)
+	ctx := context.Background()
+
+	err := s.PostStore.AddConfigurationGroup(ctx, newGroup("watched", "1", 2), nil, nil, nil)
+	assert.Nil(t, err)
+
+	rec := doRequest(router, http.MethodGet, "/group/watched/1/watch", nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	index, err := strconv.ParseUint(rec.Header().Get("X-Config-Index"), 10, 64)
+	assert.Nil(t, err)
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		extra := &config.Config{ID: "extra", GroupID: "watched", Version: "1", Labels: config.Labels{"env": "prod"}}
+		s.PostStore.ExtendConfigurationGroup(ctx, "watched", "1", []*config.Config{extra}, 0, nil)
+	}()
+
+	url := "/group/watched/1/env=prod/watch?wait=5s&index=" + strconv.FormatUint(index, 10)
+	rec = doRequest(router, http.MethodGet, url, nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var configs []*config.Config
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &configs))
+	if assert.Len(t, configs, 1) {
+		assert.Equal(t, "extra", configs[0].ID)
+	}
+}
+
+func TestWatchRejectsInvalidParameters(t *testing.T) {
+	router := newTestRouter(newTestService())
+
+	rec := doRequest(router, http.MethodGet, "/configurations/a/1/watch?index=abc&wait=forever", nil, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "index")
+	assert.Contains(t, rec.Body.String(), "wait")
+}
+
+func TestWatchSegmentIsNotALabelSelector(t *testing.T) {
+	s := newTestService()
+	router := newTestRouter(s)
+
+	members := newGroup("labelled", "1", 2)
+	members[0].Labels = config.Labels{"watch": "yes"}
+	assert.Nil(t, s.PostStore.AddConfigurationGroup(context.Background(), members, nil, nil, nil))
+
+	// /watch is the watch of the whole group, "watch," selects by label
+	rec := doRequest(router, http.MethodGet, "/group/labelled/1/watch", nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("X-Config-Index"))
+	var configs []*config.Config
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &configs))
+	assert.Len(t, configs, 2)
+
+	rec = doRequest(router, http.MethodGet, "/group/labelled/1/watch,", nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	configs = nil
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &configs))
+	if assert.Len(t, configs, 1) {
+		assert.Equal(t, members[0].ID, configs[0].ID)
+	}
+}
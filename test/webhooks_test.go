@@ -0,0 +1,299 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/config"
+	"github.com/anna02272/AlatiZaRazvojSoftvera2023-projekat/service"
+	"github.com/stretchr/testify/assert"
+)
+
+// webhookReceiver records the deliveries it gets and fails the first
+// failures of them with 500.
+type webhookReceiver struct {
+	mu       sync.Mutex
+	failures int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (rcv *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	rcv.requests = append(rcv.requests, r)
+	rcv.bodies = append(rcv.bodies, body)
+	if rcv.failures > 0 {
+		rcv.failures--
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// newWebhookService runs a dispatcher with short retries for s, or a new
+// test service if there is none.
+func newWebhookService(t *testing.T, services ...*service.Service) *service.Service {
+	s := newTestService()
+	if len(services) > 0 {
+		s = services[0]
+	}
+	s.Webhooks = service.NewWebhookDispatcher(s.PostStore)
+	// the test receivers listen on 127.0.0.1
+	s.Webhooks.AllowPrivate = true
+	s.Webhooks.BaseDelay = 10 * time.Millisecond
+	s.Webhooks.MaxDelay = 50 * time.Millisecond
+	s.Webhooks.MaxAttempts = 3
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go s.Webhooks.Run(ctx, 1)
+	return s
+}
+
+// waitForDelivery polls the delivery history until the first delivery is no
+// longer pending.
+func waitForDelivery(t *testing.T, router http.Handler, webhookID string) *config.Delivery {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		rec := doRequest(router, "GET", "/webhooks/"+webhookID+"/deliveries", nil, nil)
+		var deliveries []*config.Delivery
+		json.Unmarshal(rec.Body.Bytes(), &deliveries)
+		if len(deliveries) > 0 && deliveries[0].Status != config.DeliveryPending {
+			return deliveries[0]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("delivery didn't finish")
+	return nil
+}
+
+func TestWebhookDeliveryIsSignedAndRetried(t *testing.T) {
+	receiver := &webhookReceiver{failures: 1}
+	target := httptest.NewServer(receiver)
+	defer target.Close()
+
+	s := newWebhookService(t)
+	router := newTestRouter(s)
+
+	rec := doRequest(router, "POST", "/webhooks", &config.Webhook{
+		ID:     "hook",
+		URL:    target.URL,
+		Events: []string{config.ConfigurationCreated},
+		Secret: "s3cret",
+	}, nil)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	rec = doRequest(router, "GET", "/webhooks/hook", nil, nil)
+	assert.NotContains(t, rec.Body.String(), "s3cret")
+
+	rec = doRequest(router, "POST", "/configurations", &config.Config{ID: "hooked", Version: "1"}, map[string]string{"Idempotency-Key": "webhook-1"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	// deletes aren't subscribed to
+	doRequest(router, "DELETE", "/configurations/hooked/1", nil, nil)
+
+	delivery := waitForDelivery(t, router, "hook")
+	assert.Equal(t, config.DeliveryDelivered, delivery.Status)
+	assert.Equal(t, 2, delivery.Attempts)
+	assert.Equal(t, http.StatusNoContent, delivery.LastStatusCode)
+
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	if assert.Len(t, receiver.requests, 2) {
+		req, body := receiver.requests[1], receiver.bodies[1]
+		assert.Equal(t, config.ConfigurationCreated, req.Header.Get("X-Webhook-Event"))
+		assert.Equal(t, delivery.ID, req.Header.Get("X-Webhook-Delivery"))
+		assert.Equal(t, receiver.requests[0].Header.Get("X-Webhook-Delivery"), delivery.ID)
+		assert.Equal(t, service.SignWebhook("s3cret", req.Header.Get("X-Webhook-Timestamp"), body), req.Header.Get("X-Webhook-Signature"))
+
+		var event config.Event
+		assert.NoError(t, json.Unmarshal(body, &event))
+		assert.Equal(t, "hooked", event.Configs[0].ID)
+	}
+}
+
+func TestWebhookDeliveryFailsAfterMaxAttempts(t *testing.T) {
+	receiver := &webhookReceiver{failures: 10}
+	target := httptest.NewServer(receiver)
+	defer target.Close()
+
+	s := newWebhookService(t)
+	router := newTestRouter(s)
+
+	rec := doRequest(router, "POST", "/webhooks", &config.Webhook{ID: "broken", URL: target.URL, GroupID: "g"}, nil)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	var created config.Webhook
+	json.Unmarshal(rec.Body.Bytes(), &created)
+	assert.NotEmpty(t, created.Secret)
+
+	rec = doRequest(router, "POST", "/group", newGroup("g", "1", 2), map[string]string{"Idempotency-Key": "webhook-2"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	delivery := waitForDelivery(t, router, "broken")
+	assert.Equal(t, config.DeliveryFailed, delivery.Status)
+	assert.Equal(t, 3, delivery.Attempts)
+	assert.Equal(t, http.StatusInternalServerError, delivery.LastStatusCode)
+	assert.NotEmpty(t, delivery.LastError)
+}
+
+func TestWebhookSubscriptionValidation(t *testing.T) {
+	router := newTestRouter(newTestService())
+
+	rec := doRequest(router, "POST", "/webhooks", &config.Webhook{URL: "ftp://example.com", Events: []string{"config.changed"}}, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "url")
+	assert.Contains(t, rec.Body.String(), "events[0]")
+
+	rec = doRequest(router, "POST", "/webhooks", &config.Webhook{ID: "dup", URL: "http://example.com"}, nil)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	rec = doRequest(router, "POST", "/webhooks", &config.Webhook{ID: "dup", URL: "http://example.com"}, nil)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = doRequest(router, "DELETE", "/webhooks/dup", nil, nil)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	rec = doRequest(router, "GET", "/webhooks/dup", nil, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestPendingWebhookDeliveriesAreResumed(t *testing.T) {
+	receiver := &webhookReceiver{}
+	target := httptest.NewServer(receiver)
+	defer target.Close()
+
+	// deliveries left pending by a previous run
+	s := newTestService()
+	ctx := context.Background()
+	webhook := &config.Webhook{ID: "resumed", URL: target.URL, Secret: "s"}
+	assert.NoError(t, s.PostStore.AddWebhook(ctx, webhook))
+	retryAt := time.Now().Add(-time.Second)
+	for _, delivery := range []*config.Delivery{
+		{ID: "first", WebhookID: "resumed", Status: config.DeliveryPending, Event: &config.Event{Type: config.ConfigurationCreated}},
+		{ID: "retried", WebhookID: "resumed", Status: config.DeliveryPending, Attempts: 1, NextAttemptAt: &retryAt, Event: &config.Event{Type: config.ConfigurationDeleted}},
+		{ID: "done", WebhookID: "resumed", Status: config.DeliveryDelivered, Attempts: 1, Event: &config.Event{Type: config.ConfigurationCreated}},
+	} {
+		assert.NoError(t, s.PostStore.SaveDelivery(ctx, delivery))
+	}
+
+	newWebhookService(t, s)
+	router := newTestRouter(s)
+	deadline := time.Now().Add(5 * time.Second)
+	var deliveries []*config.Delivery
+	for time.Now().Before(deadline) {
+		rec := doRequest(router, "GET", "/webhooks/resumed/deliveries", nil, nil)
+		json.Unmarshal(rec.Body.Bytes(), &deliveries)
+		pending := 0
+		for _, delivery := range deliveries {
+			if delivery.Status == config.DeliveryPending {
+				pending++
+			}
+		}
+		if pending == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	attempts := make(map[string]int)
+	for _, delivery := range deliveries {
+		assert.Equal(t, config.DeliveryDelivered, delivery.Status, delivery.ID)
+		attempts[delivery.ID] = delivery.Attempts
+	}
+	assert.Equal(t, map[string]int{"first": 1, "retried": 2, "done": 1}, attempts)
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	assert.Len(t, receiver.requests, 2)
+}
+
+func TestDeadReceiversDontHoldUpOthers(t *testing.T) {
+	dead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer dead.Close()
+	receiver := &webhookReceiver{}
+	alive := httptest.NewServer(receiver)
+	defer alive.Close()
+
+	s := newTestService()
+	// one worker and retries far apart: the retries of the dead receivers
+	// must wait in the schedule, not in the worker
+	newWebhookService(t, s)
+	s.Webhooks.BaseDelay = time.Hour
+	s.Webhooks.MaxDelay = time.Hour
+	router := newTestRouter(s)
+
+	for _, hook := range []*config.Webhook{{ID: "dead-1", URL: dead.URL}, {ID: "dead-2", URL: dead.URL}, {ID: "alive", URL: alive.URL}} {
+		rec := doRequest(router, "POST", "/webhooks", hook, nil)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+	}
+	rec := doRequest(router, "POST", "/configurations", &config.Config{ID: "c", Version: "1"}, map[string]string{"Idempotency-Key": "webhook-dead"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	delivery := waitForDelivery(t, router, "alive")
+	assert.Equal(t, config.DeliveryDelivered, delivery.Status)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		rec = doRequest(router, "GET", "/webhooks/dead-1/deliveries", nil, nil)
+		var deliveries []*config.Delivery
+		json.Unmarshal(rec.Body.Bytes(), &deliveries)
+		if len(deliveries) == 1 && deliveries[0].Attempts == 1 {
+			assert.Equal(t, config.DeliveryPending, deliveries[0].Status)
+			if assert.NotNil(t, deliveries[0].NextAttemptAt) {
+				assert.True(t, deliveries[0].NextAttemptAt.After(time.Now().Add(59*time.Minute)))
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("the dead receiver wasn't tried")
+}
+
+func TestWebhooksDontReachInternalAddresses(t *testing.T) {
+	s := newTestService()
+	s.Webhooks = service.NewWebhookDispatcher(s.PostStore)
+	router := newTestRouter(s)
+
+	for _, url := range []string{
+		"http://127.0.0.1:8500/v1/kv/",
+		"http://localhost:8500",
+		"http://[::1]/",
+		"http://10.0.0.1/",
+		"http://192.168.1.10/",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://0.0.0.0/",
+	} {
+		rec := doRequest(router, "POST", "/webhooks", &config.Webhook{URL: url}, nil)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, url)
+	}
+
+	// the resolved address is checked again when connecting, so names
+	// pointing at internal addresses are refused as well
+	receiver := &webhookReceiver{}
+	target := httptest.NewServer(receiver)
+	defer target.Close()
+	req, _ := http.NewRequest(http.MethodPost, target.URL, nil)
+	_, err := s.Webhooks.Client.Do(req)
+	assert.ErrorContains(t, err, "not allowed")
+
+	// redirects aren't followed
+	redirect := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusFound))
+	defer redirect.Close()
+	s.Webhooks.AllowPrivate = true
+	req, _ = http.NewRequest(http.MethodPost, redirect.URL, nil)
+	resp, err := s.Webhooks.Client.Do(req)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusFound, resp.StatusCode)
+	}
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	assert.Empty(t, receiver.requests)
+}
@@ -94,3 +94,12 @@ func LogString(key string, value string) log.Field {
 func LogError(span opentracing.Span, err error, fields ...log.Field) {
 	ext.LogError(span, err, fields...)
 }
+
+// TraceID returns the ID of the trace the span belongs to, or "" if the span
+// isn't a Jaeger span.
+func TraceID(span opentracing.Span) string {
+	if sc, ok := span.Context().(jaeger.SpanContext); ok {
+		return sc.TraceID().String()
+	}
+	return ""
+}